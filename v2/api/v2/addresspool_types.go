@@ -3,8 +3,11 @@ package v2
 import (
 	"errors"
 	"net"
+	"regexp"
+	"time"
 
 	"github.com/cybozu-go/netutil"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 )
@@ -165,6 +168,89 @@ type AddressPoolSpec struct {
 	// This field can be updated only by adding subnets to the list.
 	// +kubebuilder:validation:MinItems=1
 	Subnets []SubnetSet `json:"subnets"`
+
+	// MTU overrides the MTU of Pod network interfaces for Pods in this pool.
+	// If not specified, coild uses the value given by its `--mtu` flag or
+	// detected from the host network.
+	// +kubebuilder:validation:Minimum=576
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	MTU int32 `json:"mtu,omitempty"`
+
+	// Tuning specifies network parameters applied to Pods in this pool.
+	// Changes affect only new Pods.
+	// +optional
+	Tuning *PodNetworkTuning `json:"tuning,omitempty"`
+
+	// QuarantinePeriod is the duration for which released addresses and
+	// address blocks are not allocated again.  This keeps stale conntrack
+	// entries, DNS caches, or external ACLs from directing traffic to a
+	// wrong Pod.  If not specified, released addresses are reused immediately.
+	// +optional
+	QuarantinePeriod *metav1.Duration `json:"quarantinePeriod,omitempty"`
+
+	// AllowedNamespaces selects namespaces whose Pods can use this pool by their labels.
+	// If not specified, all namespaces are allowed.
+	// +optional
+	AllowedNamespaces *metav1.LabelSelector `json:"allowedNamespaces,omitempty"`
+}
+
+// GetQuarantinePeriod returns the quarantine period of the pool, or 0 if not specified.
+func (aps AddressPoolSpec) GetQuarantinePeriod() time.Duration {
+	if aps.QuarantinePeriod == nil {
+		return 0
+	}
+	return aps.QuarantinePeriod.Duration
+}
+
+// AllowsNamespace returns true if Pods in ns are allowed to use the pool.
+func (aps AddressPoolSpec) AllowsNamespace(ns *corev1.Namespace) (bool, error) {
+	return matchSelector(aps.AllowedNamespaces, ns.Labels)
+}
+
+// PodNetworkTuning defines network parameters for Pods.
+type PodNetworkTuning struct {
+	// Sysctls is a map of sysctl keys and values set in the network namespace of Pods.
+	// Only keys under `net.` are allowed, e.g. `net.ipv4.tcp_congestion_control`
+	// or `net.ipv6.conf.eth0.accept_ra`.
+	// +optional
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+
+	// GRO enables or disables generic receive offload of both ends of the veth pair.
+	// +optional
+	GRO *bool `json:"gro,omitempty"`
+
+	// GSO enables or disables generic segmentation offload of both ends of the veth pair.
+	// +optional
+	GSO *bool `json:"gso,omitempty"`
+
+	// TSO enables or disables TCP segmentation offload of both ends of the veth pair.
+	// +optional
+	TSO *bool `json:"tso,omitempty"`
+
+	// TxQueueLen is the transmit queue length of both ends of the veth pair.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TxQueueLen *int32 `json:"txQueueLen,omitempty"`
+}
+
+var sysctlKeyRegexp = regexp.MustCompile(`^net(\.[a-zA-Z0-9_-]+)+$`)
+
+func (t *PodNetworkTuning) validate(p *field.Path) field.ErrorList {
+	if t == nil {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	for k, v := range t.Sysctls {
+		if !sysctlKeyRegexp.MatchString(k) {
+			allErrs = append(allErrs, field.Invalid(p.Child("sysctls").Key(k), k, "must be a sysctl key under net."))
+		}
+		if v == "" {
+			allErrs = append(allErrs, field.Required(p.Child("sysctls").Key(k), "value must not be empty"))
+		}
+	}
+	return allErrs
 }
 
 func (aps AddressPoolSpec) validate() field.ErrorList {
@@ -176,9 +262,34 @@ func (aps AddressPoolSpec) validate() field.ErrorList {
 		}
 	}
 
+	allErrs = append(allErrs, aps.validateMTU()...)
+	allErrs = append(allErrs, aps.validateQuarantinePeriod()...)
+	allErrs = append(allErrs, aps.Tuning.validate(field.NewPath("spec", "tuning"))...)
+	allErrs = append(allErrs, validateSelector(aps.AllowedNamespaces, field.NewPath("spec", "allowedNamespaces"))...)
 	return allErrs
 }
 
+func (aps AddressPoolSpec) validateQuarantinePeriod() field.ErrorList {
+	if aps.GetQuarantinePeriod() >= 0 {
+		return nil
+	}
+	return field.ErrorList{
+		field.Invalid(field.NewPath("spec", "quarantinePeriod"), aps.QuarantinePeriod.Duration.String(), "must not be negative"),
+	}
+}
+
+// minIPv6MTU is the minimum link MTU required by IPv6.
+const minIPv6MTU = 1280
+
+func (aps AddressPoolSpec) validateMTU() field.ErrorList {
+	if aps.MTU == 0 || aps.MTU >= minIPv6MTU || len(aps.Subnets) == 0 || aps.Subnets[0].IPv6 == nil {
+		return nil
+	}
+	return field.ErrorList{
+		field.Invalid(field.NewPath("spec", "mtu"), aps.MTU, "must be at least 1280 for IPv6 subnets"),
+	}
+}
+
 func (aps AddressPoolSpec) validateUpdate(old AddressPoolSpec) field.ErrorList {
 	var allErrs field.ErrorList
 	p := field.NewPath("spec")
@@ -204,6 +315,10 @@ func (aps AddressPoolSpec) validateUpdate(old AddressPoolSpec) field.ErrorList {
 		}
 	}
 
+	allErrs = append(allErrs, aps.validateMTU()...)
+	allErrs = append(allErrs, aps.validateQuarantinePeriod()...)
+	allErrs = append(allErrs, aps.Tuning.validate(field.NewPath("spec", "tuning"))...)
+	allErrs = append(allErrs, validateSelector(aps.AllowedNamespaces, field.NewPath("spec", "allowedNamespaces"))...)
 	return allErrs
 }
 
@@ -2,10 +2,12 @@ package v2
 
 import (
 	"context"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -223,4 +225,89 @@ var _ = Describe("AddressPool Webhook", func() {
 		err = k8sClient.Update(ctx, r)
 		Expect(err).To(HaveOccurred())
 	})
+
+	It("should allow changing MTU", func() {
+		r := &AddressPool{
+			Spec: AddressPoolSpec{
+				BlockSizeBits: 2,
+				Subnets:       []SubnetSet{makeSubnetSet("10.2.0.0/24", "fd02::/120")},
+				MTU:           1400,
+			},
+		}
+		r.Name = "test"
+
+		err := k8sClient.Create(ctx, r)
+		Expect(err).NotTo(HaveOccurred())
+
+		r.Spec.MTU = 1450
+		err = k8sClient.Update(ctx, r)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should deny too small MTU for IPv6 subnets", func() {
+		r := &AddressPool{
+			Spec: AddressPoolSpec{
+				BlockSizeBits: 2,
+				Subnets:       []SubnetSet{makeSubnetSet("", "fd02::/120")},
+				MTU:           1000,
+			},
+		}
+		r.Name = "test"
+
+		err := k8sClient.Create(ctx, r)
+		Expect(err).To(HaveOccurred())
+
+		r.Spec.Subnets = []SubnetSet{makeSubnetSet("10.2.0.0/24", "")}
+		err = k8sClient.Create(ctx, r)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should deny negative quarantine period", func() {
+		r := &AddressPool{
+			Spec: AddressPoolSpec{
+				BlockSizeBits:    2,
+				Subnets:          []SubnetSet{makeSubnetSet("10.2.0.0/24", "")},
+				QuarantinePeriod: &metav1.Duration{Duration: -time.Minute},
+			},
+		}
+		r.Name = "test"
+
+		err := k8sClient.Create(ctx, r)
+		Expect(err).To(HaveOccurred())
+
+		r.Spec.QuarantinePeriod.Duration = 5 * time.Minute
+		err = k8sClient.Create(ctx, r)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should validate sysctls", func() {
+		r := &AddressPool{
+			Spec: AddressPoolSpec{
+				BlockSizeBits: 2,
+				Subnets:       []SubnetSet{makeSubnetSet("10.2.0.0/24", "")},
+				Tuning: &PodNetworkTuning{
+					Sysctls: map[string]string{"kernel.shmmax": "1"},
+				},
+			},
+		}
+		r.Name = "test"
+
+		err := k8sClient.Create(ctx, r)
+		Expect(err).To(HaveOccurred())
+
+		r.Spec.Tuning.Sysctls = map[string]string{"net.ipv4.tcp_rmem": ""}
+		err = k8sClient.Create(ctx, r)
+		Expect(err).To(HaveOccurred())
+
+		r.Spec.Tuning.Sysctls = map[string]string{
+			"net.ipv4.tcp_rmem":            "4096 131072 6291456",
+			"net.ipv6.conf.eth0.accept_ra": "0",
+		}
+		err = k8sClient.Create(ctx, r)
+		Expect(err).NotTo(HaveOccurred())
+
+		r.Spec.Tuning.Sysctls["net/ipv4/ip_forward"] = "1"
+		err = k8sClient.Update(ctx, r)
+		Expect(err).To(HaveOccurred())
+	})
 })
@@ -1,17 +1,26 @@
 package v2
 
 import (
+	"context"
 	"net"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	apivalidation "k8s.io/apimachinery/pkg/api/validation"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cybozu-go/coil/v2/pkg/constants"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -26,6 +35,15 @@ type EgressSpec struct {
 	// +kubebuilder:validation:MinItems=1
 	Destinations []string `json:"destinations"`
 
+	// Priority decides which Egress is used when the destinations of Egresses
+	// used by a client overlap.  The Egress with the highest priority is used for
+	// the overlapping addresses.  Among Egresses of the same priority, the most
+	// specific destination is used, and then the Egress whose namespace/name
+	// comes first in lexical order.
+	// The default is 0.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
 	// Replicas is the desired number of egress (SNAT) pods.
 	// Defaults to 1.
 	// +kubebuilder:default=1
@@ -67,6 +85,196 @@ type EgressSpec struct {
 	// PodDisruptionBudget is an optional PodDisruptionBudget for Egress NAT pods.
 	// +optional
 	PodDisruptionBudget *EgressPDBSpec `json:"podDisruptionBudget,omitempty"`
+
+	// DrainTimeout is the duration for which a terminating egress pod keeps
+	// NAT for packets still sent to it.  Clients stop sending packets to a
+	// terminating egress pod as soon as its endpoint is removed from the Service,
+	// so existing flows survive the termination only with StateSync.
+	// Setting this requires StateSync, and terminationGracePeriodSeconds of the
+	// pod template must not be shorter than this.
+	// If not specified, egress pods stop soon after termination.
+	// +optional
+	DrainTimeout *metav1.Duration `json:"drainTimeout,omitempty"`
+
+	// TopologySpread spreads egress pods over topology domains such as zones and nodes.
+	// This is ignored if the pod template specifies topologySpreadConstraints.
+	// +optional
+	TopologySpread *EgressTopologySpread `json:"topologySpread,omitempty"`
+
+	// TrafficDistribution is copied to Service's spec.trafficDistribution.
+	// With PreferSameZone, client Pods send packets to egress pods in the same zone if any.
+	// Ref. https://kubernetes.io/docs/concepts/services-networking/service/#traffic-distribution
+	// +kubebuilder:validation:Enum=PreferClose;PreferSameZone;PreferSameNode
+	// +optional
+	TrafficDistribution *string `json:"trafficDistribution,omitempty"`
+
+	// AllowedClients restricts Pods that can use this Egress.
+	// If not specified, all Pods are allowed.
+	// +optional
+	AllowedClients *EgressAllowedClients `json:"allowedClients,omitempty"`
+
+	// StateSync enables synchronization of NAT state between egress pods
+	// so that a surviving egress pod can take over flows of a lost one.
+	// Replicas must not exceed 16 with StateSync because each egress pod
+	// uses one of 16 ranges of source ports.
+	// +optional
+	StateSync *EgressStateSync `json:"stateSync,omitempty"`
+}
+
+// EgressStateSync defines the synchronization of NAT state between egress pods.
+type EgressStateSync struct {
+	// SourceIPs are the addresses shared by egress pods as the source address of SNAT.
+	// At most one IPv4 address and one IPv6 address can be specified.
+	// The network must route packets destined for them to the egress pods.
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=2
+	SourceIPs []string `json:"sourceIPs"`
+}
+
+// EgressTopologySpread defines how to spread egress pods.
+type EgressTopologySpread struct {
+	// TopologyKeys is a list of node label keys to spread egress pods over.
+	// Defaults to topology.kubernetes.io/zone and kubernetes.io/hostname.
+	// +optional
+	TopologyKeys []string `json:"topologyKeys,omitempty"`
+
+	// MaxSkew is the maximum difference in the number of egress pods between topology domains.
+	// Defaults to 1.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxSkew int32 `json:"maxSkew,omitempty"`
+
+	// WhenUnsatisfiable specifies how to deal with an egress pod that cannot be spread.
+	// Defaults to ScheduleAnyway.
+	// +kubebuilder:validation:Enum=DoNotSchedule;ScheduleAnyway
+	// +kubebuilder:default=ScheduleAnyway
+	// +optional
+	WhenUnsatisfiable corev1.UnsatisfiableConstraintAction `json:"whenUnsatisfiable,omitempty"`
+}
+
+// GetTopologyKeys returns the topology keys or the default ones.
+func (ts EgressTopologySpread) GetTopologyKeys() []string {
+	if len(ts.TopologyKeys) == 0 {
+		return []string{corev1.LabelTopologyZone, corev1.LabelHostname}
+	}
+	return ts.TopologyKeys
+}
+
+// EgressAllowedClients defines Pods and Nodes allowed to use an Egress.
+// A Pod is allowed if both of NamespaceSelector and PodSelector match.
+// A Node is allowed only if NodeSelector matches.
+type EgressAllowedClients struct {
+	// NamespaceSelector selects namespaces of the client Pods by their labels.
+	// If not specified, all namespaces are selected.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// PodSelector selects client Pods by their labels.
+	// If not specified, all Pods are selected.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// NodeSelector selects Nodes whose processes in the host network can use
+	// the Egress by their labels.
+	// Unlike the other selectors, no Nodes are allowed if not specified.
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+}
+
+// AllowsClient returns true if pod in namespace ns is allowed to use the Egress.
+func (es EgressSpec) AllowsClient(ns *corev1.Namespace, pod *corev1.Pod) (bool, error) {
+	if es.AllowedClients == nil {
+		return true, nil
+	}
+	ok, err := matchSelector(es.AllowedClients.NamespaceSelector, ns.Labels)
+	if !ok || err != nil {
+		return false, err
+	}
+	return matchSelector(es.AllowedClients.PodSelector, pod.Labels)
+}
+
+// AllowsNode returns true if processes in the host network of node are allowed to use the Egress.
+func (es EgressSpec) AllowsNode(node *corev1.Node) (bool, error) {
+	if es.AllowedClients == nil {
+		return true, nil
+	}
+	if es.AllowedClients.NodeSelector == nil {
+		return false, nil
+	}
+	return matchSelector(es.AllowedClients.NodeSelector, node.Labels)
+}
+
+// Upstreams returns the Egresses used by the router Pods of the Egress.
+// They are specified by the annotations of the Pod template in the same
+// way as client Pods.
+func (es EgressSpec) Upstreams() []types.NamespacedName {
+	if es.Template == nil {
+		return nil
+	}
+	return EgressesOf(es.Template.Annotations)
+}
+
+// EgressesOf returns the Egresses specified by `egress.coil.cybozu.com/<namespace>`
+// annotations of a Pod or a Node in a stable order.
+func EgressesOf(annotations map[string]string) []types.NamespacedName {
+	var keys []types.NamespacedName
+	for k, v := range annotations {
+		if !strings.HasPrefix(k, constants.AnnEgressPrefix) {
+			continue
+		}
+		ns := k[len(constants.AnnEgressPrefix):]
+		for _, name := range strings.Split(v, ",") {
+			keys = append(keys, types.NamespacedName{Namespace: ns, Name: name})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+// FindEgressLoop follows the upstreams of the Egress specified by key and
+// returns the chain of Egresses that leads back to it, if any.
+// upstreams are used instead of those of the Egress read from r.
+func FindEgressLoop(ctx context.Context, r client.Reader, key types.NamespacedName, upstreams []types.NamespacedName) ([]types.NamespacedName, error) {
+	visited := map[types.NamespacedName]bool{key: true}
+
+	var visit func(path []types.NamespacedName, upstreams []types.NamespacedName) ([]types.NamespacedName, error)
+	visit = func(path []types.NamespacedName, upstreams []types.NamespacedName) ([]types.NamespacedName, error) {
+		for _, up := range upstreams {
+			if up == key {
+				return append(path, up), nil
+			}
+			if visited[up] {
+				continue
+			}
+			visited[up] = true
+
+			eg := &Egress{}
+			if err := r.Get(ctx, up, eg); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return nil, err
+			}
+			loop, err := visit(append(path, up), eg.Spec.Upstreams())
+			if loop != nil || err != nil {
+				return loop, err
+			}
+		}
+		return nil, nil
+	}
+	return visit([]types.NamespacedName{key}, upstreams)
+}
+
+// FormatEgressChain formats a chain of Egresses returned by FindEgressLoop.
+func FormatEgressChain(chain []types.NamespacedName) string {
+	names := make([]string, len(chain))
+	for i, k := range chain {
+		names[i] = k.String()
+	}
+	return strings.Join(names, " -> ")
 }
 
 // EgressPodTemplate defines pod template for Egress
@@ -143,6 +351,71 @@ func (es EgressSpec) validate() field.ErrorList {
 		allErrs = append(allErrs, validatePodDisruptionBudget(*es.PodDisruptionBudget, pp)...)
 	}
 
+	if es.DrainTimeout != nil {
+		allErrs = append(allErrs, es.validateDrainTimeout(p)...)
+	}
+
+	if es.TopologySpread != nil {
+		pp := p.Child("topologySpread", "topologyKeys")
+		for i, k := range es.TopologySpread.TopologyKeys {
+			allErrs = append(allErrs, validation.ValidateLabelName(k, pp.Index(i))...)
+		}
+	}
+
+	if es.AllowedClients != nil {
+		pp := p.Child("allowedClients")
+		allErrs = append(allErrs, validateSelector(es.AllowedClients.NamespaceSelector, pp.Child("namespaceSelector"))...)
+		allErrs = append(allErrs, validateSelector(es.AllowedClients.PodSelector, pp.Child("podSelector"))...)
+		allErrs = append(allErrs, validateSelector(es.AllowedClients.NodeSelector, pp.Child("nodeSelector"))...)
+	}
+
+	if es.StateSync != nil {
+		pp := p.Child("stateSync", "sourceIPs")
+		if len(es.StateSync.SourceIPs) == 0 {
+			allErrs = append(allErrs, field.Required(pp, "at least one address is required"))
+		}
+		families := make(map[bool]bool)
+		for i, s := range es.StateSync.SourceIPs {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				allErrs = append(allErrs, field.Invalid(pp.Index(i), s, "invalid IP address"))
+				continue
+			}
+			isIPv4 := ip.To4() != nil
+			if families[isIPv4] {
+				allErrs = append(allErrs, field.Invalid(pp.Index(i), s, "duplicate IP family"))
+			}
+			families[isIPv4] = true
+		}
+		if es.Replicas > constants.SourcePortSlots {
+			allErrs = append(allErrs, field.Invalid(p.Child("replicas"), es.Replicas,
+				"must not exceed "+strconv.Itoa(constants.SourcePortSlots)+" with stateSync"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateDrainTimeout checks that egress pods can keep flows during the drain timeout.
+// Clients move their flows to other egress pods as soon as the endpoint of a
+// terminating pod is removed, so draining is useful only with state sync.
+func (es EgressSpec) validateDrainTimeout(p *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	d := es.DrainTimeout.Duration
+	pp := p.Child("drainTimeout")
+	if d < 0 {
+		return append(allErrs, field.Invalid(pp, d.String(), "must not be negative"))
+	}
+	if d > 0 && es.StateSync == nil {
+		allErrs = append(allErrs, field.Forbidden(pp, "spec.stateSync is required to keep existing flows"))
+	}
+	if es.Template != nil && es.Template.Spec.TerminationGracePeriodSeconds != nil {
+		grace := time.Duration(*es.Template.Spec.TerminationGracePeriodSeconds) * time.Second
+		if grace < d {
+			allErrs = append(allErrs, field.Invalid(p.Child("template", "spec", "terminationGracePeriodSeconds"),
+				*es.Template.Spec.TerminationGracePeriodSeconds, "must not be shorter than spec.drainTimeout "+d.String()))
+		}
+	}
 	return allErrs
 }
 
@@ -0,0 +1,104 @@
+package v2
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestEgressAllowsClient(t *testing.T) {
+	t.Parallel()
+
+	ns := &corev1.Namespace{}
+	ns.Labels = map[string]string{"team": "frontend"}
+	pod := &corev1.Pod{}
+	pod.Labels = map[string]string{"app": "web"}
+	node := &corev1.Node{}
+	node.Labels = map[string]string{"role": "gateway"}
+
+	cases := []struct {
+		name      string
+		allowed   *EgressAllowedClients
+		allowPod  bool
+		allowNode bool
+	}{
+		{"unrestricted", nil, true, true},
+		{
+			"pod-only",
+			&EgressAllowedClients{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "frontend"}},
+				PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			},
+			true, false,
+		},
+		{
+			"namespace-mismatch",
+			&EgressAllowedClients{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "backend"}},
+			},
+			false, false,
+		},
+		{
+			"node-only",
+			&EgressAllowedClients{
+				PodSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+				NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"role": "gateway"}},
+			},
+			false, true,
+		},
+		{
+			"node-mismatch",
+			&EgressAllowedClients{
+				NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"role": "worker"}},
+			},
+			true, false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			es := EgressSpec{AllowedClients: tc.allowed}
+			ok, err := es.AllowsClient(ns, pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tc.allowPod {
+				t.Errorf("AllowsClient() = %v, want %v", ok, tc.allowPod)
+			}
+
+			ok, err = es.AllowsNode(node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tc.allowNode {
+				t.Errorf("AllowsNode() = %v, want %v", ok, tc.allowNode)
+			}
+		})
+	}
+}
+
+func TestEgressesOf(t *testing.T) {
+	annotations := map[string]string{
+		"egress.coil.cybozu.com/internet": "proxy,nat",
+		"egress.coil.cybozu.com/dc":       "dc",
+		"other.example.com/internet":      "ignored",
+	}
+	expected := []types.NamespacedName{
+		{Namespace: "dc", Name: "dc"},
+		{Namespace: "internet", Name: "nat"},
+		{Namespace: "internet", Name: "proxy"},
+	}
+	if diff := cmp.Diff(expected, EgressesOf(annotations)); diff != "" {
+		t.Errorf("unexpected egresses (-want +got):\n%s", diff)
+	}
+
+	es := EgressSpec{Template: &EgressPodTemplate{Metadata: Metadata{Annotations: annotations}}}
+	if diff := cmp.Diff(expected, es.Upstreams()); diff != "" {
+		t.Errorf("unexpected upstreams (-want +got):\n%s", diff)
+	}
+}
@@ -6,7 +6,10 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
@@ -14,7 +17,7 @@ import (
 func (r *Egress) SetupWebhookWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewWebhookManagedBy(mgr, r).
 		WithDefaulter(&EgressCustomDefaulter{}).
-		WithValidator(&EgressCustomValidator{}).
+		WithValidator(&EgressCustomValidator{Reader: mgr.GetClient()}).
 		Complete()
 }
 
@@ -44,8 +47,12 @@ func (r *EgressCustomDefaulter) Default(ctx context.Context, egress *Egress) err
 	return nil
 }
 
-// EgressCustomValidator is an empty struct that implements webhook.Validator
-type EgressCustomValidator struct{}
+// EgressCustomValidator implements webhook.Validator for Egress.
+// If Reader is set, it also rejects Egresses whose router Pods would form a loop.
+// +kubebuilder:object:generate=false
+type EgressCustomValidator struct {
+	Reader client.Reader
+}
 
 // +kubebuilder:webhook:path=/validate-coil-cybozu-com-v2-egress,mutating=false,failurePolicy=fail,sideEffects=None,groups=coil.cybozu.com,resources=egresses,verbs=create;update,versions=v2,name=vegress.kb.io,admissionReviewVersions={v1,v1beta1}
 
@@ -53,7 +60,13 @@ var _ admission.Validator[*Egress] = &EgressCustomValidator{}
 
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
 func (r *EgressCustomValidator) ValidateCreate(ctx context.Context, egress *Egress) (warnings admission.Warnings, err error) {
-	if errs := egress.Spec.validate(); len(errs) != 0 {
+	errs := egress.Spec.validate()
+	loopErrs, err := r.validateLoop(ctx, egress)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	errs = append(errs, loopErrs...)
+	if len(errs) != 0 {
 		return nil, apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "Egress"}, egress.Name, errs)
 	}
 
@@ -62,13 +75,43 @@ func (r *EgressCustomValidator) ValidateCreate(ctx context.Context, egress *Egre
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
 func (r *EgressCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj *Egress) (warnings admission.Warnings, err error) {
-	if errs := newObj.Spec.validateUpdate(); len(errs) != 0 {
+	errs := newObj.Spec.validateUpdate()
+	loopErrs, err := r.validateLoop(ctx, newObj)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	errs = append(errs, loopErrs...)
+	if len(errs) != 0 {
 		return nil, apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "Egress"}, newObj.Name, errs)
 	}
 
 	return nil, nil
 }
 
+// validateLoop checks that the chain of Egresses through the router Pods of egress
+// does not lead back to itself.
+func (r *EgressCustomValidator) validateLoop(ctx context.Context, egress *Egress) (field.ErrorList, error) {
+	if r.Reader == nil {
+		return nil, nil
+	}
+	upstreams := egress.Spec.Upstreams()
+	if len(upstreams) == 0 {
+		return nil, nil
+	}
+
+	key := types.NamespacedName{Namespace: egress.Namespace, Name: egress.Name}
+	loop, err := FindEgressLoop(ctx, r.Reader, key, upstreams)
+	if err != nil {
+		return nil, err
+	}
+	if loop == nil {
+		return nil, nil
+	}
+
+	p := field.NewPath("spec", "template", "metadata", "annotations")
+	return field.ErrorList{field.Invalid(p, egress.Spec.Template.Annotations, "Egress chain loops: "+FormatEgressChain(loop))}, nil
+}
+
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
 func (r *EgressCustomValidator) ValidateDelete(ctx context.Context, old *Egress) (warnings admission.Warnings, err error) {
 	return nil, nil
@@ -2,6 +2,7 @@ package v2
 
 import (
 	"context"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -10,6 +11,7 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
 )
 
 func makeEgress() *Egress {
@@ -199,4 +201,119 @@ var _ = Describe("Egress Webhook", func() {
 		err = k8sClient.Create(ctx, r)
 		Expect(err).To(HaveOccurred())
 	})
+
+	It("should deny invalid allowed clients", func() {
+		r := makeEgress()
+		r.Spec.AllowedClients = &EgressAllowedClients{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "team", Operator: "Unknown"},
+				},
+			},
+		}
+		err := k8sClient.Create(ctx, r)
+		Expect(err).To(HaveOccurred())
+
+		r = makeEgress()
+		r.Spec.AllowedClients = &EgressAllowedClients{
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"nat": "allowed"}},
+		}
+		err = k8sClient.Create(ctx, r)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should validate drain timeout", func() {
+		r := makeEgress()
+		r.Spec.StateSync = &EgressStateSync{SourceIPs: []string{"192.0.2.1"}}
+		r.Spec.DrainTimeout = &metav1.Duration{Duration: -time.Second}
+		err := k8sClient.Create(ctx, r)
+		Expect(err).To(HaveOccurred())
+
+		r = makeEgress()
+		r.Spec.DrainTimeout = &metav1.Duration{Duration: time.Minute}
+		err = k8sClient.Create(ctx, r)
+		Expect(err).To(HaveOccurred())
+
+		r = makeEgress()
+		r.Spec.StateSync = &EgressStateSync{SourceIPs: []string{"192.0.2.1"}}
+		r.Spec.DrainTimeout = &metav1.Duration{Duration: time.Minute}
+		r.Spec.Template = &EgressPodTemplate{}
+		r.Spec.Template.Spec.TerminationGracePeriodSeconds = ptr.To(int64(30))
+		err = k8sClient.Create(ctx, r)
+		Expect(err).To(HaveOccurred())
+
+		r = makeEgress()
+		r.Spec.StateSync = &EgressStateSync{SourceIPs: []string{"192.0.2.1"}}
+		r.Spec.DrainTimeout = &metav1.Duration{Duration: time.Minute}
+		err = k8sClient.Create(ctx, r)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should validate source IPs of state sync", func() {
+		r := makeEgress()
+		r.Spec.StateSync = &EgressStateSync{SourceIPs: []string{"192.0.2.1", "192.0.2.2"}}
+		err := k8sClient.Create(ctx, r)
+		Expect(err).To(HaveOccurred())
+
+		r = makeEgress()
+		r.Spec.StateSync = &EgressStateSync{SourceIPs: []string{"foo"}}
+		err = k8sClient.Create(ctx, r)
+		Expect(err).To(HaveOccurred())
+
+		r = makeEgress()
+		r.Spec.StateSync = &EgressStateSync{SourceIPs: []string{"192.0.2.1"}}
+		r.Spec.Replicas = 17
+		err = k8sClient.Create(ctx, r)
+		Expect(err).To(HaveOccurred())
+
+		r = makeEgress()
+		r.Spec.StateSync = &EgressStateSync{SourceIPs: []string{"192.0.2.1", "2001:db8::1"}}
+		err = k8sClient.Create(ctx, r)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should deny loops of Egress chains", func() {
+		r := makeEgress()
+		r.Spec.Template = &EgressPodTemplate{
+			Metadata: Metadata{
+				Annotations: map[string]string{"egress.coil.cybozu.com/default": "test"},
+			},
+		}
+		err := k8sClient.Create(ctx, r)
+		Expect(err).To(HaveOccurred())
+
+		r = makeEgress()
+		r.Spec.Template = &EgressPodTemplate{
+			Metadata: Metadata{
+				Annotations: map[string]string{"egress.coil.cybozu.com/default": "test2"},
+			},
+		}
+		err = k8sClient.Create(ctx, r)
+		Expect(err).NotTo(HaveOccurred())
+
+		r2 := makeEgress()
+		r2.Name = "test2"
+		r2.Spec.Template = &EgressPodTemplate{
+			Metadata: Metadata{
+				Annotations: map[string]string{"egress.coil.cybozu.com/default": "test"},
+			},
+		}
+		err = k8sClient.Create(ctx, r2)
+		Expect(err).To(HaveOccurred())
+
+		r2.Spec.Template = nil
+		err = k8sClient.Create(ctx, r2)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() {
+			Expect(k8sClient.Delete(ctx, r2)).To(Succeed())
+		})
+
+		r2.Spec.Template = &EgressPodTemplate{
+			Metadata: Metadata{
+				Annotations: map[string]string{"egress.coil.cybozu.com/default": "test"},
+			},
+		}
+		err = k8sClient.Update(ctx, r2)
+		Expect(err).To(HaveOccurred())
+	})
 })
@@ -0,0 +1,81 @@
+package v2
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+// SetupNamespaceWebhookWithManager registers a webhook for Namespaces
+// that validates the reference to an AddressPool.
+func SetupNamespaceWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr, &corev1.Namespace{}).
+		WithValidator(&NamespaceCustomValidator{Reader: mgr.GetClient()}).
+		Complete()
+}
+
+// NamespaceCustomValidator implements webhook.Validator for Namespaces.
+// +kubebuilder:object:generate=false
+type NamespaceCustomValidator struct {
+	Reader client.Reader
+}
+
+// +kubebuilder:webhook:path=/validate--v1-namespace,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=namespaces,verbs=create;update,versions=v1,name=vnamespace.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ admission.Validator[*corev1.Namespace] = &NamespaceCustomValidator{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (v *NamespaceCustomValidator) ValidateCreate(ctx context.Context, ns *corev1.Namespace) (warnings admission.Warnings, err error) {
+	return nil, v.validate(ctx, ns)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (v *NamespaceCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj *corev1.Namespace) (warnings admission.Warnings, err error) {
+	if oldObj.Annotations[constants.AnnPool] == newObj.Annotations[constants.AnnPool] &&
+		equality.Semantic.DeepEqual(oldObj.Labels, newObj.Labels) {
+		return nil, nil
+	}
+	return nil, v.validate(ctx, newObj)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (v *NamespaceCustomValidator) ValidateDelete(ctx context.Context, ns *corev1.Namespace) (warnings admission.Warnings, err error) {
+	return nil, nil
+}
+
+func (v *NamespaceCustomValidator) validate(ctx context.Context, ns *corev1.Namespace) error {
+	poolName, ok := ns.Annotations[constants.AnnPool]
+	if !ok {
+		return nil
+	}
+
+	p := field.NewPath("metadata", "annotations").Key(constants.AnnPool)
+	pool := &AddressPool{}
+	err := v.Reader.Get(ctx, client.ObjectKey{Name: poolName}, pool)
+	if apierrors.IsNotFound(err) {
+		errs := field.ErrorList{field.NotFound(p, poolName)}
+		return apierrors.NewInvalid(schema.GroupKind{Kind: "Namespace"}, ns.Name, errs)
+	}
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+
+	ok, err = pool.Spec.AllowsNamespace(ns)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	if !ok {
+		errs := field.ErrorList{field.Forbidden(p, "the namespace is not allowed to use pool "+poolName)}
+		return apierrors.NewInvalid(schema.GroupKind{Kind: "Namespace"}, ns.Name, errs)
+	}
+	return nil
+}
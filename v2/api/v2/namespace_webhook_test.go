@@ -0,0 +1,77 @@
+package v2
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+var _ = Describe("Namespace Webhook", func() {
+	ctx := context.TODO()
+
+	It("should validate the pool annotation", func() {
+		ap := &AddressPool{}
+		ap.Name = "ns-webhook"
+		ap.Spec.BlockSizeBits = 5
+		ap.Spec.Subnets = []SubnetSet{makeSubnetSet("10.8.0.0/24", "")}
+		err := k8sClient.Create(ctx, ap)
+		Expect(err).NotTo(HaveOccurred())
+
+		ns := &corev1.Namespace{}
+		ns.Name = "ns-webhook-bad"
+		ns.Annotations = map[string]string{constants.AnnPool: "no-such-pool"}
+		err = k8sClient.Create(ctx, ns)
+		Expect(err).To(HaveOccurred())
+
+		ns = &corev1.Namespace{}
+		ns.Name = "ns-webhook"
+		ns.Annotations = map[string]string{constants.AnnPool: "ns-webhook"}
+		err = k8sClient.Create(ctx, ns)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: "ns-webhook"}, ns)
+		Expect(err).NotTo(HaveOccurred())
+		ns.Annotations[constants.AnnPool] = "no-such-pool"
+		err = k8sClient.Update(ctx, ns)
+		Expect(err).To(HaveOccurred())
+
+		ns.Annotations[constants.AnnPool] = "ns-webhook"
+		ns.Labels = map[string]string{"foo": "bar"}
+		err = k8sClient.Update(ctx, ns)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should validate the allowed namespaces of the pool", func() {
+		ap := &AddressPool{}
+		ap.Name = "ns-webhook-restricted"
+		ap.Spec.BlockSizeBits = 5
+		ap.Spec.Subnets = []SubnetSet{makeSubnetSet("10.8.1.0/24", "")}
+		ap.Spec.AllowedNamespaces = &metav1.LabelSelector{
+			MatchLabels: map[string]string{"team": "network"},
+		}
+		err := k8sClient.Create(ctx, ap)
+		Expect(err).NotTo(HaveOccurred())
+
+		ns := &corev1.Namespace{}
+		ns.Name = "ns-webhook-restricted"
+		ns.Annotations = map[string]string{constants.AnnPool: "ns-webhook-restricted"}
+		err = k8sClient.Create(ctx, ns)
+		Expect(err).To(HaveOccurred())
+
+		ns.Labels = map[string]string{"team": "network"}
+		err = k8sClient.Create(ctx, ns)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: "ns-webhook-restricted"}, ns)
+		Expect(err).NotTo(HaveOccurred())
+		ns.Labels["team"] = "other"
+		err = k8sClient.Update(ctx, ns)
+		Expect(err).To(HaveOccurred())
+	})
+})
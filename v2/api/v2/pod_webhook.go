@@ -0,0 +1,202 @@
+package v2
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+// SetupPodWebhookWithManager registers a webhook for Pods
+// that validates the references to Egresses.
+func SetupPodWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr, &corev1.Pod{}).
+		WithValidator(&PodCustomValidator{Reader: mgr.GetClient()}).
+		Complete()
+}
+
+// PodCustomValidator implements webhook.Validator for Pods.
+// +kubebuilder:object:generate=false
+type PodCustomValidator struct {
+	Reader client.Reader
+}
+
+// +kubebuilder:webhook:path=/validate--v1-pod,mutating=false,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create;update,versions=v1,name=vpod.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ admission.Validator[*corev1.Pod] = &PodCustomValidator{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (v *PodCustomValidator) ValidateCreate(ctx context.Context, pod *corev1.Pod) (warnings admission.Warnings, err error) {
+	return v.validate(ctx, pod)
+}
+
+func (v *PodCustomValidator) validate(ctx context.Context, pod *corev1.Pod) (warnings admission.Warnings, err error) {
+	// Egresses are not applied to Pods running in the host network.
+	if pod.Spec.HostNetwork {
+		return nil, nil
+	}
+
+	var podNS *corev1.Namespace
+	var ipv4, ipv6 bool
+	var egresses []*Egress
+	var allErrs field.ErrorList
+	p := field.NewPath("metadata", "annotations")
+	for _, key := range EgressesOf(pod.Annotations) {
+		if podNS == nil {
+			podNS = &corev1.Namespace{}
+			if err := v.Reader.Get(ctx, client.ObjectKey{Name: pod.Namespace}, podNS); err != nil {
+				return nil, apierrors.NewInternalError(err)
+			}
+			ipv4, ipv6, err = v.podFamilies(ctx, podNS)
+			if err != nil {
+				return nil, apierrors.NewInternalError(err)
+			}
+		}
+
+		annPath := p.Key(constants.AnnEgressPrefix + key.Namespace)
+		eg := &Egress{}
+		err := v.Reader.Get(ctx, key, eg)
+		if apierrors.IsNotFound(err) {
+			allErrs = append(allErrs, field.NotFound(annPath, key.Name))
+			continue
+		}
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+
+		ok, err := eg.Spec.AllowsClient(podNS, pod)
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		if !ok {
+			allErrs = append(allErrs, field.Forbidden(annPath,
+				fmt.Sprintf("the pod is not allowed to use Egress %s", key)))
+			continue
+		}
+
+		if (ipv4 || ipv6) && !eg.Spec.hasDestinationFor(ipv4, ipv6) {
+			allErrs = append(allErrs, field.Invalid(annPath, key.Name,
+				fmt.Sprintf("Egress %s has no destinations of the address family of the pod", key)))
+		}
+		egresses = append(egresses, eg)
+	}
+
+	if len(allErrs) != 0 {
+		return nil, apierrors.NewInvalid(schema.GroupKind{Kind: "Pod"}, pod.Name, allErrs)
+	}
+	return overlapWarnings(egresses), nil
+}
+
+// overlapWarnings returns warnings for the Egresses whose destinations overlap.
+func overlapWarnings(egresses []*Egress) admission.Warnings {
+	sort.Slice(egresses, func(i, j int) bool {
+		if egresses[i].Namespace != egresses[j].Namespace {
+			return egresses[i].Namespace < egresses[j].Namespace
+		}
+		return egresses[i].Name < egresses[j].Name
+	})
+
+	var warnings admission.Warnings
+	for i, a := range egresses {
+		for _, b := range egresses[i+1:] {
+			for _, da := range a.Spec.Destinations {
+				_, na, err := net.ParseCIDR(da)
+				if err != nil {
+					continue
+				}
+				for _, db := range b.Spec.Destinations {
+					_, nb, err := net.ParseCIDR(db)
+					if err != nil {
+						continue
+					}
+					if len(na.IP) != len(nb.IP) || !(na.Contains(nb.IP) || nb.Contains(na.IP)) {
+						continue
+					}
+					warnings = append(warnings, fmt.Sprintf(
+						"destinations of Egress %s/%s (priority %d) and %s/%s (priority %d) overlap: %s and %s",
+						a.Namespace, a.Name, a.Spec.Priority, b.Namespace, b.Name, b.Spec.Priority, da, db))
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (v *PodCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj *corev1.Pod) (warnings admission.Warnings, err error) {
+	// Do not block unrelated updates of Pods whose Egresses have been changed or removed.
+	if maps.Equal(egressAnnotations(oldObj), egressAnnotations(newObj)) {
+		return nil, nil
+	}
+	return v.validate(ctx, newObj)
+}
+
+func egressAnnotations(pod *corev1.Pod) map[string]string {
+	anns := make(map[string]string)
+	for k, v := range pod.Annotations {
+		if strings.HasPrefix(k, constants.AnnEgressPrefix) {
+			anns[k] = v
+		}
+	}
+	return anns
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (v *PodCustomValidator) ValidateDelete(ctx context.Context, pod *corev1.Pod) (warnings admission.Warnings, err error) {
+	return nil, nil
+}
+
+// podFamilies returns the address families of the pool for Pods in the namespace.
+// If the pool is not found, or AddressPool CRD is not installed because IPAM
+// is disabled, both are false.
+func (v *PodCustomValidator) podFamilies(ctx context.Context, ns *corev1.Namespace) (ipv4, ipv6 bool, err error) {
+	poolName := constants.DefaultPool
+	if name, ok := ns.Annotations[constants.AnnPool]; ok {
+		poolName = name
+	}
+
+	pool := &AddressPool{}
+	if err := v.Reader.Get(ctx, client.ObjectKey{Name: poolName}, pool); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	if len(pool.Spec.Subnets) == 0 {
+		return false, false, nil
+	}
+
+	ss := pool.Spec.Subnets[0]
+	return ss.IPv4 != nil, ss.IPv6 != nil, nil
+}
+
+// hasDestinationFor returns true if es has destinations in any of the given address families.
+func (es EgressSpec) hasDestinationFor(ipv4, ipv6 bool) bool {
+	for _, d := range es.Destinations {
+		_, subnet, err := net.ParseCIDR(d)
+		if err != nil {
+			continue
+		}
+		if subnet.IP.To4() != nil {
+			if ipv4 {
+				return true
+			}
+		} else if ipv6 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package v2
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+func makePod(name string, annotations map[string]string) *corev1.Pod {
+	pod := &corev1.Pod{}
+	pod.Name = name
+	pod.Namespace = "pod-webhook"
+	pod.Annotations = annotations
+	pod.Spec.Containers = []corev1.Container{{Name: "main", Image: "ghcr.io/cybozu/ubuntu"}}
+	return pod
+}
+
+// noPoolReader behaves as if AddressPool CRD is not installed.
+type noPoolReader struct {
+	client.Reader
+}
+
+func (r noPoolReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if _, ok := obj.(*AddressPool); ok {
+		return &meta.NoKindMatchError{GroupKind: GroupVersion.WithKind("AddressPool").GroupKind()}
+	}
+	return r.Reader.Get(ctx, key, obj, opts...)
+}
+
+var _ = Describe("Pod Webhook", func() {
+	ctx := context.TODO()
+
+	It("should validate the egress annotations", func() {
+		ap := &AddressPool{}
+		ap.Name = "pod-webhook"
+		ap.Spec.BlockSizeBits = 5
+		ap.Spec.Subnets = []SubnetSet{makeSubnetSet("10.9.0.0/24", "")}
+		err := k8sClient.Create(ctx, ap)
+		Expect(err).NotTo(HaveOccurred())
+
+		ns := &corev1.Namespace{}
+		ns.Name = "pod-webhook"
+		ns.Annotations = map[string]string{constants.AnnPool: "pod-webhook"}
+		err = k8sClient.Create(ctx, ns)
+		Expect(err).NotTo(HaveOccurred())
+
+		eg := makeEgress()
+		eg.Name = "pod-webhook-v4"
+		err = k8sClient.Create(ctx, eg)
+		Expect(err).NotTo(HaveOccurred())
+
+		eg = makeEgress()
+		eg.Name = "pod-webhook-v6"
+		eg.Spec.Destinations = []string{"fd02::/120"}
+		err = k8sClient.Create(ctx, eg)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("referring to an existing Egress")
+		pod := makePod("pod1", map[string]string{constants.AnnEgressPrefix + "default": "pod-webhook-v4"})
+		err = k8sClient.Create(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("referring to a nonexistent Egress")
+		pod = makePod("pod2", map[string]string{constants.AnnEgressPrefix + "default": "pod-webhook-v4,no-such-egress"})
+		err = k8sClient.Create(ctx, pod)
+		Expect(err).To(HaveOccurred())
+
+		pod = makePod("pod3", map[string]string{constants.AnnEgressPrefix + "no-such-ns": "pod-webhook-v4"})
+		err = k8sClient.Create(ctx, pod)
+		Expect(err).To(HaveOccurred())
+
+		By("referring to an Egress of another address family")
+		pod = makePod("pod4", map[string]string{constants.AnnEgressPrefix + "default": "pod-webhook-v6"})
+		err = k8sClient.Create(ctx, pod)
+		Expect(err).To(HaveOccurred())
+
+		By("referring to an Egress not allowed for the Pod")
+		eg = makeEgress()
+		eg.Name = "pod-webhook-restricted"
+		eg.Spec.AllowedClients = &EgressAllowedClients{
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"nat": "allowed"}},
+		}
+		err = k8sClient.Create(ctx, eg)
+		Expect(err).NotTo(HaveOccurred())
+
+		pod = makePod("pod6", map[string]string{constants.AnnEgressPrefix + "default": "pod-webhook-restricted"})
+		err = k8sClient.Create(ctx, pod)
+		Expect(err).To(HaveOccurred())
+
+		pod.Labels = map[string]string{"nat": "allowed"}
+		err = k8sClient.Create(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("creating a Pod in the host network")
+		pod = makePod("pod5", map[string]string{constants.AnnEgressPrefix + "default": "no-such-egress"})
+		pod.Spec.HostNetwork = true
+		err = k8sClient.Create(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("warning about Egresses with overlapping destinations")
+		eg = makeEgress()
+		eg.Name = "pod-webhook-overlap"
+		eg.Spec.Destinations = []string{"10.2.3.0/24"}
+		eg.Spec.Priority = 10
+		err = k8sClient.Create(ctx, eg)
+		Expect(err).NotTo(HaveOccurred())
+
+		v := &PodCustomValidator{Reader: k8sClient}
+		pod = makePod("pod7", map[string]string{constants.AnnEgressPrefix + "default": "pod-webhook-v4"})
+		warnings, err := v.ValidateCreate(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(BeEmpty())
+
+		pod = makePod("pod7", map[string]string{constants.AnnEgressPrefix + "default": "pod-webhook-v4,pod-webhook-overlap"})
+		warnings, err = v.ValidateCreate(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(ConsistOf(
+			"destinations of Egress default/pod-webhook-overlap (priority 10) and default/pod-webhook-v4 (priority 0) overlap: 10.2.3.0/24 and 10.2.0.0/16"))
+	})
+
+	It("should validate the egress annotations on update", func() {
+		pod := &corev1.Pod{}
+		err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "pod-webhook", Name: "pod1"}, pod)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("adding a reference to a nonexistent Egress")
+		pod.Annotations[constants.AnnEgressPrefix+"default"] = "pod-webhook-v4,no-such-egress"
+		err = k8sClient.Update(ctx, pod)
+		Expect(err).To(HaveOccurred())
+
+		By("updating a Pod whose Egress has been deleted")
+		eg := &Egress{}
+		err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "pod-webhook-v4"}, eg)
+		Expect(err).NotTo(HaveOccurred())
+		err = k8sClient.Delete(ctx, eg)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "pod-webhook", Name: "pod1"}, pod)
+		Expect(err).NotTo(HaveOccurred())
+		pod.Labels = map[string]string{"foo": "bar"}
+		err = k8sClient.Update(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should allow Pods when AddressPool CRD is not installed", func() {
+		v := &PodCustomValidator{Reader: noPoolReader{Reader: k8sClient}}
+		pod := makePod("pod8", map[string]string{constants.AnnEgressPrefix + "default": "pod-webhook-v6"})
+		_, err := v.ValidateCreate(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
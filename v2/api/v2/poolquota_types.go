@@ -0,0 +1,73 @@
+package v2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PoolQuotaSpec defines the desired state of PoolQuota
+type PoolQuotaSpec struct {
+	// PoolName is the name of the AddressPool to which this quota applies.
+	// +kubebuilder:validation:MinLength=1
+	PoolName string `json:"poolName"`
+
+	// MaxAddresses is the maximum number of addresses that Pods in the namespace
+	// can use from the pool.  A Pod in a dual stack pool counts as one.
+	// +kubebuilder:validation:Minimum=0
+	MaxAddresses int32 `json:"maxAddresses"`
+}
+
+// PoolQuotaStatus defines the observed state of PoolQuota
+type PoolQuotaStatus struct {
+	// Used is the number of addresses used by Pods in the namespace.
+	// This includes the addresses reserved in Reservations.
+	// +optional
+	Used int32 `json:"used"`
+
+	// Reservations are the addresses being allocated by coild for Pods
+	// whose addresses are not yet reported in their status.
+	// +optional
+	Reservations []PoolQuotaReservation `json:"reservations,omitempty"`
+}
+
+// PoolQuotaReservation represents an address reserved for a Pod.
+type PoolQuotaReservation struct {
+	// PodName is the name of the Pod.
+	PodName string `json:"podName"`
+
+	// ContainerID is the ID of the container for which the address is allocated.
+	ContainerID string `json:"containerID"`
+
+	// Time is when the address was reserved.
+	Time metav1.Time `json:"time"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:JSONPath=.spec.poolName,name=Pool,type=string
+// +kubebuilder:printcolumn:JSONPath=.spec.maxAddresses,name=Max,type=integer
+// +kubebuilder:printcolumn:JSONPath=.status.used,name=Used,type=integer
+
+// PoolQuota is the Schema for the poolquotas API
+//
+// PoolQuota limits the number of addresses that Pods in the namespace
+// can use from an AddressPool.
+type PoolQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PoolQuotaSpec   `json:"spec,omitempty"`
+	Status PoolQuotaStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// PoolQuotaList contains a list of PoolQuota
+type PoolQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PoolQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PoolQuota{}, &PoolQuotaList{})
+}
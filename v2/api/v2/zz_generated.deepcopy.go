@@ -5,8 +5,9 @@
 package v2
 
 import (
-	v1 "k8s.io/api/apps/v1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
@@ -176,6 +177,21 @@ func (in *AddressPoolSpec) DeepCopyInto(out *AddressPoolSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Tuning != nil {
+		in, out := &in.Tuning, &out.Tuning
+		*out = new(PodNetworkTuning)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.QuarantinePeriod != nil {
+		in, out := &in.QuarantinePeriod, &out.QuarantinePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AddressPoolSpec.
@@ -329,31 +345,46 @@ func (in *Egress) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *EgressCustomDefaulter) DeepCopyInto(out *EgressCustomDefaulter) {
+func (in *EgressAllowedClients) DeepCopyInto(out *EgressAllowedClients) {
 	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressCustomDefaulter.
-func (in *EgressCustomDefaulter) DeepCopy() *EgressCustomDefaulter {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressAllowedClients.
+func (in *EgressAllowedClients) DeepCopy() *EgressAllowedClients {
 	if in == nil {
 		return nil
 	}
-	out := new(EgressCustomDefaulter)
+	out := new(EgressAllowedClients)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *EgressCustomValidator) DeepCopyInto(out *EgressCustomValidator) {
+func (in *EgressCustomDefaulter) DeepCopyInto(out *EgressCustomDefaulter) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressCustomValidator.
-func (in *EgressCustomValidator) DeepCopy() *EgressCustomValidator {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressCustomDefaulter.
+func (in *EgressCustomDefaulter) DeepCopy() *EgressCustomDefaulter {
 	if in == nil {
 		return nil
 	}
-	out := new(EgressCustomValidator)
+	out := new(EgressCustomDefaulter)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -442,7 +473,7 @@ func (in *EgressSpec) DeepCopyInto(out *EgressSpec) {
 	}
 	if in.Strategy != nil {
 		in, out := &in.Strategy, &out.Strategy
-		*out = new(v1.DeploymentStrategy)
+		*out = new(appsv1.DeploymentStrategy)
 		(*in).DeepCopyInto(*out)
 	}
 	if in.Template != nil {
@@ -460,6 +491,31 @@ func (in *EgressSpec) DeepCopyInto(out *EgressSpec) {
 		*out = new(EgressPDBSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.DrainTimeout != nil {
+		in, out := &in.DrainTimeout, &out.DrainTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.TopologySpread != nil {
+		in, out := &in.TopologySpread, &out.TopologySpread
+		*out = new(EgressTopologySpread)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TrafficDistribution != nil {
+		in, out := &in.TrafficDistribution, &out.TrafficDistribution
+		*out = new(string)
+		**out = **in
+	}
+	if in.AllowedClients != nil {
+		in, out := &in.AllowedClients, &out.AllowedClients
+		*out = new(EgressAllowedClients)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.StateSync != nil {
+		in, out := &in.StateSync, &out.StateSync
+		*out = new(EgressStateSync)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressSpec.
@@ -472,6 +528,26 @@ func (in *EgressSpec) DeepCopy() *EgressSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressStateSync) DeepCopyInto(out *EgressStateSync) {
+	*out = *in
+	if in.SourceIPs != nil {
+		in, out := &in.SourceIPs, &out.SourceIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressStateSync.
+func (in *EgressStateSync) DeepCopy() *EgressStateSync {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressStateSync)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EgressStatus) DeepCopyInto(out *EgressStatus) {
 	*out = *in
@@ -487,6 +563,26 @@ func (in *EgressStatus) DeepCopy() *EgressStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressTopologySpread) DeepCopyInto(out *EgressTopologySpread) {
+	*out = *in
+	if in.TopologyKeys != nil {
+		in, out := &in.TopologyKeys, &out.TopologyKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressTopologySpread.
+func (in *EgressTopologySpread) DeepCopy() *EgressTopologySpread {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressTopologySpread)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Metadata) DeepCopyInto(out *Metadata) {
 	*out = *in
@@ -516,6 +612,160 @@ func (in *Metadata) DeepCopy() *Metadata {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodNetworkTuning) DeepCopyInto(out *PodNetworkTuning) {
+	*out = *in
+	if in.Sysctls != nil {
+		in, out := &in.Sysctls, &out.Sysctls
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.GRO != nil {
+		in, out := &in.GRO, &out.GRO
+		*out = new(bool)
+		**out = **in
+	}
+	if in.GSO != nil {
+		in, out := &in.GSO, &out.GSO
+		*out = new(bool)
+		**out = **in
+	}
+	if in.TSO != nil {
+		in, out := &in.TSO, &out.TSO
+		*out = new(bool)
+		**out = **in
+	}
+	if in.TxQueueLen != nil {
+		in, out := &in.TxQueueLen, &out.TxQueueLen
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodNetworkTuning.
+func (in *PodNetworkTuning) DeepCopy() *PodNetworkTuning {
+	if in == nil {
+		return nil
+	}
+	out := new(PodNetworkTuning)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolQuota) DeepCopyInto(out *PoolQuota) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolQuota.
+func (in *PoolQuota) DeepCopy() *PoolQuota {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolQuota)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PoolQuota) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolQuotaList) DeepCopyInto(out *PoolQuotaList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PoolQuota, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolQuotaList.
+func (in *PoolQuotaList) DeepCopy() *PoolQuotaList {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolQuotaList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PoolQuotaList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolQuotaReservation) DeepCopyInto(out *PoolQuotaReservation) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolQuotaReservation.
+func (in *PoolQuotaReservation) DeepCopy() *PoolQuotaReservation {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolQuotaReservation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolQuotaSpec) DeepCopyInto(out *PoolQuotaSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolQuotaSpec.
+func (in *PoolQuotaSpec) DeepCopy() *PoolQuotaSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolQuotaSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolQuotaStatus) DeepCopyInto(out *PoolQuotaStatus) {
+	*out = *in
+	if in.Reservations != nil {
+		in, out := &in.Reservations, &out.Reservations
+		*out = make([]PoolQuotaReservation, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolQuotaStatus.
+func (in *PoolQuotaStatus) DeepCopy() *PoolQuotaStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolQuotaStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SubnetSet) DeepCopyInto(out *SubnetSet) {
 	*out = *in
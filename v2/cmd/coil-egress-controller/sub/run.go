@@ -117,11 +117,12 @@ func setupManager(mgr ctrl.Manager) error {
 	backend := config.backend
 
 	egressctrl := controllers.EgressReconciler{
-		Client:  mgr.GetClient(),
-		Scheme:  scheme,
-		Image:   img,
-		Port:    config.egressPort,
-		Backend: backend,
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Scheme:    scheme,
+		Image:     img,
+		Port:      config.egressPort,
+		Backend:   backend,
 	}
 	if err := egressctrl.SetupWithManager(mgr); err != nil {
 		return err
@@ -135,6 +136,9 @@ func setupManager(mgr ctrl.Manager) error {
 	if err := (&coilv2.Egress{}).SetupWebhookWithManager(mgr); err != nil {
 		return err
 	}
+	if err := coilv2.SetupPodWebhookWithManager(mgr); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -0,0 +1,62 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// drainer delays the shutdown of coil-egress so that NAT keeps working
+// for packets sent to the pod after it started terminating.
+//
+// Clients stop sending packets to a terminating pod once its endpoint is
+// removed from the Service, because kube-proxy flushes the conntrack
+// entries of the UDP tunnels to the pod.  Existing flows survive only if
+// the other egress pods take them over with state sync, so draining is
+// enabled only with state sync.
+//
+// While draining, the readiness probe fails too.
+type drainer struct {
+	timeout  time.Duration
+	draining atomic.Bool
+}
+
+// Checker is a readiness check that fails while draining.
+func (d *drainer) Checker(_ *http.Request) error {
+	if d.draining.Load() {
+		return errors.New("draining")
+	}
+	return nil
+}
+
+// SignalHandler returns a context that is canceled when the drain timeout
+// expires after SIGTERM or SIGINT.  A second signal cancels it immediately,
+// and a third one terminates the program with exit code 1.
+func (d *drainer) SignalHandler() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := make(chan os.Signal, 3)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		if d.timeout > 0 {
+			d.draining.Store(true)
+			setupLog.Info("start draining", "timeout", d.timeout)
+			select {
+			case <-c:
+			case <-time.After(d.timeout):
+			}
+			setupLog.Info("finish draining")
+		}
+		cancel()
+		<-c
+		os.Exit(1)
+	}()
+
+	return ctx
+}
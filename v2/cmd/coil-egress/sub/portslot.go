@@ -0,0 +1,132 @@
+package sub
+
+import (
+	"context"
+	"fmt"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+	"github.com/cybozu-go/coil/v2/pkg/nat/netfilter"
+)
+
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;create;delete
+
+const (
+	minSourcePort = 1024
+	maxSourcePort = 65535
+
+	// maxClaimAttempts limits the attempts to take over a slot of a lost pod.
+	maxClaimAttempts = 3
+)
+
+// sourcePortRange returns the range of source ports for the slot.
+func sourcePortRange(slot, slots int) *netfilter.PortRange {
+	size := (maxSourcePort - minSourcePort + 1) / slots
+	lo := minSourcePort + slot*size
+	return &netfilter.PortRange{Min: uint16(lo), Max: uint16(lo + size - 1)}
+}
+
+func portSlotName(egressName string, slot int) string {
+	return fmt.Sprintf("%s-port-%d", egressName, slot)
+}
+
+// claimPortSlot claims one of the slots of source ports shared by the egress
+// pods of an Egress and returns its number.
+//
+// A slot is held by a Lease owned by the pod, so it is released when the pod
+// is deleted.  A pod that restarts gets the same slot again.  Slots held by
+// pods that are gone or finished are taken over.
+func claimPortSlot(ctx context.Context, c client.Client, reader client.Reader, namespace, egressName, podName string, slots int) (int, error) {
+	pod := &corev1.Pod{}
+	if err := reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: podName}, pod); err != nil {
+		return 0, fmt.Errorf("failed to get pod %s: %w", podName, err)
+	}
+
+	for slot := 0; slot < slots; slot++ {
+		for range maxClaimAttempts {
+			claimed, retry, err := tryClaimPortSlot(ctx, c, reader, pod, portSlotName(egressName, slot), egressName)
+			if err != nil {
+				return 0, err
+			}
+			if claimed {
+				return slot, nil
+			}
+			if !retry {
+				break
+			}
+		}
+	}
+	return 0, fmt.Errorf("all %d source port slots are in use", slots)
+}
+
+func tryClaimPortSlot(ctx context.Context, c client.Client, reader client.Reader, pod *corev1.Pod, name, egressName string) (claimed, retry bool, err error) {
+	lease := &coordinationv1.Lease{}
+	lease.Namespace = pod.Namespace
+	lease.Name = name
+	lease.Labels = map[string]string{
+		constants.LabelAppName:      "coil",
+		constants.LabelAppInstance:  egressName,
+		constants.LabelAppComponent: "egress",
+	}
+	lease.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}}
+	lease.Spec.HolderIdentity = ptr.To(pod.Name)
+	err = c.Create(ctx, lease)
+	if err == nil {
+		return true, false, nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return false, false, fmt.Errorf("failed to create lease %s: %w", name, err)
+	}
+
+	current := &coordinationv1.Lease{}
+	if err := reader.Get(ctx, client.ObjectKeyFromObject(lease), current); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, true, nil
+		}
+		return false, false, fmt.Errorf("failed to get lease %s: %w", name, err)
+	}
+	holder := ptr.Deref(current.Spec.HolderIdentity, "")
+	if holder == pod.Name && ownedBy(current, pod.UID) {
+		return true, false, nil
+	}
+
+	holderPod := &corev1.Pod{}
+	err = reader.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: holder}, holderPod)
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return false, false, fmt.Errorf("failed to get pod %s: %w", holder, err)
+	case !ownedBy(current, holderPod.UID):
+	case holderPod.Status.Phase == corev1.PodSucceeded || holderPod.Status.Phase == corev1.PodFailed:
+	default:
+		return false, false, nil
+	}
+
+	// the holder is gone.  delete the lease unless someone else has taken it over.
+	err = c.Delete(ctx, current, client.Preconditions{UID: &current.UID, ResourceVersion: &current.ResourceVersion})
+	if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+		return false, false, fmt.Errorf("failed to delete lease %s: %w", name, err)
+	}
+	return false, true, nil
+}
+
+func ownedBy(obj metav1.Object, uid types.UID) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == uid {
+			return true
+		}
+	}
+	return false
+}
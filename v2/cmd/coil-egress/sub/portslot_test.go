@@ -0,0 +1,99 @@
+package sub
+
+import (
+	"context"
+	"testing"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testEgressPod(name string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name, UID: types.UID(name + "-uid")},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestSourcePortRange(t *testing.T) {
+	t.Parallel()
+
+	const slots = 16
+	prev := sourcePortRange(0, slots)
+	if prev.Min != minSourcePort {
+		t.Errorf("the first range should start from %d: %d", minSourcePort, prev.Min)
+	}
+	for slot := 1; slot < slots; slot++ {
+		r := sourcePortRange(slot, slots)
+		if r.Min != prev.Max+1 || r.Min > r.Max {
+			t.Errorf("ranges should be disjoint and contiguous: %+v, %+v", prev, r)
+		}
+		prev = r
+	}
+	if prev.Max > maxSourcePort {
+		t.Errorf("the last range exceeds %d: %d", maxSourcePort, prev.Max)
+	}
+}
+
+func TestClaimPortSlot(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	pod1 := testEgressPod("egress-1", corev1.PodRunning)
+	pod2 := testEgressPod("egress-2", corev1.PodRunning)
+	pod3 := testEgressPod("egress-3", corev1.PodRunning)
+	pod4 := testEgressPod("egress-4", corev1.PodRunning)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod1, pod2, pod3, pod4).Build()
+
+	claim := func(podName string) (int, error) {
+		return claimPortSlot(ctx, c, c, "ns", "egress", podName, 2)
+	}
+
+	slot, err := claim("egress-1")
+	if err != nil || slot != 0 {
+		t.Fatalf("egress-1 should get slot 0: %d, %v", slot, err)
+	}
+	lease := &coordinationv1.Lease{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "egress-port-0"}, lease); err != nil {
+		t.Fatal(err)
+	}
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != "egress-1" || !ownedBy(lease, pod1.UID) {
+		t.Errorf("the lease should be held and owned by egress-1: %+v", lease)
+	}
+
+	slot, err = claim("egress-1")
+	if err != nil || slot != 0 {
+		t.Errorf("restarted egress-1 should get the same slot: %d, %v", slot, err)
+	}
+
+	slot, err = claim("egress-2")
+	if err != nil || slot != 1 {
+		t.Fatalf("egress-2 should get slot 1: %d, %v", slot, err)
+	}
+
+	if _, err := claim("egress-3"); err == nil {
+		t.Error("egress-3 should not get a slot while all slots are in use")
+	}
+
+	if err := c.Delete(ctx, pod1); err != nil {
+		t.Fatal(err)
+	}
+	slot, err = claim("egress-3")
+	if err != nil || slot != 0 {
+		t.Errorf("egress-3 should take over the slot of deleted egress-1: %d, %v", slot, err)
+	}
+
+	pod2.Status.Phase = corev1.PodFailed
+	if err := c.Status().Update(ctx, pod2); err != nil {
+		t.Fatal(err)
+	}
+	slot, err = claim("egress-4")
+	if err != nil || slot != 1 {
+		t.Errorf("egress-4 should take over the slot of failed egress-2: %d, %v", slot, err)
+	}
+}
@@ -1,9 +1,12 @@
 package sub
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"k8s.io/klog/v2"
@@ -19,6 +22,12 @@ var config struct {
 	port            int
 	enableSportAuto bool
 	backend         string
+	drainTimeout    time.Duration
+	sourceIPs       []net.IP
+	enableStateSync bool
+	stateSyncPort   int
+	stateSyncCert   string
+	enableTransit   bool
 	zapOpts         zap.Options
 }
 
@@ -32,6 +41,15 @@ var rootCmd = &cobra.Command{
 			return fmt.Errorf("invalid backend: %s (must be either %s or %s)",
 				config.backend, constants.EgressBackendIPTables, constants.EgressBackendNFTables)
 		}
+		if config.drainTimeout < 0 {
+			return fmt.Errorf("invalid drain timeout: %s", config.drainTimeout)
+		}
+		if config.drainTimeout > 0 && !config.enableStateSync {
+			return errors.New("--drain-timeout requires --enable-state-sync")
+		}
+		if config.enableStateSync && len(config.sourceIPs) == 0 {
+			return errors.New("--source-ips is required for state sync")
+		}
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, _ []string) error {
@@ -56,6 +74,12 @@ func init() {
 	pf.IntVar(&config.port, "fou-port", 5555, "port number for foo-over-udp tunnels")
 	pf.BoolVar(&config.enableSportAuto, "enable-sport-auto", false, "enable automatic source port assignment")
 	pf.StringVar(&config.backend, "backend", constants.DefaultEgressBackend, "Backend for egress NAT rules: iptables or nftables (default: iptables)")
+	pf.DurationVar(&config.drainTimeout, "drain-timeout", 0, "duration to keep NAT for existing flows after receiving SIGTERM.  This requires --enable-state-sync")
+	pf.IPSliceVar(&config.sourceIPs, "source-ips", nil, "source addresses of SNAT shared by egress pods instead of the pod addresses")
+	pf.BoolVar(&config.enableStateSync, "enable-state-sync", false, "synchronize NAT state with other egress pods")
+	pf.IntVar(&config.stateSyncPort, "state-sync-port", 5556, "port number for NAT state synchronization")
+	pf.StringVar(&config.stateSyncCert, "state-sync-cert-dir", "", "directory of the key pair shared by egress pods for NAT state synchronization.  If empty, the synchronization is not encrypted nor authenticated")
+	pf.BoolVar(&config.enableTransit, "enable-transit", false, "forward the traffic of clients to other egresses used by this egress")
 
 	goflags := flag.NewFlagSet("klog", flag.ExitOnError)
 	klog.InitFlags(goflags)
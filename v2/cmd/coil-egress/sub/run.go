@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,15 +19,20 @@ import (
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	v2 "github.com/cybozu-go/coil/v2"
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
 	"github.com/cybozu-go/coil/v2/controllers"
 	"github.com/cybozu-go/coil/v2/pkg/constants"
 	"github.com/cybozu-go/coil/v2/pkg/fou"
 	egressMetrics "github.com/cybozu-go/coil/v2/pkg/metrics"
 	"github.com/cybozu-go/coil/v2/pkg/nat/netfilter"
+	"github.com/cybozu-go/coil/v2/pkg/statesync"
 )
 
 const (
 	gracefulTimeout = 5 * time.Second
+
+	// stateSyncDrainTimeout is the minimum drain timeout with state sync.
+	stateSyncDrainTimeout = 5 * time.Second
 )
 
 var (
@@ -36,6 +42,7 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(coilv2.AddToScheme(scheme))
 
 	// +kubebuilder:scaffold:scheme
 
@@ -107,23 +114,89 @@ func subMain() error {
 		return err
 	}
 
-	setupLog.Info("initialize Egress", "ipv4", ipv4.String(), "ipv6", ipv6.String(), "backend", config.backend)
-	nat, err := netfilter.NewNatServer("eth0", ipv4, ipv6, config.backend)
+	var srcIPv4, srcIPv6 net.IP
+	for _, ip := range config.sourceIPs {
+		if ip4 := ip.To4(); ip4 != nil {
+			srcIPv4 = ip4
+		} else {
+			srcIPv6 = ip
+		}
+	}
+
+	// egress pods sharing the source addresses must not assign the same
+	// tuple to different flows, so each pod uses its own source ports.
+	var srcPorts *netfilter.PortRange
+	if len(config.sourceIPs) > 0 {
+		slot, err := claimPortSlot(context.Background(), mgr.GetClient(), mgr.GetAPIReader(),
+			myNS, myName, os.Getenv("HOSTNAME"), constants.SourcePortSlots)
+		if err != nil {
+			return err
+		}
+		srcPorts = sourcePortRange(slot, constants.SourcePortSlots)
+		setupLog.Info("claimed source ports", "slot", slot, "min", srcPorts.Min, "max", srcPorts.Max)
+	}
+
+	setupLog.Info("initialize Egress", "ipv4", ipv4.String(), "ipv6", ipv6.String(), "backend", config.backend,
+		"source_ipv4", srcIPv4.String(), "source_ipv6", srcIPv6.String(), "transit", config.enableTransit)
+	nat, err := netfilter.NewNatServerWithOptions("eth0", ipv4, ipv6, config.backend, netfilter.NatServerOptions{
+		SourceIPv4:  srcIPv4,
+		SourceIPv6:  srcIPv6,
+		SourcePorts: srcPorts,
+		Transit:     config.enableTransit,
+	})
 	if err != nil {
 		return err
 	}
 
+	if config.enableStateSync {
+		setupLog.Info("setup NAT state sync", "port", config.stateSyncPort, "cert_dir", config.stateSyncCert)
+		creds := statesync.InsecureCredentials()
+		if config.stateSyncCert != "" {
+			creds, err = statesync.LoadCredentials(config.stateSyncCert)
+			if err != nil {
+				return err
+			}
+		} else {
+			setupLog.Info("WARNING: NAT state sync is neither encrypted nor authenticated")
+		}
+		addr := net.JoinHostPort("", strconv.Itoa(config.stateSyncPort))
+		peers := statePeerLister(mgr.GetClient(), myNS, myName, os.Getenv("HOSTNAME"), config.stateSyncPort)
+		server, syncer := statesync.New(addr, statesync.NewTable(), config.sourceIPs, peers, creds, ctrl.Log.WithName("state-sync"))
+		if err := mgr.Add(server); err != nil {
+			return err
+		}
+		if err := mgr.Add(syncer); err != nil {
+			return err
+		}
+	}
+
 	setupLog.Info("setup Pod watcher")
 	podSyncChecker, err := controllers.SetupPodWatcher(mgr, myNS, myName, ft, config.enableSportAuto, nat)
 	if err != nil {
 		return err
 	}
+
+	setupLog.Info("setup Node watcher")
+	if err := controllers.SetupNodeWatcher(mgr, myNS, myName, ft, config.enableSportAuto, nat); err != nil {
+		return err
+	}
+
 	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
 		return err
 	}
 	if err := mgr.AddReadyzCheck("pod-sync", podSyncChecker); err != nil {
 		return err
 	}
+	drainTimeout := config.drainTimeout
+	if config.enableStateSync && drainTimeout < stateSyncDrainTimeout {
+		// other egress pods take over the flows of this pod only if they have
+		// received the latest conntrack entries.
+		drainTimeout = stateSyncDrainTimeout
+	}
+	drainer := &drainer{timeout: drainTimeout}
+	if err := mgr.AddReadyzCheck("drain", drainer.Checker); err != nil {
+		return err
+	}
 
 	setupLog.Info("setup egress metrics collector")
 	runner := egressMetrics.NewRunner()
@@ -135,7 +208,7 @@ func subMain() error {
 	go runner.Run(context.Background())
 
 	setupLog.Info("starting manager", "version", v2.Version())
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(drainer.SignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
 		return err
 	}
@@ -0,0 +1,38 @@
+package sub
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+	"github.com/cybozu-go/coil/v2/pkg/statesync"
+)
+
+// statePeerLister returns a PeerLister that lists the other egress pods of the Egress.
+// Terminating pods are included because they may be draining flows.
+func statePeerLister(c client.Reader, namespace, egressName, podName string, port int) statesync.PeerLister {
+	return func(ctx context.Context) ([]string, error) {
+		pods := &corev1.PodList{}
+		err := c.List(ctx, pods, client.InNamespace(namespace), client.MatchingLabels{
+			constants.LabelAppName:      "coil",
+			constants.LabelAppInstance:  egressName,
+			constants.LabelAppComponent: "egress",
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var addrs []string
+		for _, pod := range pods.Items {
+			if pod.Name == podName || pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+				continue
+			}
+			addrs = append(addrs, net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(port)))
+		}
+		return addrs, nil
+	}
+}
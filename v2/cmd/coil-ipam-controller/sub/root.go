@@ -24,6 +24,7 @@ var config struct {
 
 	enableCertRotation         bool
 	enableRestartOnCertRefresh bool
+	enableActiveActive         bool
 }
 
 var rootCmd = &cobra.Command{
@@ -55,6 +56,7 @@ func init() {
 	pf.DurationVar(&config.gcInterval, "gc-interval", 1*time.Hour, "garbage collection interval")
 	pf.BoolVar(&config.enableCertRotation, "enable-cert-rotation", constants.DefaultEnableCertRotation, "enables webhook's certificate generation")
 	pf.BoolVar(&config.enableRestartOnCertRefresh, "enable-restart-on-cert-refresh", constants.DefaultEnableRestartOnCertRefresh, "enables pod's restart on webhook certificate refresh")
+	pf.BoolVar(&config.enableActiveActive, "enable-active-active", constants.DefaultEnableActiveActive, "allocate address blocks on all replicas without leader election")
 
 	goflags := flag.NewFlagSet("klog", flag.ExitOnError)
 	klog.InitFlags(goflags)
@@ -22,6 +22,7 @@ import (
 	"github.com/cybozu-go/coil/v2/pkg/cert"
 	"github.com/cybozu-go/coil/v2/pkg/indexing"
 	"github.com/cybozu-go/coil/v2/pkg/ipam"
+	"github.com/cybozu-go/coil/v2/pkg/tracing"
 	"github.com/cybozu-go/coil/v2/runners"
 )
 
@@ -99,6 +100,12 @@ func subMain() error {
 
 	ctx := ctrl.SetupSignalHandler()
 
+	shutdownTracing, err := tracing.Setup(ctx, "coil-ipam-controller")
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
 	if err := setupManager(ctx, mgr); err != nil {
 		return err
 	}
@@ -112,9 +119,10 @@ func setupManager(ctx context.Context, mgr ctrl.Manager) error {
 
 	pm := ipam.NewPoolManager(mgr.GetClient(), mgr.GetAPIReader(), ctrl.Log.WithName("pool-manager"), scheme)
 	apctrl := controllers.AddressPoolReconciler{
-		Client:  mgr.GetClient(),
-		Scheme:  scheme,
-		Manager: pm,
+		Client:       mgr.GetClient(),
+		Scheme:       scheme,
+		Manager:      pm,
+		ActiveActive: config.enableActiveActive,
 	}
 	if err := apctrl.SetupWithManager(mgr); err != nil {
 		return err
@@ -125,19 +133,31 @@ func setupManager(ctx context.Context, mgr ctrl.Manager) error {
 	}
 
 	brctrl := controllers.BlockRequestReconciler{
-		Client:  mgr.GetClient(),
-		Scheme:  scheme,
-		Manager: pm,
+		Client:       mgr.GetClient(),
+		Scheme:       scheme,
+		Manager:      pm,
+		Recorder:     mgr.GetEventRecorder("coil-ipam-controller"),
+		ActiveActive: config.enableActiveActive,
 	}
 	if err := brctrl.SetupWithManager(mgr); err != nil {
 		return err
 	}
 
+	pqctrl := controllers.PoolQuotaReconciler{
+		Client: mgr.GetClient(),
+	}
+	if err := pqctrl.SetupWithManager(mgr); err != nil {
+		return err
+	}
+
 	// register webhooks
 
 	if err := (&coilv2.AddressPool{}).SetupWebhookWithManager(mgr); err != nil {
 		return err
 	}
+	if err := coilv2.SetupNamespaceWebhookWithManager(mgr); err != nil {
+		return err
+	}
 
 	// other runners
 
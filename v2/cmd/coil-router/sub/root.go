@@ -18,6 +18,8 @@ var config struct {
 	healthAddr     string
 	protocolId     int
 	updateInterval time.Duration
+	ipv4ViaIPv6    bool
+	nexthopIface   string
 	zapOpts        zap.Options
 }
 
@@ -53,6 +55,8 @@ func init() {
 	pf.StringVar(&config.healthAddr, "health-addr", ":9389", "bind address of health/readiness probes")
 	pf.IntVar(&config.protocolId, "protocol-id", 31, "route author ID")
 	pf.DurationVar(&config.updateInterval, "update-interval", 10*time.Minute, "interval for forced route update")
+	pf.BoolVar(&config.ipv4ViaIPv6, "ipv4-via-ipv6", false, "route IPv4 blocks via IPv6 next-hops for nodes without IPv4 address")
+	pf.StringVar(&config.nexthopIface, "nexthop-interface", "", "network interface to reach IPv6 link-local next-hops")
 
 	goflags := flag.NewFlagSet("klog", flag.ExitOnError)
 	klog.InitFlags(goflags)
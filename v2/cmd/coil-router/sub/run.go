@@ -73,8 +73,8 @@ func subMain() error {
 		return err
 	}
 
-	syncer := nodenet.NewRouteSyncer(config.protocolId, ctrl.Log.WithName("route-syncer"))
-	router := runners.NewRouter(mgr, ctrl.Log.WithName("router"), nodeName, notifyCh, syncer, config.updateInterval)
+	syncer := nodenet.NewRouteSyncer(config.protocolId, config.nexthopIface, ctrl.Log.WithName("route-syncer"))
+	router := runners.NewRouter(mgr, ctrl.Log.WithName("router"), nodeName, notifyCh, syncer, config.updateInterval, config.ipv4ViaIPv6)
 	if err := mgr.Add(router); err != nil {
 		return err
 	}
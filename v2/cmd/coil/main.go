@@ -12,6 +12,7 @@ import (
 
 	v2 "github.com/cybozu-go/coil/v2"
 	"github.com/cybozu-go/coil/v2/pkg/cnirpc"
+	"github.com/cybozu-go/coil/v2/pkg/tracing"
 )
 
 const (
@@ -38,6 +39,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 	client := cnirpc.NewCNIClient(conn)
 	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
 	defer cancel()
+	defer setupTracing(ctx)()
 
 	resp, err := client.Add(ctx, cniArgs)
 	if err != nil {
@@ -78,6 +80,7 @@ func cmdDel(args *skel.CmdArgs) error {
 	client := cnirpc.NewCNIClient(conn)
 	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
 	defer cancel()
+	defer setupTracing(ctx)()
 
 	if _, err = client.Del(ctx, cniArgs); err != nil {
 		return convertError(err)
@@ -106,6 +109,7 @@ func cmdCheck(args *skel.CmdArgs) error {
 	client := cnirpc.NewCNIClient(conn)
 	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
 	defer cancel()
+	defer setupTracing(ctx)()
 
 	if _, err = client.Check(ctx, cniArgs); err != nil {
 		return convertError(err)
@@ -114,6 +118,19 @@ func cmdCheck(args *skel.CmdArgs) error {
 	return nil
 }
 
+// setupTracing enables tracing for the plugin invocation and returns
+// a function to flush spans.  Tracing is best-effort; failures are
+// ignored not to break the Pod network setup.
+func setupTracing(ctx context.Context) func() {
+	shutdown, err := tracing.Setup(ctx, "coil")
+	if err != nil {
+		return func() {}
+	}
+	return func() {
+		shutdown(ctx)
+	}
+}
+
 func main() {
 	skel.PluginMainFuncs(skel.CNIFuncs{Add: cmdAdd, Del: cmdDel, Check: cmdCheck, GC: nil, Status: nil}, version.PluginSupports("0.3.1", "0.4.0", "1.0.0"), fmt.Sprintf("coil %s", v2.Version()))
 }
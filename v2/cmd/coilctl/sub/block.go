@@ -0,0 +1,79 @@
+package sub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+var blockConfig struct {
+	node string
+	pool string
+}
+
+var blockCmd = &cobra.Command{
+	Use:   "block",
+	Short: "inspect AddressBlocks",
+}
+
+var blockLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "list address blocks",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cmd.SilenceUsage = true
+		cl, err := newClient()
+		if err != nil {
+			return err
+		}
+		return runBlockLs(cmd.Context(), cl, cmd.OutOrStdout(), blockConfig.node, blockConfig.pool)
+	},
+}
+
+func init() {
+	blockLsCmd.Flags().StringVar(&blockConfig.node, "node", "", "show only blocks owned by this node")
+	blockLsCmd.Flags().StringVar(&blockConfig.pool, "pool", "", "show only blocks of this pool")
+	blockCmd.AddCommand(blockLsCmd)
+	rootCmd.AddCommand(blockCmd)
+}
+
+func runBlockLs(ctx context.Context, cl client.Client, w io.Writer, node, pool string) error {
+	labels := client.MatchingLabels{}
+	if node != "" {
+		labels[constants.LabelNode] = node
+	}
+	if pool != "" {
+		labels[constants.LabelPool] = pool
+	}
+
+	blocks := &coilv2.AddressBlockList{}
+	if err := cl.List(ctx, blocks, labels); err != nil {
+		return err
+	}
+	slices.SortFunc(blocks.Items, func(a, b coilv2.AddressBlock) int {
+		if a.Labels[constants.LabelPool] != b.Labels[constants.LabelPool] {
+			if a.Labels[constants.LabelPool] < b.Labels[constants.LabelPool] {
+				return -1
+			}
+			return 1
+		}
+		return int(a.Index - b.Index)
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPOOL\tNODE\tINDEX\tIPV4\tIPV6")
+	for _, b := range blocks.Items {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", b.Name, b.Labels[constants.LabelPool], b.Labels[constants.LabelNode],
+			b.Index, ptr.Deref(b.IPv4, "-"), ptr.Deref(b.IPv6, "-"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,88 @@
+package sub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+var egressConfig struct {
+	namespace string
+}
+
+var egressCmd = &cobra.Command{
+	Use:   "egress",
+	Short: "inspect Egresses",
+}
+
+var egressClientsCmd = &cobra.Command{
+	Use:   "clients EGRESS",
+	Short: "list client Pods of an Egress",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		cl, err := newClient()
+		if err != nil {
+			return err
+		}
+		return runEgressClients(cmd.Context(), cl, cmd.OutOrStdout(), egressConfig.namespace, args[0])
+	},
+}
+
+func init() {
+	egressClientsCmd.Flags().StringVarP(&egressConfig.namespace, "namespace", "n", "default", "namespace of the Egress")
+	egressCmd.AddCommand(egressClientsCmd)
+	rootCmd.AddCommand(egressCmd)
+}
+
+func runEgressClients(ctx context.Context, cl client.Client, w io.Writer, namespace, name string) error {
+	eg := &coilv2.Egress{}
+	if err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, eg); err != nil {
+		return err
+	}
+
+	pods := &corev1.PodList{}
+	if err := cl.List(ctx, pods); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tNAME\tNODE\tIPS")
+	for _, pod := range pods.Items {
+		if !isEgressClient(&pod, namespace, name) {
+			continue
+		}
+		var ips []string
+		for _, podIP := range pod.Status.PodIPs {
+			ips = append(ips, podIP.IP)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", pod.Namespace, pod.Name, pod.Spec.NodeName, strings.Join(ips, ","))
+	}
+	return tw.Flush()
+}
+
+// isEgressClient returns true if pod is annotated to use the Egress.
+func isEgressClient(pod *corev1.Pod, namespace, name string) bool {
+	if pod.Spec.HostNetwork {
+		return false
+	}
+	v, ok := pod.Annotations[constants.AnnEgressPrefix+namespace]
+	if !ok {
+		return false
+	}
+	for _, n := range strings.Split(v, ",") {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,336 @@
+package sub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/cobra"
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+	"github.com/cybozu-go/coil/v2/pkg/nodenet"
+)
+
+var nodeConfig struct {
+	node          string
+	podTableId    int
+	podRulePrio   int
+	exportTableId int
+	protocolId    int
+	fix           bool
+}
+
+var nodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "inspect nodes",
+}
+
+var nodeDiagnoseCmd = &cobra.Command{
+	Use:   "diagnose",
+	Short: "compare kernel routes and rules of this node with the expected state",
+	Long: `Compare kernel routes and rules of this node with the state
+expected from AddressBlocks and Pods.
+
+With --fix, this command adds the missing rules for the Pod routing
+table and synchronizes the exported routes with AddressBlocks in the
+same way as coild.  Problems of Pod routes are only reported because
+coilctl cannot tell a stale route from one of a Pod being created.
+Delete the Pod to recreate its network.
+
+This command must be run on the node in the host network namespace.
+The flags must have the same values as those of coild.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		cmd.SilenceUsage = true
+		node := nodeConfig.node
+		if node == "" {
+			node = os.Getenv(constants.EnvNode)
+		}
+		if node == "" {
+			h, err := os.Hostname()
+			if err != nil {
+				return err
+			}
+			node = h
+		}
+
+		cl, err := newClient()
+		if err != nil {
+			return err
+		}
+		st, err := readNodeState(nodeConfig.podTableId, nodeConfig.podRulePrio, nodeConfig.exportTableId)
+		if err != nil {
+			return err
+		}
+		var fixer nodeFixer
+		if nodeConfig.fix {
+			fixer = &kernelFixer{
+				podTableId:    nodeConfig.podTableId,
+				podRulePrio:   nodeConfig.podRulePrio,
+				exportTableId: nodeConfig.exportTableId,
+				protocolId:    nodeConfig.protocolId,
+			}
+		}
+		return runNodeDiagnose(cmd.Context(), cl, cmd.OutOrStdout(), node, st, fixer)
+	},
+}
+
+func init() {
+	pf := nodeDiagnoseCmd.Flags()
+	pf.StringVar(&nodeConfig.node, "node", "", "node name (default: $"+constants.EnvNode+" or the hostname)")
+	pf.IntVar(&nodeConfig.podTableId, "pod-table-id", constants.DefautlPodTableId, "routing table ID to which coild registers routes for Pods")
+	pf.IntVar(&nodeConfig.podRulePrio, "pod-rule-prio", constants.DefautlPodRulePrio, "priority with which the rule for Pod table is inserted")
+	pf.IntVar(&nodeConfig.exportTableId, "export-table-id", constants.DefautlExportTableId, "routing table ID to which coild exports routes")
+	pf.IntVar(&nodeConfig.protocolId, "protocol-id", constants.DefautlProtocolId, "route author ID")
+	pf.BoolVar(&nodeConfig.fix, "fix", false, "fix the rules and the exported routes")
+	nodeCmd.AddCommand(nodeDiagnoseCmd)
+	rootCmd.AddCommand(nodeCmd)
+}
+
+// nodeState is the kernel state of a node relevant to Coil.
+type nodeState struct {
+	// hasRule is true if the rule for the Pod table exists for the family.
+	hasRule map[int]bool
+
+	// podRoutes and exportRoutes are destinations of routes in the tables.
+	podRoutes    []string
+	exportRoutes []string
+}
+
+func readNodeState(podTableId, podRulePrio, exportTableId int) (*nodeState, error) {
+	st := &nodeState{hasRule: make(map[int]bool)}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		rules, err := netlink.RuleList(family)
+		if err != nil {
+			return nil, fmt.Errorf("netlink: failed to list rules: %w", err)
+		}
+		for _, r := range rules {
+			if r.Priority == podRulePrio && r.Table == podTableId {
+				st.hasRule[family] = true
+			}
+		}
+	}
+
+	var err error
+	st.podRoutes, err = routeDestinations(podTableId)
+	if err != nil {
+		return nil, err
+	}
+	st.exportRoutes, err = routeDestinations(exportTableId)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func routeDestinations(tableId int) ([]string, error) {
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: tableId}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("netlink: failed to list routes in table %d: %w", tableId, err)
+	}
+	var dsts []string
+	for _, r := range routes {
+		if r.Dst != nil {
+			dsts = append(dsts, r.Dst.String())
+		}
+	}
+	return dsts, nil
+}
+
+// nodeFixer repairs the kernel state of a node.
+type nodeFixer interface {
+	// addRule adds the rule for the Pod routing table.
+	addRule(family int) error
+
+	// syncExports makes the routes in the export table the same as nets.
+	syncExports(nets []*net.IPNet) error
+}
+
+type kernelFixer struct {
+	podTableId    int
+	podRulePrio   int
+	exportTableId int
+	protocolId    int
+}
+
+func (k *kernelFixer) addRule(family int) error {
+	r := netlink.NewRule()
+	r.Family = family
+	r.Table = k.podTableId
+	r.Priority = k.podRulePrio
+	if err := netlink.RuleAdd(r); err != nil {
+		return fmt.Errorf("netlink: failed to add pod table rule: %w", err)
+	}
+	return nil
+}
+
+func (k *kernelFixer) syncExports(nets []*net.IPNet) error {
+	return nodenet.NewRouteExporter(k.exportTableId, k.protocolId, logr.Discard()).Sync(nets)
+}
+
+// nodeProblem is a difference between the kernel state and the expected one.
+type nodeProblem struct {
+	message string
+
+	// fix repairs the problem.  It is nil if coilctl cannot fix the problem.
+	fix func(nodeFixer) error
+}
+
+func runNodeDiagnose(ctx context.Context, cl client.Client, w io.Writer, node string, st *nodeState, fixer nodeFixer) error {
+	blocks := &coilv2.AddressBlockList{}
+	if err := cl.List(ctx, blocks, client.MatchingLabels{constants.LabelNode: node}); err != nil {
+		return err
+	}
+	pods := &corev1.PodList{}
+	if err := cl.List(ctx, pods, client.MatchingFields{"spec.nodeName": node}); err != nil {
+		return err
+	}
+
+	problems := diagnoseNode(blocks.Items, pods.Items, st)
+	if fixer != nil {
+		if remaining := fixNode(w, problems, fixer); remaining > 0 {
+			return fmt.Errorf("%d problem(s) remain on node %s", remaining, node)
+		}
+		return nil
+	}
+
+	for _, p := range problems {
+		fmt.Fprintln(w, p.message)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problem(s) on node %s", len(problems), node)
+	}
+	fmt.Fprintf(w, "no problems found on node %s\n", node)
+	return nil
+}
+
+// fixNode fixes problems with fixer and returns the number of problems not fixed.
+func fixNode(w io.Writer, problems []nodeProblem, fixer nodeFixer) int {
+	var remaining int
+	for _, p := range problems {
+		if p.fix == nil {
+			fmt.Fprintf(w, "%s: not fixed, delete the Pod to recreate its network\n", p.message)
+			remaining++
+			continue
+		}
+		if err := p.fix(fixer); err != nil {
+			fmt.Fprintf(w, "%s: failed to fix: %v\n", p.message, err)
+			remaining++
+			continue
+		}
+		fmt.Fprintf(w, "%s: fixed\n", p.message)
+	}
+	return remaining
+}
+
+// diagnoseNode returns the differences between st and the state expected
+// from the address blocks and Pods of the node.
+func diagnoseNode(blocks []coilv2.AddressBlock, pods []corev1.Pod, st *nodeState) []nodeProblem {
+	var problems []nodeProblem
+
+	var subnets []*net.IPNet
+	expectedExports := make(map[string]bool)
+	families := make(map[int]bool)
+	for _, b := range blocks {
+		for _, s := range []*string{b.IPv4, b.IPv6} {
+			if s == nil {
+				continue
+			}
+			_, n, err := net.ParseCIDR(*s)
+			if err != nil {
+				continue
+			}
+			subnets = append(subnets, n)
+			expectedExports[n.String()] = true
+			if n.IP.To4() != nil {
+				families[netlink.FAMILY_V4] = true
+			} else {
+				families[netlink.FAMILY_V6] = true
+			}
+		}
+	}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		if families[family] && !st.hasRule[family] {
+			name := "IPv4"
+			if family == netlink.FAMILY_V6 {
+				name = "IPv6"
+			}
+			problems = append(problems, nodeProblem{
+				message: fmt.Sprintf("missing %s rule for the Pod routing table", name),
+				fix:     func(f nodeFixer) error { return f.addRule(family) },
+			})
+		}
+	}
+
+	expectedPods := make(map[string]string)
+	for _, pod := range pods {
+		if pod.Spec.HostNetwork {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			ip := net.ParseIP(podIP.IP)
+			if ip == nil || !slices.ContainsFunc(subnets, func(n *net.IPNet) bool { return n.Contains(ip) }) {
+				continue
+			}
+			expectedPods[hostNetwork(ip).String()] = pod.Namespace + "/" + pod.Name
+		}
+	}
+
+	actualPods := make(map[string]bool)
+	for _, dst := range st.podRoutes {
+		actualPods[dst] = true
+		if _, ok := expectedPods[dst]; !ok {
+			problems = append(problems, nodeProblem{message: fmt.Sprintf("stale Pod route: %s", dst)})
+		}
+	}
+	for _, dst := range sortedKeys(expectedPods) {
+		if !actualPods[dst] {
+			problems = append(problems, nodeProblem{message: fmt.Sprintf("missing Pod route: %s for %s", dst, expectedPods[dst])})
+		}
+	}
+
+	syncExports := func(f nodeFixer) error { return f.syncExports(subnets) }
+	actualExports := make(map[string]bool)
+	for _, dst := range st.exportRoutes {
+		actualExports[dst] = true
+		if !expectedExports[dst] {
+			problems = append(problems, nodeProblem{message: fmt.Sprintf("stale exported route: %s", dst), fix: syncExports})
+		}
+	}
+	for _, dst := range sortedKeys(expectedExports) {
+		if !actualExports[dst] {
+			problems = append(problems, nodeProblem{message: fmt.Sprintf("missing exported route: %s", dst), fix: syncExports})
+		}
+	}
+
+	return problems
+}
+
+func hostNetwork(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
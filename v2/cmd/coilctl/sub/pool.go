@@ -0,0 +1,93 @@
+package sub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+var poolCmd = &cobra.Command{
+	Use:   "pool",
+	Short: "inspect AddressPools",
+}
+
+var poolUsageCmd = &cobra.Command{
+	Use:   "usage [POOL...]",
+	Short: "show the number of allocated address blocks of pools",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		cl, err := newClient()
+		if err != nil {
+			return err
+		}
+		return runPoolUsage(cmd.Context(), cl, cmd.OutOrStdout(), args)
+	},
+}
+
+func init() {
+	poolCmd.AddCommand(poolUsageCmd)
+	rootCmd.AddCommand(poolCmd)
+}
+
+func runPoolUsage(ctx context.Context, cl client.Client, w io.Writer, names []string) error {
+	var pools []coilv2.AddressPool
+	if len(names) == 0 {
+		list := &coilv2.AddressPoolList{}
+		if err := cl.List(ctx, list); err != nil {
+			return err
+		}
+		pools = list.Items
+	}
+	for _, name := range names {
+		ap := &coilv2.AddressPool{}
+		if err := cl.Get(ctx, client.ObjectKey{Name: name}, ap); err != nil {
+			return err
+		}
+		pools = append(pools, *ap)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "POOL\tBLOCK SIZE\tALLOCATED\tMAX\tUSAGE")
+	for i := range pools {
+		ap := &pools[i]
+		blocks := &coilv2.AddressBlockList{}
+		if err := cl.List(ctx, blocks, client.MatchingLabels{constants.LabelPool: ap.Name}); err != nil {
+			return err
+		}
+
+		max := maxBlocks(ap)
+		usage := "-"
+		if max > 0 {
+			usage = fmt.Sprintf("%.1f%%", float64(len(blocks.Items))*100/float64(max))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", ap.Name, 1<<ap.Spec.BlockSizeBits, len(blocks.Items), max, usage)
+	}
+	return tw.Flush()
+}
+
+// maxBlocks returns the number of address blocks that can be carved out of ap.
+func maxBlocks(ap *coilv2.AddressPool) int {
+	var max int
+	for _, sub := range ap.Spec.Subnets {
+		var n *net.IPNet
+		if sub.IPv4 != nil {
+			_, n, _ = net.ParseCIDR(*sub.IPv4)
+		} else if sub.IPv6 != nil {
+			_, n, _ = net.ParseCIDR(*sub.IPv6)
+		}
+		if n == nil {
+			continue
+		}
+		ones, bits := n.Mask.Size()
+		max += 1 << (bits - ones - int(ap.Spec.BlockSizeBits))
+	}
+	return max
+}
@@ -0,0 +1,216 @@
+package sub
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+func testBlock(name, pool, node string, index int32, ipv4, ipv6 string) *coilv2.AddressBlock {
+	b := &coilv2.AddressBlock{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				constants.LabelPool: pool,
+				constants.LabelNode: node,
+			},
+		},
+		Index: index,
+	}
+	if ipv4 != "" {
+		b.IPv4 = ptr.To(ipv4)
+	}
+	if ipv6 != "" {
+		b.IPv6 = ptr.To(ipv6)
+	}
+	return b
+}
+
+func testPod(ns, name, node string, annotations map[string]string, ips ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, Annotations: annotations},
+		Spec:       corev1.PodSpec{NodeName: node},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	for _, ip := range ips {
+		pod.Status.PodIPs = append(pod.Status.PodIPs, corev1.PodIP{IP: ip})
+	}
+	return pod
+}
+
+func testClient(objs ...client.Object) client.Client {
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithIndex(&corev1.Pod{}, "spec.nodeName", func(o client.Object) []string {
+			return []string{o.(*corev1.Pod).Spec.NodeName}
+		}).
+		Build()
+}
+
+func testObjects() []client.Object {
+	return []client.Object{
+		&coilv2.AddressPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: coilv2.AddressPoolSpec{
+				BlockSizeBits: 5,
+				Subnets: []coilv2.SubnetSet{
+					{IPv4: ptr.To("10.64.0.0/24"), IPv6: ptr.To("fd02::/120")},
+				},
+			},
+		},
+		testBlock("default-0", "default", "node1", 0, "10.64.0.0/27", "fd02::/123"),
+		testBlock("default-1", "default", "node2", 1, "10.64.0.32/27", "fd02::20/123"),
+		testPod("ns1", "pod1", "node1", map[string]string{constants.AnnEgressPrefix + "internet": "egress1,egress2"}, "10.64.0.1", "fd02::1"),
+		testPod("ns1", "pod2", "node2", map[string]string{constants.AnnEgressPrefix + "internet": "egress2"}, "10.64.0.33"),
+		&coilv2.Egress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "internet", Name: "egress1"},
+		},
+	}
+}
+
+func TestPoolUsage(t *testing.T) {
+	cl := testClient(testObjects()...)
+	buf := &bytes.Buffer{}
+	if err := runPoolUsage(context.Background(), cl, buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(strings.Split(buf.String(), "\n")[1])
+	expected := []string{"default", "32", "2", "8", "25.0%"}
+	if !cmp.Equal(fields, expected) {
+		t.Errorf("unexpected output: %s", buf.String())
+	}
+}
+
+func TestBlockLs(t *testing.T) {
+	cl := testClient(testObjects()...)
+	buf := &bytes.Buffer{}
+	if err := runBlockLs(context.Background(), cl, buf, "node2", ""); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "default-1 ") {
+		t.Errorf("unexpected output: %s", buf.String())
+	}
+}
+
+func TestWhois(t *testing.T) {
+	cl := testClient(testObjects()...)
+	buf := &bytes.Buffer{}
+	if err := runWhois(context.Background(), cl, buf, "fd02::1"); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{"default-0 (fd02::/123)", "node1", "ns1/pod1"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output should contain %q: %s", s, out)
+		}
+	}
+
+	buf.Reset()
+	if err := runWhois(context.Background(), cl, buf, "10.64.0.34"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<none>") {
+		t.Errorf("unexpected output: %s", buf.String())
+	}
+
+	if err := runWhois(context.Background(), cl, buf, "10.65.0.1"); err == nil {
+		t.Error("whois should fail for an address not in any block")
+	}
+}
+
+func TestEgressClients(t *testing.T) {
+	cl := testClient(testObjects()...)
+	buf := &bytes.Buffer{}
+	if err := runEgressClients(context.Background(), cl, buf, "internet", "egress1"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "pod1") {
+		t.Errorf("unexpected output: %s", buf.String())
+	}
+
+	if err := runEgressClients(context.Background(), cl, buf, "internet", "egress2"); err == nil {
+		t.Error("should fail for a non-existing Egress")
+	}
+}
+
+func TestDiagnoseNode(t *testing.T) {
+	blocks := []coilv2.AddressBlock{
+		*testBlock("default-0", "default", "node1", 0, "10.64.0.0/27", "fd02::/123"),
+	}
+	pods := []corev1.Pod{
+		*testPod("ns1", "pod1", "node1", nil, "10.64.0.1", "fd02::1"),
+		*testPod("ns1", "pod2", "node1", nil, "10.64.0.2"),
+	}
+	st := &nodeState{
+		hasRule:      map[int]bool{netlink.FAMILY_V4: true},
+		podRoutes:    []string{"10.64.0.1/32", "fd02::1/128", "10.64.0.3/32"},
+		exportRoutes: []string{"10.64.0.0/27"},
+	}
+
+	expected := []string{
+		"missing IPv6 rule for the Pod routing table",
+		"stale Pod route: 10.64.0.3/32",
+		"missing Pod route: 10.64.0.2/32 for ns1/pod2",
+		"missing exported route: fd02::/123",
+	}
+	problems := diagnoseNode(blocks, pods, st)
+	var messages []string
+	for _, p := range problems {
+		messages = append(messages, p.message)
+	}
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Errorf("unexpected problems (-want +got):\n%s", diff)
+	}
+
+	fixer := &testFixer{}
+	buf := &bytes.Buffer{}
+	if remaining := fixNode(buf, problems, fixer); remaining != 2 {
+		t.Errorf("expected 2 remaining problems, got %d:\n%s", remaining, buf.String())
+	}
+	if diff := cmp.Diff([]int{netlink.FAMILY_V6}, fixer.rules); diff != "" {
+		t.Errorf("unexpected rules (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"10.64.0.0/27", "fd02::/123"}, fixer.exports); diff != "" {
+		t.Errorf("unexpected exported routes (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(buf.String(), "missing exported route: fd02::/123: fixed") {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "stale Pod route: 10.64.0.3/32: not fixed") {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+type testFixer struct {
+	rules   []int
+	exports []string
+}
+
+func (f *testFixer) addRule(family int) error {
+	f.rules = append(f.rules, family)
+	return nil
+}
+
+func (f *testFixer) syncExports(nets []*net.IPNet) error {
+	f.exports = nil
+	for _, n := range nets {
+		f.exports = append(f.exports, n.String())
+	}
+	return nil
+}
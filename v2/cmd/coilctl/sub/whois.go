@@ -0,0 +1,98 @@
+package sub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+var whoisCmd = &cobra.Command{
+	Use:   "whois IP",
+	Short: "show the address block, node, and Pod of an IP address",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		cl, err := newClient()
+		if err != nil {
+			return err
+		}
+		return runWhois(cmd.Context(), cl, cmd.OutOrStdout(), args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(whoisCmd)
+}
+
+func runWhois(ctx context.Context, cl client.Client, w io.Writer, addr string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address: %s", addr)
+	}
+
+	blocks := &coilv2.AddressBlockList{}
+	if err := cl.List(ctx, blocks); err != nil {
+		return err
+	}
+
+	var block *coilv2.AddressBlock
+	var subnet string
+	for i := range blocks.Items {
+		b := &blocks.Items[i]
+		for _, s := range []*string{b.IPv4, b.IPv6} {
+			if s == nil {
+				continue
+			}
+			_, n, err := net.ParseCIDR(*s)
+			if err != nil {
+				continue
+			}
+			if n.Contains(ip) {
+				block = b
+				subnet = *s
+			}
+		}
+	}
+	if block == nil {
+		return fmt.Errorf("%s is not in any address block", addr)
+	}
+	node := block.Labels[constants.LabelNode]
+
+	pods := &corev1.PodList{}
+	if err := cl.List(ctx, pods, client.MatchingFields{"spec.nodeName": node}); err != nil {
+		return err
+	}
+	var owners []string
+	for _, pod := range pods.Items {
+		if pod.Spec.HostNetwork {
+			continue
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			if ip.Equal(net.ParseIP(podIP.IP)) {
+				owners = append(owners, pod.Namespace+"/"+pod.Name)
+			}
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "IP:\t%s\n", ip)
+	fmt.Fprintf(tw, "Pool:\t%s\n", block.Labels[constants.LabelPool])
+	fmt.Fprintf(tw, "Block:\t%s (%s)\n", block.Name, subnet)
+	fmt.Fprintf(tw, "Node:\t%s\n", node)
+	if len(owners) == 0 {
+		fmt.Fprintf(tw, "Pod:\t<none>\n")
+	}
+	for _, o := range owners {
+		fmt.Fprintf(tw, "Pod:\t%s\n", o)
+	}
+	return tw.Flush()
+}
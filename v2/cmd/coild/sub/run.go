@@ -21,9 +21,13 @@ import (
 	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
 	"github.com/cybozu-go/coil/v2/controllers"
 	"github.com/cybozu-go/coil/v2/pkg/constants"
+	"github.com/cybozu-go/coil/v2/pkg/fou"
 	"github.com/cybozu-go/coil/v2/pkg/indexing"
 	"github.com/cybozu-go/coil/v2/pkg/ipam"
+	"github.com/cybozu-go/coil/v2/pkg/nat/netfilter"
+	"github.com/cybozu-go/coil/v2/pkg/netpol"
 	"github.com/cybozu-go/coil/v2/pkg/nodenet"
+	"github.com/cybozu-go/coil/v2/pkg/tracing"
 	"github.com/cybozu-go/coil/v2/runners"
 )
 
@@ -58,6 +62,35 @@ func subMain() error {
 	if !cfg.EnableIPAM && !cfg.EnableEgress {
 		return errors.New("configuration error: both IPAM and egress are disabled")
 	}
+	if cfg.EnableNetworkPolicy && !cfg.EnableIPAM {
+		return errors.New("configuration error: network policy requires IPAM")
+	}
+	if cfg.BlockLowWatermark < 0 || cfg.BlockHighWatermark < 0 {
+		return errors.New("configuration error: block watermarks must not be negative")
+	}
+	if cfg.BlockHighWatermark != 0 && cfg.BlockHighWatermark < cfg.BlockLowWatermark {
+		return errors.New("configuration error: block high watermark must not be less than the low watermark")
+	}
+	if cfg.MinBlocksPerPool < 0 || cfg.BlockGracePeriod < 0 {
+		return errors.New("configuration error: block release settings must not be negative")
+	}
+	if cfg.EnableNodeEgress {
+		if !cfg.EnableEgress {
+			return errors.New("configuration error: node egress requires egress")
+		}
+		if len(cfg.NodeEgressUIDs) == 0 && len(cfg.NodeEgressCgroups) == 0 {
+			return errors.New("configuration error: node egress requires UIDs or cgroups")
+		}
+		if cfg.NodeEgressMark == 0 {
+			return errors.New("configuration error: node egress mark must not be zero")
+		}
+		if cfg.NodeEgressRulePrio <= cfg.PodRulePrio {
+			return errors.New("configuration error: node egress rule priority must be greater than the pod rule priority")
+		}
+		if cfg.NodeEgressNarrowTableId == cfg.NodeEgressWideTableId {
+			return errors.New("configuration error: node egress table IDs must be different")
+		}
+	}
 
 	timeout := gracefulTimeout
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
@@ -81,7 +114,12 @@ func subMain() error {
 	}
 
 	exporter := nodenet.NewRouteExporter(cfg.ExportTableId, cfg.ProtocolId, ctrl.Log.WithName("route-exporter"))
-	nodeIPAM := ipam.NewNodeIPAM(nodeName, ctrl.Log.WithName("node-ipam"), mgr, exporter)
+	nodeIPAM := ipam.NewNodeIPAM(nodeName, ctrl.Log.WithName("node-ipam"), mgr, exporter, ipam.BlockPolicy{
+		LowWatermark:       cfg.BlockLowWatermark,
+		HighWatermark:      cfg.BlockHighWatermark,
+		MinBlocks:          cfg.MinBlocksPerPool,
+		ReleaseGracePeriod: cfg.BlockGracePeriod,
+	})
 	if cfg.EnableIPAM {
 		watcher := &controllers.BlockRequestWatcher{
 			Client:   mgr.GetClient(),
@@ -108,7 +146,9 @@ func subMain() error {
 		cfg.CompatCalico,
 		cfg.RegisterFromMain,
 		ctrl.Log.WithName("pod-network"),
-		cfg.EnableIPAM)
+		cfg.EnableIPAM,
+		cfg.MTU,
+		cfg.MTUOverhead)
 	if err := podNet.Init(); err != nil {
 		return err
 	}
@@ -130,12 +170,30 @@ func subMain() error {
 		}
 	}
 
+	var podObservers []runners.PodObserver
+	enforcer := netpol.NewEnforcer()
+	if cfg.EnableNetworkPolicy {
+		npWatcher := &controllers.NetworkPolicyWatcher{
+			Client:   mgr.GetClient(),
+			NodeName: nodeName,
+			PodNet:   podNet,
+			Enforcer: enforcer,
+		}
+		if err := npWatcher.SetupWithManager(mgr); err != nil {
+			return err
+		}
+		podObservers = append(podObservers, npWatcher)
+	} else if err := enforcer.Clear(); err != nil {
+		// Rules left by a previous run would keep isolating Pods.
+		setupLog.Error(err, "failed to clear NetworkPolicy rules")
+	}
+
 	os.Remove(cfg.SocketPath)
 	l, err := net.Listen("unix", cfg.SocketPath)
 	if err != nil {
 		return err
 	}
-	server := runners.NewCoildServer(l, mgr, nodeIPAM, podNet, runners.NewNATSetup(cfg.EgressPort), cfg, grpcLogger, runners.ProcessLinkAlias, nodeName)
+	server := runners.NewCoildServer(l, mgr, nodeIPAM, podNet, runners.NewNATSetup(cfg.EgressPort), cfg, grpcLogger, runners.ProcessLinkAlias, nodeName, podObservers...)
 	if err := mgr.Add(server); err != nil {
 		return err
 	}
@@ -148,13 +206,53 @@ func subMain() error {
 			EgressPort:      cfg.EgressPort,
 			Backend:         cfg.Backend,
 			OriginatingOnly: cfg.OriginatingOnly,
+			Recorder:        mgr.GetEventRecorder("coild"),
 		}
 		if err := egressWatcher.SetupWithManager(mgr); err != nil {
 			return err
 		}
 	}
 
+	uids := make([]uint32, len(cfg.NodeEgressUIDs))
+	for i, uid := range cfg.NodeEgressUIDs {
+		uids[i] = uint32(uid)
+	}
+	nodeNatClient := netfilter.NewNodeNatClient(ipv4, ipv6, cfg.EgressPort, uids, cfg.NodeEgressCgroups, cfg.NodeEgressMark,
+		cfg.NodeEgressRulePrio, cfg.NodeEgressNarrowTableId, cfg.NodeEgressWideTableId, func(message string) {
+			setupLog.Info(message)
+		})
+	if cfg.EnableNodeEgress {
+		tunnel := fou.NewNodeFoUTunnel(cfg.EgressPort, ipv4, ipv6, func(message string) {
+			setupLog.Info(message)
+		})
+		if err := tunnel.Init(); err != nil {
+			return err
+		}
+		if err := nodeNatClient.Init(); err != nil {
+			return err
+		}
+		nodeEgressWatcher := &controllers.NodeEgressWatcher{
+			Client:    mgr.GetClient(),
+			NodeName:  nodeName,
+			Tunnel:    tunnel,
+			NatClient: nodeNatClient,
+			Recorder:  mgr.GetEventRecorder("coild"),
+		}
+		if err := nodeEgressWatcher.SetupWithManager(mgr); err != nil {
+			return err
+		}
+	} else if err := nodeNatClient.Clear(); err != nil {
+		// Clear is a no-op unless node egress was enabled before.
+		setupLog.Error(err, "failed to clear node egress rules")
+	}
+
 	ctx2 := ctrl.SetupSignalHandler()
+	shutdownTracing, err := tracing.Setup(ctx2, "coild")
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
 	if err := indexing.SetupIndexForPodByNodeName(ctx2, mgr); err != nil {
 		return err
 	}
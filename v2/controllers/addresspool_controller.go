@@ -6,9 +6,11 @@ import (
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -25,6 +27,9 @@ type AddressPoolReconciler struct {
 	client.Client
 	Scheme  *runtime.Scheme
 	Manager ipam.PoolManager
+
+	// ActiveActive makes this reconcile pools without leader election.
+	ActiveActive bool
 }
 
 var _ reconcile.Reconciler = &AddressPoolReconciler{}
@@ -93,5 +98,9 @@ func (r *AddressPoolReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				return false
 			},
 		})).
+		WithOptions(controller.Options{
+			NeedLeaderElection: ptr.To(!r.ActiveActive),
+			EnableWarmup:       ptr.To(true),
+		}).
 		Complete(r)
 }
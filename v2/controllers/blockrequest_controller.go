@@ -5,12 +5,20 @@ import (
 	"errors"
 	"fmt"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
@@ -18,24 +26,31 @@ import (
 	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
 	"github.com/cybozu-go/coil/v2/pkg/constants"
 	"github.com/cybozu-go/coil/v2/pkg/ipam"
+	"github.com/cybozu-go/coil/v2/pkg/tracing"
 )
 
 // BlockRequestReconciler reconciles a BlockRequest object
 type BlockRequestReconciler struct {
 	client.Client
-	Scheme  *runtime.Scheme
-	Manager ipam.PoolManager
+	Scheme   *runtime.Scheme
+	Manager  ipam.PoolManager
+	Recorder events.EventRecorder
+
+	// ActiveActive makes this reconcile requests without leader election.
+	ActiveActive bool
 }
 
 // +kubebuilder:rbac:groups=coil.cybozu.com,resources=blockrequests,verbs=get;list;watch
 // +kubebuilder:rbac:groups=coil.cybozu.com,resources=blockrequests/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=addressblocks,verbs=get;list;watch;update;delete
+// +kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
 
 // Reconcile implements Reconciler interface.
 // https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile?tab=doc#Reconciler
-func (r *BlockRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *BlockRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
 	logger := log.FromContext(ctx)
 	br := &coilv2.BlockRequest{}
-	err := r.Client.Get(ctx, req.NamespacedName, br)
+	err = r.Client.Get(ctx, req.NamespacedName, br)
 
 	if err != nil {
 		// as Delete event is ignored, this is unlikely to happen.
@@ -48,6 +63,16 @@ func (r *BlockRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, nil
 	}
 
+	// continue the trace started by coild that created the request.
+	ctx, span := tracing.Tracer().Start(tracing.ExtractAnnotations(ctx, br), "BlockRequestReconciler.Reconcile",
+		trace.WithAttributes(
+			attribute.String("coil.pool", br.Spec.PoolName),
+			attribute.String("coil.node", br.Spec.NodeName),
+		))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
 	blocks := &coilv2.AddressBlockList{}
 	err = r.Client.List(ctx, blocks, client.MatchingFields{
 		constants.AddressBlockRequestKey: string(br.UID),
@@ -58,6 +83,10 @@ func (r *BlockRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	}
 	if len(blocks.Items) > 0 {
 		if err := r.updateStatus(ctx, br, blocks.Items[0].Name); err != nil {
+			if apierrors.IsConflict(err) {
+				// another controller has handled the request.
+				return ctrl.Result{}, nil
+			}
 			logger.Error(err, "a block for the request has been created, but failed to update status")
 			return ctrl.Result{}, err
 		}
@@ -67,6 +96,7 @@ func (r *BlockRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	block, err := r.Manager.AllocateBlock(ctx, br.Spec.PoolName, br.Spec.NodeName, string(br.UID))
 	if errors.Is(err, ipam.ErrNoBlock) {
 		logger.Error(err, "out of blocks", "pool", br.Spec.PoolName)
+		r.recordNoBlock(ctx, br)
 
 		now := metav1.Now()
 		br.Status.Conditions = []coilv2.BlockRequestCondition{
@@ -88,6 +118,10 @@ func (r *BlockRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request
 			},
 		}
 		err = r.Client.Status().Update(ctx, br)
+		if apierrors.IsConflict(err) {
+			// another controller has handled the request.
+			return ctrl.Result{}, nil
+		}
 		if err != nil {
 			logger.Error(err, "failed to update status")
 			return ctrl.Result{}, err
@@ -101,7 +135,18 @@ func (r *BlockRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request
 
 	logger.Info("allocated", "block", block.Name, "index", block.Index, "pool", br.Spec.PoolName)
 
+	// The status update decides the block for the request.  The block is
+	// created as provisional so that coild adopts it only if this wins.
 	if err := r.updateStatus(ctx, br, block.Name); err != nil {
+		if apierrors.IsConflict(err) {
+			// another controller has handled the request with another block.
+			logger.Info("request has been handled by another controller", "block", block.Name)
+			return ctrl.Result{}, r.deleteBlock(ctx, block)
+		}
+		if apierrors.IsNotFound(err) {
+			logger.Info("request has been withdrawn", "block", block.Name)
+			return ctrl.Result{}, r.deleteBlock(ctx, block)
+		}
 		logger.Error(err, "failed to update status")
 		return ctrl.Result{}, err
 	}
@@ -109,6 +154,30 @@ func (r *BlockRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	return ctrl.Result{}, nil
 }
 
+// deleteBlock deletes a block that has been allocated for nothing.
+func (r *BlockRequestReconciler) deleteBlock(ctx context.Context, block *coilv2.AddressBlock) error {
+	controllerutil.RemoveFinalizer(block, constants.FinCoil)
+	if err := r.Client.Update(ctx, block); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return client.IgnoreNotFound(r.Client.Delete(ctx, block))
+}
+
+// recordNoBlock records events on the AddressPool and the Node of br
+// so that administrators can notice the exhaustion of the pool.
+func (r *BlockRequestReconciler) recordNoBlock(ctx context.Context, br *coilv2.BlockRequest) {
+	pool := &coilv2.AddressPool{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: br.Spec.PoolName}, pool); err == nil {
+		r.Recorder.Eventf(pool, br, corev1.EventTypeWarning, constants.ReasonPoolExhausted, "AllocateBlock",
+			"no free address block for node %s", br.Spec.NodeName)
+	}
+
+	// Node events are keyed by the node name as kubelet does.
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: br.Spec.NodeName, UID: types.UID(br.Spec.NodeName)}}
+	r.Recorder.Eventf(node, br, corev1.EventTypeWarning, constants.ReasonPoolExhausted, "AllocateBlock",
+		"pool %s does not have free blocks", br.Spec.PoolName)
+}
+
 func (r *BlockRequestReconciler) updateStatus(ctx context.Context, br *coilv2.BlockRequest, blockName string) error {
 	now := metav1.Now()
 	br.Status.Conditions = []coilv2.BlockRequestCondition{
@@ -143,5 +212,9 @@ func (r *BlockRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				return false
 			},
 		})).
+		WithOptions(controller.Options{
+			NeedLeaderElection: ptr.To(!r.ActiveActive),
+			EnableWarmup:       ptr.To(true),
+		}).
 		Complete(r)
 }
@@ -2,12 +2,14 @@ package controllers
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -15,12 +17,14 @@ import (
 	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
 	"github.com/cybozu-go/coil/v2/pkg/constants"
 	"github.com/cybozu-go/coil/v2/pkg/indexing"
+	"github.com/cybozu-go/coil/v2/pkg/ipam"
 )
 
 var _ = Describe("BlockRequest reconciler", func() {
 	ctx := context.Background()
 	var cancel context.CancelFunc
 	var poolMgr *mockPoolManager
+	var recorder *events.FakeRecorder
 
 	BeforeEach(func() {
 		ctx, cancel = context.WithCancel(context.TODO())
@@ -62,10 +66,12 @@ var _ = Describe("BlockRequest reconciler", func() {
 
 		Expect(indexing.SetupIndexForAddressBlock(ctx, mgr)).ToNot(HaveOccurred())
 
+		recorder = events.NewFakeRecorder(10)
 		brr := &BlockRequestReconciler{
-			Client:  mgr.GetClient(),
-			Manager: poolMgr,
-			Scheme:  mgr.GetScheme(),
+			Client:   mgr.GetClient(),
+			Manager:  poolMgr,
+			Scheme:   mgr.GetScheme(),
+			Recorder: recorder,
 		}
 		err = brr.SetupWithManager(mgr)
 		Expect(err).ToNot(HaveOccurred())
@@ -157,5 +163,102 @@ var _ = Describe("BlockRequest reconciler", func() {
 
 		time.Sleep(10 * time.Millisecond)
 		Expect(poolMgr.GetAllocated()).To(BeNumerically("==", 2))
+
+		By("checking that events are recorded for the exhausted pool")
+		Eventually(recorder.Events).Should(Receive(Equal("Warning PoolExhausted no free address block for node node3")))
+		Eventually(recorder.Events).Should(Receive(Equal("Warning PoolExhausted pool default does not have free blocks")))
+	})
+})
+
+var _ = Describe("BlockRequest reconcilers in active-active mode", func() {
+	It("should give only one block to a request served by two reconcilers", func() {
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+
+		ap := &coilv2.AddressPool{}
+		ap.Name = "active"
+		ap.Spec.BlockSizeBits = 1
+		ap.Spec.Subnets = []coilv2.SubnetSet{
+			{IPv4: strPtr("10.9.0.0/28")},
+		}
+		err := k8sClient.Create(ctx, ap)
+		Expect(err).To(Succeed())
+		defer func() {
+			blocks := &coilv2.AddressBlockList{}
+			err := k8sClient.List(context.Background(), blocks, client.MatchingLabels{constants.LabelPool: "active"})
+			Expect(err).To(Succeed())
+			for _, b := range blocks.Items {
+				b.Finalizers = nil
+				Expect(k8sClient.Update(context.Background(), &b)).To(Succeed())
+				Expect(k8sClient.Delete(context.Background(), &b)).To(Succeed())
+			}
+			Expect(k8sClient.DeleteAllOf(context.Background(), &coilv2.BlockRequest{})).To(Succeed())
+			Expect(k8sClient.Delete(context.Background(), ap)).To(Succeed())
+		}()
+
+		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+			Scheme:         scheme,
+			LeaderElection: false,
+			Metrics: metricsserver.Options{
+				BindAddress: "0",
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(indexing.SetupIndexForAddressBlock(ctx, mgr)).ToNot(HaveOccurred())
+		go func() {
+			err := mgr.Start(ctx)
+			if err != nil {
+				panic(err)
+			}
+		}()
+		Expect(mgr.GetCache().WaitForCacheSync(ctx)).To(BeTrue())
+
+		br := &coilv2.BlockRequest{}
+		br.Name = "br-active"
+		br.Spec.NodeName = "node0"
+		br.Spec.PoolName = "active"
+		err = k8sClient.Create(ctx, br)
+		Expect(err).To(Succeed())
+		Eventually(func() error {
+			return mgr.GetClient().Get(ctx, client.ObjectKey{Name: br.Name}, &coilv2.BlockRequest{})
+		}).Should(Succeed())
+
+		By("reconciling the request by two reconcilers with their own pool managers")
+		reconcilers := make([]*BlockRequestReconciler, 2)
+		for i := range reconcilers {
+			reconcilers[i] = &BlockRequestReconciler{
+				Client:       mgr.GetClient(),
+				Scheme:       mgr.GetScheme(),
+				Manager:      ipam.NewPoolManager(mgr.GetClient(), mgr.GetAPIReader(), ctrl.Log.WithName("PoolManager"), scheme),
+				Recorder:     events.NewFakeRecorder(10),
+				ActiveActive: true,
+			}
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, len(reconcilers))
+		for i, r := range reconcilers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: br.Name}})
+			}()
+		}
+		wg.Wait()
+		for _, err := range errs {
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		By("checking that only the block recorded in the request remains")
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: br.Name}, br)
+		Expect(err).To(Succeed())
+		Expect(br.Status.AddressBlockName).NotTo(BeEmpty())
+
+		blocks := &coilv2.AddressBlockList{}
+		err = k8sClient.List(ctx, blocks, client.MatchingLabels{constants.LabelRequest: string(br.UID)})
+		Expect(err).To(Succeed())
+		Expect(blocks.Items).To(HaveLen(1))
+		Expect(blocks.Items[0].Name).To(Equal(br.Status.AddressBlockName))
+		Expect(blocks.Items[0].Labels).To(HaveKeyWithValue(constants.LabelProvisional, "true"))
 	})
 })
@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"context"
+	"strconv"
+	"strings"
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
@@ -20,15 +22,17 @@ import (
 
 	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
 	"github.com/cybozu-go/coil/v2/pkg/constants"
+	"github.com/cybozu-go/coil/v2/pkg/statesync"
 )
 
 // EgressReconciler reconciles a Egress object
 type EgressReconciler struct {
 	client.Client
-	Scheme  *runtime.Scheme
-	Image   string
-	Port    int32
-	Backend string
+	APIReader client.Reader
+	Scheme    *runtime.Scheme
+	Image     string
+	Port      int32
+	Backend   string
 }
 
 // +kubebuilder:rbac:groups=coil.cybozu.com,resources=egresses,verbs=get;list;watch
@@ -36,9 +40,15 @@ type EgressReconciler struct {
 // +kubebuilder:rbac:groups="",resources=services;serviceaccounts,verbs=get;list;watch;create;update;patch
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch
 // +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;create
 
-// coil-egress-controller needs to have access to Pods to grant egress service accounts the same privilege.
-// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// coil-egress-controller needs to have access to Pods, Nodes, and Leases to grant egress service accounts the same privilege.
+// +kubebuilder:rbac:groups="",resources=pods;nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;create;delete
+
+// The Pod webhook of coil-egress-controller checks the address pool of the namespace.
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=addresspools,verbs=get;list;watch
 
 // Reconcile implements Reconciler interface.
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
@@ -75,6 +85,11 @@ func (r *EgressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		return ctrl.Result{}, err
 	}
 
+	if err := r.reconcileStateSyncSecret(ctx, logger, eg); err != nil {
+		logger.Error(err, "failed to reconcile secret for state sync")
+		return ctrl.Result{}, err
+	}
+
 	if err := r.reconcileDeployment(ctx, logger, eg); err != nil {
 		logger.Error(err, "failed to reconcile deployment")
 		return ctrl.Result{}, err
@@ -113,6 +128,20 @@ func (r *EgressReconciler) reconcileServiceAccount(ctx context.Context, log logr
 	return err
 }
 
+// drainGracePeriodMargin is added to the drain timeout of Egress
+// to determine terminationGracePeriodSeconds of egress pods.
+const drainGracePeriodMargin = 10
+
+// stateSyncPort is the port number of egress pods to synchronize NAT state.
+const stateSyncPort = 5556
+
+// stateSyncCertDir is the directory to mount the Secret for state sync.
+const stateSyncCertDir = "/etc/coil-egress/state-sync"
+
+func stateSyncSecretName(egressName string) string {
+	return egressName + "-state-sync"
+}
+
 func selectorLabels(name string) map[string]string {
 	return map[string]string{
 		constants.LabelAppName:      "coil",
@@ -144,6 +173,13 @@ func (r *EgressReconciler) reconcilePodTemplate(eg *coilv2.Egress, depl *appsv1.
 	}
 
 	podSpec.ServiceAccountName = constants.SAEgress
+	if eg.Spec.DrainTimeout != nil && podSpec.TerminationGracePeriodSeconds == nil {
+		// give coil-egress a chance to finish draining before SIGKILL.
+		podSpec.TerminationGracePeriodSeconds = ptr.To(int64(eg.Spec.DrainTimeout.Seconds()) + drainGracePeriodMargin)
+	}
+	if eg.Spec.TopologySpread != nil && len(podSpec.TopologySpreadConstraints) == 0 {
+		podSpec.TopologySpreadConstraints = topologySpreadConstraints(eg)
+	}
 	podSpec.Volumes = r.addVolumes(podSpec.Volumes)
 
 	var egressContainer *corev1.Container
@@ -170,6 +206,19 @@ func (r *EgressReconciler) reconcilePodTemplate(eg *coilv2.Egress, depl *appsv1.
 			egressContainer.Args = append(egressContainer.Args, "--enable-sport-auto=true")
 		}
 		egressContainer.Args = append(egressContainer.Args, "--backend="+r.Backend)
+		if eg.Spec.DrainTimeout != nil {
+			egressContainer.Args = append(egressContainer.Args, "--drain-timeout="+eg.Spec.DrainTimeout.Duration.String())
+		}
+		if eg.Spec.StateSync != nil {
+			egressContainer.Args = append(egressContainer.Args,
+				"--source-ips="+strings.Join(eg.Spec.StateSync.SourceIPs, ","),
+				"--enable-state-sync=true",
+				"--state-sync-port="+strconv.Itoa(stateSyncPort),
+				"--state-sync-cert-dir="+stateSyncCertDir)
+		}
+		if len(eg.Spec.Upstreams()) > 0 {
+			egressContainer.Args = append(egressContainer.Args, "--enable-transit=true")
+		}
 	}
 	egressContainer.Env = append(egressContainer.Env,
 		corev1.EnvVar{
@@ -190,6 +239,21 @@ func (r *EgressReconciler) reconcilePodTemplate(eg *coilv2.Egress, depl *appsv1.
 		},
 	)
 	egressContainer.VolumeMounts = r.addVolumeMounts(egressContainer.VolumeMounts)
+	if eg.Spec.StateSync != nil {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "state-sync-cert",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: stateSyncSecretName(eg.Name),
+				},
+			},
+		})
+		egressContainer.VolumeMounts = append(egressContainer.VolumeMounts, corev1.VolumeMount{
+			MountPath: stateSyncCertDir,
+			Name:      "state-sync-cert",
+			ReadOnly:  true,
+		})
+	}
 	egressContainer.SecurityContext = &corev1.SecurityContext{
 		Privileged:             ptr.To(true),
 		ReadOnlyRootFilesystem: ptr.To(true),
@@ -208,6 +272,10 @@ func (r *EgressReconciler) reconcilePodTemplate(eg *coilv2.Egress, depl *appsv1.
 		{Name: "metrics", ContainerPort: 8080, Protocol: corev1.ProtocolTCP},
 		{Name: "health", ContainerPort: 8081, Protocol: corev1.ProtocolTCP},
 	}
+	if eg.Spec.StateSync != nil {
+		egressContainer.Ports = append(egressContainer.Ports,
+			corev1.ContainerPort{Name: "state-sync", ContainerPort: stateSyncPort, Protocol: corev1.ProtocolTCP})
+	}
 	egressContainer.LivenessProbe = &corev1.Probe{
 		ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{
 			Path:   "/healthz",
@@ -226,6 +294,31 @@ func (r *EgressReconciler) reconcilePodTemplate(eg *coilv2.Egress, depl *appsv1.
 	podSpec.DeepCopyInto(&target.Spec)
 }
 
+func topologySpreadConstraints(eg *coilv2.Egress) []corev1.TopologySpreadConstraint {
+	ts := eg.Spec.TopologySpread
+	maxSkew := ts.MaxSkew
+	if maxSkew < 1 {
+		maxSkew = 1
+	}
+	whenUnsatisfiable := ts.WhenUnsatisfiable
+	if whenUnsatisfiable == "" {
+		whenUnsatisfiable = corev1.ScheduleAnyway
+	}
+
+	var constraints []corev1.TopologySpreadConstraint
+	for _, key := range ts.GetTopologyKeys() {
+		constraints = append(constraints, corev1.TopologySpreadConstraint{
+			MaxSkew:           maxSkew,
+			TopologyKey:       key,
+			WhenUnsatisfiable: whenUnsatisfiable,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: selectorLabels(eg.Name)},
+			// spread only the pods of the current revision during rolling updates.
+			MatchLabelKeys: []string{appsv1.DefaultDeploymentUniqueLabelKey},
+		})
+	}
+	return constraints
+}
+
 func (r *EgressReconciler) addVolumes(vols []corev1.Volume) []corev1.Volume {
 	noRun := true
 	for _, vol := range vols {
@@ -326,6 +419,46 @@ func (r *EgressReconciler) reconcileDeployment(ctx context.Context, log logr.Log
 	return nil
 }
 
+// reconcileStateSyncSecret creates the key pair shared by egress pods to
+// authenticate each other in state sync.  The Secret is never updated once
+// created because egress pods load it only at startup.
+func (r *EgressReconciler) reconcileStateSyncSecret(ctx context.Context, log logr.Logger, eg *coilv2.Egress) error {
+	if eg.Spec.StateSync == nil {
+		return nil
+	}
+
+	// use APIReader not to cache all Secrets in the cluster.
+	secret := &corev1.Secret{}
+	err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: eg.Namespace, Name: stateSyncSecretName(eg.Name)}, secret)
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	certPEM, keyPEM, err := statesync.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	secret.Namespace = eg.Namespace
+	secret.Name = stateSyncSecretName(eg.Name)
+	secret.Labels = selectorLabels(eg.Name)
+	secret.Type = corev1.SecretTypeTLS
+	secret.Data = map[string][]byte{
+		corev1.TLSCertKey:       certPEM,
+		corev1.TLSPrivateKeyKey: keyPEM,
+	}
+	if err := ctrl.SetControllerReference(eg, secret, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.Create(ctx, secret); err != nil {
+		return client.IgnoreAlreadyExists(err)
+	}
+	log.Info("created secret for state sync")
+	return nil
+}
+
 func (r *EgressReconciler) reconcileService(ctx context.Context, log logr.Logger, eg *coilv2.Egress) error {
 	svc := &corev1.Service{}
 	svc.Namespace = eg.Namespace
@@ -365,6 +498,7 @@ func (r *EgressReconciler) reconcileService(ctx context.Context, log logr.Logger
 		}
 		svc.Spec.IPFamilyPolicy = new(corev1.IPFamilyPolicy)
 		*svc.Spec.IPFamilyPolicy = corev1.IPFamilyPolicyPreferDualStack
+		svc.Spec.TrafficDistribution = eg.Spec.TrafficDistribution
 
 		return nil
 	})
@@ -2,10 +2,12 @@ package controllers
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"time"
 
+	"github.com/go-logr/logr"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	appsv1 "k8s.io/api/apps/v1"
@@ -13,6 +15,7 @@ import (
 	policyv1 "k8s.io/api/policy/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -52,11 +55,12 @@ var _ = Describe("Egress reconciler", func() {
 		Expect(err).ToNot(HaveOccurred())
 
 		egr := &EgressReconciler{
-			Client:  mgr.GetClient(),
-			Scheme:  mgr.GetScheme(),
-			Image:   "coil:dev",
-			Port:    5555,
-			Backend: constants.DefaultEgressBackend,
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
+			Scheme:    mgr.GetScheme(),
+			Image:     "coil:dev",
+			Port:      5555,
+			Backend:   constants.DefaultEgressBackend,
 		}
 		err = egr.SetupWithManager(mgr)
 		Expect(err).ToNot(HaveOccurred())
@@ -578,4 +582,191 @@ var _ = Describe("Egress reconciler", func() {
 			Expect(egressContainer.Args).To(ContainElement(fmt.Sprintf("--backend=%s", backend)))
 		}
 	})
+
+	It("should spread egress pods and prefer egress pods in the same zone", func() {
+		By("creating an Egress")
+		eg := makeEgress("eg8")
+		eg.Spec.TopologySpread = &coilv2.EgressTopologySpread{}
+		eg.Spec.TrafficDistribution = ptr.To(corev1.ServiceTrafficDistributionPreferClose)
+		err := k8sClient.Create(ctx, eg)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		By("checking Deployment")
+		var depl *appsv1.Deployment
+		Eventually(func() error {
+			depl = &appsv1.Deployment{}
+			return k8sClient.Get(ctx, client.ObjectKey{Namespace: eg.Namespace, Name: eg.Name}, depl)
+		}).Should(Succeed())
+
+		constraints := depl.Spec.Template.Spec.TopologySpreadConstraints
+		Expect(constraints).To(HaveLen(2))
+		Expect(constraints[0].TopologyKey).To(Equal(corev1.LabelTopologyZone))
+		Expect(constraints[1].TopologyKey).To(Equal(corev1.LabelHostname))
+		for _, c := range constraints {
+			Expect(c.MaxSkew).To(Equal(int32(1)))
+			Expect(c.WhenUnsatisfiable).To(Equal(corev1.ScheduleAnyway))
+			Expect(c.LabelSelector.MatchLabels).To(Equal(selectorLabels("eg8")))
+		}
+
+		By("checking Service")
+		var svc *corev1.Service
+		Eventually(func() error {
+			svc = &corev1.Service{}
+			return k8sClient.Get(ctx, client.ObjectKey{Namespace: eg.Namespace, Name: eg.Name}, svc)
+		}).Should(Succeed())
+		Expect(svc.Spec.TrafficDistribution).To(Equal(ptr.To(corev1.ServiceTrafficDistributionPreferClose)))
+
+		By("specifying topologySpreadConstraints in the template")
+		Eventually(func() error {
+			if err := k8sClient.Get(ctx, client.ObjectKey{Namespace: eg.Namespace, Name: eg.Name}, eg); err != nil {
+				return err
+			}
+			eg.Spec.Template = &coilv2.EgressPodTemplate{}
+			eg.Spec.Template.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{{
+				MaxSkew:           2,
+				TopologyKey:       corev1.LabelTopologyZone,
+				WhenUnsatisfiable: corev1.DoNotSchedule,
+			}}
+			return k8sClient.Update(ctx, eg)
+		}).Should(Succeed())
+
+		Eventually(func(g Gomega) {
+			depl := &appsv1.Deployment{}
+			err := k8sClient.Get(ctx, client.ObjectKey{Namespace: eg.Namespace, Name: eg.Name}, depl)
+			g.Expect(err).NotTo(HaveOccurred())
+			constraints := depl.Spec.Template.Spec.TopologySpreadConstraints
+			g.Expect(constraints).To(HaveLen(1))
+			g.Expect(constraints[0].MaxSkew).To(Equal(int32(2)))
+		}).Should(Succeed())
+	})
+
+	It("should drain egress pods", func() {
+		egr := &EgressReconciler{
+			Client:  k8sClient,
+			Scheme:  scheme,
+			Image:   "coil:dev",
+			Port:    5555,
+			Backend: constants.EgressBackendIPTables,
+		}
+
+		eg := makeEgress("eg-drain")
+		eg.Spec.DrainTimeout = &metav1.Duration{Duration: time.Minute}
+		depl := &appsv1.Deployment{}
+		depl.Namespace = eg.Namespace
+		depl.Name = eg.Name
+
+		egr.reconcilePodTemplate(eg, depl)
+
+		podSpec := depl.Spec.Template.Spec
+		Expect(podSpec.TerminationGracePeriodSeconds).To(Equal(ptr.To(int64(60 + drainGracePeriodMargin))))
+		var egressContainer *corev1.Container
+		for i := range podSpec.Containers {
+			c := &podSpec.Containers[i]
+			if c.Name == "egress" {
+				egressContainer = c
+				break
+			}
+		}
+		Expect(egressContainer).NotTo(BeNil())
+		Expect(egressContainer.Args).To(ContainElement("--drain-timeout=1m0s"))
+
+		By("respecting terminationGracePeriodSeconds in the template")
+		eg.Spec.Template = &coilv2.EgressPodTemplate{}
+		eg.Spec.Template.Spec.TerminationGracePeriodSeconds = ptr.To(int64(30))
+		egr.reconcilePodTemplate(eg, depl)
+		Expect(depl.Spec.Template.Spec.TerminationGracePeriodSeconds).To(Equal(ptr.To(int64(30))))
+	})
+
+	It("should enable state sync", func() {
+		egr := &EgressReconciler{
+			Client:    k8sClient,
+			APIReader: k8sClient,
+			Scheme:    scheme,
+			Image:     "coil:dev",
+			Port:      5555,
+			Backend:   constants.EgressBackendIPTables,
+		}
+
+		eg := makeEgress("eg-state-sync")
+		eg.Spec.StateSync = &coilv2.EgressStateSync{SourceIPs: []string{"192.0.2.1", "2001:db8::1"}}
+		err := k8sClient.Create(ctx, eg)
+		Expect(err).NotTo(HaveOccurred())
+		depl := &appsv1.Deployment{}
+		depl.Namespace = eg.Namespace
+		depl.Name = eg.Name
+
+		egr.reconcilePodTemplate(eg, depl)
+
+		var egressContainer *corev1.Container
+		for i := range depl.Spec.Template.Spec.Containers {
+			c := &depl.Spec.Template.Spec.Containers[i]
+			if c.Name == "egress" {
+				egressContainer = c
+				break
+			}
+		}
+		Expect(egressContainer).NotTo(BeNil())
+		Expect(egressContainer.Args).To(ContainElements(
+			"--source-ips=192.0.2.1,2001:db8::1",
+			"--enable-state-sync=true",
+			"--state-sync-port=5556",
+			"--state-sync-cert-dir=/etc/coil-egress/state-sync",
+		))
+		Expect(egressContainer.Ports).To(ContainElement(
+			corev1.ContainerPort{Name: "state-sync", ContainerPort: 5556, Protocol: corev1.ProtocolTCP}))
+		Expect(egressContainer.VolumeMounts).To(ContainElement(
+			corev1.VolumeMount{Name: "state-sync-cert", MountPath: "/etc/coil-egress/state-sync", ReadOnly: true}))
+		Expect(depl.Spec.Template.Spec.Volumes).To(ContainElement(
+			corev1.Volume{Name: "state-sync-cert", VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: "eg-state-sync-state-sync"},
+			}}))
+
+		By("creating the key pair")
+		err = egr.reconcileStateSyncSecret(ctx, logr.Discard(), eg)
+		Expect(err).NotTo(HaveOccurred())
+		secret := &corev1.Secret{}
+		err = k8sClient.Get(ctx, client.ObjectKey{Namespace: eg.Namespace, Name: "eg-state-sync-state-sync"}, secret)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.Type).To(Equal(corev1.SecretTypeTLS))
+		Expect(secret.OwnerReferences).To(HaveLen(1))
+		_, err = tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		Expect(err).NotTo(HaveOccurred())
+
+		By("keeping the key pair")
+		err = egr.reconcileStateSyncSecret(ctx, logr.Discard(), eg)
+		Expect(err).NotTo(HaveOccurred())
+		secret2 := &corev1.Secret{}
+		err = k8sClient.Get(ctx, client.ObjectKey{Namespace: eg.Namespace, Name: "eg-state-sync-state-sync"}, secret2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret2.Data).To(Equal(secret.Data))
+	})
+
+	It("should enable transit for chained egress", func() {
+		egr := &EgressReconciler{
+			Client:  k8sClient,
+			Scheme:  scheme,
+			Image:   "coil:dev",
+			Port:    5555,
+			Backend: constants.EgressBackendIPTables,
+		}
+
+		eg := makeEgress("eg-transit")
+		depl := &appsv1.Deployment{}
+		depl.Namespace = eg.Namespace
+		depl.Name = eg.Name
+
+		egr.reconcilePodTemplate(eg, depl)
+		Expect(depl.Spec.Template.Spec.Containers[0].Args).NotTo(ContainElement("--enable-transit=true"))
+
+		eg.Spec.Template = &coilv2.EgressPodTemplate{}
+		eg.Spec.Template.Annotations = map[string]string{"egress.coil.cybozu.com/internet": "upstream"}
+		depl = &appsv1.Deployment{}
+		depl.Namespace = eg.Namespace
+		depl.Name = eg.Name
+
+		egr.reconcilePodTemplate(eg, depl)
+		Expect(depl.Spec.Template.Annotations).To(HaveKeyWithValue("egress.coil.cybozu.com/internet", "upstream"))
+		Expect(depl.Spec.Template.Spec.Containers[0].Name).To(Equal("egress"))
+		Expect(depl.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--enable-transit=true"))
+	})
 })
@@ -10,6 +10,7 @@ import (
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/events"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -17,6 +18,7 @@ import (
 	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
 	"github.com/cybozu-go/coil/v2/pkg/constants"
 	"github.com/cybozu-go/coil/v2/pkg/fou"
+	"github.com/cybozu-go/coil/v2/pkg/nat"
 	"github.com/cybozu-go/coil/v2/pkg/nat/netfilter"
 	"github.com/cybozu-go/coil/v2/pkg/nodenet"
 )
@@ -28,10 +30,12 @@ type EgressWatcher struct {
 	EgressPort      int
 	Backend         string
 	OriginatingOnly bool
+	Recorder        events.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=coil.cybozu.com,resources=egresses,verbs=get;list;watch
-// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods;namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
 
 // Reconcile implements Reconciler interface.
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
@@ -100,6 +104,7 @@ func (r *EgressWatcher) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 						return ctrl.Result{}, err
 					}
 					logger.Error(err, "failed to reconcile Egress client pod")
+					r.recordFailure(eg, targetPod, err)
 					return ctrl.Result{}, err
 				}
 				continue
@@ -113,6 +118,7 @@ func (r *EgressWatcher) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 							return ctrl.Result{}, err
 						}
 						logger.Error(err, "failed to reconcile Egress client pod")
+						r.recordFailure(eg, targetPod, err)
 						return ctrl.Result{}, err
 					}
 					continue
@@ -126,7 +132,7 @@ func (r *EgressWatcher) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 func (r *EgressWatcher) reconcileEgressClient(ctx context.Context, eg *coilv2.Egress, pod *corev1.Pod, logger *logr.Logger) error {
 	logger.Info("Reconciling", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
 
-	hooks, err := r.getHooks(ctx, eg, logger)
+	hooks, err := r.getHooks(ctx, eg, pod, logger)
 	if err != nil {
 		return fmt.Errorf("failed to setup NAT hook: %w", err)
 	}
@@ -152,6 +158,14 @@ func (r *EgressWatcher) reconcileEgressClient(ctx context.Context, eg *coilv2.Eg
 	return nil
 }
 
+// recordFailure records events on both the Egress and the client Pod.
+func (r *EgressWatcher) recordFailure(eg *coilv2.Egress, pod *corev1.Pod, err error) {
+	r.Recorder.Eventf(eg, pod, corev1.EventTypeWarning, constants.ReasonEgressSetupFailed, "UpdateClient",
+		"failed to update NAT configuration of %s/%s: %v", pod.Namespace, pod.Name, err)
+	r.Recorder.Eventf(pod, eg, corev1.EventTypeWarning, constants.ReasonEgressSetupFailed, "UpdateClient",
+		"failed to update NAT configuration for Egress %s/%s: %v", eg.Namespace, eg.Name, err)
+}
+
 type gwNets struct {
 	gateway         net.IP
 	networks        []*net.IPNet
@@ -159,41 +173,107 @@ type gwNets struct {
 	originatingOnly bool
 }
 
-func (r *EgressWatcher) getHooks(ctx context.Context, eg *coilv2.Egress, logger *logr.Logger) ([]nodenet.SetupHook, error) {
-	var gw gwNets
-	svc := &corev1.Service{}
-
-	if err := r.Get(ctx, client.ObjectKey{Namespace: eg.Namespace, Name: eg.Name}, svc); err != nil {
+// getHooks returns the hooks to update the NAT configuration of pod.
+// As the destinations of eg may overlap with those of the other Egresses used
+// by pod, the hooks update the routes to all of them.
+// The routes to the Egresses that do not allow pod as a client are removed.
+func (r *EgressWatcher) getHooks(ctx context.Context, eg *coilv2.Egress, pod *corev1.Pod, logger *logr.Logger) ([]nodenet.SetupHook, error) {
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pod.Namespace}, ns); err != nil {
 		return nil, err
 	}
 
+	var egs []*coilv2.Egress
+	var dests, allowed []nat.Destinations
+	for _, key := range coilv2.EgressesOf(pod.Annotations) {
+		e := eg
+		if key.Namespace != eg.Namespace || key.Name != eg.Name {
+			e = &coilv2.Egress{}
+			if err := r.Get(ctx, key, e); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return nil, err
+			}
+			if e.DeletionTimestamp != nil {
+				continue
+			}
+		}
+
+		d, err := destinationsOf(e)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := e.Spec.AllowsClient(ns, pod)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowedClients in Egress %s: %w", e.Name, err)
+		}
+		egs = append(egs, e)
+		dests = append(dests, d)
+		if ok {
+			allowed = append(allowed, d)
+		} else {
+			logger.Info("pod is not allowed to use egress", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name),
+				"egress", fmt.Sprintf("%s/%s", e.Namespace, e.Name))
+			allowed = append(allowed, nat.Destinations{Priority: d.Priority})
+		}
+	}
+	resolved := netfilter.ResolveDestinations(allowed)
+
 	hooks := []nodenet.SetupHook{}
-	for _, clusterIP := range svc.Spec.ClusterIPs {
-		var subnets []*net.IPNet
-		svcIP := net.ParseIP(clusterIP)
-		if svcIP == nil {
-			return nil, fmt.Errorf("invalid ClusterIP in Service %s %s", eg.Name, svc.Spec.ClusterIP)
+	for i, e := range egs {
+		svc := &corev1.Service{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: e.Namespace, Name: e.Name}, svc); err != nil {
+			if e != eg && apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
 		}
 
-		for _, sn := range eg.Spec.Destinations {
-			_, subnet, err := net.ParseCIDR(sn)
-			if err != nil {
-				return nil, fmt.Errorf("invalid network in Egress %s", eg.Name)
+		for _, clusterIP := range svc.Spec.ClusterIPs {
+			var subnets []*net.IPNet
+			svcIP := net.ParseIP(clusterIP)
+			if svcIP == nil {
+				return nil, fmt.Errorf("invalid ClusterIP in Service %s %s", e.Name, svc.Spec.ClusterIP)
 			}
-			if (svcIP.To4() != nil) == (subnet.IP.To4() != nil) {
-				subnets = append(subnets, subnet)
+
+			var hasFamily bool
+			for _, subnet := range dests[i].Networks {
+				if (svcIP.To4() != nil) == (subnet.IP.To4() != nil) {
+					hasFamily = true
+				}
+			}
+			for _, subnet := range resolved[i] {
+				if (svcIP.To4() != nil) == (subnet.IP.To4() != nil) {
+					subnets = append(subnets, subnet)
+				}
 			}
-		}
 
-		if len(subnets) > 0 {
-			gw = gwNets{gateway: svcIP, networks: subnets, sportAuto: eg.Spec.FouSourcePortAuto, originatingOnly: r.OriginatingOnly}
-			hooks = append(hooks, r.hook(gw, logger))
+			// Even if all the destinations are shadowed by other Egresses or the Egress
+			// does not allow the Pod, the hook has to be called to remove the routes to them.
+			if hasFamily {
+				gw := gwNets{gateway: svcIP, networks: subnets, sportAuto: e.Spec.FouSourcePortAuto, originatingOnly: r.OriginatingOnly}
+				hooks = append(hooks, r.hook(gw, logger))
+			}
 		}
 	}
 
 	return hooks, nil
 }
 
+// destinationsOf returns the destinations of eg with its priority.
+func destinationsOf(eg *coilv2.Egress) (nat.Destinations, error) {
+	d := nat.Destinations{Priority: eg.Spec.Priority}
+	for _, sn := range eg.Spec.Destinations {
+		_, subnet, err := net.ParseCIDR(sn)
+		if err != nil {
+			return d, fmt.Errorf("invalid network in Egress %s", eg.Name)
+		}
+		d.Networks = append(d.Networks, subnet)
+	}
+	return d, nil
+}
+
 func (r *EgressWatcher) hook(gwn gwNets, log *logr.Logger) func(ipv4, ipv6 net.IP) error {
 	return func(ipv4, ipv6 net.IP) error {
 		// We assume that coild already has configured NAT for the client,
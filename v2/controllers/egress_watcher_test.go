@@ -73,6 +73,7 @@ var _ = Describe("Egress watcher", func() {
 			NodeName:   "coil-worker",
 			PodNet:     podNetwork,
 			EgressPort: 5555,
+			Recorder:   mgr.GetEventRecorder("coild"),
 		}
 		err = watcher.SetupWithManager(mgr)
 		Expect(err).ToNot(HaveOccurred())
@@ -170,6 +171,7 @@ var _ = Describe("Egress watcher", func() {
 type mockPodNetwork struct {
 	nUpdate int
 	ips     map[string]int
+	confs   []*nodenet.PodNetConf
 
 	mu sync.Mutex
 }
@@ -178,7 +180,10 @@ func (p *mockPodNetwork) Init() error {
 	panic("not implemented")
 }
 func (p *mockPodNetwork) List() ([]*nodenet.PodNetConf, error) {
-	panic("not implemented")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.confs, nil
 }
 
 func (p *mockPodNetwork) SetupIPAM(nsPath, podName, podNS string, conf *nodenet.PodNetConf) (*current.Result, error) {
@@ -3,7 +3,9 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"maps"
 	"net"
+	"slices"
 	"sync"
 
 	"github.com/vishvananda/netlink"
@@ -11,6 +13,7 @@ import (
 	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
 	"github.com/cybozu-go/coil/v2/pkg/fou"
 	"github.com/cybozu-go/coil/v2/pkg/ipam"
+	"github.com/cybozu-go/coil/v2/pkg/nat"
 )
 
 type mockPoolManager struct {
@@ -139,6 +142,14 @@ func (n *mockNodeIPAM) NodeInternalIP(ctx context.Context) (net.IP, net.IP, erro
 	panic("not implemented")
 }
 
+func (n *mockNodeIPAM) Allocations() []ipam.Allocation {
+	panic("not implemented")
+}
+
+func (n *mockNodeIPAM) SyncRoutes(ctx context.Context) error {
+	panic("not implemented")
+}
+
 func (n *mockNodeIPAM) ClearRoutes(ctx context.Context) error {
 	panic("not implemented")
 }
@@ -198,3 +209,60 @@ func (m *mockLink) Attrs() *netlink.LinkAttrs {
 func (m *mockLink) Type() string {
 	return "mock-link"
 }
+
+type mockNodeNatClient struct {
+	mu     sync.Mutex
+	routes map[string][]string
+	peers  []string
+}
+
+var _ nat.NodeClient = &mockNodeNatClient{}
+
+func (c *mockNodeNatClient) Init() error {
+	panic("not implemented")
+}
+
+func (c *mockNodeNatClient) SyncNat(link netlink.Link, subnets []*net.IPNet) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var nets []string
+	for _, n := range subnets {
+		nets = append(nets, n.String())
+	}
+	c.routes[link.Attrs().Name] = nets
+	return nil
+}
+
+func (c *mockNodeNatClient) Refresh() error {
+	return nil
+}
+
+func (c *mockNodeNatClient) SetPeers(peers []net.IP) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.peers = nil
+	for _, ip := range peers {
+		c.peers = append(c.peers, ip.String())
+	}
+	return nil
+}
+
+func (c *mockNodeNatClient) Clear() error {
+	panic("not implemented")
+}
+
+func (c *mockNodeNatClient) GetRoutes() map[string][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return maps.Clone(c.routes)
+}
+
+func (c *mockNodeNatClient) GetPeers() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.peers)
+}
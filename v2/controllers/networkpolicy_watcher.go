@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/cybozu-go/coil/v2/pkg/netpol"
+	"github.com/cybozu-go/coil/v2/pkg/nodenet"
+)
+
+// networkPolicyKey is the only request handled by NetworkPolicyWatcher.
+// A change of NetworkPolicies, Pods, or Namespaces may affect the rules
+// for every Pod on the node, so all events are coalesced into it.
+var networkPolicyKey = reconcile.Request{NamespacedName: types.NamespacedName{Name: "network-policies"}}
+
+// NetworkPolicyWatcher enforces NetworkPolicies on Pods running on the node.
+type NetworkPolicyWatcher struct {
+	client.Client
+	NodeName string
+	PodNet   nodenet.PodNetwork
+	Enforcer netpol.Enforcer
+
+	// syncMu serializes the synchronization of rules so that a rule set
+	// computed from an older state does not overwrite newer one.
+	syncMu sync.Mutex
+
+	mu         sync.Mutex
+	containers map[types.NamespacedName]addedPod
+}
+
+// addedPod is a Pod whose network has been set up by coild.
+type addedPod struct {
+	containerID string
+
+	// pod is used until the Pod appears in the cache.
+	pod *corev1.Pod
+}
+
+// AddPod enforces NetworkPolicies on a Pod whose network has been set up by coild.
+// The rules for the Pod are programmed before this returns, so the Pod is
+// protected before kubelet starts its containers and reports its addresses.
+func (r *NetworkPolicyWatcher) AddPod(ctx context.Context, pod *corev1.Pod, containerID string) error {
+	r.mu.Lock()
+	if r.containers == nil {
+		r.containers = make(map[types.NamespacedName]addedPod)
+	}
+	r.containers[client.ObjectKeyFromObject(pod)] = addedPod{containerID: containerID, pod: pod.DeepCopy()}
+	r.mu.Unlock()
+
+	return r.sync(ctx)
+}
+
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// Reconcile implements Reconciler interface.
+func (r *NetworkPolicyWatcher) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	if err := r.sync(ctx); err != nil {
+		log.FromContext(ctx).Error(err, "failed to enforce NetworkPolicies")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// sync compiles NetworkPolicies for the Pods on the node and programs the rules.
+func (r *NetworkPolicyWatcher) sync(ctx context.Context) error {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+
+	policies := &networkingv1.NetworkPolicyList{}
+	if err := r.List(ctx, policies); err != nil {
+		return fmt.Errorf("failed to list NetworkPolicy: %w", err)
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods); err != nil {
+		return fmt.Errorf("failed to list Pod: %w", err)
+	}
+
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaces); err != nil {
+		return fmt.Errorf("failed to list Namespace: %w", err)
+	}
+
+	local, err := r.localPods(pods.Items)
+	if err != nil {
+		return fmt.Errorf("failed to list pod networks: %w", err)
+	}
+
+	podPolicies, err := netpol.Compile(local, policies.Items, pods.Items, namespaces.Items)
+	if err != nil {
+		return fmt.Errorf("failed to compile NetworkPolicies: %w", err)
+	}
+
+	if err := r.Enforcer.Sync(podPolicies); err != nil {
+		return fmt.Errorf("failed to sync NetworkPolicy rules: %w", err)
+	}
+	return nil
+}
+
+// localPods matches Pods on the node with their host-side veths.
+// Pods registered by AddPod are matched by their containers, and the others
+// are matched by the IP addresses in their status.  Pods registered by AddPod
+// but not yet in pods are included too.
+func (r *NetworkPolicyWatcher) localPods(pods []corev1.Pod) ([]netpol.LocalPod, error) {
+	confs, err := r.PodNet.List()
+	if err != nil {
+		return nil, err
+	}
+
+	byContainer := make(map[string]string)
+	veths := make(map[string]string)
+	for _, c := range confs {
+		if c.HostVethName == "" {
+			continue
+		}
+		byContainer[c.ContainerId] = c.HostVethName
+		if c.IPv4 != nil {
+			veths[c.IPv4.String()] = c.HostVethName
+		}
+		if c.IPv6 != nil {
+			veths[c.IPv6.String()] = c.HostVethName
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// forget the containers that have been torn down.
+	for key, added := range r.containers {
+		if _, ok := byContainer[added.containerID]; !ok {
+			delete(r.containers, key)
+		}
+	}
+
+	var local []netpol.LocalPod
+	seen := make(map[types.NamespacedName]bool)
+	for i := range pods {
+		pod := &pods[i]
+		key := client.ObjectKeyFromObject(pod)
+		seen[key] = true
+		if pod.Spec.NodeName != r.NodeName || pod.Spec.HostNetwork {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if added, ok := r.containers[key]; ok {
+			local = append(local, netpol.LocalPod{Pod: pod, Iface: byContainer[added.containerID]})
+			continue
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			ip := net.ParseIP(podIP.IP)
+			if ip == nil {
+				continue
+			}
+			if veth, ok := veths[ip.String()]; ok {
+				local = append(local, netpol.LocalPod{Pod: pod, Iface: veth})
+				break
+			}
+		}
+	}
+	for key, added := range r.containers {
+		if !seen[key] {
+			local = append(local, netpol.LocalPod{Pod: added.pod, Iface: byContainer[added.containerID]})
+		}
+	}
+	return local, nil
+}
+
+// SetupWithManager registers this with the manager.
+//
+// Events that cannot change the rules are filtered out, and the Enforcer
+// rewrites only the rules for Pods whose policies have changed.
+func (r *NetworkPolicyWatcher) SetupWithManager(mgr ctrl.Manager) error {
+	enqueue := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{networkPolicyKey}
+	})
+
+	podPredicate := predicate.Funcs{
+		// Pods on other nodes without addresses cannot be peers.
+		CreateFunc: func(e event.CreateEvent) bool {
+			pod := e.Object.(*corev1.Pod)
+			return pod.Spec.NodeName == r.NodeName || len(pod.Status.PodIPs) > 0
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			o := e.ObjectOld.(*corev1.Pod)
+			n := e.ObjectNew.(*corev1.Pod)
+			return !equality.Semantic.DeepEqual(o.Labels, n.Labels) ||
+				!slices.Equal(o.Status.PodIPs, n.Status.PodIPs) ||
+				o.Status.Phase != n.Status.Phase ||
+				o.Spec.NodeName != n.Spec.NodeName
+		},
+	}
+	nsPredicate := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !equality.Semantic.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels())
+		},
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("networkpolicy-watcher").
+		Watches(&networkingv1.NetworkPolicy{}, enqueue, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&corev1.Pod{}, enqueue, builder.WithPredicates(podPredicate)).
+		Watches(&corev1.Namespace{}, enqueue, builder.WithPredicates(nsPredicate)).
+		Complete(r)
+}
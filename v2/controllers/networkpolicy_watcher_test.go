@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	"github.com/cybozu-go/coil/v2/pkg/nat/netfilter"
+	"github.com/cybozu-go/coil/v2/pkg/nodenet"
+)
+
+type mockEnforcer struct {
+	mu       sync.Mutex
+	policies []netfilter.PodPolicy
+}
+
+func (e *mockEnforcer) Sync(policies []netfilter.PodPolicy) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.policies = policies
+	return nil
+}
+
+func (e *mockEnforcer) Clear() error {
+	return e.Sync(nil)
+}
+
+func (e *mockEnforcer) get() []netfilter.PodPolicy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.policies
+}
+
+var _ = Describe("NetworkPolicy watcher", func() {
+	ctx := context.Background()
+	var cancel context.CancelFunc
+	enforcer := &mockEnforcer{}
+	var watcher *NetworkPolicyWatcher
+
+	BeforeEach(func() {
+		makePod("np1", []string{"10.1.2.1"}, nil, corev1.PodRunning)
+		makePod("np2", []string{"10.1.2.2"}, nil, corev1.PodRunning)
+
+		podNetwork := &mockPodNetwork{
+			ips: make(map[string]int),
+			confs: []*nodenet.PodNetConf{
+				{ContainerId: "np1", IFace: "eth0", IPv4: net.ParseIP("10.1.2.1"), HostVethName: "veth-np1"},
+				{ContainerId: "np3", IFace: "eth0", IPv4: net.ParseIP("10.1.2.3"), HostVethName: "veth-np3"},
+			},
+		}
+
+		ctx, cancel = context.WithCancel(context.TODO())
+		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+			Scheme:         scheme,
+			LeaderElection: false,
+			Metrics: metricsserver.Options{
+				BindAddress: "0",
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		watcher = &NetworkPolicyWatcher{
+			Client:   mgr.GetClient(),
+			NodeName: "coil-worker",
+			PodNet:   podNetwork,
+			Enforcer: enforcer,
+		}
+		err = watcher.SetupWithManager(mgr)
+		Expect(err).ToNot(HaveOccurred())
+
+		go func() {
+			err := mgr.Start(ctx)
+			if err != nil {
+				panic(err)
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		err := k8sClient.DeleteAllOf(context.Background(), &corev1.Pod{}, client.InNamespace("default"))
+		Expect(err).ShouldNot(HaveOccurred())
+		err = k8sClient.DeleteAllOf(context.Background(), &networkingv1.NetworkPolicy{}, client.InNamespace("default"))
+		Expect(err).ShouldNot(HaveOccurred())
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	It("should sync rules for Pods on the node", func() {
+		np := &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deny"},
+		}
+		err := k8sClient.Create(ctx, np)
+		Expect(err).ToNot(HaveOccurred())
+
+		// np2 is not included because its veth is not found.
+		Eventually(func() error {
+			policies := enforcer.get()
+			if len(policies) != 1 {
+				return errors.New("policies are not synced")
+			}
+			if policies[0].Iface != "veth-np1" || !policies[0].IngressIsolated || policies[0].EgressIsolated {
+				return errors.New("unexpected policy")
+			}
+			return nil
+		}).Should(Succeed())
+
+		err = k8sClient.Delete(ctx, np)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() error {
+			policies := enforcer.get()
+			if len(policies) != 1 || policies[0].IngressIsolated {
+				return errors.New("policies are not synced")
+			}
+			return nil
+		}).Should(Succeed())
+	})
+
+	It("should sync rules for Pods set up by coild before their status is updated", func() {
+		np := &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deny"},
+		}
+		err := k8sClient.Create(ctx, np)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() int {
+			return len(enforcer.get())
+		}).Should(Equal(1))
+
+		pod := &corev1.Pod{}
+		pod.Namespace = "default"
+		pod.Name = "np3"
+		pod.Spec.Containers = []corev1.Container{{Name: "c1", Image: "nginx"}}
+		pod.Spec.NodeName = "coil-worker"
+		err = k8sClient.Create(ctx, pod)
+		Expect(err).ToNot(HaveOccurred())
+
+		// the rules are programmed before AddPod returns, even if the Pod is not in the cache yet.
+		err = watcher.AddPod(ctx, pod, "np3")
+		Expect(err).ToNot(HaveOccurred())
+
+		policies := enforcer.get()
+		Expect(policies).To(HaveLen(2))
+		Expect(policies).To(ContainElement(And(
+			HaveField("Iface", "veth-np3"),
+			HaveField("IngressIsolated", true),
+		)))
+	})
+})
@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+	"github.com/cybozu-go/coil/v2/pkg/fou"
+	"github.com/cybozu-go/coil/v2/pkg/nat"
+	"github.com/cybozu-go/coil/v2/pkg/nat/netfilter"
+)
+
+// NodeEgressWatcher routes the traffic of processes in the host network
+// through the Egresses specified by the annotations of the node.
+//
+// The gateways are kept in memory, so if coild restarts, this implementation
+// can leave some tunnels as garbage.  Such garbage tunnels do no harm as
+// NatClient.Init removes the routes to them.
+type NodeEgressWatcher struct {
+	client.Client
+	NodeName  string
+	Tunnel    fou.FoUTunnel
+	NatClient nat.NodeClient
+	Recorder  events.EventRecorder
+
+	// RefreshInterval is the interval to resolve the selected processes again.
+	// If zero, defaultNodeEgressRefreshInterval is used.
+	RefreshInterval time.Duration
+
+	mu       sync.Mutex
+	gateways map[string]net.IP
+}
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=egresses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
+
+// Reconcile implements Reconciler interface.
+func (r *NodeEgressWatcher) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	node := &corev1.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: r.NodeName}, node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "failed to get node")
+		return ctrl.Result{}, err
+	}
+
+	var egs []*coilv2.Egress
+	var dests []nat.Destinations
+	for _, key := range coilv2.EgressesOf(node.Annotations) {
+		eg := &coilv2.Egress{}
+		if err := r.Get(ctx, key, eg); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			logger.Error(err, "failed to get egress", "egress", key.String())
+			return ctrl.Result{}, err
+		}
+		if eg.DeletionTimestamp != nil {
+			continue
+		}
+		// The tunnel to the Egress is removed if the node is no longer allowed to use it.
+		ok, err := eg.Spec.AllowsNode(node)
+		if err != nil {
+			logger.Error(err, "invalid allowedClients", "egress", key.String())
+			r.recordFailure(eg, node, err)
+			return ctrl.Result{}, err
+		}
+		if !ok {
+			logger.Info("node is not allowed to use egress", "egress", key.String())
+			continue
+		}
+
+		d, err := destinationsOf(eg)
+		if err != nil {
+			logger.Error(err, "failed to get gateways", "egress", key.String())
+			r.recordFailure(eg, node, err)
+			return ctrl.Result{}, err
+		}
+		egs = append(egs, eg)
+		dests = append(dests, d)
+	}
+	resolved := netfilter.ResolveDestinations(dests)
+
+	desired := make(map[string]gwNets)
+	var peers []net.IP
+	for i, eg := range egs {
+		key := client.ObjectKeyFromObject(eg)
+		gws, err := r.getGateways(ctx, eg, resolved[i])
+		if err != nil {
+			logger.Error(err, "failed to get gateways", "egress", key.String())
+			r.recordFailure(eg, node, err)
+			return ctrl.Result{}, err
+		}
+		for _, gw := range gws {
+			desired[gw.gateway.String()] = gw
+			peers = append(peers, gw.gateway)
+		}
+		if len(gws) == 0 {
+			continue
+		}
+		podIPs, err := r.getPodIPs(ctx, eg)
+		if err != nil {
+			logger.Error(err, "failed to get egress pods", "egress", key.String())
+			return ctrl.Result{}, err
+		}
+		peers = append(peers, podIPs...)
+	}
+
+	// Replies from the Egress pods are decapsulated before their source
+	// addresses are translated back to the ClusterIPs, so both are peers.
+	if err := r.NatClient.SetPeers(peers); err != nil {
+		logger.Error(err, "failed to set tunnel peers")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.sync(desired); err != nil {
+		logger.Error(err, "failed to sync node egress")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// getGateways returns the gateways of eg with the destinations in networks for each IP family.
+func (r *NodeEgressWatcher) getGateways(ctx context.Context, eg *coilv2.Egress, networks []*net.IPNet) ([]gwNets, error) {
+	svc := &corev1.Service{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: eg.Namespace, Name: eg.Name}, svc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var gws []gwNets
+	for _, clusterIP := range svc.Spec.ClusterIPs {
+		svcIP := net.ParseIP(clusterIP)
+		if svcIP == nil {
+			return nil, fmt.Errorf("invalid ClusterIP in Service %s %s", eg.Name, clusterIP)
+		}
+
+		var subnets []*net.IPNet
+		for _, subnet := range networks {
+			if (svcIP.To4() != nil) == (subnet.IP.To4() != nil) {
+				subnets = append(subnets, subnet)
+			}
+		}
+
+		if len(subnets) > 0 {
+			gws = append(gws, gwNets{gateway: svcIP, networks: subnets, sportAuto: eg.Spec.FouSourcePortAuto})
+		}
+	}
+	return gws, nil
+}
+
+// getPodIPs returns the addresses of the pods of eg.
+func (r *NodeEgressWatcher) getPodIPs(ctx context.Context, eg *coilv2.Egress) ([]net.IP, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(eg.Namespace), client.MatchingLabels(selectorLabels(eg.Name))); err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, pod := range pods.Items {
+		for _, podIP := range pod.Status.PodIPs {
+			if ip := net.ParseIP(podIP.IP); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips, nil
+}
+
+func (r *NodeEgressWatcher) sync(desired map[string]gwNets) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.gateways == nil {
+		r.gateways = make(map[string]net.IP)
+	}
+
+	for key, gw := range desired {
+		link, err := r.Tunnel.AddPeer(gw.gateway, gw.sportAuto)
+		if errors.Is(err, fou.ErrIPFamilyMismatch) {
+			// ignore unsupported IP family link
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add tunnel to %s: %w", gw.gateway, err)
+		}
+		if err := r.NatClient.SyncNat(link, gw.networks); err != nil {
+			return fmt.Errorf("failed to sync routes via %s: %w", gw.gateway, err)
+		}
+		r.gateways[key] = gw.gateway
+	}
+
+	for key, ip := range r.gateways {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		// deleting the tunnel also removes the routes via it.
+		if err := r.Tunnel.DelPeer(ip); err != nil {
+			return fmt.Errorf("failed to delete tunnel to %s: %w", ip, err)
+		}
+		delete(r.gateways, key)
+	}
+	return nil
+}
+
+func (r *NodeEgressWatcher) recordFailure(eg *coilv2.Egress, node *corev1.Node, err error) {
+	r.Recorder.Eventf(eg, node, corev1.EventTypeWarning, constants.ReasonEgressSetupFailed, "UpdateNode",
+		"failed to update NAT configuration of node %s: %v", node.Name, err)
+	r.Recorder.Eventf(node, eg, corev1.EventTypeWarning, constants.ReasonEgressSetupFailed, "UpdateNode",
+		"failed to update NAT configuration for Egress %s/%s: %v", eg.Namespace, eg.Name, err)
+}
+
+const defaultNodeEgressRefreshInterval = 30 * time.Second
+
+// refresh calls NatClient.Refresh periodically until ctx is done.
+// Failures are reported but do not stop coild because the other
+// processes and Pods on the node are not affected.
+func (r *NodeEgressWatcher) refresh(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("node-egress-refresher")
+
+	interval := r.RefreshInterval
+	if interval == 0 {
+		interval = defaultNodeEgressRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// report the same failure only once
+	var lastErr string
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		err := r.NatClient.Refresh()
+		if err == nil {
+			lastErr = ""
+			continue
+		}
+		if err.Error() == lastErr {
+			continue
+		}
+		lastErr = err.Error()
+		logger.Error(err, "failed to refresh node egress rules")
+
+		node := &corev1.Node{}
+		if err2 := r.Get(ctx, client.ObjectKey{Name: r.NodeName}, node); err2 == nil {
+			r.Recorder.Eventf(node, nil, corev1.EventTypeWarning, constants.ReasonEgressSetupFailed, "RefreshNode",
+				"failed to update node egress rules: %v", err)
+		}
+	}
+}
+
+// SetupWithManager registers this with the manager.
+func (r *NodeEgressWatcher) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.Add(manager.RunnableFunc(r.refresh)); err != nil {
+		return err
+	}
+
+	nodeKey := reconcile.Request{NamespacedName: types.NamespacedName{Name: r.NodeName}}
+	enqueue := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{nodeKey}
+	})
+	isMyNode := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == r.NodeName
+	})
+	isEgressPod := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetLabels()[constants.LabelAppComponent] == "egress"
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("node-egress-watcher").
+		For(&corev1.Node{}, builder.WithPredicates(isMyNode)).
+		Watches(&coilv2.Egress{}, enqueue).
+		Watches(&corev1.Service{}, enqueue).
+		Watches(&corev1.Pod{}, enqueue, builder.WithPredicates(isEgressPod)).
+		Complete(r)
+}
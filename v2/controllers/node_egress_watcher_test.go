@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+)
+
+var _ = Describe("Node egress watcher", func() {
+	ctx := context.Background()
+	var cancel context.CancelFunc
+	var ft *mockFoUTunnel
+	var cl *mockNodeNatClient
+
+	BeforeEach(func() {
+		eg := makeEgress("node-egress")
+		err := k8sClient.Create(ctx, eg)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		svc := &corev1.Service{}
+		svc.Namespace = "default"
+		svc.Name = "node-egress"
+		svc.Spec.Type = corev1.ServiceTypeClusterIP
+		svc.Spec.Ports = []corev1.ServicePort{{
+			Port:       5555,
+			TargetPort: intstr.FromInt(5555),
+			Protocol:   corev1.ProtocolUDP,
+		}}
+		err = k8sClient.Create(ctx, svc)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		makeNode("egress-client-node", []string{"10.2.1.1"}, map[string]string{
+			"default": "node-egress",
+		})
+
+		ctx, cancel = context.WithCancel(context.TODO())
+		ft = &mockFoUTunnel{peers: make(map[string]bool)}
+		cl = &mockNodeNatClient{routes: make(map[string][]string)}
+		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+			Scheme:         scheme,
+			LeaderElection: false,
+			Metrics: metricsserver.Options{
+				BindAddress: "0",
+			},
+			Controller: config.Controller{
+				SkipNameValidation: ptr.To(true),
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		watcher := &NodeEgressWatcher{
+			Client:    mgr.GetClient(),
+			NodeName:  "egress-client-node",
+			Tunnel:    ft,
+			NatClient: cl,
+			Recorder:  mgr.GetEventRecorder("coild"),
+		}
+		err = watcher.SetupWithManager(mgr)
+		Expect(err).ToNot(HaveOccurred())
+
+		go func() {
+			err := mgr.Start(ctx)
+			if err != nil {
+				panic(err)
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		node := &corev1.Node{}
+		node.Name = "egress-client-node"
+		err := k8sClient.Delete(context.Background(), node)
+		Expect(err).ShouldNot(HaveOccurred())
+		svc := &corev1.Service{}
+		svc.Namespace = "default"
+		svc.Name = "node-egress"
+		err = k8sClient.Delete(context.Background(), svc)
+		Expect(err).ShouldNot(HaveOccurred())
+		err = k8sClient.DeleteAllOf(context.Background(), &coilv2.Egress{}, client.InNamespace("default"))
+		Expect(err).ShouldNot(HaveOccurred())
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	It("should route the traffic of the node through the Egress", func() {
+		svc := &corev1.Service{}
+		err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "node-egress"}, svc)
+		Expect(err).ToNot(HaveOccurred())
+		gw := svc.Spec.ClusterIP
+
+		Eventually(func() bool {
+			return reflect.DeepEqual(ft.GetPeers(), map[string]bool{gw: false}) &&
+				reflect.DeepEqual(cl.GetRoutes(), map[string][]string{gw: {"10.1.2.0/24"}}) &&
+				reflect.DeepEqual(cl.GetPeers(), []string{gw})
+		}).Should(BeTrue())
+
+		eg := &coilv2.Egress{}
+		err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "node-egress"}, eg)
+		Expect(err).ToNot(HaveOccurred())
+		eg.Spec.Destinations = []string{"10.1.3.0/24"}
+		err = k8sClient.Update(ctx, eg)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() bool {
+			return reflect.DeepEqual(cl.GetRoutes(), map[string][]string{gw: {"10.1.3.0/24"}})
+		}).Should(BeTrue())
+
+		node := &corev1.Node{}
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: "egress-client-node"}, node)
+		Expect(err).ToNot(HaveOccurred())
+		node.Annotations = nil
+		err = k8sClient.Update(ctx, node)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() bool {
+			return len(ft.GetPeers()) == 0 && len(cl.GetPeers()) == 0
+		}).Should(BeTrue())
+	})
+
+	It("should remove the tunnel when the node is no longer allowed", func() {
+		Eventually(func() int {
+			return len(ft.GetPeers())
+		}).Should(Equal(1))
+
+		eg := &coilv2.Egress{}
+		err := k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "node-egress"}, eg)
+		Expect(err).ToNot(HaveOccurred())
+		eg.Spec.AllowedClients = &coilv2.EgressAllowedClients{
+			NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"egress": "allowed"}},
+		}
+		err = k8sClient.Update(ctx, eg)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() int {
+			return len(ft.GetPeers())
+		}).Should(Equal(0))
+
+		node := &corev1.Node{}
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: "egress-client-node"}, node)
+		Expect(err).ToNot(HaveOccurred())
+		node.Labels = map[string]string{"egress": "allowed"}
+		err = k8sClient.Update(ctx, node)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() int {
+			return len(ft.GetPeers())
+		}).Should(Equal(1))
+	})
+})
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+	"github.com/cybozu-go/coil/v2/pkg/fou"
+	"github.com/cybozu-go/coil/v2/pkg/nat"
+)
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=egresses,verbs=get;list;watch
+
+// SetupNodeWatcher registers node watching reconciler to mgr.
+func SetupNodeWatcher(mgr ctrl.Manager, ns, name string, ft fou.FoUTunnel, encapSportAuto bool, nat nat.Server) error {
+	r := &nodeWatcher{
+		client:         mgr.GetClient(),
+		myNS:           ns,
+		myName:         name,
+		ft:             ft,
+		encapSportAuto: encapSportAuto,
+		nat:            nat,
+		nodeAddrs:      make(map[string][]net.IP),
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("node-watcher").
+		For(&corev1.Node{}).
+		Watches(&coilv2.Egress{}, handler.EnqueueRequestsFromMapFunc(r.mapEgress)).
+		Complete(r)
+}
+
+// nodeWatcher adds FoU tunnels for nodes annotated to use this egress,
+// and removes them when the annotations or the nodes are deleted.
+//
+// As with podWatcher, the mapping between node and tunnel is kept in memory.
+type nodeWatcher struct {
+	client         client.Client
+	myNS           string
+	myName         string
+	ft             fou.FoUTunnel
+	encapSportAuto bool
+	nat            nat.Server
+
+	mu        sync.Mutex
+	nodeAddrs map[string][]net.IP
+}
+
+func (r *nodeWatcher) shouldHandle(node *corev1.Node) bool {
+	v, ok := node.Annotations[constants.AnnEgressPrefix+r.myNS]
+	if !ok {
+		return false
+	}
+	for _, n := range strings.Split(v, ",") {
+		if n == r.myName {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *nodeWatcher) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	node := &corev1.Node{}
+	err := r.client.Get(ctx, req.NamespacedName, node)
+	if err != nil && !apierrors.IsNotFound(err) {
+		logger.Error(err, "failed to get node")
+		return ctrl.Result{}, err
+	}
+
+	handle := err == nil && node.DeletionTimestamp == nil && r.shouldHandle(node)
+	if handle {
+		allowed, err := r.isAllowed(ctx, node)
+		if err != nil {
+			logger.Error(err, "failed to check allowedClients")
+			return ctrl.Result{}, err
+		}
+		if !allowed {
+			// The tunnel is removed if the node is no longer allowed.
+			logger.Info("node is not allowed to use this egress", "node", node.Name)
+			handle = false
+		}
+	}
+
+	var addrs []net.IP
+	if handle {
+		for _, a := range node.Status.Addresses {
+			if a.Type != corev1.NodeInternalIP {
+				continue
+			}
+			if ip := net.ParseIP(a.Address); ip != nil {
+				addrs = append(addrs, ip)
+			}
+		}
+	}
+
+	if err := r.setNode(req.Name, addrs, logger); err != nil {
+		logger.Error(err, "failed to setup tunnel")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// isAllowed returns true if this egress allows node as a client.
+// If the Egress is not found, it is being deleted, so any client is allowed.
+func (r *nodeWatcher) isAllowed(ctx context.Context, node *corev1.Node) (bool, error) {
+	eg := &coilv2.Egress{}
+	if err := r.client.Get(ctx, client.ObjectKey{Namespace: r.myNS, Name: r.myName}, eg); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return eg.Spec.AllowsNode(node)
+}
+
+// mapEgress returns the client nodes of this egress when it is updated.
+func (r *nodeWatcher) mapEgress(ctx context.Context, obj client.Object) []reconcile.Request {
+	if obj.GetNamespace() != r.myNS || obj.GetName() != r.myName {
+		return nil
+	}
+
+	var nodes corev1.NodeList
+	if err := r.client.List(ctx, &nodes); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list nodes")
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for i := range nodes.Items {
+		if !r.shouldHandle(&nodes.Items[i]) {
+			continue
+		}
+		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&nodes.Items[i])})
+	}
+	return reqs
+}
+
+// setNode adds tunnels to addrs and removes tunnels to the other addresses of the node.
+func (r *nodeWatcher) setNode(name string, addrs []net.IP, logger logr.Logger) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing := r.nodeAddrs[name]
+
+OUTER:
+	for _, ip := range addrs {
+		for _, eip := range existing {
+			if ip.Equal(eip) {
+				continue OUTER
+			}
+		}
+
+		logger.Info("add node", "node", name, "ip", ip.String())
+		link, err := r.ft.AddPeer(ip, r.encapSportAuto)
+		if errors.Is(err, fou.ErrIPFamilyMismatch) {
+			logger.Info("skipping unsupported node IP", "node", name, "ip", ip.String())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.nat.AddClient(ip, link); err != nil {
+			return err
+		}
+	}
+
+OUTER2:
+	for _, eip := range existing {
+		for _, ip := range addrs {
+			if eip.Equal(ip) {
+				continue OUTER2
+			}
+		}
+		logger.Info("delete peer", "node", name, "ip", eip.String())
+		if err := r.ft.DelPeer(eip); err != nil {
+			return err
+		}
+	}
+
+	if len(addrs) == 0 {
+		delete(r.nodeAddrs, name)
+		return nil
+	}
+	r.nodeAddrs[name] = addrs
+	return nil
+}
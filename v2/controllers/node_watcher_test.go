@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	"github.com/cybozu-go/coil/v2/pkg/nat"
+	"github.com/cybozu-go/coil/v2/pkg/nat/mock"
+)
+
+func makeNode(name string, ips []string, egresses map[string]string) {
+	node := &corev1.Node{}
+	node.Name = name
+	node.Annotations = make(map[string]string)
+	for k, v := range egresses {
+		node.Annotations["egress.coil.cybozu.com/"+k] = v
+	}
+	err := k8sClient.Create(context.Background(), node)
+	ExpectWithOffset(1, err).ShouldNot(HaveOccurred())
+
+	for _, ip := range ips {
+		node.Status.Addresses = append(node.Status.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: ip})
+	}
+	node.Status.Addresses = append(node.Status.Addresses, corev1.NodeAddress{Type: corev1.NodeHostName, Address: name})
+	err = k8sClient.Status().Update(context.Background(), node)
+	ExpectWithOffset(1, err).ShouldNot(HaveOccurred())
+}
+
+var _ = Describe("Node watcher", Ordered, func() {
+	ctx := context.Background()
+	var cancel context.CancelFunc
+	var ft *mockFoUTunnel
+	var nat nat.Server
+
+	BeforeEach(func() {
+		makeNode("egress-node1", []string{"10.2.0.1", "fd02::1"}, nil)
+		makeNode("egress-node2", []string{"10.2.0.2"}, map[string]string{
+			"internet": "egress2",
+		})
+		makeNode("egress-node3", []string{"10.2.0.3"}, map[string]string{
+			"internet": "egress1",
+			"external": "egress2",
+		})
+
+		ctx, cancel = context.WithCancel(context.TODO())
+		ft = &mockFoUTunnel{peers: make(map[string]bool)}
+		nat = mock.NewNatServer()
+		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+			Scheme:         scheme,
+			LeaderElection: false,
+			Metrics: metricsserver.Options{
+				BindAddress: "0",
+			},
+			Controller: config.Controller{
+				SkipNameValidation: ptr.To(true),
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		err = SetupNodeWatcher(mgr, "internet", "egress2", ft, false, nat)
+		Expect(err).ToNot(HaveOccurred())
+
+		go func() {
+			err := mgr.Start(ctx)
+			if err != nil {
+				panic(err)
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		for _, name := range []string{"egress-node1", "egress-node2", "egress-node3"} {
+			node := &corev1.Node{}
+			node.Name = name
+			err := k8sClient.Delete(context.Background(), node)
+			Expect(client.IgnoreNotFound(err)).ShouldNot(HaveOccurred())
+		}
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	It("should handle annotated nodes", func() {
+		Eventually(func() bool {
+			return reflect.DeepEqual(ft.GetPeers(), map[string]bool{
+				"10.2.0.2": false,
+			})
+		}).Should(BeTrue())
+
+		Eventually(func() bool {
+			return reflect.DeepEqual(nat.GetClients(), map[string]struct{}{
+				"10.2.0.2": {},
+			})
+		}).Should(BeTrue())
+	})
+
+	It("should follow annotation changes", func() {
+		node1 := &corev1.Node{}
+		err := k8sClient.Get(ctx, client.ObjectKey{Name: "egress-node1"}, node1)
+		Expect(err).NotTo(HaveOccurred())
+		node1.Annotations = map[string]string{"egress.coil.cybozu.com/internet": "egress1,egress2"}
+		err = k8sClient.Update(ctx, node1)
+		Expect(err).NotTo(HaveOccurred())
+
+		node2 := &corev1.Node{}
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: "egress-node2"}, node2)
+		Expect(err).NotTo(HaveOccurred())
+		node2.Annotations = nil
+		err = k8sClient.Update(ctx, node2)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() bool {
+			return reflect.DeepEqual(ft.GetPeers(), map[string]bool{
+				"10.2.0.1": false,
+				"fd02::1":  false,
+			})
+		}).Should(BeTrue())
+	})
+
+	It("should check node deletion", func() {
+		Eventually(func() bool {
+			return reflect.DeepEqual(ft.GetPeers(), map[string]bool{
+				"10.2.0.2": false,
+			})
+		}).Should(BeTrue())
+
+		node2 := &corev1.Node{}
+		node2.Name = "egress-node2"
+		err := k8sClient.Delete(ctx, node2)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() bool {
+			return len(ft.GetPeers()) == 0
+		}).Should(BeTrue())
+	})
+})
@@ -16,11 +16,14 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
 	"github.com/cybozu-go/coil/v2/pkg/constants"
 	"github.com/cybozu-go/coil/v2/pkg/fou"
 	"github.com/cybozu-go/coil/v2/pkg/nat"
@@ -53,7 +56,8 @@ func init() {
 	metrics.Registry.MustRegister(ClientPodInfo)
 }
 
-// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods;namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=egresses,verbs=get;list;watch
 
 // SetupPodWatcher registers pod watching reconciler to mgr and returns a readiness checker
 // that reports whether the initial pod sync has completed.
@@ -89,6 +93,14 @@ func SetupPodWatcher(mgr ctrl.Manager, ns, name string, ft fou.FoUTunnel, encapS
 			if !r.shouldHandle(&pod) {
 				continue
 			}
+			allowed, err := r.isAllowed(ctx, &pod)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				log.FromContext(ctx).Info("pod is not allowed to use this egress", "pod", pod.Name, "namespace", pod.Namespace)
+				continue
+			}
 			if !isTerminated(&pod) {
 				if err := r.addPod(&pod, log.FromContext(ctx)); err != nil {
 					return err
@@ -109,6 +121,8 @@ func SetupPodWatcher(mgr ctrl.Manager, ns, name string, ft fou.FoUTunnel, encapS
 
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Pod{}).
+		Watches(&coilv2.Egress{}, handler.EnqueueRequestsFromMapFunc(r.mapEgress)).
+		Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.mapNamespace)).
 		Complete(r); err != nil {
 		return nil, err
 	}
@@ -195,6 +209,21 @@ func (r *podWatcher) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 			return ctrl.Result{}, err
 		}
 
+		allowed, err := r.isAllowed(ctx, pod)
+		if err != nil {
+			logger.Error(err, "failed to check allowedClients")
+			return ctrl.Result{}, err
+		}
+		if !allowed {
+			// revoke the tunnel if the pod is no longer allowed.
+			logger.Info("pod is not allowed to use this egress", "pod", pod.Name, "namespace", pod.Namespace)
+			if err := r.delPod(req.NamespacedName, logger); err != nil {
+				logger.Error(err, "failed to remove tunnel")
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+
 		if !isTerminated(pod) {
 			if err := r.addPod(pod, logger); err != nil {
 				logger.Error(err, "failed to setup tunnel")
@@ -222,6 +251,58 @@ func (r *podWatcher) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 	return ctrl.Result{}, nil
 }
 
+// isAllowed returns true if this egress allows pod as a client.
+// If the Egress is not found, it is being deleted, so any client is allowed.
+func (r *podWatcher) isAllowed(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	eg := &coilv2.Egress{}
+	if err := r.client.Get(ctx, client.ObjectKey{Namespace: r.myNS, Name: r.myName}, eg); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if eg.Spec.AllowedClients == nil {
+		return true, nil
+	}
+
+	ns := &corev1.Namespace{}
+	if err := r.client.Get(ctx, client.ObjectKey{Name: pod.Namespace}, ns); err != nil {
+		return false, err
+	}
+	return eg.Spec.AllowsClient(ns, pod)
+}
+
+// mapEgress returns the client Pods of this egress when it is updated.
+func (r *podWatcher) mapEgress(ctx context.Context, obj client.Object) []reconcile.Request {
+	if obj.GetNamespace() != r.myNS || obj.GetName() != r.myName {
+		return nil
+	}
+	return r.clientRequests(ctx)
+}
+
+// mapNamespace returns the client Pods in the namespace when its labels are updated.
+func (r *podWatcher) mapNamespace(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.clientRequests(ctx, client.InNamespace(obj.GetName()))
+}
+
+func (r *podWatcher) clientRequests(ctx context.Context, opts ...client.ListOption) []reconcile.Request {
+	var pods corev1.PodList
+	if err := r.client.List(ctx, &pods, opts...); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list pods")
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !r.shouldHandle(pod) {
+			continue
+		}
+		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pod)})
+	}
+	return reqs
+}
+
 func (r *podWatcher) addPod(pod *corev1.Pod, logger logr.Logger) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -11,6 +11,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -18,6 +19,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
 	"github.com/cybozu-go/coil/v2/pkg/nat"
 	"github.com/cybozu-go/coil/v2/pkg/nat/mock"
 )
@@ -399,4 +401,48 @@ var _ = Describe("Pod watcher", Ordered, func() {
 			})
 		}, 5*time.Second, 1*time.Second).Should(BeTrue())
 	})
+
+	It("should revoke tunnels of Pods not allowed to use the egress", func() {
+		Eventually(func() int {
+			return len(ft.GetPeers())
+		}).Should(Equal(3))
+
+		ns := &corev1.Namespace{}
+		ns.Name = "internet"
+		err := k8sClient.Create(ctx, ns)
+		Expect(client.IgnoreAlreadyExists(err)).NotTo(HaveOccurred())
+
+		eg := &coilv2.Egress{}
+		eg.Namespace = "internet"
+		eg.Name = "egress2"
+		eg.Spec.Destinations = []string{"10.1.2.0/24"}
+		eg.Spec.Replicas = 1
+		eg.Spec.AllowedClients = &coilv2.EgressAllowedClients{
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"nat": "allowed"}},
+		}
+		err = k8sClient.Create(ctx, eg)
+		Expect(err).NotTo(HaveOccurred())
+		defer func() {
+			err := k8sClient.Delete(ctx, eg)
+			Expect(err).NotTo(HaveOccurred())
+		}()
+
+		Eventually(func() int {
+			return len(ft.GetPeers())
+		}).Should(Equal(0))
+
+		pod2 := &corev1.Pod{}
+		err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "pod2"}, pod2)
+		Expect(err).NotTo(HaveOccurred())
+		pod2.Labels = map[string]string{"nat": "allowed"}
+		err = k8sClient.Update(ctx, pod2)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() bool {
+			return reflect.DeepEqual(ft.GetPeers(), map[string]bool{
+				"10.1.1.2": true,
+				"fd01::2":  true,
+			})
+		}).Should(BeTrue())
+	})
 })
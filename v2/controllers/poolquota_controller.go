@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+// reservationGracePeriod is the time to keep a reservation for a Pod that is not found.
+const reservationGracePeriod = time.Minute
+
+// PoolQuotaReconciler counts addresses used by Pods in the namespace of PoolQuota.
+type PoolQuotaReconciler struct {
+	client.Client
+}
+
+var _ reconcile.Reconciler = &PoolQuotaReconciler{}
+
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=poolquotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=poolquotas/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=pods;namespaces,verbs=get;list;watch
+
+// Reconcile implements Reconciler interface.
+func (r *PoolQuotaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	quota := &coilv2.PoolQuota{}
+	if err := r.Get(ctx, req.NamespacedName, quota); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	used, reservations, err := r.countAddresses(ctx, quota)
+	if err != nil {
+		logger.Error(err, "failed to count addresses")
+		return ctrl.Result{}, err
+	}
+
+	// reservations for Pods not yet seen are checked again after the grace period.
+	var result ctrl.Result
+	if len(reservations) > 0 {
+		result.RequeueAfter = reservationGracePeriod
+	}
+
+	if quota.Status.Used == used && equality.Semantic.DeepEqual(quota.Status.Reservations, reservations) {
+		return result, nil
+	}
+
+	// coild reserves addresses by updating the status, so the update
+	// fails with a conflict if a reservation is made in the meantime.
+	quota.Status.Used = used
+	quota.Status.Reservations = reservations
+	if err := r.Status().Update(ctx, quota); err != nil {
+		if apierrors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		logger.Error(err, "failed to update status")
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// countAddresses returns the number of addresses used by Pods in the namespace
+// if the namespace uses the pool, or 0 otherwise.  It also returns the
+// reservations that are still needed.  A reservation is no longer needed
+// once the Pod reports its address, or if the Pod has gone or terminated.
+func (r *PoolQuotaReconciler) countAddresses(ctx context.Context, quota *coilv2.PoolQuota) (int32, []coilv2.PoolQuotaReservation, error) {
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: quota.Namespace}, ns); err != nil {
+		return 0, nil, err
+	}
+
+	nsPool := constants.DefaultPool
+	if v, ok := ns.Annotations[constants.AnnPool]; ok {
+		nsPool = v
+	}
+	if nsPool != quota.Spec.PoolName {
+		return 0, nil, nil
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(quota.Namespace)); err != nil {
+		return 0, nil, err
+	}
+
+	counted := make(map[string]bool)
+	running := make(map[string]bool)
+	for _, pod := range pods.Items {
+		if pod.Spec.HostNetwork {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		running[pod.Name] = true
+		if len(pod.Status.PodIPs) == 0 {
+			continue
+		}
+		counted[pod.Name] = true
+	}
+
+	var reservations []coilv2.PoolQuotaReservation
+	for _, res := range quota.Status.Reservations {
+		if counted[res.PodName] {
+			continue
+		}
+		// the Pod may not be in the cache yet.
+		if !running[res.PodName] && time.Since(res.Time.Time) >= reservationGracePeriod {
+			continue
+		}
+		counted[res.PodName] = true
+		reservations = append(reservations, res)
+	}
+	return int32(len(counted)), reservations, nil
+}
+
+func (r *PoolQuotaReconciler) quotasInNamespace(ctx context.Context, namespace string) []reconcile.Request {
+	quotas := &coilv2.PoolQuotaList{}
+	if err := r.List(ctx, quotas, client.InNamespace(namespace)); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list PoolQuota", "namespace", namespace)
+		return nil
+	}
+
+	requests := make([]reconcile.Request, len(quotas.Items))
+	for i := range quotas.Items {
+		requests[i] = reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&quotas.Items[i])}
+	}
+	return requests
+}
+
+// SetupWithManager registers this with the manager.
+func (r *PoolQuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&coilv2.PoolQuota{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+			return r.quotasInNamespace(ctx, obj.GetNamespace())
+		})).
+		Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+			return r.quotasInNamespace(ctx, obj.GetName())
+		})).
+		Complete(r)
+}
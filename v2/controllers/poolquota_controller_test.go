@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+var _ = Describe("PoolQuota reconciler", func() {
+	ctx := context.Background()
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.TODO())
+		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+			Scheme:         scheme,
+			LeaderElection: false,
+			Metrics: metricsserver.Options{
+				BindAddress: "0",
+			},
+			Controller: config.Controller{
+				SkipNameValidation: ptr.To(true),
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		pqr := PoolQuotaReconciler{
+			Client: mgr.GetClient(),
+		}
+		err = pqr.SetupWithManager(mgr)
+		Expect(err).ToNot(HaveOccurred())
+
+		go func() {
+			err := mgr.Start(ctx)
+			if err != nil {
+				panic(err)
+			}
+		}()
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	AfterEach(func() {
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	It("should count addresses used by Pods in the namespace", func() {
+		ns := &corev1.Namespace{}
+		ns.Name = "quota"
+		ns.Annotations = map[string]string{constants.AnnPool: "v4"}
+		err := k8sClient.Create(ctx, ns)
+		Expect(err).NotTo(HaveOccurred())
+
+		quota := &coilv2.PoolQuota{}
+		quota.Namespace = "quota"
+		quota.Name = "v4"
+		quota.Spec.PoolName = "v4"
+		quota.Spec.MaxAddresses = 10
+		err = k8sClient.Create(ctx, quota)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("creating Pods")
+		for _, name := range []string{"pod1", "pod2", "pod3"} {
+			pod := &corev1.Pod{}
+			pod.Namespace = "quota"
+			pod.Name = name
+			pod.Spec.Containers = []corev1.Container{{Name: "foo", Image: "nginx"}}
+			pod.Spec.HostNetwork = name == "pod3"
+			err = k8sClient.Create(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+
+			pod.Status.Phase = corev1.PodRunning
+			pod.Status.PodIPs = []corev1.PodIP{{IP: "10.4.0.1"}}
+			err = k8sClient.Status().Update(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		pending := &corev1.Pod{}
+		pending.Namespace = "quota"
+		pending.Name = "pending"
+		pending.Spec.Containers = []corev1.Container{{Name: "foo", Image: "nginx"}}
+		err = k8sClient.Create(ctx, pending)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			err := k8sClient.Get(ctx, client.ObjectKeyFromObject(quota), quota)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(quota.Status.Used).To(Equal(int32(2)))
+		}).Should(Succeed())
+
+		By("completing a Pod")
+		pod := &corev1.Pod{}
+		err = k8sClient.Get(ctx, client.ObjectKey{Namespace: "quota", Name: "pod1"}, pod)
+		Expect(err).NotTo(HaveOccurred())
+		pod.Status.Phase = corev1.PodSucceeded
+		err = k8sClient.Status().Update(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			err := k8sClient.Get(ctx, client.ObjectKeyFromObject(quota), quota)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(quota.Status.Used).To(Equal(int32(1)))
+		}).Should(Succeed())
+
+		By("reserving addresses")
+		Eventually(func() error {
+			if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(quota), quota); err != nil {
+				return err
+			}
+			quota.Status.Used = 4
+			quota.Status.Reservations = []coilv2.PoolQuotaReservation{
+				{PodName: "pending", ContainerID: "c-pending", Time: metav1.Now()},
+				{PodName: "pod2", ContainerID: "c-pod2", Time: metav1.Now()},
+				{PodName: "gone", ContainerID: "c-gone", Time: metav1.NewTime(time.Now().Add(-2 * reservationGracePeriod))},
+			}
+			return k8sClient.Status().Update(ctx, quota)
+		}).Should(Succeed())
+
+		Eventually(func(g Gomega) {
+			err := k8sClient.Get(ctx, client.ObjectKeyFromObject(quota), quota)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(quota.Status.Used).To(Equal(int32(2)))
+			g.Expect(quota.Status.Reservations).To(HaveLen(1))
+			g.Expect(quota.Status.Reservations[0].PodName).To(Equal("pending"))
+		}).Should(Succeed())
+
+		By("changing the pool of the namespace")
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: "quota"}, ns)
+		Expect(err).NotTo(HaveOccurred())
+		ns.Annotations[constants.AnnPool] = "default"
+		err = k8sClient.Update(ctx, ns)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			err := k8sClient.Get(ctx, client.ObjectKeyFromObject(quota), quota)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(quota.Status.Used).To(Equal(int32(0)))
+		}).Should(Succeed())
+	})
+})
@@ -0,0 +1,594 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v7.35.1
+// source: pkg/adminrpc/admin.proto
+
+package adminrpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Allocation represents IP addresses allocated by coild for a container interface.
+type Allocation struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ContainerId   string                 `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	Ifname        string                 `protobuf:"bytes,2,opt,name=ifname,proto3" json:"ifname,omitempty"`
+	Pool          string                 `protobuf:"bytes,3,opt,name=pool,proto3" json:"pool,omitempty"`
+	Block         string                 `protobuf:"bytes,4,opt,name=block,proto3" json:"block,omitempty"`
+	Ipv4          string                 `protobuf:"bytes,5,opt,name=ipv4,proto3" json:"ipv4,omitempty"`
+	Ipv6          string                 `protobuf:"bytes,6,opt,name=ipv6,proto3" json:"ipv6,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Allocation) Reset() {
+	*x = Allocation{}
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Allocation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Allocation) ProtoMessage() {}
+
+func (x *Allocation) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Allocation.ProtoReflect.Descriptor instead.
+func (*Allocation) Descriptor() ([]byte, []int) {
+	return file_pkg_adminrpc_admin_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Allocation) GetContainerId() string {
+	if x != nil {
+		return x.ContainerId
+	}
+	return ""
+}
+
+func (x *Allocation) GetIfname() string {
+	if x != nil {
+		return x.Ifname
+	}
+	return ""
+}
+
+func (x *Allocation) GetPool() string {
+	if x != nil {
+		return x.Pool
+	}
+	return ""
+}
+
+func (x *Allocation) GetBlock() string {
+	if x != nil {
+		return x.Block
+	}
+	return ""
+}
+
+func (x *Allocation) GetIpv4() string {
+	if x != nil {
+		return x.Ipv4
+	}
+	return ""
+}
+
+func (x *Allocation) GetIpv6() string {
+	if x != nil {
+		return x.Ipv6
+	}
+	return ""
+}
+
+// ListAllocationsResponse is the response for ListAllocations.
+type ListAllocationsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Allocations   []*Allocation          `protobuf:"bytes,1,rep,name=allocations,proto3" json:"allocations,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAllocationsResponse) Reset() {
+	*x = ListAllocationsResponse{}
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAllocationsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAllocationsResponse) ProtoMessage() {}
+
+func (x *ListAllocationsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAllocationsResponse.ProtoReflect.Descriptor instead.
+func (*ListAllocationsResponse) Descriptor() ([]byte, []int) {
+	return file_pkg_adminrpc_admin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListAllocationsResponse) GetAllocations() []*Allocation {
+	if x != nil {
+		return x.Allocations
+	}
+	return nil
+}
+
+// PodNetwork represents the network configuration of a Pod found in the kernel.
+type PodNetwork struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ContainerId   string                 `protobuf:"bytes,1,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	Ifname        string                 `protobuf:"bytes,2,opt,name=ifname,proto3" json:"ifname,omitempty"`
+	Pool          string                 `protobuf:"bytes,3,opt,name=pool,proto3" json:"pool,omitempty"`
+	Ipv4          string                 `protobuf:"bytes,4,opt,name=ipv4,proto3" json:"ipv4,omitempty"`
+	Ipv6          string                 `protobuf:"bytes,5,opt,name=ipv6,proto3" json:"ipv6,omitempty"`
+	HostVeth      string                 `protobuf:"bytes,6,opt,name=host_veth,json=hostVeth,proto3" json:"host_veth,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PodNetwork) Reset() {
+	*x = PodNetwork{}
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PodNetwork) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PodNetwork) ProtoMessage() {}
+
+func (x *PodNetwork) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PodNetwork.ProtoReflect.Descriptor instead.
+func (*PodNetwork) Descriptor() ([]byte, []int) {
+	return file_pkg_adminrpc_admin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PodNetwork) GetContainerId() string {
+	if x != nil {
+		return x.ContainerId
+	}
+	return ""
+}
+
+func (x *PodNetwork) GetIfname() string {
+	if x != nil {
+		return x.Ifname
+	}
+	return ""
+}
+
+func (x *PodNetwork) GetPool() string {
+	if x != nil {
+		return x.Pool
+	}
+	return ""
+}
+
+func (x *PodNetwork) GetIpv4() string {
+	if x != nil {
+		return x.Ipv4
+	}
+	return ""
+}
+
+func (x *PodNetwork) GetIpv6() string {
+	if x != nil {
+		return x.Ipv6
+	}
+	return ""
+}
+
+func (x *PodNetwork) GetHostVeth() string {
+	if x != nil {
+		return x.HostVeth
+	}
+	return ""
+}
+
+// ListPodNetworksResponse is the response for ListPodNetworks.
+type ListPodNetworksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PodNetworks   []*PodNetwork          `protobuf:"bytes,1,rep,name=pod_networks,json=podNetworks,proto3" json:"pod_networks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPodNetworksResponse) Reset() {
+	*x = ListPodNetworksResponse{}
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPodNetworksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPodNetworksResponse) ProtoMessage() {}
+
+func (x *ListPodNetworksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPodNetworksResponse.ProtoReflect.Descriptor instead.
+func (*ListPodNetworksResponse) Descriptor() ([]byte, []int) {
+	return file_pkg_adminrpc_admin_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListPodNetworksResponse) GetPodNetworks() []*PodNetwork {
+	if x != nil {
+		return x.PodNetworks
+	}
+	return nil
+}
+
+// EgressGateway represents a gateway of egress NAT and its destinations.
+type EgressGateway struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Gateway         string                 `protobuf:"bytes,1,opt,name=gateway,proto3" json:"gateway,omitempty"`
+	Destinations    []string               `protobuf:"bytes,2,rep,name=destinations,proto3" json:"destinations,omitempty"`
+	SportAuto       bool                   `protobuf:"varint,3,opt,name=sport_auto,json=sportAuto,proto3" json:"sport_auto,omitempty"`
+	OriginatingOnly bool                   `protobuf:"varint,4,opt,name=originating_only,json=originatingOnly,proto3" json:"originating_only,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *EgressGateway) Reset() {
+	*x = EgressGateway{}
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EgressGateway) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EgressGateway) ProtoMessage() {}
+
+func (x *EgressGateway) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EgressGateway.ProtoReflect.Descriptor instead.
+func (*EgressGateway) Descriptor() ([]byte, []int) {
+	return file_pkg_adminrpc_admin_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *EgressGateway) GetGateway() string {
+	if x != nil {
+		return x.Gateway
+	}
+	return ""
+}
+
+func (x *EgressGateway) GetDestinations() []string {
+	if x != nil {
+		return x.Destinations
+	}
+	return nil
+}
+
+func (x *EgressGateway) GetSportAuto() bool {
+	if x != nil {
+		return x.SportAuto
+	}
+	return false
+}
+
+func (x *EgressGateway) GetOriginatingOnly() bool {
+	if x != nil {
+		return x.OriginatingOnly
+	}
+	return false
+}
+
+// EgressHook represents the egress NAT configuration for a Pod on the node.
+//
+// `egresses` are Egresses referenced by the Pod in the form of `namespace/name`.
+// `error` is set if coild fails to resolve the gateways of the Egresses.
+type EgressHook struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PodNamespace  string                 `protobuf:"bytes,1,opt,name=pod_namespace,json=podNamespace,proto3" json:"pod_namespace,omitempty"`
+	PodName       string                 `protobuf:"bytes,2,opt,name=pod_name,json=podName,proto3" json:"pod_name,omitempty"`
+	Egresses      []string               `protobuf:"bytes,3,rep,name=egresses,proto3" json:"egresses,omitempty"`
+	Gateways      []*EgressGateway       `protobuf:"bytes,4,rep,name=gateways,proto3" json:"gateways,omitempty"`
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EgressHook) Reset() {
+	*x = EgressHook{}
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EgressHook) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EgressHook) ProtoMessage() {}
+
+func (x *EgressHook) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EgressHook.ProtoReflect.Descriptor instead.
+func (*EgressHook) Descriptor() ([]byte, []int) {
+	return file_pkg_adminrpc_admin_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *EgressHook) GetPodNamespace() string {
+	if x != nil {
+		return x.PodNamespace
+	}
+	return ""
+}
+
+func (x *EgressHook) GetPodName() string {
+	if x != nil {
+		return x.PodName
+	}
+	return ""
+}
+
+func (x *EgressHook) GetEgresses() []string {
+	if x != nil {
+		return x.Egresses
+	}
+	return nil
+}
+
+func (x *EgressHook) GetGateways() []*EgressGateway {
+	if x != nil {
+		return x.Gateways
+	}
+	return nil
+}
+
+func (x *EgressHook) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// ListEgressHooksResponse is the response for ListEgressHooks.
+type ListEgressHooksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hooks         []*EgressHook          `protobuf:"bytes,1,rep,name=hooks,proto3" json:"hooks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListEgressHooksResponse) Reset() {
+	*x = ListEgressHooksResponse{}
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListEgressHooksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListEgressHooksResponse) ProtoMessage() {}
+
+func (x *ListEgressHooksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_adminrpc_admin_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListEgressHooksResponse.ProtoReflect.Descriptor instead.
+func (*ListEgressHooksResponse) Descriptor() ([]byte, []int) {
+	return file_pkg_adminrpc_admin_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListEgressHooksResponse) GetHooks() []*EgressHook {
+	if x != nil {
+		return x.Hooks
+	}
+	return nil
+}
+
+var File_pkg_adminrpc_admin_proto protoreflect.FileDescriptor
+
+const file_pkg_adminrpc_admin_proto_rawDesc = "" +
+	"\n" +
+	"\x18pkg/adminrpc/admin.proto\x12\fpkg.adminrpc\x1a\x1bgoogle/protobuf/empty.proto\"\x99\x01\n" +
+	"\n" +
+	"Allocation\x12!\n" +
+	"\fcontainer_id\x18\x01 \x01(\tR\vcontainerId\x12\x16\n" +
+	"\x06ifname\x18\x02 \x01(\tR\x06ifname\x12\x12\n" +
+	"\x04pool\x18\x03 \x01(\tR\x04pool\x12\x14\n" +
+	"\x05block\x18\x04 \x01(\tR\x05block\x12\x12\n" +
+	"\x04ipv4\x18\x05 \x01(\tR\x04ipv4\x12\x12\n" +
+	"\x04ipv6\x18\x06 \x01(\tR\x04ipv6\"U\n" +
+	"\x17ListAllocationsResponse\x12:\n" +
+	"\vallocations\x18\x01 \x03(\v2\x18.pkg.adminrpc.AllocationR\vallocations\"\xa0\x01\n" +
+	"\n" +
+	"PodNetwork\x12!\n" +
+	"\fcontainer_id\x18\x01 \x01(\tR\vcontainerId\x12\x16\n" +
+	"\x06ifname\x18\x02 \x01(\tR\x06ifname\x12\x12\n" +
+	"\x04pool\x18\x03 \x01(\tR\x04pool\x12\x12\n" +
+	"\x04ipv4\x18\x04 \x01(\tR\x04ipv4\x12\x12\n" +
+	"\x04ipv6\x18\x05 \x01(\tR\x04ipv6\x12\x1b\n" +
+	"\thost_veth\x18\x06 \x01(\tR\bhostVeth\"V\n" +
+	"\x17ListPodNetworksResponse\x12;\n" +
+	"\fpod_networks\x18\x01 \x03(\v2\x18.pkg.adminrpc.PodNetworkR\vpodNetworks\"\x97\x01\n" +
+	"\rEgressGateway\x12\x18\n" +
+	"\agateway\x18\x01 \x01(\tR\agateway\x12\"\n" +
+	"\fdestinations\x18\x02 \x03(\tR\fdestinations\x12\x1d\n" +
+	"\n" +
+	"sport_auto\x18\x03 \x01(\bR\tsportAuto\x12)\n" +
+	"\x10originating_only\x18\x04 \x01(\bR\x0foriginatingOnly\"\xb7\x01\n" +
+	"\n" +
+	"EgressHook\x12#\n" +
+	"\rpod_namespace\x18\x01 \x01(\tR\fpodNamespace\x12\x19\n" +
+	"\bpod_name\x18\x02 \x01(\tR\apodName\x12\x1a\n" +
+	"\begresses\x18\x03 \x03(\tR\begresses\x127\n" +
+	"\bgateways\x18\x04 \x03(\v2\x1b.pkg.adminrpc.EgressGatewayR\bgateways\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"I\n" +
+	"\x17ListEgressHooksResponse\x12.\n" +
+	"\x05hooks\x18\x01 \x03(\v2\x18.pkg.adminrpc.EgressHookR\x05hooks2\xf4\x02\n" +
+	"\x05Admin\x12P\n" +
+	"\x0fListAllocations\x12\x16.google.protobuf.Empty\x1a%.pkg.adminrpc.ListAllocationsResponse\x12P\n" +
+	"\x0fListPodNetworks\x12\x16.google.protobuf.Empty\x1a%.pkg.adminrpc.ListPodNetworksResponse\x127\n" +
+	"\x05RunGC\x12\x16.google.protobuf.Empty\x1a\x16.google.protobuf.Empty\x12<\n" +
+	"\n" +
+	"SyncRoutes\x12\x16.google.protobuf.Empty\x1a\x16.google.protobuf.Empty\x12P\n" +
+	"\x0fListEgressHooks\x12\x16.google.protobuf.Empty\x1a%.pkg.adminrpc.ListEgressHooksResponseB+Z)github.com/cybozu-go/coil/v2/pkg/adminrpcb\x06proto3"
+
+var (
+	file_pkg_adminrpc_admin_proto_rawDescOnce sync.Once
+	file_pkg_adminrpc_admin_proto_rawDescData []byte
+)
+
+func file_pkg_adminrpc_admin_proto_rawDescGZIP() []byte {
+	file_pkg_adminrpc_admin_proto_rawDescOnce.Do(func() {
+		file_pkg_adminrpc_admin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pkg_adminrpc_admin_proto_rawDesc), len(file_pkg_adminrpc_admin_proto_rawDesc)))
+	})
+	return file_pkg_adminrpc_admin_proto_rawDescData
+}
+
+var file_pkg_adminrpc_admin_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_pkg_adminrpc_admin_proto_goTypes = []any{
+	(*Allocation)(nil),              // 0: pkg.adminrpc.Allocation
+	(*ListAllocationsResponse)(nil), // 1: pkg.adminrpc.ListAllocationsResponse
+	(*PodNetwork)(nil),              // 2: pkg.adminrpc.PodNetwork
+	(*ListPodNetworksResponse)(nil), // 3: pkg.adminrpc.ListPodNetworksResponse
+	(*EgressGateway)(nil),           // 4: pkg.adminrpc.EgressGateway
+	(*EgressHook)(nil),              // 5: pkg.adminrpc.EgressHook
+	(*ListEgressHooksResponse)(nil), // 6: pkg.adminrpc.ListEgressHooksResponse
+	(*emptypb.Empty)(nil),           // 7: google.protobuf.Empty
+}
+var file_pkg_adminrpc_admin_proto_depIdxs = []int32{
+	0, // 0: pkg.adminrpc.ListAllocationsResponse.allocations:type_name -> pkg.adminrpc.Allocation
+	2, // 1: pkg.adminrpc.ListPodNetworksResponse.pod_networks:type_name -> pkg.adminrpc.PodNetwork
+	4, // 2: pkg.adminrpc.EgressHook.gateways:type_name -> pkg.adminrpc.EgressGateway
+	5, // 3: pkg.adminrpc.ListEgressHooksResponse.hooks:type_name -> pkg.adminrpc.EgressHook
+	7, // 4: pkg.adminrpc.Admin.ListAllocations:input_type -> google.protobuf.Empty
+	7, // 5: pkg.adminrpc.Admin.ListPodNetworks:input_type -> google.protobuf.Empty
+	7, // 6: pkg.adminrpc.Admin.RunGC:input_type -> google.protobuf.Empty
+	7, // 7: pkg.adminrpc.Admin.SyncRoutes:input_type -> google.protobuf.Empty
+	7, // 8: pkg.adminrpc.Admin.ListEgressHooks:input_type -> google.protobuf.Empty
+	1, // 9: pkg.adminrpc.Admin.ListAllocations:output_type -> pkg.adminrpc.ListAllocationsResponse
+	3, // 10: pkg.adminrpc.Admin.ListPodNetworks:output_type -> pkg.adminrpc.ListPodNetworksResponse
+	7, // 11: pkg.adminrpc.Admin.RunGC:output_type -> google.protobuf.Empty
+	7, // 12: pkg.adminrpc.Admin.SyncRoutes:output_type -> google.protobuf.Empty
+	6, // 13: pkg.adminrpc.Admin.ListEgressHooks:output_type -> pkg.adminrpc.ListEgressHooksResponse
+	9, // [9:14] is the sub-list for method output_type
+	4, // [4:9] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_pkg_adminrpc_admin_proto_init() }
+func file_pkg_adminrpc_admin_proto_init() {
+	if File_pkg_adminrpc_admin_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pkg_adminrpc_admin_proto_rawDesc), len(file_pkg_adminrpc_admin_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pkg_adminrpc_admin_proto_goTypes,
+		DependencyIndexes: file_pkg_adminrpc_admin_proto_depIdxs,
+		MessageInfos:      file_pkg_adminrpc_admin_proto_msgTypes,
+	}.Build()
+	File_pkg_adminrpc_admin_proto = out.File
+	file_pkg_adminrpc_admin_proto_goTypes = nil
+	file_pkg_adminrpc_admin_proto_depIdxs = nil
+}
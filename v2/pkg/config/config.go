@@ -12,23 +12,37 @@ import (
 )
 
 type Config struct {
-	MetricsAddr            string
-	HealthAddr             string
-	PodTableId             int
-	PodRulePrio            int
-	ExportTableId          int
-	ProtocolId             int
-	SocketPath             string
-	CompatCalico           bool
-	EgressPort             int
-	RegisterFromMain       bool
-	ZapOpts                zap.Options
-	EnableIPAM             bool
-	EnableEgress           bool
-	AddressBlockGCInterval time.Duration
-	Backend                string
-	OriginatingOnly        bool
-	ClearRoutesOnShutdown  bool
+	MetricsAddr             string
+	HealthAddr              string
+	PodTableId              int
+	PodRulePrio             int
+	ExportTableId           int
+	ProtocolId              int
+	SocketPath              string
+	CompatCalico            bool
+	EgressPort              int
+	RegisterFromMain        bool
+	ZapOpts                 zap.Options
+	EnableIPAM              bool
+	EnableEgress            bool
+	AddressBlockGCInterval  time.Duration
+	Backend                 string
+	OriginatingOnly         bool
+	ClearRoutesOnShutdown   bool
+	MTU                     int
+	MTUOverhead             int
+	EnableNetworkPolicy     bool
+	BlockLowWatermark       int
+	BlockHighWatermark      int
+	MinBlocksPerPool        int
+	BlockGracePeriod        time.Duration
+	EnableNodeEgress        bool
+	NodeEgressUIDs          []uint
+	NodeEgressCgroups       []string
+	NodeEgressMark          uint32
+	NodeEgressRulePrio      int
+	NodeEgressNarrowTableId int
+	NodeEgressWideTableId   int
 }
 
 func Parse(rootCmd *cobra.Command) *Config {
@@ -50,6 +64,20 @@ func Parse(rootCmd *cobra.Command) *Config {
 	pf.StringVar(&config.Backend, "backend", constants.DefaultEgressBackend, "backend for egress NAT rules: iptables or nftables (default: iptables)")
 	pf.BoolVar(&config.OriginatingOnly, "enable-originating-only", constants.DefaultOriginatingOnly, "egress should be used only for connections originating in the pod (default: false)")
 	pf.BoolVar(&config.ClearRoutesOnShutdown, "clear-routes-on-shutdown", constants.DefaultClearRoutesOnShutdown, "clear export routes when the node is deleted")
+	pf.IntVar(&config.MTU, "mtu", constants.DefaultMTU, "MTU of Pod network interfaces; 0 to detect from the host default route")
+	pf.IntVar(&config.MTUOverhead, "mtu-overhead", constants.DefaultMTUOverhead, "bytes subtracted from the detected MTU for encapsulation such as Foo-over-UDP")
+	pf.BoolVar(&config.EnableNetworkPolicy, "enable-network-policy", constants.DefaultEnableNetworkPolicy, "enforce NetworkPolicies with nftables for Pods on the node")
+	pf.IntVar(&config.BlockLowWatermark, "block-low-watermark", constants.DefaultBlockLowWatermark, "request a new address block in the background when free addresses of a pool fall below this; 0 to disable")
+	pf.IntVar(&config.BlockHighWatermark, "block-high-watermark", constants.DefaultBlockHighWatermark, "release empty address blocks only while free addresses of a pool exceed this")
+	pf.IntVar(&config.MinBlocksPerPool, "min-blocks-per-pool", constants.DefaultMinBlocksPerPool, "number of address blocks of each pool kept on the node even if they are empty")
+	pf.DurationVar(&config.BlockGracePeriod, "block-release-grace-period", constants.DefaultBlockGracePeriod, "duration for which an address block must stay empty before it is released")
+	pf.BoolVar(&config.EnableNodeEgress, "enable-node-egress", constants.DefaultEnableNodeEgress, "enable Egress for processes running in the host network")
+	pf.UintSliceVar(&config.NodeEgressUIDs, "node-egress-uids", nil, "UIDs of processes in the host network that use Egress")
+	pf.StringSliceVar(&config.NodeEgressCgroups, "node-egress-cgroups", nil, "cgroup v2 paths of processes in the host network that use Egress")
+	pf.Uint32Var(&config.NodeEgressMark, "node-egress-mark", constants.DefaultNodeEgressMark, "packet mark bit for processes in the host network that use Egress")
+	pf.IntVar(&config.NodeEgressRulePrio, "node-egress-rule-prio", constants.DefaultNodeEgressRulePrio, "base priority of the rules for node Egress; must be greater than --pod-rule-prio")
+	pf.IntVar(&config.NodeEgressNarrowTableId, "node-egress-narrow-table-id", constants.DefaultNodeEgressNarrowTableId, "routing table ID for node Egress destinations in private networks")
+	pf.IntVar(&config.NodeEgressWideTableId, "node-egress-wide-table-id", constants.DefaultNodeEgressWideTableId, "routing table ID for the other node Egress destinations")
 
 	goflags := flag.NewFlagSet("klog", flag.ExitOnError)
 	klog.InitFlags(goflags)
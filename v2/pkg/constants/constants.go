@@ -6,6 +6,19 @@ import "time"
 const (
 	AnnPool         = "coil.cybozu.com/pool"
 	AnnEgressPrefix = "egress.coil.cybozu.com/"
+
+	// AnnQuarantinedBlocks records blocks of an AddressPool in quarantine
+	AnnQuarantinedBlocks = "coil.cybozu.com/quarantined-blocks"
+
+	// AnnQuarantinedAddresses records addresses of an AddressBlock in quarantine
+	AnnQuarantinedAddresses = "coil.cybozu.com/quarantined-addresses"
+
+	// AnnTracePrefix is the prefix of annotations to propagate the trace context
+	AnnTracePrefix = "trace.coil.cybozu.com/"
+
+	// well-known annotations for Pod bandwidth limits
+	AnnIngressBandwidth = "kubernetes.io/ingress-bandwidth"
+	AnnEgressBandwidth  = "kubernetes.io/egress-bandwidth"
 )
 
 // Label keys
@@ -15,6 +28,10 @@ const (
 	LabelRequest  = "coil.cybozu.com/request"
 	LabelReserved = "coil.cybozu.com/reserved"
 
+	// LabelProvisional marks an AddressBlock that is not yet given to the node.
+	// coild adopts the block named in the status of its BlockRequest by removing this.
+	LabelProvisional = "coil.cybozu.com/provisional"
+
 	LabelAppName      = "app.kubernetes.io/name"
 	LabelAppInstance  = "app.kubernetes.io/instance"
 	LabelAppComponent = "app.kubernetes.io/component"
@@ -59,6 +76,24 @@ const (
 	EnvEgressName   = "COIL_EGRESS_NAME"
 )
 
+// Event reasons
+const (
+	// ReasonPoolExhausted is recorded on AddressPool and Node when no free block is left in the pool.
+	ReasonPoolExhausted = "PoolExhausted"
+
+	// ReasonAllocationFailed is recorded on Pod when coild fails to allocate addresses.
+	ReasonAllocationFailed = "AddressAllocationFailed"
+
+	// ReasonQuotaExceeded is recorded on Pod when the PoolQuota of the namespace is exceeded.
+	ReasonQuotaExceeded = "PoolQuotaExceeded"
+
+	// ReasonPodNetworkFailed is recorded on Pod when coild fails to setup the Pod network.
+	ReasonPodNetworkFailed = "PodNetworkSetupFailed"
+
+	// ReasonEgressSetupFailed is recorded on Pod or Node and Egress when coild fails to configure NAT for them.
+	ReasonEgressSetupFailed = "EgressSetupFailed"
+)
+
 // Config flags
 const (
 	IsChained = "IS_CHAINED"
@@ -66,21 +101,34 @@ const (
 
 // Default config values
 const (
-	DefautlMetricsAddr            = ":9384"
-	DefautlHealthAddr             = ":9385"
-	DefautlPodTableId             = 116
-	DefautlPodRulePrio            = 2000
-	DefautlExportTableId          = 119
-	DefautlProtocolId             = 30
-	DefaultCompatCalico           = false
-	DefaultEgressPort             = 5555
-	DefaultRegisterFromMain       = false
-	DefaultEnableIPAM             = true
-	DefaultEnableEgress           = true
-	DefaultAddressBlockGCInterval = 5 * time.Minute
+	DefautlMetricsAddr             = ":9384"
+	DefautlHealthAddr              = ":9385"
+	DefautlPodTableId              = 116
+	DefautlPodRulePrio             = 2000
+	DefautlExportTableId           = 119
+	DefautlProtocolId              = 30
+	DefaultCompatCalico            = false
+	DefaultEgressPort              = 5555
+	DefaultRegisterFromMain        = false
+	DefaultEnableIPAM              = true
+	DefaultEnableEgress            = true
+	DefaultAddressBlockGCInterval  = 5 * time.Minute
+	DefaultMTU                     = 0
+	DefaultMTUOverhead             = 0
+	DefaultEnableNetworkPolicy     = false
+	DefaultBlockLowWatermark       = 0
+	DefaultBlockHighWatermark      = 0
+	DefaultMinBlocksPerPool        = 0
+	DefaultBlockGracePeriod        = 0 * time.Second
+	DefaultEnableNodeEgress        = false
+	DefaultNodeEgressMark          = 0x200000
+	DefaultNodeEgressRulePrio      = 2100
+	DefaultNodeEgressNarrowTableId = 127
+	DefaultNodeEgressWideTableId   = 128
 
 	DefaultEnableCertRotation         = false
 	DefaultEnableRestartOnCertRefresh = false
+	DefaultEnableActiveActive         = false
 )
 
 // MetricsNS is the namespace for Prometheus metrics
@@ -103,6 +151,10 @@ const (
 	EgressBackendNFTables = "nftables"
 )
 
+// SourcePortSlots is the number of disjoint ranges of source ports
+// for egress pods sharing the source addresses of SNAT.
+const SourcePortSlots = 16
+
 // Default backend
 const DefaultEgressBackend = EgressBackendIPTables
 
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"os/exec"
+	"strings"
 	"sync"
 
 	"github.com/containernetworking/plugins/pkg/ip"
@@ -21,6 +22,16 @@ const (
 
 const fouDummy = "fou-dummy"
 
+// Names of the flow based tunnel devices
+const (
+	ipip4Device = "coil_ipip4"
+	ipip6Device = "coil_ipip6"
+)
+
+// looseRPFilter is the value of rp_filter to accept packets from the
+// tunnels even if the route to the source is not via the tunnels.
+const looseRPFilter = "2"
+
 func fouName(addr net.IP) string {
 	if v4 := addr.To4(); v4 != nil {
 		return fmt.Sprintf("%s%x", FoU4LinkPrefix, []byte(v4))
@@ -75,11 +86,23 @@ func NewFoUTunnel(port int, localIPv4, localIPv6 net.IP, logFunc func(string)) F
 	}
 }
 
+// NewNodeFoUTunnel creates a new FoUTunnel in the host network namespace.
+// The arguments are the same as NewFoUTunnel.
+//
+// Unlike NewFoUTunnel, this does not disable rp_filter of the whole node.
+// Instead, rp_filter of the tunnel devices is set to loose mode.
+func NewNodeFoUTunnel(port int, localIPv4, localIPv6 net.IP, logFunc func(string)) FoUTunnel {
+	t := NewFoUTunnel(port, localIPv4, localIPv6, logFunc).(*fouTunnel)
+	t.hostNetwork = true
+	return t
+}
+
 type fouTunnel struct {
-	port    int
-	local4  net.IP
-	local6  net.IP
-	logFunc func(string)
+	port        int
+	local4      net.IP
+	local6      net.IP
+	logFunc     func(string)
+	hostNetwork bool
 
 	mu sync.Mutex
 }
@@ -107,11 +130,14 @@ func (t *fouTunnel) Init() error {
 		if err != nil {
 			return fmt.Errorf("netlink: fou add failed: %w", err)
 		}
-		if _, err := sysctl.Sysctl("net.ipv4.conf.default.rp_filter", "0"); err != nil {
-			return fmt.Errorf("setting net.ipv4.conf.default.rp_filter=0 failed: %w", err)
-		}
-		if _, err := sysctl.Sysctl("net.ipv4.conf.all.rp_filter", "0"); err != nil {
-			return fmt.Errorf("setting net.ipv4.conf.all.rp_filter=0 failed: %w", err)
+		// rp_filter of the tunnel devices is set when they are created in the host network.
+		if !t.hostNetwork {
+			if _, err := sysctl.Sysctl("net.ipv4.conf.default.rp_filter", "0"); err != nil {
+				return fmt.Errorf("setting net.ipv4.conf.default.rp_filter=0 failed: %w", err)
+			}
+			if _, err := sysctl.Sysctl("net.ipv4.conf.all.rp_filter", "0"); err != nil {
+				return fmt.Errorf("setting net.ipv4.conf.all.rp_filter=0 failed: %w", err)
+			}
 		}
 		if err := ip.EnableIP4Forward(); err != nil {
 			return fmt.Errorf("failed to enable IPv4 forwarding: %w", err)
@@ -179,6 +205,16 @@ func (t *fouTunnel) addPeer4(addr net.IP, sportAuto bool) (netlink.Link, error)
 		return nil, fmt.Errorf("netlink: failed to setup ipip device: %w", err)
 	}
 
+	if t.hostNetwork {
+		// the effective value is the maximum of this and net.ipv4.conf.all.rp_filter.
+		for _, name := range []string{linkName, ipip4Device} {
+			key := fmt.Sprintf("net.ipv4.conf.%s.rp_filter", name)
+			if _, err := sysctl.Sysctl(key, looseRPFilter); err != nil {
+				return nil, fmt.Errorf("setting %s=%s failed: %w", key, looseRPFilter, err)
+			}
+		}
+	}
+
 	return netlink.LinkByName(linkName)
 }
 
@@ -326,7 +362,6 @@ func (t *fouTunnel) addOrRecreatePeer6(addr net.IP, sportAuto bool) (string, err
 // By default, these interfaces will be created in new network namespaces,
 // but this behavior can be disabled by setting net.core.fb_tunnels_only_for_init_net = 2.
 func setupFlowBasedIP4TunDevice() error {
-	ipip4Device := "coil_ipip4"
 	// Set up IPv4 tunnel device if requested.
 	if err := setupDevice(&netlink.Iptun{
 		LinkAttrs: netlink.LinkAttrs{Name: ipip4Device},
@@ -346,8 +381,6 @@ func setupFlowBasedIP4TunDevice() error {
 
 // See setupFlowBasedIP4TunDevice
 func setupFlowBasedIP6TunDevice() error {
-	ipip6Device := "coil_ipip6"
-
 	// Set up IPv6 tunnel device if requested.
 	if err := setupDevice(&netlink.Ip6tnl{
 		LinkAttrs: netlink.LinkAttrs{Name: ipip6Device},
@@ -433,3 +466,50 @@ func (t *fouTunnel) DelPeer(addr net.IP) error {
 	}
 	return err
 }
+
+// ClearNodeTunnel removes the FoU listening sockets for port and the devices
+// created by a FoUTunnel from NewNodeFoUTunnel.  It does nothing if the
+// tunnel has not been initialized.
+func ClearNodeTunnel(port int) error {
+	dummy, err := netlink.LinkByName(fouDummy)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("netlink: failed to list links: %w", err)
+	}
+	for _, l := range links {
+		name := l.Attrs().Name
+		if !strings.HasPrefix(name, FoU4LinkPrefix) && !strings.HasPrefix(name, FoU6LinkPrefix) &&
+			name != ipip4Device && name != ipip6Device {
+			continue
+		}
+		if err := netlink.LinkDel(l); err != nil {
+			return fmt.Errorf("netlink: failed to delete %s: %w", name, err)
+		}
+	}
+
+	fous, err := netlink.FouList(0)
+	if err != nil {
+		return fmt.Errorf("netlink: fou list failed: %w", err)
+	}
+	for _, f := range fous {
+		if f.Port != port {
+			continue
+		}
+		if err := netlink.FouDel(f); err != nil {
+			return fmt.Errorf("netlink: fou del failed: %w", err)
+		}
+	}
+
+	// the dummy link is removed last because it marks the tunnel as initialized.
+	if err := netlink.LinkDel(dummy); err != nil {
+		return fmt.Errorf("netlink: failed to delete %s: %w", fouDummy, err)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
 	"github.com/vishvananda/netlink"
 )
 
@@ -15,6 +16,73 @@ func TestFoU(t *testing.T) {
 	t.Run("Dual", testFoUDual)
 	t.Run("IPv4", testFoUV4)
 	t.Run("IPv6", testFoUV6)
+	t.Run("Node", testFoUNode)
+}
+
+func testFoUNode(t *testing.T) {
+	t.Parallel()
+
+	fNS, err := ns.GetNS("/run/netns/test-fou-node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fNS.Close()
+
+	err = fNS.Do(func(ns.NetNS) error {
+		if _, err := sysctl.Sysctl("net.ipv4.conf.all.rp_filter", "1"); err != nil {
+			return err
+		}
+
+		fou := NewNodeFoUTunnel(5555, net.ParseIP("127.0.0.1"), nil, nil)
+		if err := fou.Init(); err != nil {
+			return fmt.Errorf("fou.Init failed: %w", err)
+		}
+		link, err := fou.AddPeer(net.ParseIP("10.1.1.1"), false)
+		if err != nil {
+			return fmt.Errorf("failed to call AddPeer with 10.1.1.1: %w", err)
+		}
+
+		// rp_filter of the node must not be changed.
+		if v, err := sysctl.Sysctl("net.ipv4.conf.all.rp_filter"); err != nil {
+			return err
+		} else if v != "1" {
+			return fmt.Errorf("net.ipv4.conf.all.rp_filter is changed to %s", v)
+		}
+		for _, name := range []string{link.Attrs().Name, "coil_ipip4"} {
+			v, err := sysctl.Sysctl("net.ipv4.conf." + name + ".rp_filter")
+			if err != nil {
+				return err
+			}
+			if v != "2" {
+				return fmt.Errorf("rp_filter of %s is not loose: %s", name, v)
+			}
+		}
+
+		if err := ClearNodeTunnel(5555); err != nil {
+			return fmt.Errorf("ClearNodeTunnel failed: %w", err)
+		}
+		if fou.IsInitialized() {
+			return errors.New("the tunnel is still initialized")
+		}
+		fous, err := netlink.FouList(0)
+		if err != nil {
+			return fmt.Errorf("failed to list fou: %w", err)
+		}
+		if len(fous) != 0 {
+			return fmt.Errorf("fou is not removed: %+v", fous)
+		}
+		for _, name := range []string{link.Attrs().Name, "coil_ipip4"} {
+			if _, err := netlink.LinkByName(name); err == nil {
+				return fmt.Errorf("%s is not removed", name)
+			}
+		}
+
+		// clearing twice is a no-op.
+		return ClearNodeTunnel(5555)
+	})
+	if err != nil {
+		t.Error(err)
+	}
 }
 
 func testFoUDual(t *testing.T) {
@@ -3,6 +3,7 @@ package ipam
 import (
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/bits-and-blooms/bitset"
 	"github.com/cybozu-go/netutil"
@@ -13,6 +14,9 @@ type allocator struct {
 	ipv6         *net.IPNet
 	usage        *bitset.BitSet
 	lastAllocIdx int64
+
+	// quarantine holds freed indexes that must not be allocated until the time.
+	quarantine map[uint]time.Time
 }
 
 func newAllocator(ipv4, ipv6 *string) *allocator {
@@ -40,7 +44,39 @@ func newAllocator(ipv4, ipv6 *string) *allocator {
 }
 
 func (a *allocator) isFull() bool {
-	return a.usage.All()
+	_, ok := a.nextAvailable(0, time.Now())
+	return !ok
+}
+
+// available returns the number of indexes that can be allocated now.
+func (a *allocator) available() uint {
+	n := a.usage.Len() - a.usage.Count()
+	now := time.Now()
+	for idx, until := range a.quarantine {
+		if until.After(now) && !a.usage.Test(idx) {
+			n--
+		}
+	}
+	return n
+}
+
+// nextAvailable returns the first clear index from `from` that is not in quarantine.
+func (a *allocator) nextAvailable(from uint, now time.Time) (uint, bool) {
+	for {
+		idx, ok := a.usage.NextClear(from)
+		if !ok {
+			return 0, false
+		}
+		until, ok := a.quarantine[idx]
+		if !ok {
+			return idx, true
+		}
+		if !until.After(now) {
+			delete(a.quarantine, idx)
+			return idx, true
+		}
+		from = idx + 1
+	}
 }
 
 func (a *allocator) isEmpty() bool {
@@ -77,11 +113,13 @@ func (a *allocator) register(ipv4, ipv6 net.IP) (uint, bool) {
 }
 
 func (a *allocator) allocate() (ipv4, ipv6 net.IP, idx uint, ok bool) {
+	now := time.Now()
+
 	// try to get an usable index from the last allocated index
-	idx, ok = a.usage.NextClear(uint(a.lastAllocIdx + 1))
+	idx, ok = a.nextAvailable(uint(a.lastAllocIdx+1), now)
 	if !ok {
 		// if an usable index is not found, try to get from index 0
-		if idx, ok = a.usage.NextClear(0); !ok {
+		if idx, ok = a.nextAvailable(0, now); !ok {
 			return nil, nil, 0, false
 		}
 	}
@@ -100,3 +138,15 @@ func (a *allocator) allocate() (ipv4, ipv6 net.IP, idx uint, ok bool) {
 func (a *allocator) free(idx uint) {
 	a.usage.Clear(idx)
 }
+
+// freeWithQuarantine frees idx and keeps it from being allocated for d.
+func (a *allocator) freeWithQuarantine(idx uint, d time.Duration) {
+	a.free(idx)
+	if d <= 0 {
+		return
+	}
+	if a.quarantine == nil {
+		a.quarantine = make(map[uint]time.Time)
+	}
+	a.quarantine[idx] = time.Now().Add(d)
+}
@@ -3,6 +3,7 @@ package ipam
 import (
 	"net"
 	"testing"
+	"time"
 )
 
 func TestAllocator(t *testing.T) {
@@ -11,6 +12,7 @@ func TestAllocator(t *testing.T) {
 	t.Run("dual", testAllocatorDual)
 	t.Run("fill", testAllocatorFill)
 	t.Run("notToReuse", testAllocatorNotToReUse)
+	t.Run("quarantine", testAllocatorQuarantine)
 }
 
 func testAllocatorV4(t *testing.T) {
@@ -332,3 +334,48 @@ func testAllocatorNotToReUse(t *testing.T) {
 		}
 	}
 }
+
+func testAllocatorQuarantine(t *testing.T) {
+	t.Parallel()
+
+	ipv4 := "10.2.3.0/30"
+	a := newAllocator(&ipv4, nil)
+	a.fill()
+
+	a.freeWithQuarantine(1, time.Hour)
+	a.freeWithQuarantine(2, 100*time.Millisecond)
+	if !a.isFull() {
+		t.Error("quarantined addresses should not be allocatable")
+	}
+	if a.available() != 0 {
+		t.Error("available should be 0, but", a.available())
+	}
+	if _, _, idx, ok := a.allocate(); ok {
+		t.Error("should not allocate quarantined addresses", idx)
+	}
+
+	a.freeWithQuarantine(3, 0)
+	if a.available() != 1 {
+		t.Error("available should be 1, but", a.available())
+	}
+	if _, _, idx, ok := a.allocate(); !ok {
+		t.Error("should allocate addresses")
+	} else if idx != 3 {
+		t.Error("idx should be 3, but", idx)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if ip, _, idx, ok := a.allocate(); !ok {
+		t.Error("should allocate an address after the quarantine period")
+	} else {
+		if !ip.Equal(net.ParseIP("10.2.3.2")) {
+			t.Error("unexpected ip:", ip)
+		}
+		if idx != 2 {
+			t.Error("idx should be 2, but", idx)
+		}
+	}
+	if _, _, idx, ok := a.allocate(); ok {
+		t.Error("should not allocate quarantined addresses", idx)
+	}
+}
@@ -4,13 +4,19 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -19,11 +25,16 @@ import (
 	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
 	"github.com/cybozu-go/coil/v2/pkg/constants"
 	"github.com/cybozu-go/coil/v2/pkg/nodenet"
+	"github.com/cybozu-go/coil/v2/pkg/tracing"
 )
 
 // DefaultAllocTimeout is the default timeout duration for NodeIPAM.Allocate
 const DefaultAllocTimeout = 10 * time.Second
 
+// requestPollInterval is the interval to check the status of a BlockRequest
+// in case the notification from BlockRequestWatcher is lost.
+const requestPollInterval = time.Second
+
 type allocInfo struct {
 	IPv4      net.IP
 	IPv6      net.IP
@@ -36,6 +47,16 @@ func allocKey(containerID, iface string) string {
 	return fmt.Sprintf("%s:%s", containerID, iface)
 }
 
+// Allocation represents IP addresses allocated for `(ContainerID, Iface)`.
+type Allocation struct {
+	ContainerID string
+	Iface       string
+	PoolName    string
+	BlockName   string
+	IPv4        net.IP
+	IPv6        net.IP
+}
+
 // NodeIPAM manages IP address assignments to Pods on each node.
 type NodeIPAM interface {
 	// Register registers previously allocated IP addresses.
@@ -71,12 +92,20 @@ type NodeIPAM interface {
 	// NodeInternalIP returns node's internal IP addresses
 	NodeInternalIP(ctx context.Context) (ipv4, ipv6 net.IP, err error)
 
+	// Allocations returns the IP addresses currently allocated on this node.
+	Allocations() []Allocation
+
+	// SyncRoutes exports routes for the address blocks owned by this node
+	// to the kernel routing table.
+	SyncRoutes(ctx context.Context) error
+
 	// ClearRoutes removes all exported routes from the kernel routing table.
 	// This should be called when the node is being deleted to stop BGP advertisement.
 	ClearRoutes(ctx context.Context) error
 }
 
 // +kubebuilder:rbac:groups=coil.cybozu.com,resources=addressblocks,verbs=get;list;update;patch;delete
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=addresspools,verbs=get;list;watch;update
 // +kubebuilder:rbac:groups=coil.cybozu.com,resources=blockrequests,verbs=get;list;watch;create;delete
 // +kubebuilder:rbac:groups=coil.cybozu.com,resources=blockrequests/status,verbs=get
 
@@ -88,6 +117,8 @@ type nodeIPAM struct {
 	scheme    *runtime.Scheme
 	exporter  nodenet.RouteExporter
 
+	policy BlockPolicy
+
 	mu    sync.Mutex
 	pools map[string]*nodePool
 	node  *corev1.Node
@@ -95,11 +126,29 @@ type nodeIPAM struct {
 	allocInfoMap sync.Map
 }
 
+// BlockPolicy controls how many addresses and blocks are kept on a node for each pool.
+type BlockPolicy struct {
+	// LowWatermark is the number of free addresses below which a new block is
+	// requested in the background.  0 disables the pre-allocation.
+	LowWatermark int
+
+	// HighWatermark is the number of free addresses above which empty blocks are released.
+	// Empty blocks are not released if the free addresses would fall below LowWatermark.
+	HighWatermark int
+
+	// MinBlocks is the number of blocks kept even if they are empty.
+	MinBlocks int
+
+	// ReleaseGracePeriod is the duration for which a block must stay empty
+	// before it is released.  Blocks kept by this are released by GC.
+	ReleaseGracePeriod time.Duration
+}
+
 // NewNodeIPAM creates a new NodeIPAM object.
 //
 // If `exporter` is non-nil, this calls `exporter.Sync` to
 // add or delete routes when it allocate or delete AddressBlocks.
-func NewNodeIPAM(nodeName string, l logr.Logger, mgr manager.Manager, exporter nodenet.RouteExporter) NodeIPAM {
+func NewNodeIPAM(nodeName string, l logr.Logger, mgr manager.Manager, exporter nodenet.RouteExporter, policy BlockPolicy) NodeIPAM {
 	return &nodeIPAM{
 		nodeName:  nodeName,
 		log:       l,
@@ -107,6 +156,7 @@ func NewNodeIPAM(nodeName string, l logr.Logger, mgr manager.Manager, exporter n
 		apiReader: mgr.GetAPIReader(),
 		scheme:    mgr.GetScheme(),
 		exporter:  exporter,
+		policy:    policy,
 		pools:     make(map[string]*nodePool),
 	}
 }
@@ -118,6 +168,10 @@ func (n *nodeIPAM) ClearRoutes(ctx context.Context) error {
 	return n.exporter.Sync(nil)
 }
 
+func (n *nodeIPAM) SyncRoutes(ctx context.Context) error {
+	return n.sync(ctx)
+}
+
 func (n *nodeIPAM) sync(ctx context.Context) error {
 	if n.exporter == nil {
 		return nil
@@ -132,6 +186,9 @@ func (n *nodeIPAM) sync(ctx context.Context) error {
 
 	var subnets []*net.IPNet
 	for _, block := range blocks.Items {
+		if block.Labels[constants.LabelProvisional] == "true" {
+			continue
+		}
 		if block.IPv4 != nil {
 			_, n, _ := net.ParseCIDR(*block.IPv4)
 			subnets = append(subnets, n)
@@ -175,9 +232,39 @@ func (n *nodeIPAM) gc(ctx context.Context) error {
 			return err
 		}
 	}
+	if err := n.deleteStaleRequests(ctx); err != nil {
+		return err
+	}
 	return n.sync(ctx)
 }
 
+// deleteStaleRequests deletes BlockRequests of this node that nobody waits for.
+// Such requests are left when coild is restarted while waiting for them.
+// The caller must hold n.mu.
+func (n *nodeIPAM) deleteStaleRequests(ctx context.Context) error {
+	reqs := &coilv2.BlockRequestList{}
+	if err := n.apiReader.List(ctx, reqs); err != nil {
+		return err
+	}
+
+	for _, req := range reqs.Items {
+		if req.Spec.NodeName != n.nodeName {
+			continue
+		}
+		if p, ok := n.pools[req.Spec.PoolName]; ok {
+			if _, ok := p.waiters.Load(req.Name); ok {
+				continue
+			}
+		}
+
+		n.log.Info("deleting a stale BlockRequest", "request", req.Name)
+		if err := n.client.Delete(ctx, &req); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete BlockRequest %s: %w", req.Name, err)
+		}
+	}
+	return nil
+}
+
 func (n *nodeIPAM) syncUnregisteredPool(ctx context.Context) error {
 	blocks := &coilv2.AddressBlockList{}
 	if err := n.apiReader.List(ctx, blocks, client.MatchingLabels{
@@ -199,6 +286,14 @@ func (n *nodeIPAM) syncUnregisteredPool(ctx context.Context) error {
 }
 
 func (n *nodeIPAM) Allocate(ctx context.Context, poolName, containerID, iface string) (ipv4, ipv6 net.IP, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "NodeIPAM.Allocate", trace.WithAttributes(
+		attribute.String("coil.pool", poolName),
+		attribute.String("coil.container_id", containerID),
+	))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
 	key := allocKey(containerID, iface)
 	if val, ok := n.allocInfoMap.Load(key); ok {
 		val := val.(*allocInfo)
@@ -209,15 +304,10 @@ func (n *nodeIPAM) Allocate(ctx context.Context, poolName, containerID, iface st
 	if err != nil {
 		return nil, nil, err
 	}
-	ai, toSync, err := p.allocate(ctx)
+	ai, err := p.allocate(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
-	if toSync {
-		if err := n.sync(ctx); err != nil {
-			return nil, nil, err
-		}
-	}
 	n.allocInfoMap.Store(key, ai)
 	return ai.IPv4, ai.IPv6, nil
 }
@@ -243,6 +333,31 @@ func (n *nodeIPAM) Free(ctx context.Context, containerID, iface string) error {
 	return nil
 }
 
+func (n *nodeIPAM) Allocations() []Allocation {
+	var allocs []Allocation
+	n.allocInfoMap.Range(func(key, val any) bool {
+		k := key.(string)
+		i := strings.LastIndex(k, ":")
+		ai := val.(*allocInfo)
+		allocs = append(allocs, Allocation{
+			ContainerID: k[:i],
+			Iface:       k[i+1:],
+			PoolName:    ai.Pool.poolName,
+			BlockName:   ai.BlockName,
+			IPv4:        ai.IPv4,
+			IPv6:        ai.IPv6,
+		})
+		return true
+	})
+	sort.Slice(allocs, func(i, j int) bool {
+		if allocs[i].ContainerID != allocs[j].ContainerID {
+			return allocs[i].ContainerID < allocs[j].ContainerID
+		}
+		return allocs[i].Iface < allocs[j].Iface
+	})
+	return allocs
+}
+
 func (n *nodeIPAM) Notify(req *coilv2.BlockRequest) {
 	n.mu.Lock()
 	p, ok := n.pools[req.Spec.PoolName]
@@ -264,19 +379,23 @@ func (n *nodeIPAM) getPool(ctx context.Context, name string) (*nodePool, error)
 	p, ok := n.pools[name]
 	if !ok {
 		p = &nodePool{
-			poolName:            name,
-			nodeName:            n.nodeName,
-			node:                n.node,
-			log:                 n.log.WithValues("pool", name),
-			client:              n.client,
-			apiReader:           n.apiReader,
-			scheme:              n.scheme,
-			requestCompletionCh: make(chan *coilv2.BlockRequest),
-			blockAlloc:          make(map[string]*allocator),
+			poolName:   name,
+			nodeName:   n.nodeName,
+			node:       n.node,
+			log:        n.log.WithValues("pool", name),
+			client:     n.client,
+			apiReader:  n.apiReader,
+			scheme:     n.scheme,
+			policy:     n.policy,
+			syncRoutes: n.sync,
+			blockAlloc: make(map[string]*allocator),
+			emptySince: make(map[string]time.Time),
+			blockReady: make(chan struct{}),
 		}
 		if err := p.syncBlock(ctx); err != nil {
 			return nil, err
 		}
+		p.updateMetrics()
 		n.pools[name] = p
 	}
 
@@ -332,10 +451,33 @@ type nodePool struct {
 	apiReader client.Reader
 	scheme    *runtime.Scheme
 
-	requestCompletionCh chan *coilv2.BlockRequest
+	policy     BlockPolicy
+	syncRoutes func(context.Context) error
+
+	// waiters holds channels to notify the completion of BlockRequests keyed by the name.
+	waiters sync.Map
 
 	mu         sync.Mutex
 	blockAlloc map[string]*allocator
+
+	// emptySince records when each block became empty.
+	emptySince map[string]time.Time
+
+	// pending is the number of BlockRequests in flight.
+	pending int
+
+	// waiting is the number of allocations waiting for a new block.
+	waiting int
+
+	// blockReady is closed and replaced when a BlockRequest completes.
+	blockReady chan struct{}
+}
+
+// blockRequest represents the result of a BlockRequest in flight.
+// The fields are protected by nodePool.mu.
+type blockRequest struct {
+	done bool
+	err  error
 }
 
 // syncBlock synchronizes address block information.
@@ -349,7 +491,13 @@ func (p *nodePool) syncBlock(ctx context.Context) error {
 		return err
 	}
 
+	current := make(map[string]bool)
 	for _, block := range blocks.Items {
+		// provisional blocks may be discarded by coil-ipam-controller.
+		if block.Labels[constants.LabelProvisional] == "true" {
+			continue
+		}
+		current[block.Name] = true
 		if _, ok := p.blockAlloc[block.Name]; ok {
 			continue
 		}
@@ -363,8 +511,63 @@ func (p *nodePool) syncBlock(ctx context.Context) error {
 		if block.Labels[constants.LabelReserved] == "true" {
 			a.fill()
 		}
+		if q := decodeQuarantine(block.Annotations[constants.AnnQuarantinedAddresses], time.Now()); len(q) > 0 {
+			a.quarantine = q
+		}
 		p.blockAlloc[block.Name] = a
 	}
+
+	for name, alloc := range p.blockAlloc {
+		if current[name] {
+			continue
+		}
+		if !alloc.isEmpty() {
+			p.log.Error(nil, "removing a block in use that no longer exists", "name", name)
+		} else {
+			p.log.Info("removing a block that no longer exists", "name", name)
+		}
+		delete(p.blockAlloc, name)
+		delete(p.emptySince, name)
+	}
+	return nil
+}
+
+// adoptBlock makes the block allocated for the request available to this node
+// and deletes the other blocks allocated for the same request.
+func (p *nodePool) adoptBlock(ctx context.Context, name string, requestUID types.UID) error {
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		b := &coilv2.AddressBlock{}
+		if err := p.apiReader.Get(ctx, client.ObjectKey{Name: name}, b); err != nil {
+			return err
+		}
+		if _, ok := b.Labels[constants.LabelProvisional]; !ok {
+			return nil
+		}
+		delete(b.Labels, constants.LabelProvisional)
+		return p.client.Update(ctx, b)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to adopt %s: %w", name, err)
+	}
+
+	blocks := &coilv2.AddressBlockList{}
+	err = p.apiReader.List(ctx, blocks, client.MatchingLabels{
+		constants.LabelRequest: string(requestUID),
+	})
+	if err != nil {
+		// the remaining blocks will be collected by coil-ipam-controller.
+		p.log.Error(err, "failed to list blocks for the request")
+		return nil
+	}
+	for _, b := range blocks.Items {
+		if b.Name == name || b.Labels[constants.LabelProvisional] != "true" {
+			continue
+		}
+		p.log.Info("deleting a block allocated for nothing", "block", b.Name)
+		if err := p.deleteBlock(ctx, b.Name); err != nil {
+			p.log.Error(err, "failed to delete a block allocated for nothing", "block", b.Name)
+		}
+	}
 	return nil
 }
 
@@ -395,29 +598,41 @@ func (p *nodePool) deleteBlock(ctx context.Context, name string) error {
 func (p *nodePool) gc(ctx context.Context) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	defer p.updateMetrics()
 
 	if err := p.syncBlock(ctx); err != nil {
 		return err
 	}
 
+	now := time.Now()
 	for name, alloc := range p.blockAlloc {
 		if !alloc.isEmpty() {
 			continue
 		}
+		if _, ok := p.emptySince[name]; !ok {
+			p.emptySince[name] = now
+		}
+		if !p.releasable(name, alloc, now) {
+			continue
+		}
 
 		p.log.Info("freeing an unused block", "block", name)
-		if err := p.deleteBlock(ctx, name); err != nil {
+		if err := p.releaseBlock(ctx, name); err != nil {
 			return err
 		}
-		delete(p.blockAlloc, name)
+		gcReclaimedBlocks.WithLabelValues(p.poolName, p.nodeName).Inc()
 	}
 
 	return nil
 }
 
 func (p *nodePool) notify(req *coilv2.BlockRequest) {
+	v, ok := p.waiters.Load(req.Name)
+	if !ok {
+		return
+	}
 	select {
-	case p.requestCompletionCh <- req:
+	case v.(chan *coilv2.BlockRequest) <- req:
 	default:
 	}
 }
@@ -425,6 +640,7 @@ func (p *nodePool) notify(req *coilv2.BlockRequest) {
 func (p *nodePool) register(containerID, iface string, ipv4, ipv6 net.IP) *allocInfo {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	defer p.updateMetrics()
 
 	for block, alloc := range p.blockAlloc {
 		if idx, ok := alloc.register(ipv4, ipv6); ok {
@@ -453,12 +669,17 @@ func (p *nodePool) register(containerID, iface string, ipv4, ipv6 net.IP) *alloc
 	return nil
 }
 
-func (p *nodePool) allocateFrom(alloc *allocator, block string, toSync bool) (*allocInfo, bool, error) {
+func (p *nodePool) allocateFrom(alloc *allocator, block string) *allocInfo {
 	ipv4, ipv6, idx, ok := alloc.allocate()
 	if !ok {
 		panic("bug")
 	}
 
+	if _, ok := p.emptySince[block]; ok {
+		delete(p.emptySince, block)
+		blockReuses.WithLabelValues(p.poolName, p.nodeName).Inc()
+	}
+
 	p.log.Info("allocated",
 		"block", block,
 		"ipv4", ipv4, "ipv6", ipv6,
@@ -469,86 +690,324 @@ func (p *nodePool) allocateFrom(alloc *allocator, block string, toSync bool) (*a
 		BlockName: block,
 		Index:     idx,
 		Pool:      p,
-	}, toSync, nil
+	}
 }
 
-func (p *nodePool) allocate(ctx context.Context) (*allocInfo, bool, error) {
+func (p *nodePool) allocate(ctx context.Context) (*allocInfo, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	defer p.updateMetrics()
 
-	for block, alloc := range p.blockAlloc {
-		if alloc.isFull() {
-			continue
+	ctx, cancel := context.WithTimeout(ctx, DefaultAllocTimeout)
+	defer cancel()
+
+	p.waiting++
+	defer func() {
+		p.waiting--
+	}()
+
+	var req *blockRequest
+	for {
+		for block, alloc := range p.blockAlloc {
+			if alloc.isFull() {
+				continue
+			}
+
+			defer p.prefetch()
+			return p.allocateFrom(alloc, block), nil
+		}
+
+		if req != nil && req.done {
+			if req.err != nil {
+				return nil, req.err
+			}
+			// the block was taken by other allocations.
+			req = nil
+		}
+
+		// request a new block unless the blocks being requested are
+		// enough for the allocations waiting for them.
+		if req == nil && p.pending*p.blockCapacity(ctx) < p.waiting {
+			p.log.Info("requesting a new block")
+			req = p.startRequest(ctx)
 		}
 
-		return p.allocateFrom(alloc, block, false)
+		ready := p.blockReady
+		p.mu.Unlock()
+		select {
+		case <-ready:
+		case <-ctx.Done():
+		}
+		p.mu.Lock()
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("aborting new block request: %w", err)
+		}
 	}
+}
 
-	p.log.Info("requesting a new block")
-	ctx, cancel := context.WithTimeout(ctx, DefaultAllocTimeout)
-	defer cancel()
+// prefetch requests a new block in the background if the number of free
+// addresses falls below the low watermark.  The caller must hold p.mu.
+func (p *nodePool) prefetch() {
+	if p.policy.LowWatermark == 0 || p.pending > 0 {
+		return
+	}
+	if p.freeAddresses() >= uint(p.policy.LowWatermark) {
+		return
+	}
 
-	reqName := fmt.Sprintf("req-%s-%s", p.poolName, p.nodeName)
+	p.log.Info("requesting a new block in the background")
+	p.startRequest(context.Background())
+}
 
-	// delete existing request, if any
-	req := &coilv2.BlockRequest{}
-	req.Name = reqName
-	err := p.client.Delete(ctx, req)
-	if err != nil && !apierrors.IsNotFound(err) {
-		return nil, false, fmt.Errorf("failed to delete existing BlockRequest: %w", err)
+// startRequest requests a new block in a goroutine so that other allocations
+// can proceed while waiting for the request.  The goroutine continues the
+// trace of ctx but is not canceled with ctx.  The caller must hold p.mu.
+func (p *nodePool) startRequest(ctx context.Context) *blockRequest {
+	req := &blockRequest{}
+	p.pending++
+	ctx = trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, DefaultAllocTimeout)
+		defer cancel()
+
+		// routes are exported before the block becomes available to Pods.
+		block, err := p.requestNewBlock(ctx)
+		if err == nil {
+			err = p.syncRoutes(ctx)
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		defer p.updateMetrics()
+		if err == nil {
+			err = p.syncBlock(ctx)
+		}
+		if err != nil {
+			p.log.Error(err, "failed to request a new block", "block", block)
+		}
+		p.pending--
+		req.done = true
+		req.err = err
+		close(p.blockReady)
+		p.blockReady = make(chan struct{})
+	}()
+	return req
+}
+
+// requestNewBlock requests a new block with tracing and metrics.
+func (p *nodePool) requestNewBlock(ctx context.Context) (string, error) {
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, "BlockRequest")
+	block, err := p.requestBlock(ctx)
+	tracing.EndSpan(span, err)
+	blockRequestDuration.WithLabelValues(p.poolName).Observe(time.Since(start).Seconds())
+	if err != nil {
+		blockRequests.WithLabelValues(p.poolName, p.nodeName, blockRequestFailed).Inc()
+		return "", err
+	}
+	blockRequests.WithLabelValues(p.poolName, p.nodeName, blockRequestAllocated).Inc()
+	return block, nil
+}
+
+// blockCapacity returns the number of addresses in a block of the pool.
+// The caller must hold p.mu.
+func (p *nodePool) blockCapacity(ctx context.Context) int {
+	for _, alloc := range p.blockAlloc {
+		return int(alloc.usage.Len())
+	}
+	return 1 << p.addressPool(ctx).Spec.BlockSizeBits
+}
+
+// freeAddresses returns the number of free addresses in the blocks of the pool.
+// The caller must hold p.mu.
+func (p *nodePool) freeAddresses() uint {
+	var free uint
+	for _, alloc := range p.blockAlloc {
+		free += alloc.available()
+	}
+	return free
+}
+
+// canRelease returns true if an empty block having the given number of
+// available addresses can be released without violating the watermarks.
+// The caller must hold p.mu.
+func (p *nodePool) canRelease(size uint) bool {
+	free := p.freeAddresses()
+	return free > uint(p.policy.HighWatermark) && free-size >= uint(p.policy.LowWatermark)
+}
+
+// addressPool returns the AddressPool of the pool.
+// An empty AddressPool is returned if it cannot be retrieved.
+func (p *nodePool) addressPool(ctx context.Context) *coilv2.AddressPool {
+	ap := &coilv2.AddressPool{}
+	if err := p.client.Get(ctx, client.ObjectKey{Name: p.poolName}, ap); err != nil {
+		if !apierrors.IsNotFound(err) {
+			p.log.Error(err, "failed to get AddressPool")
+		}
+		return &coilv2.AddressPool{}
 	}
+	return ap
+}
 
-	req = &coilv2.BlockRequest{}
-	req.Name = reqName
+// requestBlock creates a uniquely named BlockRequest and waits for its completion.
+// The trace context of ctx is passed to coil-ipam-controller via annotations.
+func (p *nodePool) requestBlock(ctx context.Context) (string, error) {
+	req := &coilv2.BlockRequest{}
+	req.Name = fmt.Sprintf("req-%s-%s-%s", p.poolName, p.nodeName, utilrand.String(5))
+	req.Labels = map[string]string{
+		constants.LabelPool: p.poolName,
+		constants.LabelNode: p.nodeName,
+	}
 	if err := controllerutil.SetOwnerReference(p.node, req, p.scheme); err != nil {
-		return nil, false, fmt.Errorf("failed to set owner reference: %w", err)
+		return "", fmt.Errorf("failed to set owner reference: %w", err)
 	}
 	req.Spec.NodeName = p.nodeName
 	req.Spec.PoolName = p.poolName
-	if err := p.client.Create(ctx, req); err != nil {
-		return nil, false, fmt.Errorf("failed to create BlockRequest: %w", err)
-	}
+	tracing.InjectAnnotations(ctx, req)
 
-	p.log.Info("waiting for request completion")
-	select {
-	case <-ctx.Done():
-		return nil, false, fmt.Errorf("aborting new block request: %w", ctx.Err())
-	case req = <-p.requestCompletionCh:
+	// register the channel before creating the request not to miss the notification.
+	ch := make(chan *coilv2.BlockRequest, 1)
+	p.waiters.Store(req.Name, ch)
+	defer p.waiters.Delete(req.Name)
+
+	err := retry.OnError(retry.DefaultBackoff, isTransientError, func() error {
+		return p.client.Create(ctx, req)
+	})
+	// a retried request may fail because the previous attempt has succeeded.
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("failed to create BlockRequest: %w", err)
+	}
+	defer p.deleteRequest(ctx, req.Name)
+
+	p.log.Info("waiting for request completion", "request", req.Name)
+	ticker := time.NewTicker(requestPollInterval)
+	defer ticker.Stop()
+	for len(req.Status.Conditions) == 0 {
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("aborting new block request: %w", ctx.Err())
+		case req = <-ch:
+		case <-ticker.C:
+			// in case the notification is lost.
+			if err := p.apiReader.Get(ctx, client.ObjectKey{Name: req.Name}, req); err != nil {
+				if apierrors.IsNotFound(err) {
+					return "", fmt.Errorf("BlockRequest %s has been deleted", req.Name)
+				}
+				p.log.Error(err, "failed to get BlockRequest", "request", req.Name)
+			}
+		}
 	}
 
 	block, err := req.GetResult()
 	if err != nil {
-		p.log.Error(err, "request failed", "conditions", fmt.Sprintf("%+v", req.Status.Conditions))
-		return nil, false, err
+		p.log.Error(err, "request failed", "request", req.Name, "conditions", fmt.Sprintf("%+v", req.Status.Conditions))
+		return "", err
 	}
 
-	if err := p.syncBlock(ctx); err != nil {
-		return nil, false, fmt.Errorf("failed to sync blocks: %w", err)
+	// Only the block recorded in the status is given to this node
+	// as active-active controllers may allocate blocks for a request.
+	if err := p.adoptBlock(ctx, block, req.UID); err != nil {
+		return "", err
 	}
-	alloc, ok := p.blockAlloc[block]
-	if !ok {
-		panic("bug: " + block)
+	return block, nil
+}
+
+// deleteRequest deletes a BlockRequest.
+// This is done even after ctx is canceled not to leave the request.
+func (p *nodePool) deleteRequest(ctx context.Context, name string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultAllocTimeout)
+	defer cancel()
+
+	req := &coilv2.BlockRequest{}
+	req.Name = name
+	if err := p.client.Delete(ctx, req); err != nil && !apierrors.IsNotFound(err) {
+		p.log.Error(err, "failed to delete BlockRequest", "request", name)
 	}
-	return p.allocateFrom(alloc, block, true)
+}
+
+// isTransientError returns true if err is worth retrying.
+func isTransientError(err error) bool {
+	return apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsInternalError(err)
 }
 
 func (p *nodePool) free(ctx context.Context, blockName string, idx uint) (bool, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	defer p.updateMetrics()
 
 	alloc, ok := p.blockAlloc[blockName]
 	if !ok {
-		panic("bug: " + blockName)
+		// the block has been removed by syncBlock.
+		p.log.Info("freeing an address of a removed block", "block", blockName, "index", idx)
+		return false, nil
+	}
+	if d := p.addressPool(ctx).Spec.GetQuarantinePeriod(); d > 0 {
+		alloc.freeWithQuarantine(idx, d)
+		// The address is freed anyway; failing to record it only shortens
+		// the quarantine if coild restarts.
+		if err := persistAddressQuarantine(ctx, p.client, blockName, alloc.quarantine); err != nil {
+			p.log.Error(err, "failed to record quarantined addresses", "block", blockName)
+		}
+	} else {
+		alloc.free(idx)
 	}
-	alloc.free(idx)
 	if !alloc.isEmpty() {
 		return false, nil
 	}
 
+	now := time.Now()
+	p.emptySince[blockName] = now
+	if !p.releasable(blockName, alloc, now) {
+		return false, nil
+	}
+
 	p.log.Info("freeing an empty block", "block", blockName)
-	if err := p.deleteBlock(ctx, blockName); err != nil {
+	if err := p.releaseBlock(ctx, blockName); err != nil {
 		return false, fmt.Errorf("failed to free block %s: %w", blockName, err)
 	}
-	delete(p.blockAlloc, blockName)
 	return true, nil
 }
+
+// releasable returns true if an empty block can be released at now.
+// The caller must hold p.mu.
+func (p *nodePool) releasable(name string, alloc *allocator, now time.Time) bool {
+	if len(p.blockAlloc) <= p.policy.MinBlocks {
+		return false
+	}
+	if !p.canRelease(alloc.available()) {
+		return false
+	}
+	return now.Sub(p.emptySince[name]) >= p.policy.ReleaseGracePeriod
+}
+
+// releaseBlock deletes an empty block and forgets it.
+// The caller must hold p.mu.
+func (p *nodePool) releaseBlock(ctx context.Context, name string) error {
+	// record the block in quarantine before deleting it.
+	if d := p.addressPool(ctx).Spec.GetQuarantinePeriod(); d > 0 {
+		b := &coilv2.AddressBlock{}
+		err := p.apiReader.Get(ctx, client.ObjectKey{Name: name}, b)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			err = persistBlockQuarantine(ctx, p.apiReader, p.client, p.poolName, []uint{uint(b.Index)}, time.Now().Add(d))
+			if err != nil {
+				return fmt.Errorf("failed to record %s in quarantine: %w", name, err)
+			}
+		}
+	}
+
+	if err := p.deleteBlock(ctx, name); err != nil {
+		return err
+	}
+	delete(p.blockAlloc, name)
+	delete(p.emptySince, name)
+	blockReleases.WithLabelValues(p.poolName, p.nodeName).Inc()
+	return nil
+}
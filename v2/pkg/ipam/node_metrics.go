@@ -0,0 +1,148 @@
+package ipam
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+// Values of "result" label of block requests
+const (
+	blockRequestAllocated = "allocated"
+	blockRequestFailed    = "failed"
+)
+
+var (
+	nodeAddressesUsed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "addresses_used",
+			Help:      "the number of addresses in use in the blocks held by the node",
+		},
+		[]string{"pool", "node"},
+	)
+
+	nodeAddressesFree = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "addresses_free",
+			Help:      "the number of free addresses in the blocks held by the node",
+		},
+		[]string{"pool", "node"},
+	)
+
+	nodeAddressesQuarantined = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "addresses_quarantined",
+			Help:      "the number of released addresses not yet allocatable due to the quarantine period",
+		},
+		[]string{"pool", "node"},
+	)
+
+	nodeBlocks = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "blocks",
+			Help:      "the number of address blocks held by the node",
+		},
+		[]string{"pool", "node"},
+	)
+
+	blockRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "block_requests_total",
+			Help:      "the number of BlockRequests created by the node",
+		},
+		[]string{"pool", "node", "result"},
+	)
+
+	blockRequestsInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "block_requests_in_flight",
+			Help:      "the number of BlockRequests waiting for completion",
+		},
+		[]string{"pool", "node"},
+	)
+
+	blockRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "block_request_duration_seconds",
+			Help:      "the time spent waiting for BlockRequest completion",
+			Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"pool"},
+	)
+
+	blockReleases = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "block_releases_total",
+			Help:      "the number of empty address blocks returned to the pool by the node",
+		},
+		[]string{"pool", "node"},
+	)
+
+	blockReuses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "block_reuses_total",
+			Help:      "the number of empty address blocks reused before being released",
+		},
+		[]string{"pool", "node"},
+	)
+
+	gcReclaimedBlocks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "gc_reclaimed_blocks_total",
+			Help:      "the number of unused address blocks released by the garbage collection",
+		},
+		[]string{"pool", "node"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(nodeAddressesUsed)
+	metrics.Registry.MustRegister(nodeAddressesFree)
+	metrics.Registry.MustRegister(nodeAddressesQuarantined)
+	metrics.Registry.MustRegister(nodeBlocks)
+	metrics.Registry.MustRegister(blockRequests)
+	metrics.Registry.MustRegister(blockRequestsInFlight)
+	metrics.Registry.MustRegister(blockRequestDuration)
+	metrics.Registry.MustRegister(blockReleases)
+	metrics.Registry.MustRegister(blockReuses)
+	metrics.Registry.MustRegister(gcReclaimedBlocks)
+}
+
+// updateMetrics updates the gauges from the allocators of the pool.
+// The caller must hold p.mu.
+func (p *nodePool) updateMetrics() {
+	var used, free, quarantined uint
+	for _, alloc := range p.blockAlloc {
+		n := alloc.usage.Count()
+		a := alloc.available()
+		used += n
+		free += a
+		quarantined += alloc.usage.Len() - n - a
+	}
+	nodeAddressesUsed.WithLabelValues(p.poolName, p.nodeName).Set(float64(used))
+	nodeAddressesFree.WithLabelValues(p.poolName, p.nodeName).Set(float64(free))
+	nodeAddressesQuarantined.WithLabelValues(p.poolName, p.nodeName).Set(float64(quarantined))
+	nodeBlocks.WithLabelValues(p.poolName, p.nodeName).Set(float64(len(p.blockAlloc)))
+	blockRequestsInFlight.WithLabelValues(p.poolName, p.nodeName).Set(float64(p.pending))
+}
@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/bsm/gomega/types"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -88,6 +90,8 @@ func testController(ctx context.Context, npMap map[string]NodeIPAM) {
 			if apierrors.IsNotFound(err) {
 				block = block.DeepCopy()
 				block.Labels[constants.LabelNode] = req.Spec.NodeName
+				block.Labels[constants.LabelRequest] = string(req.UID)
+				block.Labels[constants.LabelProvisional] = "true"
 				if err := k8sClient.Create(ctx, block); err != nil {
 					return err
 				}
@@ -174,7 +178,7 @@ var _ = Describe("NodeIPAM", func() {
 	})
 
 	It("should timeout if there is no working controller", func() {
-		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM"), mgr, nil)
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM"), mgr, nil, BlockPolicy{})
 
 		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
 		defer cancel()
@@ -186,8 +190,8 @@ var _ = Describe("NodeIPAM", func() {
 	It("should acquire block and allocate IP addresses", func() {
 		e1 := &mockExporter{}
 		e2 := &mockExporter{}
-		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM1"), mgr, e1)
-		nodeIPAM2 := NewNodeIPAM("node2", ctrl.Log.WithName("NodeIPAM2"), mgr, e2)
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM1"), mgr, e1, BlockPolicy{})
+		nodeIPAM2 := NewNodeIPAM("node2", ctrl.Log.WithName("NodeIPAM2"), mgr, e2, BlockPolicy{})
 
 		// run the dummy controller
 		ctx, cancel := context.WithCancel(ctx)
@@ -203,6 +207,13 @@ var _ = Describe("NodeIPAM", func() {
 		Expect(ipv6).To(EqualIP(net.ParseIP("fd02::0200")))
 		Expect(e1.Equal([]string{"10.2.0.0/31", "fd02::200/127"})).To(BeTrue())
 
+		allocs := nodeIPAM.Allocations()
+		Expect(allocs).To(HaveLen(1))
+		Expect(allocs[0].ContainerID).To(Equal("c0"))
+		Expect(allocs[0].Iface).To(Equal("eth0"))
+		Expect(allocs[0].PoolName).To(Equal("default"))
+		Expect(allocs[0].IPv4).To(EqualIP(net.ParseIP("10.2.0.0")))
+
 		for i := 0; i < 3; i++ {
 			_, _, err := nodeIPAM.Allocate(ctx, "default", fmt.Sprintf("c%d", i+1), "eth0")
 			Expect(err).ToNot(HaveOccurred())
@@ -214,6 +225,12 @@ var _ = Describe("NodeIPAM", func() {
 			"fd02::202/127",
 		})).To(BeTrue())
 
+		By("checking the metrics of the node")
+		Expect(testutil.ToFloat64(nodeAddressesUsed.WithLabelValues("default", "node1"))).To(BeNumerically("==", 4))
+		Expect(testutil.ToFloat64(nodeAddressesFree.WithLabelValues("default", "node1"))).To(BeNumerically("==", 0))
+		Expect(testutil.ToFloat64(nodeBlocks.WithLabelValues("default", "node1"))).To(BeNumerically("==", 2))
+		Expect(testutil.ToFloat64(blockRequests.WithLabelValues("default", "node1", blockRequestAllocated))).To(BeNumerically(">=", 2))
+
 		_, _, err = nodeIPAM.Allocate(ctx, "default", "cxx", "eth0")
 		Expect(err).To(HaveOccurred())
 
@@ -249,7 +266,7 @@ var _ = Describe("NodeIPAM", func() {
 	})
 
 	It("can restore state and return unused blocks", func() {
-		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM3"), mgr, nil)
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM3"), mgr, nil, BlockPolicy{})
 
 		// run the dummy controller
 		ctx, cancel := context.WithCancel(ctx)
@@ -279,13 +296,17 @@ var _ = Describe("NodeIPAM", func() {
 
 		// recreate node IPAM
 		e1 := &mockExporter{}
-		nodeIPAM = NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-recreated"), mgr, e1)
+		nodeIPAM = NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-recreated"), mgr, e1, BlockPolicy{})
 		err = nodeIPAM.Register(ctx, "default", "c0", "eth2", ipv4, ipv6)
 		Expect(err).ToNot(HaveOccurred())
 
+		reclaimed := testutil.ToFloat64(gcReclaimedBlocks.WithLabelValues("default", "node1"))
 		err = nodeIPAM.GC(ctx)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(e1.Equal([]string{"10.2.0.2/31", "fd02::202/127"})).To(BeTrue())
+		Expect(testutil.ToFloat64(gcReclaimedBlocks.WithLabelValues("default", "node1"))).To(Equal(reclaimed + 1))
+		Expect(testutil.ToFloat64(nodeBlocks.WithLabelValues("default", "node1"))).To(BeNumerically("==", 1))
+		Expect(testutil.ToFloat64(nodeAddressesUsed.WithLabelValues("default", "node1"))).To(BeNumerically("==", 1))
 
 		// confirm that 1 unused block is returned
 		blocks = &coilv2.AddressBlockList{}
@@ -299,6 +320,242 @@ var _ = Describe("NodeIPAM", func() {
 		Expect(ipv6).To(EqualIP(net.ParseIP("fd02::0203")))
 	})
 
+	It("should keep free addresses between the watermarks", func() {
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-watermarks"), mgr, nil, BlockPolicy{LowWatermark: 2, HighWatermark: 2})
+
+		// run the dummy controller
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go testController(ctx, map[string]NodeIPAM{
+			"node1": nodeIPAM,
+		})
+
+		countBlocks := func() int {
+			blocks := &coilv2.AddressBlockList{}
+			err := k8sClient.List(ctx, blocks, client.MatchingLabels{constants.LabelNode: "node1"})
+			Expect(err).ToNot(HaveOccurred())
+			return len(blocks.Items)
+		}
+
+		By("allocating an address to trigger pre-allocation")
+		_, _, err := nodeIPAM.Allocate(ctx, "default", "c0", "eth0")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(countBlocks).Should(Equal(2))
+		Eventually(func() float64 {
+			return testutil.ToFloat64(nodeAddressesFree.WithLabelValues("default", "node1"))
+		}).Should(BeNumerically("==", 3))
+
+		By("allocating addresses from the pre-allocated block")
+		_, _, err = nodeIPAM.Allocate(ctx, "default", "c1", "eth0")
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = nodeIPAM.Allocate(ctx, "default", "c2", "eth0")
+		Expect(err).ToNot(HaveOccurred())
+		Consistently(countBlocks).Should(Equal(2))
+
+		By("freeing addresses")
+		Expect(nodeIPAM.Free(ctx, "c0", "eth0")).To(Succeed())
+		Expect(nodeIPAM.Free(ctx, "c1", "eth0")).To(Succeed())
+		Expect(nodeIPAM.Free(ctx, "c2", "eth0")).To(Succeed())
+
+		// one empty block is released, but the other is kept for the low watermark.
+		Eventually(countBlocks).Should(Equal(1))
+		Expect(nodeIPAM.GC(ctx)).To(Succeed())
+		Expect(countBlocks()).To(Equal(1))
+	})
+
+	It("should keep empty blocks for the grace period", func() {
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-grace"), mgr, nil, BlockPolicy{ReleaseGracePeriod: time.Second})
+
+		// run the dummy controller
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go testController(ctx, map[string]NodeIPAM{
+			"node1": nodeIPAM,
+		})
+
+		countBlocks := func() int {
+			blocks := &coilv2.AddressBlockList{}
+			err := k8sClient.List(ctx, blocks, client.MatchingLabels{constants.LabelNode: "node1"})
+			Expect(err).ToNot(HaveOccurred())
+			return len(blocks.Items)
+		}
+
+		_, _, err := nodeIPAM.Allocate(ctx, "default", "c0", "eth0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodeIPAM.Free(ctx, "c0", "eth0")).To(Succeed())
+		Expect(countBlocks()).To(Equal(1))
+
+		By("reusing the empty block")
+		reuses := testutil.ToFloat64(blockReuses.WithLabelValues("default", "node1"))
+		_, _, err = nodeIPAM.Allocate(ctx, "default", "c1", "eth0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(testutil.ToFloat64(blockReuses.WithLabelValues("default", "node1"))).To(Equal(reuses + 1))
+		Expect(nodeIPAM.Free(ctx, "c1", "eth0")).To(Succeed())
+
+		By("running GC within the grace period")
+		Expect(nodeIPAM.GC(ctx)).To(Succeed())
+		Expect(countBlocks()).To(Equal(1))
+
+		By("running GC after the grace period")
+		releases := testutil.ToFloat64(blockReleases.WithLabelValues("default", "node1"))
+		time.Sleep(time.Second)
+		Expect(nodeIPAM.GC(ctx)).To(Succeed())
+		Expect(countBlocks()).To(Equal(0))
+		Expect(testutil.ToFloat64(blockReleases.WithLabelValues("default", "node1"))).To(Equal(releases + 1))
+	})
+
+	It("should keep the minimum number of blocks", func() {
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-min-blocks"), mgr, nil, BlockPolicy{MinBlocks: 1})
+
+		// run the dummy controller
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go testController(ctx, map[string]NodeIPAM{
+			"node1": nodeIPAM,
+		})
+
+		_, _, err := nodeIPAM.Allocate(ctx, "default", "c0", "eth0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodeIPAM.Free(ctx, "c0", "eth0")).To(Succeed())
+		Expect(nodeIPAM.GC(ctx)).To(Succeed())
+
+		blocks := &coilv2.AddressBlockList{}
+		err = k8sClient.List(ctx, blocks, client.MatchingLabels{constants.LabelNode: "node1"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(blocks.Items).To(HaveLen(1))
+	})
+
+	It("should not reuse addresses in quarantine", func() {
+		ap := &coilv2.AddressPool{}
+		err := k8sClient.Get(ctx, client.ObjectKey{Name: "default"}, ap)
+		Expect(err).ToNot(HaveOccurred())
+		ap.Spec.QuarantinePeriod = &metav1.Duration{Duration: time.Hour}
+		err = k8sClient.Update(ctx, ap)
+		Expect(err).ToNot(HaveOccurred())
+		defer func() {
+			ap := &coilv2.AddressPool{}
+			err := k8sClient.Get(ctx, client.ObjectKey{Name: "default"}, ap)
+			Expect(err).ToNot(HaveOccurred())
+			ap.Spec.QuarantinePeriod = nil
+			delete(ap.Annotations, constants.AnnQuarantinedBlocks)
+			err = k8sClient.Update(ctx, ap)
+			Expect(err).ToNot(HaveOccurred())
+		}()
+		Eventually(func() *metav1.Duration {
+			ap := &coilv2.AddressPool{}
+			if err := mgr.GetClient().Get(ctx, client.ObjectKey{Name: "default"}, ap); err != nil {
+				return nil
+			}
+			return ap.Spec.QuarantinePeriod
+		}).ShouldNot(BeNil())
+
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-quarantine"), mgr, nil, BlockPolicy{})
+
+		// run the dummy controller
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go testController(ctx, map[string]NodeIPAM{
+			"node1": nodeIPAM,
+		})
+
+		ipv4, _, err := nodeIPAM.Allocate(ctx, "default", "c0", "eth0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ipv4).To(EqualIP(net.ParseIP("10.2.0.0")))
+		_, _, err = nodeIPAM.Allocate(ctx, "default", "c1", "eth0")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(nodeIPAM.Free(ctx, "c0", "eth0")).To(Succeed())
+		Expect(testutil.ToFloat64(nodeAddressesQuarantined.WithLabelValues("default", "node1"))).To(BeNumerically("==", 1))
+
+		blocks := &coilv2.AddressBlockList{}
+		err = k8sClient.List(ctx, blocks, client.MatchingLabels{constants.LabelNode: "node1"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(blocks.Items).To(HaveLen(1))
+		q := decodeQuarantine(blocks.Items[0].Annotations[constants.AnnQuarantinedAddresses], time.Now())
+		Expect(q).To(HaveKey(uint(0)))
+
+		// the freed address is in quarantine, so a new block is requested.
+		ipv4, _, err = nodeIPAM.Allocate(ctx, "default", "c2", "eth0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ipv4).To(EqualIP(net.ParseIP("10.2.0.2")))
+	})
+
+	It("should request blocks concurrently for bursty allocations", func() {
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-burst"), mgr, nil, BlockPolicy{})
+
+		// run the dummy controller
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go testController(ctx, map[string]NodeIPAM{
+			"node1": nodeIPAM,
+		})
+
+		requests := testutil.ToFloat64(blockRequests.WithLabelValues("default", "node1", blockRequestAllocated))
+
+		var wg sync.WaitGroup
+		errs := make([]error, 4)
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, errs[i] = nodeIPAM.Allocate(ctx, "default", fmt.Sprintf("c%d", i), "eth0")
+			}()
+		}
+		wg.Wait()
+		for _, err := range errs {
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		// two blocks of two addresses are enough for four allocations.
+		Expect(testutil.ToFloat64(blockRequests.WithLabelValues("default", "node1", blockRequestAllocated))).To(Equal(requests + 2))
+		Expect(nodeIPAM.Allocations()).To(HaveLen(4))
+
+		// completed requests are deleted.
+		Eventually(func() []coilv2.BlockRequest {
+			reqs := &coilv2.BlockRequestList{}
+			err := k8sClient.List(ctx, reqs)
+			Expect(err).ToNot(HaveOccurred())
+			return reqs.Items
+		}).Should(BeEmpty())
+	})
+
+	It("should complete a request without notification", func() {
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-poll"), mgr, nil, BlockPolicy{})
+
+		// run the dummy controller that does not notify node1.
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go testController(ctx, map[string]NodeIPAM{})
+
+		ipv4, _, err := nodeIPAM.Allocate(ctx, "default", "c0", "eth0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ipv4).To(EqualIP(net.ParseIP("10.2.0.0")))
+	})
+
+	It("should delete stale requests", func() {
+		req := &coilv2.BlockRequest{}
+		req.Name = "req-default-node1"
+		req.Spec.NodeName = "node1"
+		req.Spec.PoolName = "default"
+		err := k8sClient.Create(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+
+		other := &coilv2.BlockRequest{}
+		other.Name = "req-default-node2"
+		other.Spec.NodeName = "node2"
+		other.Spec.PoolName = "default"
+		err = k8sClient.Create(ctx, other)
+		Expect(err).ToNot(HaveOccurred())
+
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-stale"), mgr, nil, BlockPolicy{})
+		Expect(nodeIPAM.GC(ctx)).To(Succeed())
+
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: req.Name}, &coilv2.BlockRequest{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: other.Name}, &coilv2.BlockRequest{})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
 	It("should ignore reserved blocks", func() {
 		By("creating a reserved block")
 		block := &coilv2.AddressBlock{
@@ -318,7 +575,7 @@ var _ = Describe("NodeIPAM", func() {
 		err := k8sClient.Create(ctx, block)
 		Expect(err).ShouldNot(HaveOccurred())
 
-		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM3"), mgr, nil)
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM3"), mgr, nil, BlockPolicy{})
 
 		// run the dummy controller
 		ctx, cancel := context.WithCancel(ctx)
@@ -337,20 +594,85 @@ var _ = Describe("NodeIPAM", func() {
 		Expect(blocks.Items).To(HaveLen(2))
 	})
 
+	It("should adopt only the block recorded in the request", func() {
+		By("creating a provisional block for another request")
+		block := &coilv2.AddressBlock{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "default-0",
+				Labels: map[string]string{
+					constants.LabelPool:        "default",
+					constants.LabelNode:        "node1",
+					constants.LabelRequest:     "9f1f8b27-2d0c-4f63-8d1e-3c5c2b0ad1c4",
+					constants.LabelProvisional: "true",
+				},
+				Finalizers: []string{constants.FinCoil},
+			},
+			Index: 0,
+			IPv4:  strPtr("10.2.0.0/31"),
+			IPv6:  strPtr("fd02::0200/127"),
+		}
+		err := k8sClient.Create(ctx, block)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		e := &mockExporter{}
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-provisional"), mgr, e, BlockPolicy{})
+
+		// run the dummy controller
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go testController(ctx, map[string]NodeIPAM{
+			"node1": nodeIPAM,
+		})
+
+		ipv4, _, err := nodeIPAM.Allocate(ctx, "default", "c0", "eth0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ipv4).To(EqualIP(net.ParseIP("10.2.0.2")))
+		Expect(e.Equal([]string{"10.2.0.2/31", "fd02::202/127"})).To(BeTrue())
+
+		b := &coilv2.AddressBlock{}
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: "default-1"}, b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.Labels).NotTo(HaveKey(constants.LabelProvisional))
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: "default-0"}, b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.Labels).To(HaveKeyWithValue(constants.LabelProvisional, "true"))
+	})
+
+	It("should forget blocks removed from the API", func() {
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-removed"), mgr, nil, BlockPolicy{})
+
+		// run the dummy controller
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go testController(ctx, map[string]NodeIPAM{
+			"node1": nodeIPAM,
+		})
+
+		_, _, err := nodeIPAM.Allocate(ctx, "default", "c0", "eth0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(testutil.ToFloat64(nodeBlocks.WithLabelValues("default", "node1"))).To(BeNumerically("==", 1))
+
+		cleanBlocks()
+
+		Expect(nodeIPAM.GC(ctx)).To(Succeed())
+		Expect(testutil.ToFloat64(nodeBlocks.WithLabelValues("default", "node1"))).To(BeNumerically("==", 0))
+		Expect(nodeIPAM.Free(ctx, "c0", "eth0")).To(Succeed())
+	})
+
 	It("can return node internal IPs", func() {
-		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM4"), mgr, nil)
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM4"), mgr, nil, BlockPolicy{})
 		ipv4, ipv6, err := nodeIPAM.NodeInternalIP(ctx)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(ipv4).To(EqualIP(net.ParseIP("10.20.30.41")))
 		Expect(ipv6).To(EqualIP(net.ParseIP("fd10::41")))
 
-		nodeIPAM = NewNodeIPAM("node2", ctrl.Log.WithName("NodeIPAM5"), mgr, nil)
+		nodeIPAM = NewNodeIPAM("node2", ctrl.Log.WithName("NodeIPAM5"), mgr, nil, BlockPolicy{})
 		ipv4, ipv6, err = nodeIPAM.NodeInternalIP(ctx)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(ipv4).To(EqualIP(net.ParseIP("10.20.30.42")))
 		Expect(ipv6).To(BeNil())
 
-		nodeIPAM = NewNodeIPAM("node3", ctrl.Log.WithName("NodeIPAM5"), mgr, nil)
+		nodeIPAM = NewNodeIPAM("node3", ctrl.Log.WithName("NodeIPAM5"), mgr, nil, BlockPolicy{})
 		ipv4, ipv6, err = nodeIPAM.NodeInternalIP(ctx)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(ipv4).To(BeNil())
@@ -364,13 +686,13 @@ var _ = Describe("NodeIPAM", func() {
 		e.Sync([]*net.IPNet{ipnet})
 		Expect(e.Equal([]string{"10.2.0.0/24"})).To(BeTrue())
 
-		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-clear"), mgr, e)
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-clear"), mgr, e, BlockPolicy{})
 		Expect(nodeIPAM.ClearRoutes(ctx)).To(Succeed())
 		Expect(e.Equal([]string{})).To(BeTrue())
 	})
 
 	It("should do nothing when exporter is nil", func() {
-		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-clear-nil"), mgr, nil)
+		nodeIPAM := NewNodeIPAM("node1", ctrl.Log.WithName("NodeIPAM-clear-nil"), mgr, nil, BlockPolicy{})
 		Expect(nodeIPAM.ClearRoutes(ctx)).To(Succeed())
 	})
 })
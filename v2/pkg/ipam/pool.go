@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/bits-and-blooms/bitset"
 	"github.com/go-logr/logr"
 	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -23,7 +25,7 @@ import (
 var ErrNoBlock = errors.New("out of blocks")
 
 // +kubebuilder:rbac:groups=coil.cybozu.com,resources=addressblocks,verbs=get;list;watch;create
-// +kubebuilder:rbac:groups=coil.cybozu.com,resources=addresspools,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=addresspools,verbs=get;list;watch;update;patch
 
 // PoolManager manages address pools.
 type PoolManager interface {
@@ -62,11 +64,22 @@ var (
 		},
 		[]string{"pool"},
 	)
+
+	poolConflicts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "controller",
+			Name:      "block_conflicts_total",
+			Help:      "the number of address blocks found to be allocated by another controller",
+		},
+		[]string{"pool"},
+	)
 )
 
 func init() {
 	metrics.Registry.MustRegister(poolMaxBlocks)
 	metrics.Registry.MustRegister(poolAllocated)
+	metrics.Registry.MustRegister(poolConflicts)
 }
 
 type poolManager struct {
@@ -83,6 +96,7 @@ type poolManager struct {
 func NewPoolManager(cl client.Client, r client.Reader, l logr.Logger, scheme *runtime.Scheme) PoolManager {
 	poolMaxBlocks.Reset()
 	poolAllocated.Reset()
+	poolConflicts.Reset()
 
 	return &poolManager{
 		client: cl,
@@ -108,6 +122,7 @@ func (pm *poolManager) getPool(ctx context.Context, name string) (*pool, error)
 			scheme:          pm.scheme,
 			maxBlocks:       poolMaxBlocks.WithLabelValues(name),
 			allocatedBlocks: poolAllocated.WithLabelValues(name),
+			quarantine:      make(map[uint]time.Time),
 		}
 		err := p.SyncBlocks(ctx)
 		if err != nil {
@@ -126,6 +141,7 @@ func (pm *poolManager) DropPool(name string) {
 	delete(pm.pools, name)
 	poolMaxBlocks.DeleteLabelValues(name)
 	poolAllocated.DeleteLabelValues(name)
+	poolConflicts.DeleteLabelValues(name)
 }
 
 func (pm *poolManager) SyncPool(ctx context.Context, name string) error {
@@ -164,6 +180,10 @@ type pool struct {
 
 	mu        sync.Mutex
 	allocated bitset.BitSet
+
+	// quarantine holds indexes of released blocks that must not be
+	// allocated until the time.
+	quarantine map[uint]time.Time
 }
 
 // SyncBlocks synchronizes allocated field with the current AddressBlocks.
@@ -192,7 +212,6 @@ func (p *pool) SyncBlocks(ctx context.Context) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.allocated.ClearAll()
 	blocks := &coilv2.AddressBlockList{}
 	err = p.reader.List(ctx, blocks, client.MatchingLabels{
 		constants.LabelPool: p.name,
@@ -201,28 +220,63 @@ func (p *pool) SyncBlocks(ctx context.Context) error {
 		return err
 	}
 
+	prev := p.allocated.Clone()
+	p.allocated.ClearAll()
 	var allocatedBlocks int
 	for _, b := range blocks.Items {
 		p.allocated.Set(uint(b.Index))
 		allocatedBlocks += 1
 	}
+
+	// blocks released since the last sync are put in quarantine.
+	// coild records the blocks it releases in the AddressPool, and this
+	// records the others so that the quarantine survives restarts.
+	now := time.Now()
+	p.loadQuarantine(ap, now)
+	if d := ap.Spec.GetQuarantinePeriod(); d > 0 {
+		until := now.Add(d)
+		var released []uint
+		for idx, ok := prev.NextSet(0); ok; idx, ok = prev.NextSet(idx + 1) {
+			if p.allocated.Test(idx) {
+				continue
+			}
+			if _, ok := p.quarantine[idx]; ok {
+				continue
+			}
+			p.quarantine[idx] = until
+			released = append(released, idx)
+		}
+		if len(released) > 0 {
+			if err := persistBlockQuarantine(ctx, p.reader, p.client, p.name, released, until); err != nil {
+				p.log.Error(err, "failed to record quarantined blocks", "indexes", released)
+				return err
+			}
+		}
+	}
 	p.allocatedBlocks.Set(float64(allocatedBlocks))
 
 	p.log.Info("resynced block usage", "blocks", len(blocks.Items))
 	return nil
 }
 
+// loadQuarantine merges the quarantine recorded in the AddressPool and
+// forgets expired entries.
+// The caller must hold p.mu.
+func (p *pool) loadQuarantine(ap *coilv2.AddressPool, now time.Time) {
+	for idx, until := range p.quarantine {
+		if !until.After(now) {
+			delete(p.quarantine, idx)
+		}
+	}
+	mergeQuarantine(p.quarantine, decodeQuarantine(ap.Annotations[constants.AnnQuarantinedBlocks], now))
+}
+
 // AllocateBlock creates an AddressBlock and returns it.
 // If the pool runs out of the free blocks, this returns ErrNoBlock.
 func (p *pool) AllocateBlock(ctx context.Context, nodeName, requestUID string) (*coilv2.AddressBlock, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	nextIndex, ok := p.allocated.NextClear(0)
-	if !ok {
-		nextIndex = p.allocated.Len()
-	}
-
 	ap := &coilv2.AddressPool{}
 	err := p.client.Get(ctx, client.ObjectKey{Name: p.name}, ap)
 	if err != nil {
@@ -233,7 +287,29 @@ func (p *pool) AllocateBlock(ctx context.Context, nodeName, requestUID string) (
 		p.log.Info("unable to carve out a block because pool is under deletion")
 		return nil, ErrNoBlock
 	}
+	p.loadQuarantine(ap, time.Now())
+
+	// The allocated field is just a hint as other controllers may allocate blocks.
+	// The name of a block is determined by its index, so the creation fails
+	// if the block has been allocated by others.
+	for {
+		nextIndex := p.nextIndex(time.Now())
+		r, err := p.createBlock(ctx, ap, nextIndex, nodeName, requestUID)
+		if !apierrors.IsAlreadyExists(err) {
+			return r, err
+		}
+
+		p.log.Info("block has already been allocated", "index", nextIndex)
+		p.allocated.Set(nextIndex)
+		p.allocatedBlocks.Inc()
+		poolConflicts.WithLabelValues(p.name).Inc()
+	}
+}
 
+// createBlock creates the AddressBlock of the given index.
+// If the index is out of the pool, this returns ErrNoBlock.
+// The caller must hold p.mu.
+func (p *pool) createBlock(ctx context.Context, ap *coilv2.AddressPool, nextIndex uint, nodeName, requestUID string) (*coilv2.AddressBlock, error) {
 	var currentIndex uint
 	for _, ss := range ap.Spec.Subnets {
 		var ones, bits int
@@ -261,6 +337,9 @@ func (p *pool) AllocateBlock(ctx context.Context, nodeName, requestUID string) (
 			constants.LabelPool:    p.name,
 			constants.LabelNode:    nodeName,
 			constants.LabelRequest: requestUID,
+			// the block is given to the node only if the request is updated
+			// with the block.  See BlockRequestReconciler.
+			constants.LabelProvisional: "true",
 		}
 		controllerutil.AddFinalizer(r, constants.FinCoil)
 		r.Index = int32(nextIndex)
@@ -273,7 +352,9 @@ func (p *pool) AllocateBlock(ctx context.Context, nodeName, requestUID string) (
 			r.IPv6 = &s
 		}
 		if err := p.client.Create(ctx, r); err != nil {
-			p.log.Error(err, "failed to create AddressBlock", "index", nextIndex, "node", nodeName)
+			if !apierrors.IsAlreadyExists(err) {
+				p.log.Error(err, "failed to create AddressBlock", "index", nextIndex, "node", nodeName)
+			}
 			return nil, err
 		}
 
@@ -287,6 +368,27 @@ func (p *pool) AllocateBlock(ctx context.Context, nodeName, requestUID string) (
 	return nil, ErrNoBlock
 }
 
+// nextIndex returns the lowest index of blocks that is neither allocated nor in quarantine.
+// The caller must hold p.mu.
+func (p *pool) nextIndex(now time.Time) uint {
+	var from uint
+	for {
+		idx, ok := p.allocated.NextClear(from)
+		if !ok {
+			idx = max(from, p.allocated.Len())
+		}
+		until, ok := p.quarantine[idx]
+		if !ok {
+			return idx
+		}
+		if !until.After(now) {
+			delete(p.quarantine, idx)
+			return idx
+		}
+		from = idx + 1
+	}
+}
+
 // IsUsed returns true if the pool is used by some AddressBlock.
 func (p *pool) IsUsed(ctx context.Context) (bool, error) {
 	blocks := &coilv2.AddressBlockList{}
@@ -3,10 +3,12 @@ package ipam
 import (
 	"context"
 	"fmt"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -101,6 +103,27 @@ var _ = Describe("PoolManager", func() {
 		})
 	})
 
+	Context("multiple controllers", func() {
+		It("should skip blocks allocated by another controller", func() {
+			pm := NewPoolManager(mgr.GetClient(), mgr.GetAPIReader(), ctrl.Log.WithName("PoolManager"), scheme)
+			err := pm.SyncPool(ctx, "default")
+			Expect(err).ToNot(HaveOccurred())
+
+			By("allocating a block by another controller")
+			other := NewPoolManager(mgr.GetClient(), mgr.GetAPIReader(), ctrl.Log.WithName("PoolManager2"), scheme)
+			block, err := other.AllocateBlock(ctx, "default", "node2", "8e5c0e8b-4a8c-4bd4-8c36-bd1e8a4c3ef3")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(block.Index).To(Equal(int32(0)))
+
+			block, err = pm.AllocateBlock(ctx, "default", "node1", "5a6d130a-adbe-46f9-9da9-bc5da7cc5f04")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(block.Index).To(Equal(int32(1)))
+			Expect(block.Labels[constants.LabelNode]).To(Equal("node1"))
+			Expect(promtest.ToFloat64(poolConflicts.WithLabelValues("default"))).To(Equal(float64(1)))
+			Expect(promtest.ToFloat64(poolAllocated.WithLabelValues("default"))).To(Equal(float64(2)))
+		})
+	})
+
 	Context("IPv4 pool", func() {
 		It("should allocate blocks", func() {
 			pm := NewPoolManager(mgr.GetClient(), mgr.GetAPIReader(), ctrl.Log.WithName("PoolManager"), scheme)
@@ -146,5 +169,70 @@ var _ = Describe("PoolManager", func() {
 			Expect(block.Labels[constants.LabelNode]).To(Equal("node2"))
 			Expect(block.Labels[constants.LabelPool]).To(Equal("v4"))
 		})
+
+		It("should not reallocate blocks in quarantine", func() {
+			ap := &coilv2.AddressPool{}
+			err := k8sClient.Get(ctx, client.ObjectKey{Name: "v4"}, ap)
+			Expect(err).ToNot(HaveOccurred())
+			ap.Spec.QuarantinePeriod = &metav1.Duration{Duration: time.Hour}
+			err = k8sClient.Update(ctx, ap)
+			Expect(err).ToNot(HaveOccurred())
+			defer func() {
+				ap := &coilv2.AddressPool{}
+				err := k8sClient.Get(ctx, client.ObjectKey{Name: "v4"}, ap)
+				Expect(err).ToNot(HaveOccurred())
+				ap.Spec.QuarantinePeriod = nil
+				delete(ap.Annotations, constants.AnnQuarantinedBlocks)
+				err = k8sClient.Update(ctx, ap)
+				Expect(err).ToNot(HaveOccurred())
+			}()
+
+			pm := NewPoolManager(mgr.GetClient(), mgr.GetAPIReader(), ctrl.Log.WithName("PoolManager"), scheme)
+			Eventually(func() *metav1.Duration {
+				ap := &coilv2.AddressPool{}
+				if err := mgr.GetClient().Get(ctx, client.ObjectKey{Name: "v4"}, ap); err != nil {
+					return nil
+				}
+				return ap.Spec.QuarantinePeriod
+			}).ShouldNot(BeNil())
+
+			block, err := pm.AllocateBlock(ctx, "v4", "node1", "5a6d130a-adbe-46f9-9da9-bc5da7cc5f04")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(block.Index).To(Equal(int32(0)))
+
+			controllerutil.RemoveFinalizer(block, constants.FinCoil)
+			err = k8sClient.Update(ctx, block)
+			Expect(err).ToNot(HaveOccurred())
+			err = k8sClient.Delete(ctx, block)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(func() error {
+				if err := pm.SyncPool(ctx, "v4"); err != nil {
+					return err
+				}
+				if val := int(promtest.ToFloat64(poolAllocated.WithLabelValues("v4"))); val != 0 {
+					return fmt.Errorf("unexpected allocated_blocks value: %d", val)
+				}
+				return nil
+			}, 1, 0.1).Should(Succeed())
+
+			By("checking the quarantine is recorded in the AddressPool")
+			Eventually(func() string {
+				ap := &coilv2.AddressPool{}
+				if err := mgr.GetClient().Get(ctx, client.ObjectKey{Name: "v4"}, ap); err != nil {
+					return ""
+				}
+				return ap.Annotations[constants.AnnQuarantinedBlocks]
+			}).ShouldNot(BeEmpty())
+
+			// a new manager, as after a restart, restores the quarantine.
+			pm = NewPoolManager(mgr.GetClient(), mgr.GetAPIReader(), ctrl.Log.WithName("PoolManager"), scheme)
+			block, err = pm.AllocateBlock(ctx, "v4", "node2", "5a6d130a-adbe-46f9-9da9-bc5da7cc5f04")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(block.Index).To(Equal(int32(1)))
+
+			_, err = pm.AllocateBlock(ctx, "v4", "node2", "5a6d130a-adbe-46f9-9da9-bc5da7cc5f04")
+			Expect(err).To(MatchError(ErrNoBlock))
+		})
 	})
 })
@@ -0,0 +1,116 @@
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+// Quarantines are persisted in annotations so that they survive restarts
+// of coild and coil-ipam-controller.  The value of such an annotation is
+// a JSON object that maps indexes to the time until which they are quarantined.
+
+// decodeQuarantine parses the value of a quarantine annotation.
+// Entries expired at now are dropped.  A malformed value is treated as empty.
+func decodeQuarantine(s string, now time.Time) map[uint]time.Time {
+	q := make(map[uint]time.Time)
+	if s == "" {
+		return q
+	}
+	if err := json.Unmarshal([]byte(s), &q); err != nil {
+		return make(map[uint]time.Time)
+	}
+	for idx, until := range q {
+		if !until.After(now) {
+			delete(q, idx)
+		}
+	}
+	return q
+}
+
+// encodeQuarantine returns the value of a quarantine annotation for q.
+// Entries expired at now are dropped.  If no entries remain, this returns "".
+func encodeQuarantine(q map[uint]time.Time, now time.Time) string {
+	active := make(map[uint]time.Time)
+	for idx, until := range q {
+		if until.After(now) {
+			active[idx] = until.UTC()
+		}
+	}
+	if len(active) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(active)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// mergeQuarantine copies the entries of src into dst keeping the later time.
+func mergeQuarantine(dst, src map[uint]time.Time) {
+	for idx, until := range src {
+		if dst[idx].Before(until) {
+			dst[idx] = until
+		}
+	}
+}
+
+// persistBlockQuarantine records in the AddressPool that blocks of the given
+// indexes must not be allocated until the time.
+func persistBlockQuarantine(ctx context.Context, reader client.Reader, c client.Client, poolName string, indexes []uint, until time.Time) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		ap := &coilv2.AddressPool{}
+		if err := reader.Get(ctx, client.ObjectKey{Name: poolName}, ap); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+
+		now := time.Now()
+		q := decodeQuarantine(ap.Annotations[constants.AnnQuarantinedBlocks], now)
+		for _, idx := range indexes {
+			mergeQuarantine(q, map[uint]time.Time{idx: until})
+		}
+		s := encodeQuarantine(q, now)
+		if s == "" && ap.Annotations[constants.AnnQuarantinedBlocks] == "" {
+			return nil
+		}
+		if ap.Annotations == nil {
+			ap.Annotations = make(map[string]string)
+		}
+		if s == "" {
+			delete(ap.Annotations, constants.AnnQuarantinedBlocks)
+		} else {
+			ap.Annotations[constants.AnnQuarantinedBlocks] = s
+		}
+		return c.Update(ctx, ap)
+	})
+}
+
+// persistAddressQuarantine records the quarantine of addresses in the AddressBlock.
+func persistAddressQuarantine(ctx context.Context, c client.Client, blockName string, q map[uint]time.Time) error {
+	var value *string
+	if s := encodeQuarantine(q, time.Now()); s != "" {
+		value = &s
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]*string{
+				constants.AnnQuarantinedAddresses: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	b := &coilv2.AddressBlock{}
+	b.Name = blockName
+	return client.IgnoreNotFound(c.Patch(ctx, b, client.RawPatch(types.MergePatchType, patch)))
+}
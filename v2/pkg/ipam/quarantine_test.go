@@ -0,0 +1,49 @@
+package ipam
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuarantineAnnotation(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	q := map[uint]time.Time{
+		1: now.Add(time.Hour),
+		2: now.Add(-time.Second),
+		5: now.Add(time.Minute),
+	}
+
+	s := encodeQuarantine(q, now)
+	decoded := decodeQuarantine(s, now)
+	if len(decoded) != 2 {
+		t.Fatalf("expired entries should be dropped: %s", s)
+	}
+	for _, idx := range []uint{1, 5} {
+		if !decoded[idx].Equal(q[idx]) {
+			t.Errorf("unexpected time for %d: %v", idx, decoded[idx])
+		}
+	}
+
+	if len(decodeQuarantine(s, now.Add(2*time.Hour))) != 0 {
+		t.Error("all entries should expire")
+	}
+	if encodeQuarantine(q, now.Add(2*time.Hour)) != "" {
+		t.Error("empty quarantine should be encoded as an empty string")
+	}
+	if len(decodeQuarantine("broken", now)) != 0 {
+		t.Error("malformed value should be treated as empty")
+	}
+
+	mergeQuarantine(decoded, map[uint]time.Time{
+		1: now,
+		3: now.Add(time.Hour),
+	})
+	if !decoded[1].Equal(q[1]) {
+		t.Error("merge should keep the later time")
+	}
+	if _, ok := decoded[3]; !ok {
+		t.Error("merge should add new entries")
+	}
+}
@@ -57,6 +57,8 @@ func cleanBlocks() {
 	}
 	err = k8sClient.DeleteAllOf(ctx, &coilv2.AddressBlock{})
 	Expect(err).ToNot(HaveOccurred())
+	err = k8sClient.DeleteAllOf(ctx, &coilv2.BlockRequest{})
+	Expect(err).ToNot(HaveOccurred())
 	time.Sleep(10 * time.Millisecond)
 }
 
@@ -169,7 +169,7 @@ func (c *egressCollector) getNfTablesNATCounter(protocol string) (uint64, uint64
 	for _, rule := range rules {
 		for _, e := range rule.Exprs {
 			if counter, ok := e.(*expr.Counter); ok {
-				// A rule in the egress pod must be only one, so we can return by finding a first one.
+				// The first counter in the chain counts all packets NATed by the egress pod.
 				return counter.Packets, counter.Bytes, nil
 			}
 		}
@@ -195,7 +195,7 @@ func (c *egressCollector) getNfTablesInvalidCounter(protocol string) (uint64, ui
 	for _, rule := range rules {
 		for _, e := range rule.Exprs {
 			if counter, ok := e.(*expr.Counter); ok {
-				// A rule in the egress pod must be only one, so we can return by finding a first one.
+				// The first counter in the chain counts all packets NATed by the egress pod.
 				return counter.Packets, counter.Bytes, nil
 			}
 		}
@@ -28,3 +28,31 @@ type Client interface {
 	// removed. Init must have been called beforehand.
 	SyncNat(link netlink.Link, subnets []*net.IPNet, originatingOnly bool) error
 }
+
+// NodeClient is the interface for a NAT client that routes egress traffic
+// of processes in the host network namespace through tunnel links.
+type NodeClient interface {
+	// Init prepares the packet marking and routing rules.  It is idempotent
+	// and clears any routes previously added by SyncNat.
+	Init() error
+
+	// SyncNat reconciles the egress routes on link to match subnets.
+	SyncNat(link netlink.Link, subnets []*net.IPNet) error
+
+	// Refresh updates the packet marking rules if the selected processes
+	// have changed, e.g. a cgroup is created again.
+	Refresh() error
+
+	// SetPeers replaces the addresses from which Foo-over-UDP packets are accepted.
+	SetPeers(peers []net.IP) error
+
+	// Clear removes everything configured by Init and SyncNat.
+	Clear() error
+}
+
+// Destinations is a set of destination networks of an egress NAT server
+// with the priority to resolve overlaps with the destinations of other servers.
+type Destinations struct {
+	Priority int32
+	Networks []*net.IPNet
+}
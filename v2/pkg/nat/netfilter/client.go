@@ -143,7 +143,7 @@ func (n *NatClient) SyncNat(link netlink.Link, subnets []*net.IPNet, originating
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
-	routesBySubnet, err := collectRoutesBySubnet(link.Attrs().Index)
+	routesBySubnet, err := collectRoutesBySubnet(link.Attrs().Index, ncNarrowTableID, ncWideTableID)
 	if err != nil {
 		return err
 	}
@@ -336,39 +336,94 @@ func (n *NatClient) addRoute(link netlink.Link, ipn *net.IPNet) error {
 		inCluster = n.v6InCluster
 	}
 
+	return addTunnelRoute(link, ipn, inCluster, ncNarrowTableID, ncWideTableID)
+}
+
+// addTunnelRoute adds a route to ipn via link into narrowTable if ipn is
+// in one of inCluster networks, or into wideTable otherwise.
+func addTunnelRoute(link netlink.Link, ipn *net.IPNet, inCluster []*net.IPNet, narrowTable, wideTable int) error {
 	// link up here to minimize the down time
 	// See https://github.com/cybozu-go/coil/issues/287.
 	if err := netlink.LinkSetUp(link); err != nil {
 		return fmt.Errorf("netlink: failed to link up %s: %w", link.Attrs().Name, err)
 	}
 
+	table := wideTable
 	for _, cipn := range inCluster {
-		if !cipn.Contains(ipn.IP) {
-			continue
-		}
-
-		if err := netlink.RouteAdd(&netlink.Route{
-			Table:     ncNarrowTableID,
-			Dst:       ipn,
-			LinkIndex: link.Attrs().Index,
-			Protocol:  ncProtocolID,
-		}); err != nil {
-			return fmt.Errorf("netlink: failed to add route(table %d) to %s: %w", ncNarrowTableID, ipn.String(), err)
+		if cipn.Contains(ipn.IP) {
+			table = narrowTable
+			break
 		}
-		return nil
 	}
 
-	if err := netlink.RouteAdd(&netlink.Route{
-		Table:     ncWideTableID,
+	// Replace the route instead of adding it because the destination may move
+	// from another link when the priorities of the servers change.
+	if err := netlink.RouteReplace(&netlink.Route{
+		Table:     table,
 		Dst:       ipn,
 		LinkIndex: link.Attrs().Index,
 		Protocol:  ncProtocolID,
 	}); err != nil {
-		return fmt.Errorf("netlink: failed to add route(table %d) to %s: %w", ncWideTableID, ipn.String(), err)
+		return fmt.Errorf("netlink: failed to add route(table %d) to %s: %w", table, ipn.String(), err)
 	}
 	return nil
 }
 
+// ResolveDestinations returns the networks of each element of dests excluding
+// those shadowed by the other elements, so that SyncNat routes the traffic
+// deterministically.  A network is shadowed if an element with a higher priority
+// has a network covering it, or if an earlier element with the same priority has
+// the same network.  The rest of overlaps are resolved by longest prefix match.
+//
+// Note that networks in the wide table do not cover in-cluster networks because
+// the rules for in-cluster networks take precedence over the wide table.
+func ResolveDestinations(dests []nat.Destinations) [][]*net.IPNet {
+	res := make([][]*net.IPNet, len(dests))
+	for i, d := range dests {
+	OUTER:
+		for _, ipn := range d.Networks {
+			for j, o := range dests {
+				if j == i || o.Priority < d.Priority || (o.Priority == d.Priority && j > i) {
+					continue
+				}
+				for _, oipn := range o.Networks {
+					if !coversNetwork(oipn, ipn) {
+						continue
+					}
+					if o.Priority > d.Priority || oipn.String() == ipn.String() {
+						continue OUTER
+					}
+				}
+			}
+			res[i] = append(res[i], ipn)
+		}
+	}
+	return res
+}
+
+// coversNetwork returns true if all the addresses in inner are routed by the route to outer.
+func coversNetwork(outer, inner *net.IPNet) bool {
+	oones, obits := outer.Mask.Size()
+	iones, ibits := inner.Mask.Size()
+	if obits != ibits || oones > iones || !outer.Contains(inner.IP) {
+		return false
+	}
+	return isInCluster(outer) || !isInCluster(inner)
+}
+
+func isInCluster(ipn *net.IPNet) bool {
+	inCluster := v4PrivateList
+	if ipn.IP.To4() == nil {
+		inCluster = v6PrivateList
+	}
+	for _, cipn := range inCluster {
+		if cipn.Contains(ipn.IP) {
+			return true
+		}
+	}
+	return false
+}
+
 func (n *NatClient) deleteRoute(r *netlink.Route) error {
 	if n.logFunc != nil {
 		n.logFunc(fmt.Sprintf("removing a destination %s", r.Dst.String()))
@@ -380,10 +435,10 @@ func (n *NatClient) deleteRoute(r *netlink.Route) error {
 	return nil
 }
 
-func collectRoutesBySubnet(linkIndex int) (map[string]netlink.Route, error) {
+func collectRoutesBySubnet(linkIndex int, tables ...int) (map[string]netlink.Route, error) {
 	routes := make(map[string]netlink.Route)
 	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
-		for _, table := range []int{ncNarrowTableID, ncWideTableID} {
+		for _, table := range tables {
 			res, err := collectRoutesFromTable(family, table, linkIndex)
 			if err != nil {
 				return nil, fmt.Errorf("failed to collect route %d %d %d: %w", family, table, linkIndex, err)
@@ -21,6 +21,7 @@ import (
 	"github.com/vishvananda/netlink"
 
 	"github.com/cybozu-go/coil/v2/pkg/constants"
+	"github.com/cybozu-go/coil/v2/pkg/nat"
 )
 
 const (
@@ -997,3 +998,80 @@ func newDummyDevice(name string) (netlink.Link, error) {
 
 	return link, nil
 }
+
+func TestResolveDestinations(t *testing.T) {
+	parse := func(cidrs ...string) []*net.IPNet {
+		var res []*net.IPNet
+		for _, c := range cidrs {
+			_, ipn, err := net.ParseCIDR(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res = append(res, ipn)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name  string
+		dests []nat.Destinations
+		want  [][]*net.IPNet
+	}{
+		{
+			name: "no overlap",
+			dests: []nat.Destinations{
+				{Networks: parse("192.0.2.0/24", "fd01::/64")},
+				{Networks: parse("198.51.100.0/24")},
+			},
+			want: [][]*net.IPNet{parse("192.0.2.0/24", "fd01::/64"), parse("198.51.100.0/24")},
+		},
+		{
+			name: "same priority",
+			dests: []nat.Destinations{
+				{Networks: parse("0.0.0.0/0", "192.0.2.0/24")},
+				{Networks: parse("192.0.2.0/24", "192.0.2.0/25")},
+			},
+			want: [][]*net.IPNet{parse("0.0.0.0/0", "192.0.2.0/24"), parse("192.0.2.0/25")},
+		},
+		{
+			name: "higher priority covers lower",
+			dests: []nat.Destinations{
+				{Networks: parse("192.0.2.0/25", "198.51.100.0/24")},
+				{Priority: 10, Networks: parse("192.0.2.0/24")},
+			},
+			want: [][]*net.IPNet{parse("198.51.100.0/24"), parse("192.0.2.0/24")},
+		},
+		{
+			name: "higher priority is more specific",
+			dests: []nat.Destinations{
+				{Networks: parse("0.0.0.0/0")},
+				{Priority: 10, Networks: parse("192.0.2.0/24")},
+			},
+			want: [][]*net.IPNet{parse("0.0.0.0/0"), parse("192.0.2.0/24")},
+		},
+		{
+			name: "wide networks do not cover in-cluster networks",
+			dests: []nat.Destinations{
+				{Networks: parse("10.1.0.0/16", "fd02::/64")},
+				{Priority: 10, Networks: parse("0.0.0.0/0", "::/0")},
+			},
+			want: [][]*net.IPNet{parse("10.1.0.0/16", "fd02::/64"), parse("0.0.0.0/0", "::/0")},
+		},
+		{
+			name: "different families",
+			dests: []nat.Destinations{
+				{Networks: parse("::/0")},
+				{Priority: 10, Networks: parse("0.0.0.0/0")},
+			},
+			want: [][]*net.IPNet{parse("::/0"), parse("0.0.0.0/0")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ResolveDestinations(tt.dests)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveDestinations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
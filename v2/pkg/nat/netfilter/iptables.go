@@ -9,7 +9,10 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
-func setIPTablesMasqRules(family int, iface string, ip net.IP) error {
+// setIPTablesMasqRules sets up SNAT rules for packets going out from iface.
+// If src is nil, packets are masqueraded with the address of iface.
+// If ports is not nil, TCP and UDP packets SNATed to src get source ports in the range.
+func setIPTablesMasqRules(family int, iface string, ip, src net.IP, ports *PortRange) error {
 	ipn := netlink.NewIPNet(ip)
 	ipp, err := netlinkToIptablesFamily(family)
 	if err != nil {
@@ -20,7 +23,32 @@ func setIPTablesMasqRules(family int, iface string, ip net.IP) error {
 		return err
 	}
 
+	if src != nil && ports != nil {
+		// The metrics collector reads the first counter in the chain,
+		// so count all packets before splitting them by protocol.
+		spec := []string{"!", "-s", ipn.String(), "-o", iface}
+		if err := ipt.AppendUnique(natTable, natChain, spec...); err != nil {
+			return fmt.Errorf("failed to setup counter rule: %w", err)
+		}
+
+		// port ranges can be specified only with protocols having ports.
+		// ex. iptables -t nat -A POSTROUTING ! -s 10.0.0.1/32 -o eth0 -p tcp -j SNAT --to-source 192.0.2.1:1024-5055
+		toSource := fmt.Sprintf("%s:%d-%d", src.String(), ports.Min, ports.Max)
+		if src.To4() == nil {
+			toSource = fmt.Sprintf("[%s]:%d-%d", src.String(), ports.Min, ports.Max)
+		}
+		for _, proto := range []string{"tcp", "udp"} {
+			spec := []string{"!", "-s", ipn.String(), "-o", iface, "-p", proto, "-j", "SNAT", "--to-source", toSource}
+			if err := ipt.AppendUnique(natTable, natChain, spec...); err != nil {
+				return fmt.Errorf("failed to setup SNAT rule for %s: %w", proto, err)
+			}
+		}
+	}
+
 	spec := []string{"!", "-s", ipn.String(), "-o", iface, "-j", "MASQUERADE"}
+	if src != nil {
+		spec = []string{"!", "-s", ipn.String(), "-o", iface, "-j", "SNAT", "--to-source", src.String()}
+	}
 	if err := ipt.AppendUnique(natTable, natChain, spec...); err != nil {
 		return fmt.Errorf("failed to setup masquerade rule: %w", err)
 	}
@@ -33,6 +61,27 @@ func setIPTablesMasqRules(family int, iface string, ip net.IP) error {
 	return nil
 }
 
+// setIPTablesTransitRules sets up SNAT rules for packets forwarded to other
+// egress NAT servers through the tunnels whose names start with linkPrefix.
+// The packets must have ip as the source address because the other servers
+// only know this server as their client.
+func setIPTablesTransitRules(family int, linkPrefix string, ip net.IP) error {
+	ipp, err := netlinkToIptablesFamily(family)
+	if err != nil {
+		return err
+	}
+	ipt, err := iptables.NewWithProtocol(ipp)
+	if err != nil {
+		return err
+	}
+
+	spec := []string{"!", "-s", netlink.NewIPNet(ip).String(), "-o", linkPrefix + "+", "-j", "SNAT", "--to-source", ip.String()}
+	if err := ipt.AppendUnique(natTable, natChain, spec...); err != nil {
+		return fmt.Errorf("failed to setup SNAT rule for transit traffic: %w", err)
+	}
+	return nil
+}
+
 func setIPTablesConnmarkRules(family int, link netlink.Link) error {
 	ipp, err := netlinkToIptablesFamily(family)
 	if err != nil {
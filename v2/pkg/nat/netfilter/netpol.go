@@ -0,0 +1,408 @@
+package netfilter
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	netpolTable        = "coil-netpol"
+	netpolForwardChain = "forward"
+	netpolEgressChain  = "egress"
+	netpolIngressChain = "ingress"
+
+	ipv4DstOffset = 16
+	ipv6DstOffset = 24
+
+	// offset of the destination port in TCP, UDP, and SCTP headers
+	l4DstPortOffset = 2
+	l4DstPortLen    = 2
+)
+
+// PolicyMatch is a condition of traffic allowed by NetworkPolicies.
+// Zero-valued fields match anything.
+type PolicyMatch struct {
+	// Peer is the network of the remote end of the traffic.
+	Peer *net.IPNet
+
+	// Except is a list of networks excluded from Peer.
+	Except []*net.IPNet
+
+	// Protocol is an IP protocol number such as unix.IPPROTO_TCP.
+	Protocol uint8
+
+	// Port is the destination port.  If EndPort is non-zero,
+	// ports in the range [Port, EndPort] match.
+	Port    uint16
+	EndPort uint16
+}
+
+// PodPolicy represents the traffic allowed for a Pod on the node.
+type PodPolicy struct {
+	// Iface is the name of the host-side veth of the Pod.
+	Iface string
+
+	// IngressIsolated is true if the Pod is selected by any NetworkPolicy
+	// for ingress.  Then, only the traffic matching Ingress is allowed.
+	IngressIsolated bool
+	Ingress         []PolicyMatch
+
+	// EgressIsolated is true if the Pod is selected by any NetworkPolicy
+	// for egress.  Then, only the traffic matching Egress is allowed.
+	EgressIsolated bool
+	Egress         []PolicyMatch
+}
+
+// NetworkPolicyRules keeps nftables rules to enforce NetworkPolicies.
+//
+// The rules are kept in a dedicated table of the inet family.  Packets
+// forwarded from/to the host-side veth of an isolated Pod jump to a chain
+// for the Pod that returns if the packet matches any of the allowed traffic,
+// or drops it otherwise.
+//
+// The first Sync rebuilds the whole table.  Subsequent calls rewrite only
+// the chains of Pods whose policies have changed.  Either way, the update is
+// done in a single transaction so that Pods are never left unprotected.
+type NetworkPolicyRules struct {
+	mu sync.Mutex
+
+	// chains are the chains for Pods applied last time.
+	// nil means the table has to be rebuilt.
+	chains map[string]podChain
+}
+
+type podChain struct {
+	ifaceKey expr.MetaKey
+	iface    string
+	matches  []PolicyMatch
+}
+
+func (c podChain) ingress() bool {
+	return c.ifaceKey == expr.MetaKeyOIFNAME
+}
+
+func (c podChain) equal(other podChain) bool {
+	return c.ifaceKey == other.ifaceKey && c.iface == other.iface && reflect.DeepEqual(c.matches, other.matches)
+}
+
+// Sync updates the rules to enforce policies.
+func (r *NetworkPolicyRules) Sync(policies []PodPolicy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	desired := make(map[string]podChain)
+	for _, p := range policies {
+		if p.EgressIsolated {
+			desired[netpolEgressChain+"-"+p.Iface] = podChain{ifaceKey: expr.MetaKeyIIFNAME, iface: p.Iface, matches: p.Egress}
+		}
+		if p.IngressIsolated {
+			desired[netpolIngressChain+"-"+p.Iface] = podChain{ifaceKey: expr.MetaKeyOIFNAME, iface: p.Iface, matches: p.Ingress}
+		}
+	}
+	if r.chains != nil && maps.EqualFunc(r.chains, desired, podChain.equal) {
+		return nil
+	}
+
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to create nftables connection: %w", err)
+	}
+
+	t := &nftables.Table{Family: nftables.TableFamilyINet, Name: netpolTable}
+	egress := &nftables.Chain{Name: netpolEgressChain, Table: t}
+	ingress := &nftables.Chain{Name: netpolIngressChain, Table: t}
+	if r.chains == nil {
+		// Adding the table before deleting it makes the deletion succeed
+		// even if the table does not exist yet.
+		conn.AddTable(t)
+		conn.DelTable(t)
+		t = conn.AddTable(t)
+		egress = conn.AddChain(egress)
+		ingress = conn.AddChain(ingress)
+		addNetpolForwardChain(conn, t)
+	} else {
+		// The jumps to the chains for Pods are added again below.
+		conn.FlushChain(egress)
+		conn.FlushChain(ingress)
+	}
+
+	names := slices.Sorted(maps.Keys(desired))
+	for _, name := range names {
+		pc := desired[name]
+		dispatch := egress
+		if pc.ingress() {
+			dispatch = ingress
+		}
+
+		c := &nftables.Chain{Name: name, Table: t}
+		old, ok := r.chains[name]
+		switch {
+		case ok && old.equal(pc):
+			// keep the chain as is
+		case ok:
+			conn.FlushChain(c)
+			addPodPolicyRules(conn, c, pc)
+		default:
+			c = conn.AddChain(c)
+			addPodPolicyRules(conn, c, pc)
+		}
+
+		// ex. nft add rule inet coil-netpol egress iifname "veth0" jump egress-veth0
+		conn.AddRule(&nftables.Rule{
+			Table: t,
+			Chain: dispatch,
+			Exprs: []expr.Any{
+				&expr.Meta{
+					Key:      pc.ifaceKey,
+					Register: nftRegister,
+				},
+				&expr.Cmp{
+					Op:       expr.CmpOpEq,
+					Register: nftRegister,
+					Data:     ifname(pc.iface),
+				},
+				&expr.Verdict{
+					Kind:  expr.VerdictJump,
+					Chain: name,
+				},
+			},
+		})
+	}
+
+	for name := range r.chains {
+		if _, ok := desired[name]; !ok {
+			conn.DelChain(&nftables.Chain{Name: name, Table: t})
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		// The kernel may have a different state than r.chains.
+		r.chains = nil
+		return fmt.Errorf("failed to flush nftables rules: %w", err)
+	}
+	r.chains = desired
+	return nil
+}
+
+// Clear removes all nftables rules for NetworkPolicies.
+func (r *NetworkPolicyRules) Clear() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to create nftables connection: %w", err)
+	}
+
+	t := &nftables.Table{Family: nftables.TableFamilyINet, Name: netpolTable}
+	conn.AddTable(t)
+	conn.DelTable(t)
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables rules: %w", err)
+	}
+	r.chains = nil
+	return nil
+}
+
+func addNetpolForwardChain(conn *nftables.Conn, t *nftables.Table) {
+	forward := conn.AddChain(&nftables.Chain{
+		Name:     netpolForwardChain,
+		Table:    t,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookForward,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   func() *nftables.ChainPolicy { p := nftables.ChainPolicyAccept; return &p }(),
+	})
+
+	// ex. nft add rule inet coil-netpol forward ct state established,related accept
+	conn.AddRule(&nftables.Rule{
+		Table: t,
+		Chain: forward,
+		Exprs: []expr.Any{
+			&expr.Ct{
+				Register: nftRegister,
+				Key:      expr.CtKeySTATE,
+			},
+			&expr.Bitwise{
+				SourceRegister: nftRegister,
+				DestRegister:   nftRegister,
+				Len:            4,
+				Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitESTABLISHED | expr.CtStateBitRELATED),
+				Xor:            binaryutil.NativeEndian.PutUint32(0),
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpNeq,
+				Register: nftRegister,
+				Data:     binaryutil.NativeEndian.PutUint32(0),
+			},
+			&expr.Verdict{
+				Kind: expr.VerdictAccept,
+			},
+		},
+	})
+
+	// Egress chains must be evaluated before ingress chains because
+	// traffic between Pods on the same node is subject to both.
+	for _, name := range []string{netpolEgressChain, netpolIngressChain} {
+		conn.AddRule(&nftables.Rule{
+			Table: t,
+			Chain: forward,
+			Exprs: []expr.Any{
+				&expr.Verdict{
+					Kind:  expr.VerdictJump,
+					Chain: name,
+				},
+			},
+		})
+	}
+}
+
+func addPodPolicyRules(conn *nftables.Conn, c *nftables.Chain, pc podChain) {
+	for _, m := range pc.matches {
+		exprs := policyMatchExprs(m, pc.ingress())
+		exprs = append(exprs, &expr.Counter{}, &expr.Verdict{Kind: expr.VerdictReturn})
+		conn.AddRule(&nftables.Rule{
+			Table: c.Table,
+			Chain: c,
+			Exprs: exprs,
+		})
+	}
+
+	conn.AddRule(&nftables.Rule{
+		Table: c.Table,
+		Chain: c,
+		Exprs: []expr.Any{
+			&expr.Counter{},
+			&expr.Verdict{Kind: expr.VerdictDrop},
+		},
+	})
+}
+
+// policyMatchExprs returns expressions for m.  The peer is the source
+// of the packet for ingress, and the destination for egress.
+func policyMatchExprs(m PolicyMatch, ingress bool) []expr.Any {
+	var exprs []expr.Any
+
+	if m.Peer != nil {
+		nfproto := byte(unix.NFPROTO_IPV4)
+		offset := uint32(ipv4DstOffset)
+		if ingress {
+			offset = ipv4SrcOffset
+		}
+		length := uint32(ipv4SrcLen)
+		if m.Peer.IP.To4() == nil {
+			nfproto = unix.NFPROTO_IPV6
+			offset = ipv6DstOffset
+			if ingress {
+				offset = ipv6SrcOffset
+			}
+			length = ipv6SrcLen
+		}
+
+		// ex. meta nfproto ipv4 ip saddr 10.0.0.0/8 ip saddr != 10.1.0.0/16
+		exprs = append(exprs,
+			&expr.Meta{
+				Key:      expr.MetaKeyNFPROTO,
+				Register: nftRegister,
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: nftRegister,
+				Data:     []byte{nfproto},
+			},
+		)
+		exprs = append(exprs, networkExprs(m.Peer, offset, length, expr.CmpOpEq)...)
+		for _, n := range m.Except {
+			if (n.IP.To4() == nil) != (m.Peer.IP.To4() == nil) {
+				continue
+			}
+			exprs = append(exprs, networkExprs(n, offset, length, expr.CmpOpNeq)...)
+		}
+	}
+
+	if m.Protocol != 0 {
+		// ex. meta l4proto tcp
+		exprs = append(exprs,
+			&expr.Meta{
+				Key:      expr.MetaKeyL4PROTO,
+				Register: nftRegister,
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: nftRegister,
+				Data:     []byte{m.Protocol},
+			},
+		)
+	}
+
+	if m.Port != 0 {
+		// ex. th dport 8080-8090
+		exprs = append(exprs, &expr.Payload{
+			DestRegister: nftRegister,
+			Base:         expr.PayloadBaseTransportHeader,
+			Offset:       l4DstPortOffset,
+			Len:          l4DstPortLen,
+		})
+		if m.EndPort == 0 {
+			exprs = append(exprs, &expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: nftRegister,
+				Data:     binaryutil.BigEndian.PutUint16(m.Port),
+			})
+		} else {
+			exprs = append(exprs,
+				&expr.Cmp{
+					Op:       expr.CmpOpGte,
+					Register: nftRegister,
+					Data:     binaryutil.BigEndian.PutUint16(m.Port),
+				},
+				&expr.Cmp{
+					Op:       expr.CmpOpLte,
+					Register: nftRegister,
+					Data:     binaryutil.BigEndian.PutUint16(m.EndPort),
+				},
+			)
+		}
+	}
+
+	return exprs
+}
+
+func networkExprs(n *net.IPNet, offset, length uint32, op expr.CmpOp) []expr.Any {
+	ip := n.IP.Mask(n.Mask)
+	if length == ipv4SrcLen {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+
+	return []expr.Any{
+		&expr.Payload{
+			DestRegister: nftRegister,
+			Base:         expr.PayloadBaseNetworkHeader,
+			Offset:       offset,
+			Len:          length,
+		},
+		&expr.Bitwise{
+			SourceRegister: nftRegister,
+			DestRegister:   nftRegister,
+			Len:            length,
+			Mask:           net.IP(n.Mask).To16()[16-length:],
+			Xor:            make([]byte, length),
+		},
+		&expr.Cmp{
+			Op:       op,
+			Register: nftRegister,
+			Data:     ip,
+		},
+	}
+}
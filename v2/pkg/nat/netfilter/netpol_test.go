@@ -0,0 +1,181 @@
+//go:build privileged
+
+package netfilter
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+func TestNetworkPolicyRules(t *testing.T) {
+	_, peer4, _ := net.ParseCIDR("10.0.0.0/8")
+	_, except4, _ := net.ParseCIDR("10.1.0.0/16")
+	_, peer6, _ := net.ParseCIDR("fd02::/16")
+
+	policies := []PodPolicy{
+		{
+			Iface:           "veth1",
+			IngressIsolated: true,
+			Ingress: []PolicyMatch{
+				{Peer: peer4, Except: []*net.IPNet{except4}, Protocol: unix.IPPROTO_TCP, Port: 80},
+				{Peer: peer6, Protocol: unix.IPPROTO_UDP, Port: 8000, EndPort: 8080},
+			},
+			EgressIsolated: true,
+		},
+		{
+			Iface:          "veth2",
+			EgressIsolated: true,
+			Egress: []PolicyMatch{
+				{Protocol: unix.IPPROTO_UDP, Port: 53},
+			},
+		},
+		{
+			Iface: "veth3",
+		},
+	}
+
+	tns, err := testutils.NewNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tns.Close()
+
+	err = tns.Do(func(ns.NetNS) error {
+		rules := &NetworkPolicyRules{}
+		if err := rules.Sync(policies); err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := nftables.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		table := &nftables.Table{Family: nftables.TableFamilyINet, Name: netpolTable}
+
+		chains := listNetpolChains(t, conn)
+		expected := []string{"egress", "egress-veth1", "egress-veth2", "forward", "ingress", "ingress-veth1"}
+		if !slices.Equal(chains, expected) {
+			t.Errorf("expected chains %v, got %v", expected, chains)
+		}
+
+		counts := map[string]int{
+			// established + 2 jumps
+			"forward": 3,
+			// jumps to the chains for Pods
+			"egress":  2,
+			"ingress": 1,
+			// 2 matches + drop
+			"ingress-veth1": 3,
+			// drop only
+			"egress-veth1": 1,
+			// 1 match + drop
+			"egress-veth2": 2,
+		}
+		checkNetpolRules(t, conn, table, counts)
+		handles := netpolRuleHandles(t, conn, table, "egress-veth2")
+
+		// unchanged chains should be kept as is
+		policies[0].Ingress = policies[0].Ingress[:1]
+		if err := rules.Sync(policies); err != nil {
+			t.Fatal(err)
+		}
+		counts["ingress-veth1"] = 2
+		checkNetpolRules(t, conn, table, counts)
+		if !slices.Equal(handles, netpolRuleHandles(t, conn, table, "egress-veth2")) {
+			t.Error("unchanged chain egress-veth2 was rewritten")
+		}
+
+		// rules for Pods no longer isolated should be removed
+		if err := rules.Sync(policies[1:]); err != nil {
+			t.Fatal(err)
+		}
+		chains = listNetpolChains(t, conn)
+		expected = []string{"egress", "egress-veth2", "forward", "ingress"}
+		if !slices.Equal(chains, expected) {
+			t.Errorf("expected chains %v, got %v", expected, chains)
+		}
+		checkNetpolRules(t, conn, table, map[string]int{"forward": 3, "egress": 1, "ingress": 0, "egress-veth2": 2})
+
+		if err := rules.Clear(); err != nil {
+			t.Fatal(err)
+		}
+		tables, err := conn.ListTablesOfFamily(nftables.TableFamilyINet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tbl := range tables {
+			if tbl.Name == netpolTable {
+				t.Error("table should have been deleted")
+			}
+		}
+
+		// clearing twice should succeed
+		if err := rules.Clear(); err != nil {
+			t.Fatal(err)
+		}
+
+		// the table should be rebuilt after clearing
+		if err := rules.Sync(policies[1:]); err != nil {
+			t.Fatal(err)
+		}
+		chains = listNetpolChains(t, conn)
+		if !slices.Equal(chains, expected) {
+			t.Errorf("expected chains %v, got %v", expected, chains)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkNetpolRules(t *testing.T, conn *nftables.Conn, table *nftables.Table, counts map[string]int) {
+	t.Helper()
+
+	for name, count := range counts {
+		rules, err := conn.GetRules(table, &nftables.Chain{Name: name, Table: table})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != count {
+			t.Errorf("expected %d rules in %s, got %d", count, name, len(rules))
+		}
+	}
+}
+
+func netpolRuleHandles(t *testing.T, conn *nftables.Conn, table *nftables.Table, chain string) []uint64 {
+	t.Helper()
+
+	rules, err := conn.GetRules(table, &nftables.Chain{Name: chain, Table: table})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handles := make([]uint64, len(rules))
+	for i, r := range rules {
+		handles[i] = r.Handle
+	}
+	return handles
+}
+
+func listNetpolChains(t *testing.T, conn *nftables.Conn) []string {
+	t.Helper()
+
+	chains, err := conn.ListChainsOfTableFamily(nftables.TableFamilyINet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range chains {
+		if c.Table.Name == netpolTable {
+			names = append(names, c.Name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -10,10 +10,13 @@ import (
 	"github.com/google/nftables/binaryutil"
 	"github.com/google/nftables/expr"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 const (
-	nftRegister = 1
+	nftRegister        = 1
+	nftPortMinRegister = 2
+	nftPortMaxRegister = 3
 
 	ipv4SrcOffset = 12
 	ipv4SrcLen    = 4
@@ -25,7 +28,10 @@ const (
 	nftRuleIDOutputPrefix = "coil-output-"
 )
 
-func setNFTablesMasqRules(family int, iface string, ip net.IP) (err error) {
+// setNFTablesMasqRules sets up SNAT rules for packets going out from iface.
+// If src is nil, packets are masqueraded with the address of iface.
+// If ports is not nil, TCP and UDP packets SNATed to src get source ports in the range.
+func setNFTablesMasqRules(family int, iface string, ip, src net.IP, ports *PortRange) (err error) {
 	conn, err := nftables.New()
 	if err != nil {
 		return fmt.Errorf("failed to create nftables connection: %w", err)
@@ -70,11 +76,16 @@ func setNFTablesMasqRules(family int, iface string, ip net.IP) (err error) {
 		return fmt.Errorf("invalid table family %d", family)
 	}
 
-	// ex. nft add rule ip nat POSTROUTING ip saddr != 10.0.0.0/24 oifname "eth0" counter masquerade
-	masqRule := &nftables.Rule{
-		Table: t,
-		Chain: c,
-		Exprs: []expr.Any{
+	var srcData []byte
+	if src != nil {
+		srcData = src.To4()
+		if nf == nftables.TableFamilyIPv6 {
+			srcData = src.To16()
+		}
+	}
+
+	matchExprs := func() []expr.Any {
+		return []expr.Any{
 			&expr.Payload{
 				DestRegister: nftRegister,
 				Base:         expr.PayloadBaseNetworkHeader,
@@ -102,9 +113,73 @@ func setNFTablesMasqRules(family int, iface string, ip net.IP) (err error) {
 				Register: nftRegister,
 				Data:     []byte(iface + "\x00"),
 			},
-			&expr.Counter{},
-			&expr.Masq{},
-		},
+		}
+	}
+
+	if src != nil && ports != nil {
+		// The metrics collector reads the first counter in the chain,
+		// so count all packets before splitting them by protocol.
+		// ex. nft add rule ip nat POSTROUTING ip saddr != 10.0.0.0/24 oifname "eth0" counter
+		conn.AddRule(&nftables.Rule{Table: t, Chain: c, Exprs: append(matchExprs(), &expr.Counter{})})
+
+		// port ranges can be specified only with protocols having ports.
+		// ex. nft add rule ip nat POSTROUTING ip saddr != 10.0.0.0/24 oifname "eth0" meta l4proto tcp snat to 192.0.2.1:1024-5055
+		for _, proto := range []byte{unix.IPPROTO_TCP, unix.IPPROTO_UDP} {
+			exprs := append(matchExprs(),
+				&expr.Meta{
+					Key:      expr.MetaKeyL4PROTO,
+					Register: nftRegister,
+				},
+				&expr.Cmp{
+					Op:       expr.CmpOpEq,
+					Register: nftRegister,
+					Data:     []byte{proto},
+				},
+				&expr.Immediate{
+					Register: nftRegister,
+					Data:     srcData,
+				},
+				&expr.Immediate{
+					Register: nftPortMinRegister,
+					Data:     binaryutil.BigEndian.PutUint16(ports.Min),
+				},
+				&expr.Immediate{
+					Register: nftPortMaxRegister,
+					Data:     binaryutil.BigEndian.PutUint16(ports.Max),
+				},
+				&expr.NAT{
+					Type:        expr.NATTypeSourceNAT,
+					Family:      uint32(nf),
+					RegAddrMin:  nftRegister,
+					RegProtoMin: nftPortMinRegister,
+					RegProtoMax: nftPortMaxRegister,
+					Specified:   true,
+				},
+			)
+			conn.AddRule(&nftables.Rule{Table: t, Chain: c, Exprs: exprs})
+		}
+	}
+
+	// ex. nft add rule ip nat POSTROUTING ip saddr != 10.0.0.0/24 oifname "eth0" counter masquerade
+	natExprs := []expr.Any{&expr.Masq{}}
+	if src != nil {
+		// ex. nft add rule ip nat POSTROUTING ip saddr != 10.0.0.0/24 oifname "eth0" counter snat to 192.0.2.1
+		natExprs = []expr.Any{
+			&expr.Immediate{
+				Register: nftRegister,
+				Data:     srcData,
+			},
+			&expr.NAT{
+				Type:       expr.NATTypeSourceNAT,
+				Family:     uint32(nf),
+				RegAddrMin: nftRegister,
+			},
+		}
+	}
+	masqRule := &nftables.Rule{
+		Table: t,
+		Chain: c,
+		Exprs: append(append(matchExprs(), &expr.Counter{}), natExprs...),
 	}
 	conn.AddRule(masqRule)
 
@@ -166,6 +241,87 @@ func setNFTablesMasqRules(family int, iface string, ip net.IP) (err error) {
 	return nil
 }
 
+// setNFTablesTransitRules sets up SNAT rules for packets forwarded to other
+// egress NAT servers through the tunnels whose names start with linkPrefix.
+func setNFTablesTransitRules(family int, linkPrefix string, ip net.IP) error {
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to create nftables connection: %w", err)
+	}
+
+	nf, err := netlinkToNFTablesFamily(family)
+	if err != nil {
+		return err
+	}
+
+	var offset, length uint32
+	ipData := ip.To4()
+	switch nf {
+	case nftables.TableFamilyIPv4:
+		offset = ipv4SrcOffset
+		length = ipv4SrcLen
+	case nftables.TableFamilyIPv6:
+		offset = ipv6SrcOffset
+		length = ipv6SrcLen
+		ipData = ip.To16()
+	default:
+		return fmt.Errorf("invalid table family %d", family)
+	}
+
+	t := conn.AddTable(&nftables.Table{Family: nf, Name: natTable})
+	c := conn.AddChain(&nftables.Chain{
+		Name:     natChain,
+		Table:    t,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityNATSource,
+	})
+
+	// ex. nft add rule ip nat POSTROUTING ip saddr != 10.0.0.1 oifname "fou4_*" counter snat to 10.0.0.1
+	conn.AddRule(&nftables.Rule{
+		Table: t,
+		Chain: c,
+		Exprs: []expr.Any{
+			&expr.Payload{
+				DestRegister: nftRegister,
+				Base:         expr.PayloadBaseNetworkHeader,
+				Offset:       offset,
+				Len:          length,
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpNeq,
+				Register: nftRegister,
+				Data:     ipData,
+			},
+			&expr.Meta{
+				Key:      expr.MetaKeyOIFNAME,
+				Register: nftRegister,
+			},
+			// compare only the prefix without the terminating NUL
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: nftRegister,
+				Data:     []byte(linkPrefix),
+			},
+			&expr.Counter{},
+			&expr.Immediate{
+				Register: nftRegister,
+				Data:     ipData,
+			},
+			&expr.NAT{
+				Type:       expr.NATTypeSourceNAT,
+				Family:     uint32(nf),
+				RegAddrMin: nftRegister,
+			},
+		},
+	})
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables rules: %w", err)
+	}
+	return nil
+}
+
 func setNFTablesConnmarkRules(family int, link netlink.Link) error {
 	conn, err := nftables.New()
 	if err != nil {
@@ -0,0 +1,612 @@
+package netfilter
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/vishvananda/netlink"
+
+	"github.com/cybozu-go/coil/v2/pkg/fou"
+	"github.com/cybozu-go/coil/v2/pkg/nat"
+)
+
+const (
+	nodeEgressTable       = "coil-node-egress"
+	nodeEgressOutputChain = "output"
+	nodeEgressInputChain  = "input"
+	nodeEgressPeerSet     = "fou-peers"
+
+	// CgroupRoot is the mount point of the cgroup v2 hierarchy.
+	CgroupRoot = "/sys/fs/cgroup"
+)
+
+// Offsets of the rule priorities for node egress from the base priority.
+const (
+	nnLinkLocalPrioOffset = 0
+	nnNarrowPrioOffset    = 1
+	nnLocalPrioOffset     = 2
+	nnWidePrioOffset      = 50
+)
+
+var _ nat.NodeClient = &NodeNatClient{}
+
+// NodeNatClient routes the traffic of selected processes in the host network
+// namespace through Foo-over-UDP tunnels to egress NAT servers.
+//
+// The processes are selected by their UIDs or cgroups.  Packets of connections
+// originating from them are marked by nftables, and routed by policy routing
+// rules that match the mark.  Other traffic of the node is not affected.
+//
+// Foo-over-UDP packets are accepted only from the peers set by SetPeers
+// because the tunnel decapsulates packets with any source address into
+// the host network.
+type NodeNatClient struct {
+	ipv4        net.IP
+	ipv6        net.IP
+	port        int
+	v4InCluster []*net.IPNet
+	v6InCluster []*net.IPNet
+	uids        []uint32
+	cgroups     []string
+	mark        uint32
+	rulePrio    int
+	narrowTable int
+	wideTable   int
+	logFunc     func(string)
+
+	mu sync.Mutex
+	// inodes of the cgroups matched by the current rules
+	cgroupInodes map[string]uint64
+}
+
+// NewNodeNatClient creates a NodeNatClient.
+// ipv4 and ipv6 are the addresses of the node.  Either can be nil to disable the family.
+// port is the UDP port of the Foo-over-UDP tunnels.
+// cgroups are paths of cgroup v2 relative to CgroupRoot.
+// mark is the packet mark bit, and rulePrio is the base priority of the routing rules.
+// narrowTable and wideTable are the routing tables for the routes via the tunnels.
+func NewNodeNatClient(ipv4, ipv6 net.IP, port int, uids []uint32, cgroups []string, mark uint32, rulePrio, narrowTable, wideTable int, logFunc func(string)) *NodeNatClient {
+	return &NodeNatClient{
+		ipv4:        ipv4,
+		ipv6:        ipv6,
+		port:        port,
+		v4InCluster: v4PrivateList,
+		v6InCluster: v6PrivateList,
+		uids:        uids,
+		cgroups:     cgroups,
+		mark:        mark,
+		rulePrio:    rulePrio,
+		narrowTable: narrowTable,
+		wideTable:   wideTable,
+		logFunc:     logFunc,
+	}
+}
+
+// Init sets up the nftables rules to mark packets and the routing rules.
+// It is idempotent and clears any routes previously added by SyncNat.
+// Foo-over-UDP packets are dropped until SetPeers is called.
+//
+// Cgroups that do not exist yet are not treated as errors.  They are
+// reported through logFunc and matched after Refresh finds them.
+func (n *NodeNatClient) Init() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.clear(); err != nil {
+		return err
+	}
+
+	if err := n.setInputRules(); err != nil {
+		return err
+	}
+
+	inodes, err := n.resolveCgroups()
+	if err != nil && n.logFunc != nil {
+		n.logFunc(err.Error())
+	}
+	if err := n.setMarkRules(inodes, false); err != nil {
+		return err
+	}
+
+	for _, family := range n.families() {
+		if err := n.initRules(family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncNat reconciles the routes via link to match subnets.
+// Init must have been called beforehand.
+func (n *NodeNatClient) SyncNat(link netlink.Link, subnets []*net.IPNet) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	routesBySubnet, err := collectRoutesBySubnet(link.Attrs().Index, n.narrowTable, n.wideTable)
+	if err != nil {
+		return err
+	}
+
+	adds, dels := diffRoutes(routesBySubnet, subnets)
+	for _, r := range dels {
+		if n.logFunc != nil {
+			n.logFunc(fmt.Sprintf("removing a destination %s", r.Dst.String()))
+		}
+		if err := netlink.RouteDel(r); err != nil {
+			return fmt.Errorf("netlink: failed to delete a route %+v, %w", r, err)
+		}
+	}
+
+	for _, ipn := range adds {
+		inCluster := n.v4InCluster
+		if ipn.IP.To4() == nil {
+			if n.ipv6 == nil {
+				continue
+			}
+			inCluster = n.v6InCluster
+		} else if n.ipv4 == nil {
+			continue
+		}
+		if err := addTunnelRoute(link, ipn, inCluster, n.narrowTable, n.wideTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refresh resolves the cgroups again and updates the rules to mark packets
+// if any of them has been created, removed, or recreated since the last time.
+// It returns an error for the cgroups that cannot be resolved after updating
+// the rules for the others.  Init must have been called beforehand.
+func (n *NodeNatClient) Refresh() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	inodes, resolveErr := n.resolveCgroups()
+	if !maps.Equal(inodes, n.cgroupInodes) {
+		if n.logFunc != nil {
+			n.logFunc(fmt.Sprintf("updating rules for cgroups %v", inodes))
+		}
+		if err := n.setMarkRules(inodes, true); err != nil {
+			return err
+		}
+	}
+	return resolveErr
+}
+
+// SetPeers replaces the addresses from which Foo-over-UDP packets are accepted.
+// Init must have been called beforehand.
+func (n *NodeNatClient) SetPeers(peers []net.IP) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to create nftables connection: %w", err)
+	}
+	for _, family := range n.families() {
+		nf, err := netlinkToNFTablesFamily(family)
+		if err != nil {
+			return err
+		}
+		set := nodePeerSet(nf)
+		var elements []nftables.SetElement
+		for _, ip := range peers {
+			if v4 := ip.To4(); v4 != nil {
+				if family == netlink.FAMILY_V4 {
+					elements = append(elements, nftables.SetElement{Key: v4})
+				}
+			} else if family == netlink.FAMILY_V6 {
+				elements = append(elements, nftables.SetElement{Key: ip.To16()})
+			}
+		}
+		// flushing and adding elements in the same transaction never opens the tunnel to others.
+		conn.FlushSet(set)
+		if len(elements) > 0 {
+			if err := conn.SetAddElements(set, elements); err != nil {
+				return fmt.Errorf("failed to add elements to set %s: %w", set.Name, err)
+			}
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables rules: %w", err)
+	}
+	return nil
+}
+
+// Clear removes the nftables rules, the routing rules, and the routes for node egress.
+// It also removes the Foo-over-UDP tunnel set up in the host network.
+// It does nothing if node egress has never been set up on the node.
+func (n *NodeNatClient) Clear() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := fou.ClearNodeTunnel(n.port); err != nil {
+		return fmt.Errorf("failed to clear the tunnel: %w", err)
+	}
+
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to create nftables connection: %w", err)
+	}
+	tables, err := conn.ListTables()
+	if err != nil {
+		return fmt.Errorf("failed to list nftables tables: %w", err)
+	}
+	for _, t := range tables {
+		if t.Name == nodeEgressTable {
+			return n.clear()
+		}
+	}
+	return nil
+}
+
+func (n *NodeNatClient) families() []int {
+	var families []int
+	if n.ipv4 != nil {
+		families = append(families, netlink.FAMILY_V4)
+	}
+	if n.ipv6 != nil {
+		families = append(families, netlink.FAMILY_V6)
+	}
+	return families
+}
+
+func (n *NodeNatClient) clear() error {
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to create nftables connection: %w", err)
+	}
+	for _, nf := range []nftables.TableFamily{nftables.TableFamilyIPv4, nftables.TableFamilyIPv6} {
+		// Adding the table before deleting it makes the deletion succeed
+		// even if the table does not exist yet.
+		t := &nftables.Table{Family: nf, Name: nodeEgressTable}
+		conn.AddTable(t)
+		conn.DelTable(t)
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables rules: %w", err)
+	}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		gw := defaultIPv4GW
+		if family == netlink.FAMILY_V6 {
+			gw = defaultIPv6GW
+		}
+
+		rules, err := netlink.RuleList(family)
+		if err != nil {
+			return fmt.Errorf("netlink: rule list failed: %w", err)
+		}
+		for _, r := range rules {
+			if r.Priority < n.rulePrio || r.Priority > n.rulePrio+nnWidePrioOffset || r.Mark != n.mark {
+				continue
+			}
+			if r.Dst == nil {
+				// workaround for a library issue
+				r.Dst = gw
+			}
+			if err := netlink.RuleDel(&r); err != nil {
+				return fmt.Errorf("netlink: failed to delete a rule: %+v, %w", r, err)
+			}
+		}
+
+		for _, t := range []int{n.narrowTable, n.wideTable} {
+			routes, err := netlink.RouteListFiltered(family, &netlink.Route{Table: t}, netlink.RT_FILTER_TABLE)
+			if err != nil {
+				return fmt.Errorf("netlink: route list failed: %w", err)
+			}
+			for _, r := range routes {
+				if r.Dst == nil {
+					// workaround for a library issue
+					r.Dst = gw
+				}
+				if err := netlink.RouteDel(&r); err != nil {
+					return fmt.Errorf("netlink: failed to delete a route in table %d: %+v, %w", t, r, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (n *NodeNatClient) initRules(family int) error {
+	linkLocal := v4LinkLocal
+	inCluster := n.v4InCluster
+	if family == netlink.FAMILY_V6 {
+		linkLocal = v6LinkLocal
+		inCluster = n.v6InCluster
+	}
+
+	add := func(table, prio int, dst *net.IPNet) error {
+		r := newRule(family, table, n.rulePrio+prio)
+		r.Mark = n.mark
+		r.Mask = &n.mark
+		r.Dst = dst
+		if err := netlink.RuleAdd(r); err != nil && !errors.Is(err, syscall.EEXIST) {
+			return fmt.Errorf("netlink: failed to add rule %s: %w", r.String(), err)
+		}
+		return nil
+	}
+
+	if err := add(mainTableID, nnLinkLocalPrioOffset, linkLocal); err != nil {
+		return err
+	}
+	if err := add(n.narrowTable, nnNarrowPrioOffset, nil); err != nil {
+		return err
+	}
+	for i, ipn := range inCluster {
+		if err := add(mainTableID, nnLocalPrioOffset+i, ipn); err != nil {
+			return err
+		}
+	}
+	return add(n.wideTable, nnWidePrioOffset, nil)
+}
+
+// setInputRules sets up the set of the peers and the rules to drop
+// Foo-over-UDP packets from the others.
+func (n *NodeNatClient) setInputRules() error {
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to create nftables connection: %w", err)
+	}
+	for _, family := range n.families() {
+		nf, err := netlinkToNFTablesFamily(family)
+		if err != nil {
+			return err
+		}
+		if err := addNodeInputChain(conn, nf, n.port); err != nil {
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables rules: %w", err)
+	}
+	return nil
+}
+
+// resolveCgroups returns the inode numbers of the cgroups that exist.
+// The returned error describes the cgroups that cannot be resolved.
+func (n *NodeNatClient) resolveCgroups() (map[string]uint64, error) {
+	inodes := make(map[string]uint64)
+	var errs []error
+	for _, cg := range n.cgroups {
+		fi, err := os.Stat(filepath.Join(CgroupRoot, cg))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to stat cgroup %s: %w", cg, err))
+			continue
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			errs = append(errs, fmt.Errorf("failed to get the inode number of cgroup %s", cg))
+			continue
+		}
+		inodes[cg] = st.Ino
+	}
+	return inodes, errors.Join(errs...)
+}
+
+// setMarkRules sets up the tables to mark packets of the selected processes.
+// If replace is true, the existing rules are replaced atomically.
+func (n *NodeNatClient) setMarkRules(inodes map[string]uint64, replace bool) error {
+	matches := n.socketMatches(inodes)
+
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to create nftables connection: %w", err)
+	}
+	for _, family := range n.families() {
+		nf, err := netlinkToNFTablesFamily(family)
+		if err != nil {
+			return err
+		}
+		addNodeEgressTable(conn, nf, matches, n.mark, replace)
+	}
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables rules: %w", err)
+	}
+	n.cgroupInodes = inodes
+	return nil
+}
+
+// socketMatches returns expressions to match the sockets of the selected processes.
+func (n *NodeNatClient) socketMatches(inodes map[string]uint64) [][]expr.Any {
+	var matches [][]expr.Any
+	for _, uid := range n.uids {
+		matches = append(matches, []expr.Any{
+			&expr.Meta{
+				Key:      expr.MetaKeySKUID,
+				Register: nftRegister,
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: nftRegister,
+				Data:     binaryutil.NativeEndian.PutUint32(uid),
+			},
+		})
+	}
+
+	for _, cg := range n.cgroups {
+		ino, ok := inodes[cg]
+		if !ok {
+			continue
+		}
+		level := len(strings.Split(strings.Trim(filepath.Clean("/"+cg), "/"), "/"))
+
+		matches = append(matches, []expr.Any{
+			&expr.Socket{
+				Key:      expr.SocketKeyCgroupv2,
+				Level:    uint32(level),
+				Register: nftRegister,
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: nftRegister,
+				Data:     binaryutil.NativeEndian.PutUint64(ino),
+			},
+		})
+	}
+	return matches
+}
+
+// addNodeEgressTable adds a table that marks packets of connections
+// originating from the sockets matching any of matches.
+// If flush is true, the existing rules in the table are removed in the same transaction.
+//
+// The chain is of type route so that the packets are routed again with the mark.
+// Packets already marked, such as ones encapsulated by the tunnels, are not
+// marked again to avoid routing them into the tunnels repeatedly.
+func addNodeEgressTable(conn *nftables.Conn, nf nftables.TableFamily, matches [][]expr.Any, mark uint32, flush bool) {
+	t := conn.AddTable(&nftables.Table{Family: nf, Name: nodeEgressTable})
+	c := conn.AddChain(&nftables.Chain{
+		Name:     nodeEgressOutputChain,
+		Table:    t,
+		Type:     nftables.ChainTypeRoute,
+		Hooknum:  nftables.ChainHookOutput,
+		Priority: nftables.ChainPriorityMangle,
+	})
+	if flush {
+		conn.FlushChain(c)
+	}
+	for _, m := range matches {
+		// ex. nft add rule ip coil-node-egress output ct direction original meta mark & 0x200000 == 0 meta skuid 1000 meta mark set meta mark | 0x200000
+		exprs := []expr.Any{
+			&expr.Ct{
+				Register: nftRegister,
+				Key:      expr.CtKeyDIRECTION,
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: nftRegister,
+				Data:     []byte{0}, // IP_CT_DIR_ORIGINAL
+			},
+			&expr.Meta{
+				Key:      expr.MetaKeyMARK,
+				Register: nftRegister,
+			},
+			&expr.Bitwise{
+				SourceRegister: nftRegister,
+				DestRegister:   nftRegister,
+				Len:            4,
+				Mask:           binaryutil.NativeEndian.PutUint32(mark),
+				Xor:            binaryutil.NativeEndian.PutUint32(0),
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: nftRegister,
+				Data:     binaryutil.NativeEndian.PutUint32(0),
+			},
+		}
+		exprs = append(exprs, m...)
+		exprs = append(exprs,
+			&expr.Meta{
+				Key:      expr.MetaKeyMARK,
+				Register: nftRegister,
+			},
+			&expr.Bitwise{
+				SourceRegister: nftRegister,
+				DestRegister:   nftRegister,
+				Len:            4,
+				Mask:           binaryutil.NativeEndian.PutUint32(^mark),
+				Xor:            binaryutil.NativeEndian.PutUint32(mark),
+			},
+			&expr.Meta{
+				Key:            expr.MetaKeyMARK,
+				SourceRegister: true,
+				Register:       nftRegister,
+			},
+		)
+		conn.AddRule(&nftables.Rule{
+			Table: t,
+			Chain: c,
+			Exprs: exprs,
+		})
+	}
+}
+
+func nodePeerSet(nf nftables.TableFamily) *nftables.Set {
+	keyType := nftables.TypeIPAddr
+	if nf == nftables.TableFamilyIPv6 {
+		keyType = nftables.TypeIP6Addr
+	}
+	return &nftables.Set{
+		Table:   &nftables.Table{Family: nf, Name: nodeEgressTable},
+		Name:    nodeEgressPeerSet,
+		KeyType: keyType,
+	}
+}
+
+// addNodeInputChain adds the set of peers and the chain to drop Foo-over-UDP
+// packets to port from the others.
+func addNodeInputChain(conn *nftables.Conn, nf nftables.TableFamily, port int) error {
+	t := conn.AddTable(&nftables.Table{Family: nf, Name: nodeEgressTable})
+	set := nodePeerSet(nf)
+	set.Table = t
+	if err := conn.AddSet(set, nil); err != nil {
+		return fmt.Errorf("failed to add set %s: %w", set.Name, err)
+	}
+	c := conn.AddChain(&nftables.Chain{
+		Name:     nodeEgressInputChain,
+		Table:    t,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityFilter,
+	})
+
+	saddrOffset, saddrLen := uint32(12), uint32(4)
+	if nf == nftables.TableFamilyIPv6 {
+		saddrOffset, saddrLen = 8, 16
+	}
+	// ex. nft add rule ip coil-node-egress input meta l4proto udp udp dport 5555 ip saddr != @fou-peers drop
+	conn.AddRule(&nftables.Rule{
+		Table: t,
+		Chain: c,
+		Exprs: []expr.Any{
+			&expr.Meta{
+				Key:      expr.MetaKeyL4PROTO,
+				Register: nftRegister,
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: nftRegister,
+				Data:     []byte{syscall.IPPROTO_UDP},
+			},
+			&expr.Payload{
+				DestRegister: nftRegister,
+				Base:         expr.PayloadBaseTransportHeader,
+				Offset:       2,
+				Len:          2,
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: nftRegister,
+				Data:     binaryutil.BigEndian.PutUint16(uint16(port)),
+			},
+			&expr.Payload{
+				DestRegister: nftRegister,
+				Base:         expr.PayloadBaseNetworkHeader,
+				Offset:       saddrOffset,
+				Len:          saddrLen,
+			},
+			&expr.Lookup{
+				SourceRegister: nftRegister,
+				SetName:        set.Name,
+				SetID:          set.ID,
+				Invert:         true,
+			},
+			&expr.Counter{},
+			&expr.Verdict{Kind: expr.VerdictDrop},
+		},
+	})
+	return nil
+}
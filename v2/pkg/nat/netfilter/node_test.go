@@ -0,0 +1,158 @@
+//go:build privileged
+
+package netfilter
+
+import (
+	"net"
+	"testing"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/google/nftables"
+	"github.com/vishvananda/netlink"
+)
+
+func TestNodeNatClient(t *testing.T) {
+	tns, err := testutils.NewNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tns.Close()
+
+	err = tns.Do(func(ns.NetNS) error {
+		link, err := netlink.LinkByName("lo")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		const mark = 0x200000
+		const prio = 2100
+		const narrowTable = 127
+		const wideTable = 128
+		nc := NewNodeNatClient(net.ParseIP("10.20.30.40").To4(), nil, 5555, []uint32{1000}, []string{"no-such-cgroup"}, mark, prio, narrowTable, wideTable, nil)
+		// Clear does nothing before Init
+		if err := nc.Clear(); err != nil {
+			t.Fatal(err)
+		}
+		// missing cgroups do not fail Init
+		if err := nc.Init(); err != nil {
+			t.Fatal(err)
+		}
+		// Init is idempotent
+		if err := nc.Init(); err != nil {
+			t.Fatal(err)
+		}
+		// Refresh reports missing cgroups
+		if err := nc.Refresh(); err == nil {
+			t.Error("Refresh should report the missing cgroup")
+		}
+
+		conn, err := nftables.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		table := &nftables.Table{Family: nftables.TableFamilyIPv4, Name: nodeEgressTable}
+		rules, err := conn.GetRules(table, &nftables.Chain{Name: nodeEgressOutputChain, Table: table})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != 1 {
+			t.Errorf("expected 1 rule, got %d", len(rules))
+		}
+		rules, err = conn.GetRules(table, &nftables.Chain{Name: nodeEgressInputChain, Table: table})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != 1 {
+			t.Errorf("expected 1 input rule, got %d", len(rules))
+		}
+
+		// addresses of the other family are ignored
+		if err := nc.SetPeers([]net.IP{net.ParseIP("10.100.0.1"), net.ParseIP("10.64.0.1"), net.ParseIP("fd02::1")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := nc.SetPeers([]net.IP{net.ParseIP("10.100.0.1"), net.ParseIP("fd02::1")}); err != nil {
+			t.Fatal(err)
+		}
+		elements, err := conn.GetSetElements(nodePeerSet(nftables.TableFamilyIPv4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(elements) != 1 || !net.IP(elements[0].Key).Equal(net.ParseIP("10.100.0.1")) {
+			t.Errorf("unexpected peers: %v", elements)
+		}
+
+		rs, err := netlink.RuleList(netlink.FAMILY_V4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		for _, r := range rs {
+			if r.Mark == mark {
+				count++
+			}
+		}
+		// link local + narrow + 3 private networks + wide
+		if count != 6 {
+			t.Errorf("expected 6 rules, got %d", count)
+		}
+
+		_, subnet1, _ := net.ParseCIDR("192.168.1.0/24")
+		_, subnet2, _ := net.ParseCIDR("0.0.0.0/0")
+		_, subnet3, _ := net.ParseCIDR("fd02::/16")
+		if err := nc.SyncNat(link, []*net.IPNet{subnet1, subnet2, subnet3}); err != nil {
+			t.Fatal(err)
+		}
+		narrow, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: narrowTable}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(narrow) != 1 {
+			t.Errorf("expected 1 narrow route, got %d", len(narrow))
+		}
+		wide, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: wideTable}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(wide) != 1 {
+			t.Errorf("expected 1 wide route, got %d", len(wide))
+		}
+
+		if err := nc.SyncNat(link, []*net.IPNet{subnet2}); err != nil {
+			t.Fatal(err)
+		}
+		narrow, err = netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: narrowTable}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(narrow) != 0 {
+			t.Errorf("narrow route should have been removed: %v", narrow)
+		}
+
+		if err := nc.Clear(); err != nil {
+			t.Fatal(err)
+		}
+		tables, err := conn.ListTablesOfFamily(nftables.TableFamilyIPv4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tbl := range tables {
+			if tbl.Name == nodeEgressTable {
+				t.Error("table should have been deleted")
+			}
+		}
+		rs, err = netlink.RuleList(netlink.FAMILY_V4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range rs {
+			if r.Mark == mark {
+				t.Errorf("rule should have been deleted: %v", r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/vishvananda/netlink"
 
 	"github.com/cybozu-go/coil/v2/pkg/constants"
+	"github.com/cybozu-go/coil/v2/pkg/fou"
 	"github.com/cybozu-go/coil/v2/pkg/nat"
 )
 
@@ -17,10 +18,13 @@ const (
 	linkName = "egress-dummy"
 )
 
+// The table and the rule priorities must not conflict with those of NatClient
+// because an egress pod can also be a client of another Egress.
 const (
-	nsTableID    = 118
-	nsProtocolID = 30
-	nsRulePrio   = 2000
+	nsTableID         = 120
+	nsProtocolID      = 30
+	nsRulePrio        = 1700
+	nsTransitRulePrio = 1701
 )
 
 const (
@@ -35,10 +39,14 @@ var _ nat.Server = &NatServer{}
 // NatServer implements the nat.Server interface using netfilter (iptables or nftables)
 // for NAT masquerading and routing rules.
 type NatServer struct {
-	iface   string
-	ipv4    net.IP
-	ipv6    net.IP
-	backend string
+	iface    string
+	ipv4     net.IP
+	ipv6     net.IP
+	srcIPv4  net.IP
+	srcIPv6  net.IP
+	srcPorts *PortRange
+	transit  bool
+	backend  string
 
 	clients map[string]struct{}
 	mu      sync.RWMutex
@@ -48,12 +56,45 @@ type NatServer struct {
 // It sets up masquerade rules and FIB rules for the given IPv4 and/or IPv6 addresses
 // using the specified backend (iptables or nftables).
 func NewNatServer(iface string, ipv4, ipv6 net.IP, backend string) (*NatServer, error) {
+	return NewNatServerWithOptions(iface, ipv4, ipv6, backend, NatServerOptions{})
+}
+
+// NatServerOptions is a set of optional parameters of NatServer.
+type NatServerOptions struct {
+	// SourceIPv4 and SourceIPv6 are the addresses to which packets are SNATed
+	// instead of being masqueraded if they are not nil.
+	SourceIPv4 net.IP
+	SourceIPv6 net.IP
+
+	// SourcePorts limits the source ports of TCP and UDP packets SNATed to
+	// SourceIPv4 or SourceIPv6 if it is not nil.  Egress pods sharing the
+	// source addresses must use disjoint ranges so that they never assign
+	// the same tuple to different flows.
+	SourcePorts *PortRange
+
+	// Transit enables to forward the traffic of the clients through
+	// Foo-over-UDP tunnels to other egress NAT servers.
+	Transit bool
+}
+
+// PortRange is an inclusive range of port numbers.
+type PortRange struct {
+	Min uint16
+	Max uint16
+}
+
+// NewNatServerWithOptions is the same as NewNatServer except that it takes additional options.
+func NewNatServerWithOptions(iface string, ipv4, ipv6 net.IP, backend string, opts NatServerOptions) (*NatServer, error) {
 	n := &NatServer{
-		iface:   iface,
-		ipv4:    ipv4,
-		ipv6:    ipv6,
-		backend: backend,
-		clients: make(map[string]struct{}),
+		iface:    iface,
+		ipv4:     ipv4,
+		ipv6:     ipv6,
+		srcIPv4:  opts.SourceIPv4,
+		srcIPv6:  opts.SourceIPv6,
+		srcPorts: opts.SourcePorts,
+		transit:  opts.Transit,
+		backend:  backend,
+		clients:  make(map[string]struct{}),
 	}
 	if err := n.init(); err != nil {
 		return nil, err
@@ -156,13 +197,23 @@ func (n *NatServer) init() error {
 func (n *NatServer) initIPv4() error {
 	switch n.backend {
 	case constants.EgressBackendIPTables:
-		if err := setIPTablesMasqRules(netlink.FAMILY_V4, n.iface, n.ipv4); err != nil {
+		if err := setIPTablesMasqRules(netlink.FAMILY_V4, n.iface, n.ipv4, n.srcIPv4, n.srcPorts); err != nil {
 			return err
 		}
+		if n.transit {
+			if err := setIPTablesTransitRules(netlink.FAMILY_V4, fou.FoU4LinkPrefix, n.ipv4); err != nil {
+				return err
+			}
+		}
 	case constants.EgressBackendNFTables:
-		if err := setNFTablesMasqRules(netlink.FAMILY_V4, n.iface, n.ipv4); err != nil {
+		if err := setNFTablesMasqRules(netlink.FAMILY_V4, n.iface, n.ipv4, n.srcIPv4, n.srcPorts); err != nil {
 			return err
 		}
+		if n.transit {
+			if err := setNFTablesTransitRules(netlink.FAMILY_V4, fou.FoU4LinkPrefix, n.ipv4); err != nil {
+				return err
+			}
+		}
 	default:
 		return fmt.Errorf("invalid nat backend: %s", n.backend)
 	}
@@ -173,13 +224,23 @@ func (n *NatServer) initIPv4() error {
 func (n *NatServer) initIPv6() error {
 	switch n.backend {
 	case constants.EgressBackendIPTables:
-		if err := setIPTablesMasqRules(netlink.FAMILY_V6, n.iface, n.ipv6); err != nil {
+		if err := setIPTablesMasqRules(netlink.FAMILY_V6, n.iface, n.ipv6, n.srcIPv6, n.srcPorts); err != nil {
 			return err
 		}
+		if n.transit {
+			if err := setIPTablesTransitRules(netlink.FAMILY_V6, fou.FoU6LinkPrefix, n.ipv6); err != nil {
+				return err
+			}
+		}
 	case constants.EgressBackendNFTables:
-		if err := setNFTablesMasqRules(netlink.FAMILY_V6, n.iface, n.ipv6); err != nil {
+		if err := setNFTablesMasqRules(netlink.FAMILY_V6, n.iface, n.ipv6, n.srcIPv6, n.srcPorts); err != nil {
 			return err
 		}
+		if n.transit {
+			if err := setNFTablesTransitRules(netlink.FAMILY_V6, fou.FoU6LinkPrefix, n.ipv6); err != nil {
+				return err
+			}
+		}
 	default:
 		return fmt.Errorf("invalid nat backend: %s", n.backend)
 	}
@@ -197,6 +258,23 @@ func (n *NatServer) setFibRules(family int) error {
 	if err := netlink.RuleAdd(r); err != nil {
 		return fmt.Errorf("failed to add fib rule to egress table: %w", err)
 	}
+
+	if !n.transit {
+		return nil
+	}
+
+	// The replies for the clients forwarded to other egress NAT servers come back
+	// through the tunnels, not from iface.  Only locally generated packets are excluded.
+	r = netlink.NewRule()
+	r.Family = family
+	r.IifName = "lo"
+	r.Invert = true
+	r.Table = nsTableID
+	r.Priority = nsTransitRulePrio
+
+	if err := netlink.RuleAdd(r); err != nil {
+		return fmt.Errorf("failed to add fib rule for transit traffic: %w", err)
+	}
 	return nil
 }
 
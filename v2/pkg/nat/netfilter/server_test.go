@@ -13,6 +13,7 @@ import (
 	"github.com/containernetworking/plugins/pkg/testutils"
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
 	"github.com/vishvananda/netlink"
 
 	"github.com/cybozu-go/coil/v2/pkg/constants"
@@ -447,3 +448,156 @@ func checkIPRoutes(ipv4, ipv6 net.IP) error {
 	}
 	return nil
 }
+
+func TestNatServerTransit(t *testing.T) {
+	tns, err := testutils.NewNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tns.Close()
+
+	err = tns.Do(func(ns.NetNS) error {
+		ipv4 := net.ParseIP("10.20.30.40").To4()
+		n := &NatServer{
+			iface:   "lo",
+			ipv4:    ipv4,
+			transit: true,
+			backend: constants.EgressBackendNFTables,
+			clients: make(map[string]struct{}),
+		}
+		if err := n.setFibRules(netlink.FAMILY_V4); err != nil {
+			return err
+		}
+		if err := setNFTablesTransitRules(netlink.FAMILY_V4, "fou4_", ipv4); err != nil {
+			return err
+		}
+
+		rs, err := netlink.RuleList(netlink.FAMILY_V4)
+		if err != nil {
+			return err
+		}
+		var found bool
+		for _, r := range rs {
+			if r.Priority != nsTransitRulePrio {
+				continue
+			}
+			found = true
+			if r.Table != nsTableID {
+				return fmt.Errorf("wrong table for transit rule: %d", r.Table)
+			}
+			if r.IifName != "lo" || !r.Invert {
+				return fmt.Errorf("transit rule should match packets not from lo: %+v", r)
+			}
+		}
+		if !found {
+			return errors.New("no transit rule found")
+		}
+
+		conn, err := nftables.New()
+		if err != nil {
+			return err
+		}
+		table := &nftables.Table{Family: nftables.TableFamilyIPv4, Name: natTable}
+		rules, err := conn.GetRules(table, &nftables.Chain{Name: natChain, Table: table})
+		if err != nil {
+			return err
+		}
+		if len(rules) != 1 {
+			return fmt.Errorf("expected 1 SNAT rule, got %d", len(rules))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIPTablesSourcePorts(t *testing.T) {
+	tns, err := testutils.NewNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tns.Close()
+
+	ipv4 := net.ParseIP("10.20.30.40").To4()
+	src := net.ParseIP("192.0.2.1").To4()
+	ports := &PortRange{Min: 1024, Max: 5055}
+
+	err = tns.Do(func(ns.NetNS) error {
+		if err := setIPTablesMasqRules(netlink.FAMILY_V4, "lo", ipv4, src, ports); err != nil {
+			return err
+		}
+		ipt, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
+		if err != nil {
+			return err
+		}
+		for _, proto := range []string{"tcp", "udp"} {
+			exist, err := ipt.Exists(natTable, natChain, "!", "-s", "10.20.30.40/32", "-o", "lo", "-p", proto, "-j", "SNAT", "--to-source", "192.0.2.1:1024-5055")
+			if err != nil {
+				return err
+			}
+			if !exist {
+				return fmt.Errorf("SNAT rule with the port range not found for %s", proto)
+			}
+		}
+		exist, err := ipt.Exists(natTable, natChain, "!", "-s", "10.20.30.40/32", "-o", "lo", "-j", "SNAT", "--to-source", "192.0.2.1")
+		if err != nil {
+			return err
+		}
+		if !exist {
+			return errors.New("SNAT rule for other protocols not found")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNFTablesSourcePorts(t *testing.T) {
+	tns, err := testutils.NewNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tns.Close()
+
+	ipv4 := net.ParseIP("10.20.30.40").To4()
+	src := net.ParseIP("192.0.2.1").To4()
+	ports := &PortRange{Min: 1024, Max: 5055}
+
+	err = tns.Do(func(ns.NetNS) error {
+		if err := setNFTablesMasqRules(netlink.FAMILY_V4, "lo", ipv4, src, ports); err != nil {
+			return err
+		}
+		conn, err := nftables.New()
+		if err != nil {
+			return err
+		}
+		table := &nftables.Table{Family: nftables.TableFamilyIPv4, Name: natTable}
+		rules, err := conn.GetRules(table, &nftables.Chain{Name: natChain, Table: table})
+		if err != nil {
+			return err
+		}
+		if len(rules) != 4 {
+			return fmt.Errorf("expected 4 NAT rules, got %d", len(rules))
+		}
+		if _, ok := rules[0].Exprs[len(rules[0].Exprs)-1].(*expr.Counter); !ok {
+			return errors.New("the first rule should count all packets")
+		}
+		var ranged int
+		for _, r := range rules {
+			for _, e := range r.Exprs {
+				if n, ok := e.(*expr.NAT); ok && n.RegProtoMin != 0 && n.Specified {
+					ranged++
+				}
+			}
+		}
+		if ranged != 2 {
+			return fmt.Errorf("expected 2 rules with the port range, got %d", ranged)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
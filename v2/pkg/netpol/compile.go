@@ -0,0 +1,281 @@
+package netpol
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/cybozu-go/coil/v2/pkg/nat/netfilter"
+)
+
+// LocalPod is a Pod running on the node.
+type LocalPod struct {
+	Pod *corev1.Pod
+
+	// Iface is the name of the host-side veth of the Pod.
+	Iface string
+}
+
+// Compile computes the traffic allowed for local Pods by NetworkPolicies.
+//
+// pods and namespaces are all Pods and Namespaces in the cluster.
+// They are used to resolve the peers of NetworkPolicy rules.
+func Compile(local []LocalPod, policies []networkingv1.NetworkPolicy, pods []corev1.Pod, namespaces []corev1.Namespace) ([]netfilter.PodPolicy, error) {
+	c := &compiler{
+		pods:     pods,
+		nsLabels: make(map[string]labels.Set),
+	}
+	for i := range namespaces {
+		c.nsLabels[namespaces[i].Name] = namespaces[i].Labels
+	}
+
+	var result []netfilter.PodPolicy
+	for _, lp := range local {
+		pp := netfilter.PodPolicy{Iface: lp.Iface}
+
+		for i := range policies {
+			np := &policies[i]
+			if np.Namespace != lp.Pod.Namespace {
+				continue
+			}
+
+			sel, err := metav1.LabelSelectorAsSelector(&np.Spec.PodSelector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid podSelector in %s/%s: %w", np.Namespace, np.Name, err)
+			}
+			if !sel.Matches(labels.Set(lp.Pod.Labels)) {
+				continue
+			}
+
+			ingress, egress := policyTypes(np)
+			if ingress {
+				pp.IngressIsolated = true
+				for _, rule := range np.Spec.Ingress {
+					matches, err := c.ruleMatches(np, lp.Pod, rule.From, rule.Ports, true)
+					if err != nil {
+						return nil, err
+					}
+					pp.Ingress = append(pp.Ingress, matches...)
+				}
+			}
+			if egress {
+				pp.EgressIsolated = true
+				for _, rule := range np.Spec.Egress {
+					matches, err := c.ruleMatches(np, lp.Pod, rule.To, rule.Ports, false)
+					if err != nil {
+						return nil, err
+					}
+					pp.Egress = append(pp.Egress, matches...)
+				}
+			}
+		}
+
+		result = append(result, pp)
+	}
+
+	return result, nil
+}
+
+// policyTypes returns whether np applies to ingress and/or egress.
+// If policyTypes is not specified, ingress is always included and
+// egress is included only when the policy has egress rules.
+func policyTypes(np *networkingv1.NetworkPolicy) (ingress, egress bool) {
+	if len(np.Spec.PolicyTypes) == 0 {
+		return true, len(np.Spec.Egress) > 0
+	}
+	for _, t := range np.Spec.PolicyTypes {
+		switch t {
+		case networkingv1.PolicyTypeIngress:
+			ingress = true
+		case networkingv1.PolicyTypeEgress:
+			egress = true
+		}
+	}
+	return
+}
+
+type compiler struct {
+	pods     []corev1.Pod
+	nsLabels map[string]labels.Set
+}
+
+// peer is a remote end resolved from a NetworkPolicyPeer.
+// pod is set when the peer is selected by pod or namespace selectors.
+type peer struct {
+	network *net.IPNet
+	except  []*net.IPNet
+	pod     *corev1.Pod
+}
+
+func (c *compiler) ruleMatches(np *networkingv1.NetworkPolicy, local *corev1.Pod,
+	npPeers []networkingv1.NetworkPolicyPeer, npPorts []networkingv1.NetworkPolicyPort, ingress bool) ([]netfilter.PolicyMatch, error) {
+
+	var peers []peer
+	if len(npPeers) == 0 {
+		// empty peers match all sources/destinations
+		peers = []peer{{}}
+	}
+	for _, p := range npPeers {
+		resolved, err := c.resolvePeer(np, p)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, resolved...)
+	}
+
+	var matches []netfilter.PolicyMatch
+	for _, p := range peers {
+		if len(npPorts) == 0 {
+			matches = append(matches, netfilter.PolicyMatch{Peer: p.network, Except: p.except})
+			continue
+		}
+
+		// Named ports are resolved against the Pod that receives the traffic.
+		target := local
+		if !ingress {
+			target = p.pod
+		}
+		for _, port := range npPorts {
+			m, ok := portMatch(port, target)
+			if !ok {
+				continue
+			}
+			m.Peer = p.network
+			m.Except = p.except
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+func (c *compiler) resolvePeer(np *networkingv1.NetworkPolicy, p networkingv1.NetworkPolicyPeer) ([]peer, error) {
+	if p.IPBlock != nil {
+		_, n, err := net.ParseCIDR(p.IPBlock.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ipBlock in %s/%s: %w", np.Namespace, np.Name, err)
+		}
+		pr := peer{network: n}
+		for _, e := range p.IPBlock.Except {
+			_, en, err := net.ParseCIDR(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ipBlock in %s/%s: %w", np.Namespace, np.Name, err)
+			}
+			pr.except = append(pr.except, en)
+		}
+		return []peer{pr}, nil
+	}
+
+	podSel := labels.Everything()
+	if p.PodSelector != nil {
+		sel, err := metav1.LabelSelectorAsSelector(p.PodSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid podSelector in %s/%s: %w", np.Namespace, np.Name, err)
+		}
+		podSel = sel
+	}
+
+	var nsSel labels.Selector
+	if p.NamespaceSelector != nil {
+		sel, err := metav1.LabelSelectorAsSelector(p.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespaceSelector in %s/%s: %w", np.Namespace, np.Name, err)
+		}
+		nsSel = sel
+	}
+
+	var peers []peer
+	for i := range c.pods {
+		pod := &c.pods[i]
+		if pod.Spec.HostNetwork {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		if nsSel == nil {
+			if pod.Namespace != np.Namespace {
+				continue
+			}
+		} else if !nsSel.Matches(c.nsLabels[pod.Namespace]) {
+			continue
+		}
+		if !podSel.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+
+		for _, podIP := range pod.Status.PodIPs {
+			ip := net.ParseIP(podIP.IP)
+			if ip == nil {
+				continue
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 32
+			}
+			peers = append(peers, peer{
+				network: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)},
+				pod:     pod,
+			})
+		}
+	}
+	return peers, nil
+}
+
+// portMatch converts port into a PolicyMatch.  A named port is looked up
+// in the containers of pod.  It returns false if the port cannot be resolved.
+func portMatch(port networkingv1.NetworkPolicyPort, pod *corev1.Pod) (netfilter.PolicyMatch, bool) {
+	proto := corev1.ProtocolTCP
+	if port.Protocol != nil {
+		proto = *port.Protocol
+	}
+
+	var m netfilter.PolicyMatch
+	switch proto {
+	case corev1.ProtocolTCP:
+		m.Protocol = unix.IPPROTO_TCP
+	case corev1.ProtocolUDP:
+		m.Protocol = unix.IPPROTO_UDP
+	case corev1.ProtocolSCTP:
+		m.Protocol = unix.IPPROTO_SCTP
+	default:
+		return m, false
+	}
+
+	if port.Port == nil {
+		return m, true
+	}
+
+	if port.Port.Type == intstr.Int {
+		m.Port = uint16(port.Port.IntVal)
+		if port.EndPort != nil {
+			m.EndPort = uint16(*port.EndPort)
+		}
+		return m, true
+	}
+
+	// endPort is not allowed with a named port.
+	if pod == nil {
+		return m, false
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, cp := range c.Ports {
+			cpProto := cp.Protocol
+			if cpProto == "" {
+				cpProto = corev1.ProtocolTCP
+			}
+			if cp.Name == port.Port.StrVal && cpProto == proto {
+				m.Port = uint16(cp.ContainerPort)
+				return m, true
+			}
+		}
+	}
+	return m, false
+}
@@ -0,0 +1,270 @@
+package netpol
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+
+	"github.com/cybozu-go/coil/v2/pkg/nat/netfilter"
+)
+
+func testPod(ns, name string, lbls map[string]string, ips ...string) corev1.Pod {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, Labels: lbls},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name: "c",
+				Ports: []corev1.ContainerPort{
+					{Name: "http", ContainerPort: 8080},
+					{Name: "dns", ContainerPort: 1053, Protocol: corev1.ProtocolUDP},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	for _, ip := range ips {
+		pod.Status.PodIPs = append(pod.Status.PodIPs, corev1.PodIP{IP: ip})
+	}
+	return pod
+}
+
+func hostNet(s string) *net.IPNet {
+	ip := net.ParseIP(s)
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func cidr(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func TestCompile(t *testing.T) {
+	pods := []corev1.Pod{
+		testPod("ns1", "web", map[string]string{"app": "web"}, "10.0.0.1", "fd00::1"),
+		testPod("ns1", "client", map[string]string{"app": "client"}, "10.0.0.2"),
+		testPod("ns2", "client", map[string]string{"app": "client"}, "10.0.0.3"),
+		testPod("ns2", "dns", map[string]string{"app": "dns"}, "10.0.0.4"),
+	}
+	hostPod := testPod("ns1", "host", map[string]string{"app": "client"}, "192.168.0.1")
+	hostPod.Spec.HostNetwork = true
+	donePod := testPod("ns1", "done", map[string]string{"app": "client"}, "10.0.0.5")
+	donePod.Status.Phase = corev1.PodSucceeded
+	pods = append(pods, hostPod, donePod)
+
+	namespaces := []corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns1", Labels: map[string]string{"team": "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "ns2", Labels: map[string]string{"team": "b"}}},
+	}
+	local := []LocalPod{
+		{Pod: &pods[0], Iface: "veth-web"},
+		{Pod: &pods[1], Iface: "veth-client"},
+	}
+
+	tcp := corev1.ProtocolTCP
+	udp := corev1.ProtocolUDP
+
+	tests := []struct {
+		name     string
+		policies []networkingv1.NetworkPolicy
+		expected []netfilter.PodPolicy
+	}{
+		{
+			name: "no policies",
+			expected: []netfilter.PodPolicy{
+				{Iface: "veth-web"},
+				{Iface: "veth-client"},
+			},
+		},
+		{
+			name: "deny all ingress",
+			policies: []networkingv1.NetworkPolicy{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "deny"},
+			}},
+			expected: []netfilter.PodPolicy{
+				{Iface: "veth-web", IngressIsolated: true},
+				{Iface: "veth-client", IngressIsolated: true},
+			},
+		},
+		{
+			name: "policies in other namespaces are ignored",
+			policies: []networkingv1.NetworkPolicy{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "deny"},
+			}},
+			expected: []netfilter.PodPolicy{
+				{Iface: "veth-web"},
+				{Iface: "veth-client"},
+			},
+		},
+		{
+			name: "pod selector in the same namespace",
+			policies: []networkingv1.NetworkPolicy{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "web"},
+				Spec: networkingv1.NetworkPolicySpec{
+					PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+					Ingress: []networkingv1.NetworkPolicyIngressRule{{
+						From: []networkingv1.NetworkPolicyPeer{{
+							PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}},
+						}},
+						Ports: []networkingv1.NetworkPolicyPort{
+							{Port: ptr.To(intstr.FromString("http"))},
+						},
+					}},
+				},
+			}},
+			expected: []netfilter.PodPolicy{
+				{
+					Iface:           "veth-web",
+					IngressIsolated: true,
+					Ingress: []netfilter.PolicyMatch{
+						{Peer: hostNet("10.0.0.2"), Protocol: unix.IPPROTO_TCP, Port: 8080},
+					},
+				},
+				{Iface: "veth-client"},
+			},
+		},
+		{
+			name: "namespace selector and pod selector",
+			policies: []networkingv1.NetworkPolicy{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "web"},
+				Spec: networkingv1.NetworkPolicySpec{
+					PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+					Ingress: []networkingv1.NetworkPolicyIngressRule{{
+						From: []networkingv1.NetworkPolicyPeer{
+							{
+								NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}},
+								PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}},
+							},
+							{
+								NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+							},
+						},
+					}},
+				},
+			}},
+			expected: []netfilter.PodPolicy{
+				{
+					Iface:           "veth-web",
+					IngressIsolated: true,
+					Ingress: []netfilter.PolicyMatch{
+						{Peer: hostNet("10.0.0.3")},
+						{Peer: hostNet("10.0.0.1")},
+						{Peer: hostNet("fd00::1")},
+						{Peer: hostNet("10.0.0.2")},
+					},
+				},
+				{Iface: "veth-client"},
+			},
+		},
+		{
+			name: "egress with ipBlock and named port of peers",
+			policies: []networkingv1.NetworkPolicy{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "client"},
+				Spec: networkingv1.NetworkPolicySpec{
+					PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}},
+					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+					Egress: []networkingv1.NetworkPolicyEgressRule{
+						{
+							To: []networkingv1.NetworkPolicyPeer{{
+								NamespaceSelector: &metav1.LabelSelector{},
+								PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "dns"}},
+							}},
+							Ports: []networkingv1.NetworkPolicyPort{
+								{Protocol: &udp, Port: ptr.To(intstr.FromString("dns"))},
+								{Protocol: &tcp, Port: ptr.To(intstr.FromString("dns"))},
+							},
+						},
+						{
+							To: []networkingv1.NetworkPolicyPeer{{
+								IPBlock: &networkingv1.IPBlock{
+									CIDR:   "192.168.0.0/16",
+									Except: []string{"192.168.1.0/24"},
+								},
+							}},
+							Ports: []networkingv1.NetworkPolicyPort{
+								{Port: ptr.To(intstr.FromInt32(30000)), EndPort: ptr.To[int32](32767)},
+							},
+						},
+					},
+				},
+			}},
+			expected: []netfilter.PodPolicy{
+				{Iface: "veth-web"},
+				{
+					Iface:          "veth-client",
+					EgressIsolated: true,
+					Egress: []netfilter.PolicyMatch{
+						{Peer: hostNet("10.0.0.4"), Protocol: unix.IPPROTO_UDP, Port: 1053},
+						{
+							Peer:     cidr("192.168.0.0/16"),
+							Except:   []*net.IPNet{cidr("192.168.1.0/24")},
+							Protocol: unix.IPPROTO_TCP,
+							Port:     30000,
+							EndPort:  32767,
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "allow all egress",
+			policies: []networkingv1.NetworkPolicy{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "all"},
+				Spec: networkingv1.NetworkPolicySpec{
+					PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}},
+					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+					Egress:      []networkingv1.NetworkPolicyEgressRule{{}},
+				},
+			}},
+			expected: []netfilter.PodPolicy{
+				{Iface: "veth-web"},
+				{
+					Iface:          "veth-client",
+					EgressIsolated: true,
+					Egress:         []netfilter.PolicyMatch{{}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := Compile(local, tt.policies, pods, namespaces)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expected, actual); diff != "" {
+				t.Errorf("unexpected result (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPolicyTypes(t *testing.T) {
+	np := &networkingv1.NetworkPolicy{}
+	if ingress, egress := policyTypes(np); !ingress || egress {
+		t.Errorf("unexpected default policy types: ingress=%v egress=%v", ingress, egress)
+	}
+
+	np.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{{}}
+	if ingress, egress := policyTypes(np); !ingress || !egress {
+		t.Errorf("egress should be included if egress rules exist: ingress=%v egress=%v", ingress, egress)
+	}
+
+	np.Spec.PolicyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeEgress}
+	if ingress, egress := policyTypes(np); ingress || !egress {
+		t.Errorf("unexpected policy types: ingress=%v egress=%v", ingress, egress)
+	}
+}
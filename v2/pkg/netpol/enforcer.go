@@ -0,0 +1,18 @@
+package netpol
+
+import "github.com/cybozu-go/coil/v2/pkg/nat/netfilter"
+
+// Enforcer programs the traffic allowed for local Pods into the node.
+type Enforcer interface {
+	// Sync updates the rules to enforce policies.
+	// Only the rules for Pods whose policies have changed are rewritten.
+	Sync(policies []netfilter.PodPolicy) error
+
+	// Clear removes all the rules.
+	Clear() error
+}
+
+// NewEnforcer creates an Enforcer backed by nftables.
+func NewEnforcer() Enforcer {
+	return &netfilter.NetworkPolicyRules{}
+}
@@ -0,0 +1,192 @@
+package nodenet
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	ifbPrefix = "coilbw"
+
+	// tbfLatencyInMillis is the maximum time a packet can sit in the TBF queue.
+	// This is the same value as the reference bandwidth plugin.
+	tbfLatencyInMillis = 25
+)
+
+// BandwidthLimits represents traffic shaping parameters for a Pod.
+// The semantics are the same as the `bandwidth` capability of CNI.
+// Rates are in bits per second, and bursts are in bits.
+// Zero means no limit.
+type BandwidthLimits struct {
+	// IngressRate limits the traffic sent to the Pod.
+	IngressRate  uint64 `json:"ingressRate"`
+	IngressBurst uint64 `json:"ingressBurst"`
+
+	// EgressRate limits the traffic sent from the Pod.
+	EgressRate  uint64 `json:"egressRate"`
+	EgressBurst uint64 `json:"egressBurst"`
+}
+
+// IsZero returns true if bw has no limits.
+func (bw *BandwidthLimits) IsZero() bool {
+	return bw == nil || (bw.IngressRate == 0 && bw.EgressRate == 0)
+}
+
+// Validate checks that every rate comes with a burst and vice versa.
+func (bw *BandwidthLimits) Validate() error {
+	if err := validateRateAndBurst(bw.IngressRate, bw.IngressBurst); err != nil {
+		return fmt.Errorf("invalid ingress bandwidth: %w", err)
+	}
+	if err := validateRateAndBurst(bw.EgressRate, bw.EgressBurst); err != nil {
+		return fmt.Errorf("invalid egress bandwidth: %w", err)
+	}
+	return nil
+}
+
+func validateRateAndBurst(rate, burst uint64) error {
+	switch {
+	case rate != 0 && burst == 0:
+		return errors.New("burst must be set with rate")
+	case rate == 0 && burst != 0:
+		return errors.New("rate must be set with burst")
+	case rate != 0 && rate < 8:
+		return errors.New("rate must be at least 8 bps")
+	case burst/8 >= math.MaxUint32:
+		return errors.New("burst cannot be more than 4GiB")
+	}
+	return nil
+}
+
+// ifbName returns the name of the ifb device used to shape the egress
+// traffic of a Pod. It is derived from the same key as the host veth alias.
+func ifbName(containerId, iface string) string {
+	sum := sha1.Sum([]byte(containerId + ":" + iface))
+	return ifbPrefix + hex.EncodeToString(sum[:])[:15-len(ifbPrefix)]
+}
+
+// setupBandwidth installs TBF qdiscs for the Pod connected to hLink.
+//
+// Traffic to the Pod is shaped by a root TBF qdisc of the host-side veth.
+// Traffic from the Pod arrives at the ingress of the host-side veth where
+// it cannot be shaped, so it is redirected to an ifb device whose root
+// TBF qdisc does the shaping.
+func setupBandwidth(hLink netlink.Link, ifb string, bw *BandwidthLimits) error {
+	if bw.IngressRate > 0 {
+		if err := addTBF(hLink.Attrs().Index, bw.IngressRate, bw.IngressBurst); err != nil {
+			return fmt.Errorf("netlink: failed to add tbf qdisc to %s: %w", hLink.Attrs().Name, err)
+		}
+	}
+
+	if bw.EgressRate == 0 {
+		return nil
+	}
+
+	err := netlink.LinkAdd(&netlink.Ifb{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:  ifb,
+			Flags: net.FlagUp,
+			MTU:   hLink.Attrs().MTU,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("netlink: failed to add ifb device %s: %w", ifb, err)
+	}
+	ifbLink, err := netlink.LinkByName(ifb)
+	if err != nil {
+		return fmt.Errorf("netlink: failed to look up ifb device %s: %w", ifb, err)
+	}
+
+	ingress := &netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: hLink.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	}
+	if err := netlink.QdiscAdd(ingress); err != nil {
+		return fmt.Errorf("netlink: failed to add ingress qdisc to %s: %w", hLink.Attrs().Name, err)
+	}
+
+	err = netlink.FilterAdd(&netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: hLink.Attrs().Index,
+			Parent:    ingress.Handle,
+			Priority:  1,
+			Protocol:  syscall.ETH_P_ALL,
+		},
+		ClassId: netlink.MakeHandle(1, 1),
+		// RedirIndex is not set because the library adds another mirred action for it.
+		Actions: []netlink.Action{
+			&netlink.MirredAction{
+				MirredAction: netlink.TCA_EGRESS_REDIR,
+				Ifindex:      ifbLink.Attrs().Index,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("netlink: failed to add redirect filter to %s: %w", hLink.Attrs().Name, err)
+	}
+
+	if err := addTBF(ifbLink.Attrs().Index, bw.EgressRate, bw.EgressBurst); err != nil {
+		return fmt.Errorf("netlink: failed to add tbf qdisc to %s: %w", ifb, err)
+	}
+	return nil
+}
+
+// teardownBandwidth deletes the ifb device for the Pod, if any.
+// Qdiscs on the host-side veth are deleted together with the veth.
+func teardownBandwidth(ifb string) error {
+	l, err := netlink.LinkByName(ifb)
+	if err != nil {
+		var lnf netlink.LinkNotFoundError
+		if errors.As(err, &lnf) {
+			return nil
+		}
+		return fmt.Errorf("netlink: failed to look up ifb device %s: %w", ifb, err)
+	}
+
+	if err := netlink.LinkDel(l); err != nil && !errors.Is(err, syscall.ENODEV) {
+		return fmt.Errorf("netlink: failed to delete ifb device %s: %w", ifb, err)
+	}
+	return nil
+}
+
+func addTBF(linkIndex int, rateInBits, burstInBits uint64) error {
+	rate := rateInBits / 8
+	burst := burstInBits / 8
+	buffer := tbfTicks(float64(burst) * float64(netlink.TIME_UNITS_PER_SEC) / float64(rate))
+	latency := float64(netlink.TIME_UNITS_PER_SEC) * tbfLatencyInMillis / 1000
+	limit := float64(rate)*latency/float64(netlink.TIME_UNITS_PER_SEC) + float64(burst)
+
+	return netlink.QdiscAdd(&netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: linkIndex,
+			Handle:    netlink.MakeHandle(1, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		Rate:   rate,
+		Buffer: buffer,
+		Limit:  clampUint32(limit),
+	})
+}
+
+// tbfTicks converts microseconds to the kernel's packet scheduler ticks.
+// Runtimes pass very large bursts for Pods without an explicit burst,
+// so the result is clamped instead of being allowed to overflow.
+func tbfTicks(usec float64) uint32 {
+	return clampUint32(usec * netlink.TickInUsec())
+}
+
+func clampUint32(v float64) uint32 {
+	if v >= math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(v)
+}
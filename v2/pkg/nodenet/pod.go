@@ -61,6 +61,15 @@ type PodNetConf struct {
 	IPv4         net.IP
 	IPv6         net.IP
 	HostVethName string
+
+	// Bandwidth is applied by SetupIPAM, if non-nil.
+	Bandwidth *BandwidthLimits
+
+	// MTU overrides the default MTU of the veth pair, if non-zero.
+	MTU int
+
+	// Tuning is applied by SetupIPAM, if non-nil.
+	Tuning *PodTuning
 }
 
 // PodNetwork represents an interface to configure container networking.
@@ -85,17 +94,21 @@ type PodNetwork interface {
 	// Check checks the pod network's status.
 	Check(containerId, iface string) error
 
-	// Destroy disconnects the container network by deleting the veth pair.
-	// IPv4 and IPv6 in conf can be left nil.
+	// Destroy disconnects the container network by deleting the veth pair
+	// and the ifb device for bandwidth limits, if any.
 	Destroy(containerId, iface string) error
 
 	// List returns a list of already setup network configurations.
 	List() ([]*PodNetConf, error)
 }
 
-// NewPodNetwork creates a PodNetwork
-func NewPodNetwork(podTableID, podRulePrio, protocolId int, hostIPv4, hostIPv6 net.IP, compatCalico, registerFromMain bool, log logr.Logger, enableIPAM bool) PodNetwork {
+// NewPodNetwork creates a PodNetwork.
+// If `mtu` is zero, the MTU is detected from the host network in Init
+// and reduced by `mtuOverhead`.
+func NewPodNetwork(podTableID, podRulePrio, protocolId int, hostIPv4, hostIPv6 net.IP, compatCalico, registerFromMain bool, log logr.Logger, enableIPAM bool, mtu, mtuOverhead int) PodNetwork {
 	return &podNetwork{
+		mtu:              mtu,
+		mtuOverhead:      mtuOverhead,
 		podTableId:       podTableID,
 		podRulePrio:      podRulePrio,
 		protocolId:       netlink.RouteProtocol(protocolId),
@@ -115,6 +128,7 @@ type podNetwork struct {
 	podRulePrio      int
 	protocolId       netlink.RouteProtocol
 	mtu              int
+	mtuOverhead      int
 	hostIPv4         net.IP
 	hostIPv6         net.IP
 	compatCalico     bool
@@ -219,11 +233,18 @@ func (pn *podNetwork) Init() error {
 		pn.log.Error(err, "warning: failed to init IPv6 routing rule")
 	}
 
-	if mtu, err := netutil.DetectMTU(); err != nil {
-		pn.log.Error(err, "warning: failed to auto-detect the host MTU")
-	} else {
-		pn.mtu = mtu
+	if pn.mtu == 0 {
+		mtu, err := netutil.DetectMTU()
+		switch {
+		case err != nil:
+			pn.log.Error(err, "warning: failed to auto-detect the host MTU")
+		case mtu <= pn.mtuOverhead:
+			pn.log.Info("warning: detected MTU is too small", "mtu", mtu, "overhead", pn.mtuOverhead)
+		default:
+			pn.mtu = mtu - pn.mtuOverhead
+		}
 	}
+	pn.log.Info("pod network MTU", "mtu", pn.mtu)
 
 	return nil
 }
@@ -287,6 +308,10 @@ func (pn *podNetwork) SetupIPAM(nsPath, podName, podNS string, conf *PodNetConf)
 	default:
 		return nil, err
 	}
+	ifb := ifbName(conf.ContainerId, conf.IFace)
+	if err := teardownBandwidth(ifb); err != nil {
+		return nil, err
+	}
 
 	// In compatCalico mode, veth names are deterministic per pod.
 	// A stale veth from a previous sandbox (different ContainerId) won't be
@@ -304,13 +329,18 @@ func (pn *podNetwork) SetupIPAM(nsPath, podName, podNS string, conf *PodNetConf)
 		}
 	}
 
+	mtu := pn.mtu
+	if conf.MTU != 0 {
+		mtu = conf.MTU
+	}
+
 	// setup veth and configure IP addresses
 	err = containerNS.Do(func(hostNS ns.NetNS) error {
 		vethName := ""
 		if pn.compatCalico {
 			vethName = calicoVethName(podName, podNS)
 		}
-		hVeth, cVeth, err := ip.SetupVethWithName(conf.IFace, vethName, pn.mtu, "", hostNS)
+		hVeth, cVeth, err := ip.SetupVethWithName(conf.IFace, vethName, mtu, "", hostNS)
 		if err != nil {
 			return fmt.Errorf("failed to setup veth: %w", err)
 		}
@@ -320,6 +350,17 @@ func (pn *podNetwork) SetupIPAM(nsPath, podName, podNS string, conf *PodNetConf)
 			return fmt.Errorf("netlink: failed to get veth link for container: %w", err)
 		}
 
+		if conf.Tuning != nil {
+			if err := setSysctls(conf.Tuning.Sysctls); err != nil {
+				netlink.LinkDel(cLink)
+				return err
+			}
+			if err := tuneLink(cLink, conf.Tuning); err != nil {
+				netlink.LinkDel(cLink)
+				return err
+			}
+		}
+
 		if err := netlink.LinkSetUp(cLink); err != nil {
 			netlink.LinkDel(cLink)
 			return fmt.Errorf("netlink: failed to up link for container: %w", err)
@@ -397,6 +438,12 @@ func (pn *podNetwork) SetupIPAM(nsPath, podName, podNS string, conf *PodNetConf)
 		return nil, fmt.Errorf("netlink: failed to set alias: %w", err)
 	}
 
+	if conf.Tuning != nil {
+		if err := tuneLink(hLink, conf.Tuning); err != nil {
+			return nil, err
+		}
+	}
+
 	// setup routing on the host side
 	if conf.IPv6 != nil {
 		err = netlink.AddrAdd(hLink, &netlink.Addr{
@@ -500,6 +547,15 @@ func (pn *podNetwork) SetupIPAM(nsPath, podName, podNS string, conf *PodNetConf)
 		return nil, err
 	}
 
+	if !conf.Bandwidth.IsZero() {
+		if err := setupBandwidth(hLink, ifb, conf.Bandwidth); err != nil {
+			if err2 := teardownBandwidth(ifb); err2 != nil {
+				pn.log.Error(err2, "failed to cleanup ifb device", "name", ifb)
+			}
+			return nil, err
+		}
+	}
+
 	hLink = nil
 	return result, nil
 }
@@ -676,6 +732,10 @@ func (pn *podNetwork) Destroy(containerId, iface string) error {
 	pn.cLocks.LockKey(containerId)
 	defer pn.cLocks.UnlockKey(containerId)
 
+	if err := teardownBandwidth(ifbName(containerId, iface)); err != nil {
+		return err
+	}
+
 	l, err := lookup(containerId, iface)
 	if err == errNotFound {
 		return nil
@@ -6,8 +6,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/safchain/ethtool"
+	"github.com/vishvananda/netlink"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
@@ -28,6 +32,12 @@ var podConfMap = map[string]PodNetConf{
 		ContainerId: "d8f4a9c50c85b36eff718aab2ac39209e541a4551420488c33d9216cf1795b3a",
 		IFace:       "eth0",
 		IPv4:        net.ParseIP("10.1.2.4"),
+		Bandwidth: &BandwidthLimits{
+			IngressRate:  10_000_000,
+			IngressBurst: 1_000_000,
+			EgressRate:   20_000_000,
+			EgressBurst:  2_000_000,
+		},
 	},
 	"pod3": {
 		PoolName:    "default",
@@ -47,12 +57,18 @@ var podConfMap = map[string]PodNetConf{
 		ContainerId: "368df12902d559b568aab1d4642943c2c5322bdd17457cdec8081a988e1a2ddf",
 		IFace:       "eth0",
 		IPv4:        net.ParseIP("10.1.2.6"),
+		MTU:         1400,
 	},
 	"pod6": {
 		PoolName:    "default",
 		ContainerId: "c80bcafb191e73ba0c269609ac6992e362ff3f042f66dfb82894b577673586f4",
 		IFace:       "eth0",
 		IPv6:        net.ParseIP("fd02::5"),
+		Tuning: &PodTuning{
+			Sysctls:    map[string]string{"net.ipv6.conf.eth0.accept_ra": "0"},
+			GRO:        ptr.To(false),
+			TxQueueLen: ptr.To(100),
+		},
 	},
 }
 
@@ -62,7 +78,7 @@ func TestPodNetwork(t *testing.T) {
 	}
 
 	pn := NewPodNetwork(116, 2000, 30, net.ParseIP("10.20.30.41"), net.ParseIP("fd10::41"),
-		false, false, ctrl.Log.WithName("pod-network"), true)
+		false, false, ctrl.Log.WithName("pod-network"), true, 0, 0)
 	if err := pn.Init(); err != nil {
 		t.Fatal(err)
 	}
@@ -385,6 +401,86 @@ func TestPodNetwork(t *testing.T) {
 		}
 	}
 
+	// check bandwidth limits of pod2
+	hLink, err := lookup(podConfMap["pod2"].ContainerId, podConfMap["pod2"].IFace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := tbfRate(t, hLink); rate != 10_000_000/8 {
+		t.Error("wrong ingress rate for pod2", rate)
+	}
+	ifb := ifbName(podConfMap["pod2"].ContainerId, podConfMap["pod2"].IFace)
+	ifbLink, err := netlink.LinkByName(ifb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := tbfRate(t, ifbLink); rate != 20_000_000/8 {
+		t.Error("wrong egress rate for pod2", rate)
+	}
+
+	// pod1 has no bandwidth limits
+	hLink, err = lookup(podConfMap["pod1"].ContainerId, podConfMap["pod1"].IFace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := tbfRate(t, hLink); rate != 0 {
+		t.Error("pod1 should not be shaped", rate)
+	}
+	if _, err := netlink.LinkByName(ifbName(podConfMap["pod1"].ContainerId, podConfMap["pod1"].IFace)); err == nil {
+		t.Error("ifb device for pod1 should not exist")
+	}
+
+	// check MTU of pod5
+	hLink, err = lookup(podConfMap["pod5"].ContainerId, podConfMap["pod5"].IFace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtu := hLink.Attrs().MTU; mtu != 1400 {
+		t.Error("wrong MTU of the host veth for pod5", mtu)
+	}
+	out, err := exec.Command("ip", "netns", "exec", "pod5", "cat", "/sys/class/net/eth0/mtu").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtu := strings.TrimSpace(string(out)); mtu != "1400" {
+		t.Error("wrong MTU of the container veth for pod5", mtu)
+	}
+
+	// check tuning of pod6
+	hLink, err = lookup(podConfMap["pod6"].ContainerId, podConfMap["pod6"].IFace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qlen := hLink.Attrs().TxQLen; qlen != 100 {
+		t.Error("wrong txqueuelen of the host veth for pod6", qlen)
+	}
+	out, err = exec.Command("ip", "netns", "exec", "pod6", "cat", "/proc/sys/net/ipv6/conf/eth0/accept_ra").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := strings.TrimSpace(string(out)); v != "0" {
+		t.Error("sysctl is not set for pod6", v)
+	}
+	out, err = exec.Command("ip", "netns", "exec", "pod6", "cat", "/sys/class/net/eth0/tx_queue_len").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := strings.TrimSpace(string(out)); v != "100" {
+		t.Error("wrong txqueuelen of the container veth for pod6", v)
+	}
+	e, err := ethtool.NewEthtool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	features, err := e.Features(hLink.Attrs().Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features["rx-gro"] {
+		t.Error("GRO is not disabled for pod6")
+	}
+
 	// destroy pod2 network
 	err = pn.Destroy(podConfMap["pod2"].ContainerId, podConfMap["pod2"].IFace)
 	if err != nil {
@@ -401,6 +497,10 @@ func TestPodNetwork(t *testing.T) {
 		}
 	}
 
+	if _, err := netlink.LinkByName(ifb); err == nil {
+		t.Error("ifb device for pod2 has not been destroyed")
+	}
+
 	// destroy should be idempotent
 	err = pn.Destroy(podConfMap["pod2"].ContainerId, podConfMap["pod2"].IFace)
 	if err != nil {
@@ -414,6 +514,21 @@ func TestPodNetwork(t *testing.T) {
 	}
 }
 
+func tbfRate(t *testing.T, l netlink.Link) uint64 {
+	t.Helper()
+
+	qdiscs, err := netlink.QdiscList(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range qdiscs {
+		if tbf, ok := q.(*netlink.Tbf); ok {
+			return tbf.Rate
+		}
+	}
+	return 0
+}
+
 func isDualStack(conf *PodNetConf) bool {
 	return conf.IPv4 != nil && conf.IPv6 != nil
 }
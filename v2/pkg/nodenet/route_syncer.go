@@ -10,6 +10,9 @@ import (
 )
 
 // GatewayInfo is a set of destination networks for a gateway.
+//
+// If Gateway is an IPv6 address, Networks may contain IPv4 networks.
+// Such networks are routed via the IPv6 next-hop as described in RFC 5549.
 type GatewayInfo struct {
 	Gateway  net.IP
 	Networks []*net.IPNet
@@ -24,20 +27,51 @@ type RouteSyncer interface {
 // NewRouteSyncer creates a DirectRouter that marks routes with protocolId.
 //
 // protocolId must be different from the ID for NewPodNetwork.
-func NewRouteSyncer(protocolId int, log logr.Logger) RouteSyncer {
+//
+// nexthopIface is the name of the network interface used to reach
+// IPv6 link-local gateways.  If empty, routes via link-local gateways
+// are not programmed.
+func NewRouteSyncer(protocolId int, nexthopIface string, log logr.Logger) RouteSyncer {
 	return &routeSyncer{
-		protocolId: netlink.RouteProtocol(protocolId),
-		log:        log,
+		protocolId:   netlink.RouteProtocol(protocolId),
+		nexthopIface: nexthopIface,
+		log:          log,
 	}
 }
 
 type routeSyncer struct {
-	protocolId netlink.RouteProtocol
-	log        logr.Logger
+	protocolId   netlink.RouteProtocol
+	nexthopIface string
+	log          logr.Logger
 
 	mu sync.Mutex
 }
 
+func routeKey(gw net.IP, dst *net.IPNet) string {
+	return gw.String() + " " + dst.String()
+}
+
+// routeGateway returns the next-hop address of r, which is either
+// in RTA_GATEWAY or in RTA_VIA.
+func routeGateway(r *netlink.Route) net.IP {
+	if via, ok := r.Via.(*netlink.Via); ok {
+		return via.Addr
+	}
+	return r.Gw
+}
+
+func (d *routeSyncer) nexthopLinkIndex() (int, error) {
+	if d.nexthopIface == "" {
+		return 0, nil
+	}
+
+	l, err := netlink.LinkByName(d.nexthopIface)
+	if err != nil {
+		return 0, fmt.Errorf("netlink: failed to find link %s: %w", d.nexthopIface, err)
+	}
+	return l.Attrs().Index, nil
+}
+
 func (d *routeSyncer) Sync(gis []GatewayInfo) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -48,22 +82,41 @@ func (d *routeSyncer) Sync(gis []GatewayInfo) error {
 		return fmt.Errorf("netlink: failed to list routes: %w", err)
 	}
 
+	linkIndex, err := d.nexthopLinkIndex()
+	if err != nil {
+		return err
+	}
+
 	routeMap := make(map[string]*netlink.Route)
 	for _, gi := range gis {
-		strIP := gi.Gateway.String()
+		isLinkLocal := gi.Gateway.IsLinkLocalUnicast()
+		if isLinkLocal && linkIndex == 0 {
+			d.log.Info("ignored link-local gateway as no next-hop interface is specified", "gateway", gi.Gateway.String())
+			continue
+		}
+
 		for _, n := range gi.Networks {
-			routeMap[strIP+" "+n.String()] = &netlink.Route{
+			r := &netlink.Route{
 				Dst:      n,
-				Gw:       gi.Gateway,
 				Scope:    netlink.SCOPE_UNIVERSE,
 				Protocol: d.protocolId,
 			}
+			if isLinkLocal {
+				r.LinkIndex = linkIndex
+			}
+			if n.IP.To4() != nil && gi.Gateway.To4() == nil {
+				// IPv4 destination via IPv6 next-hop (RFC 5549)
+				r.Via = &netlink.Via{AddrFamily: netlink.FAMILY_V6, Addr: gi.Gateway}
+			} else {
+				r.Gw = gi.Gateway
+			}
+			routeMap[routeKey(gi.Gateway, n)] = r
 		}
 	}
 
 	currentMap := make(map[string]bool)
 	for _, r := range routes {
-		key := r.Gw.String() + " " + r.Dst.String()
+		key := routeKey(routeGateway(&r), r.Dst)
 		if _, ok := routeMap[key]; !ok {
 			if err := netlink.RouteDel(&r); err != nil {
 				return fmt.Errorf("netlink: failed to delete route: %w", err)
@@ -65,7 +65,7 @@ func checkRoutingTable(r RouteSyncer, expected []GatewayInfo) error {
 	routeMap := make(map[string]bool)
 	var nCoil int
 	for _, r := range routes {
-		routeMap[r.Gw.String()+" "+r.Dst.String()] = true
+		routeMap[routeKey(routeGateway(&r), r.Dst)] = true
 		if r.Protocol == 31 {
 			nCoil++
 		}
@@ -98,7 +98,7 @@ func TestRouteSyncer(t *testing.T) {
 
 	setupFake(t)
 
-	r := NewRouteSyncer(31, ctrl.Log.WithName("test"))
+	r := NewRouteSyncer(31, "", ctrl.Log.WithName("test"))
 
 	gws := []GatewayInfo{
 		{net.ParseIP("10.9.0.2"), []*net.IPNet{
@@ -141,4 +141,18 @@ func TestRouteSyncer(t *testing.T) {
 	if err := checkRoutingTable(r, gws); err != nil {
 		t.Fatal(err)
 	}
+
+	// IPv4 networks via an IPv6 next-hop
+	gws = []GatewayInfo{
+		{net.ParseIP("10.9.0.2"), []*net.IPNet{
+			{IP: net.ParseIP("192.168.2.0"), Mask: net.CIDRMask(24, 32)},
+		}},
+		{net.ParseIP("fd09::2"), []*net.IPNet{
+			{IP: net.ParseIP("192.168.3.0"), Mask: net.CIDRMask(24, 32)},
+			{IP: net.ParseIP("fd03::0100"), Mask: net.CIDRMask(120, 128)},
+		}},
+	}
+	if err := checkRoutingTable(r, gws); err != nil {
+		t.Fatal(err)
+	}
 }
@@ -0,0 +1,72 @@
+package nodenet
+
+import (
+	"fmt"
+
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	"github.com/safchain/ethtool"
+	"github.com/vishvananda/netlink"
+)
+
+// PodTuning represents network parameters applied to a Pod.
+// Nil fields are left unchanged.
+type PodTuning struct {
+	// Sysctls are set in the container network namespace.
+	Sysctls map[string]string
+
+	// GRO, GSO, TSO, and TxQueueLen are applied to both ends of the veth pair.
+	GRO        *bool
+	GSO        *bool
+	TSO        *bool
+	TxQueueLen *int
+}
+
+func (t *PodTuning) features() map[string]bool {
+	features := make(map[string]bool)
+	if t.GRO != nil {
+		features["rx-gro"] = *t.GRO
+	}
+	if t.GSO != nil {
+		features["tx-generic-segmentation"] = *t.GSO
+	}
+	if t.TSO != nil {
+		features["tx-tcp-segmentation"] = *t.TSO
+		features["tx-tcp6-segmentation"] = *t.TSO
+	}
+	return features
+}
+
+// setSysctls sets sysctls in the current network namespace.
+func setSysctls(sysctls map[string]string) error {
+	for k, v := range sysctls {
+		if _, err := sysctl.Sysctl(k, v); err != nil {
+			return fmt.Errorf("failed to set sysctl %s=%s: %w", k, v, err)
+		}
+	}
+	return nil
+}
+
+// tuneLink applies offload settings and the transmit queue length to l.
+// l must be in the current network namespace.
+func tuneLink(l netlink.Link, t *PodTuning) error {
+	name := l.Attrs().Name
+
+	if features := t.features(); len(features) > 0 {
+		e, err := ethtool.NewEthtool()
+		if err != nil {
+			return fmt.Errorf("ethtool: failed to initialize: %w", err)
+		}
+		defer e.Close()
+
+		if err := e.Change(name, features); err != nil {
+			return fmt.Errorf("ethtool: failed to change features of %s: %w", name, err)
+		}
+	}
+
+	if t.TxQueueLen != nil {
+		if err := netlink.LinkSetTxQLen(l, *t.TxQueueLen); err != nil {
+			return fmt.Errorf("netlink: failed to set txqueuelen of %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package statesync
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// ServerName is the DNS name in the certificates of egress pods.
+	ServerName = "coil-egress-state-sync"
+
+	// CertFile and KeyFile are the names of the files of the key pair.
+	// They are the same as the keys of kubernetes.io/tls Secrets.
+	CertFile = "tls.crt"
+	KeyFile  = "tls.key"
+
+	certValidity = 10 * 365 * 24 * time.Hour
+)
+
+// Credentials are the transport credentials of Server and Syncer.
+type Credentials struct {
+	Server credentials.TransportCredentials
+	Client credentials.TransportCredentials
+}
+
+// InsecureCredentials returns Credentials without transport security.
+func InsecureCredentials() Credentials {
+	return Credentials{
+		Server: insecure.NewCredentials(),
+		Client: insecure.NewCredentials(),
+	}
+}
+
+// LoadCredentials loads the key pair from dir and returns Credentials for
+// mutual TLS authentication.  The certificate is self-signed and trusted by
+// itself only, so only the egress pods sharing the key pair can connect to
+// each other.
+func LoadCredentials(dir string) (Credentials, error) {
+	certPath := filepath.Join(dir, CertFile)
+	cert, err := tls.LoadX509KeyPair(certPath, filepath.Join(dir, KeyFile))
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to load the key pair in %s: %w", dir, err)
+	}
+	if len(cert.Certificate) == 0 {
+		return Credentials{}, errors.New("no certificate in " + certPath)
+	}
+	ca, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to parse %s: %w", certPath, err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	return Credentials{
+		Server: credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+			MinVersion:   tls.VersionTLS13,
+		}),
+		Client: credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+			ServerName:   ServerName,
+			MinVersion:   tls.VersionTLS13,
+		}),
+	}, nil
+}
+
+// GenerateKeyPair generates a self-signed certificate for ServerName and its
+// private key in PEM format.
+func GenerateKeyPair() (certPEM, keyPEM []byte, err error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: ServerName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{ServerName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, priv.Public(), priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create a certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal the private key: %w", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,221 @@
+package statesync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/cybozu-go/coil/v2/pkg/syncrpc"
+)
+
+const (
+	// pollInterval is the interval to scan the conntrack table for changes.
+	pollInterval = 1 * time.Second
+
+	// resyncPeriod is the period to send unchanged entries again so that
+	// the peers do not expire them while the flows are alive.
+	resyncPeriod = 10 * time.Second
+
+	// maxEntriesPerMessage limits the size of a message.
+	maxEntriesPerMessage = 1000
+)
+
+// Server serves the conntrack entries of flows SNATed to the source IPs.
+//
+// Server scans the conntrack table once per pollInterval regardless of
+// the number of peers, and fans the result out to their streams.
+type Server struct {
+	addr      string
+	table     Table
+	sourceIPs []net.IP
+	imported  *importLog
+	creds     credentials.TransportCredentials
+	logger    logr.Logger
+
+	mu   sync.Mutex
+	subs map[chan []*syncrpc.ConntrackEntry]struct{}
+}
+
+var _ manager.LeaderElectionRunnable = &Server{}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable.
+func (s *Server) Start(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+
+	grpcServer := grpc.NewServer(grpc.Creds(s.creds))
+	syncrpc.RegisterConntrackSyncServer(grpcServer, &watchServer{server: s})
+
+	go func() {
+		<-ctx.Done()
+		grpcServer.Stop()
+	}()
+	go s.poll(ctx)
+
+	s.logger.Info("start serving conntrack entries", "addr", s.addr)
+	return grpcServer.Serve(l)
+}
+
+// poll scans the conntrack table while there are peers watching.
+func (s *Server) poll(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.hasSubscribers() {
+			entries, err := s.list(time.Now())
+			if err != nil {
+				s.logger.Error(err, "failed to list conntrack entries")
+			} else {
+				s.publish(entries)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// list returns the entries to be exported.
+func (s *Server) list(now time.Time) ([]*syncrpc.ConntrackEntry, error) {
+	var entries []*syncrpc.ConntrackEntry
+	for _, ip := range s.sourceIPs {
+		es, err := s.table.List(ip)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, es...)
+	}
+
+	return slices.DeleteFunc(entries, func(e *syncrpc.ConntrackEntry) bool {
+		return s.imported.recent(entryKey(e), now)
+	}), nil
+}
+
+// subscribe returns a channel to receive the entries scanned by poll.
+// A slow subscriber receives only the latest entries.
+func (s *Server) subscribe() chan []*syncrpc.ConntrackEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan []*syncrpc.ConntrackEntry, 1)
+	s.subs[ch] = struct{}{}
+	return ch
+}
+
+func (s *Server) unsubscribe(ch chan []*syncrpc.ConntrackEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, ch)
+}
+
+func (s *Server) hasSubscribers() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs) > 0
+}
+
+// publish sends entries to all subscribers replacing the ones not received yet.
+// The entries are shared by the subscribers and must not be modified.
+func (s *Server) publish(entries []*syncrpc.ConntrackEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- entries
+	}
+}
+
+type watchServer struct {
+	syncrpc.UnimplementedConntrackSyncServer
+	server *Server
+}
+
+func (w *watchServer) Watch(_ *emptypb.Empty, stream grpc.ServerStreamingServer[syncrpc.ConntrackEntries]) error {
+	s := w.server
+	ch := s.subscribe()
+	defer s.unsubscribe(ch)
+
+	t := newTracker(resyncPeriod)
+	for {
+		var entries []*syncrpc.ConntrackEntry
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case entries = <-ch:
+		}
+
+		changed := t.update(entries, time.Now())
+		for len(changed) > 0 {
+			n := min(len(changed), maxEntriesPerMessage)
+			if err := stream.Send(&syncrpc.ConntrackEntries{Entries: changed[:n]}); err != nil {
+				return err
+			}
+			changed = changed[n:]
+		}
+	}
+}
+
+type sentEntry struct {
+	tcpState uint32
+	status   uint32
+	at       time.Time
+}
+
+// tracker remembers the entries sent to a peer to send only changed ones.
+type tracker struct {
+	resync time.Duration
+	sent   map[string]sentEntry
+}
+
+func newTracker(resync time.Duration) *tracker {
+	return &tracker{
+		resync: resync,
+		sent:   make(map[string]sentEntry),
+	}
+}
+
+// update returns entries that are new, changed, or not sent for the resync period.
+func (t *tracker) update(entries []*syncrpc.ConntrackEntry, now time.Time) []*syncrpc.ConntrackEntry {
+	var changed []*syncrpc.ConntrackEntry
+	current := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		key := entryKey(e)
+		current[key] = struct{}{}
+
+		prev, ok := t.sent[key]
+		if ok && prev.tcpState == e.TcpState && prev.status == e.Status && now.Sub(prev.at) < t.resync {
+			continue
+		}
+		t.sent[key] = sentEntry{tcpState: e.TcpState, status: e.Status, at: now}
+		changed = append(changed, e)
+	}
+
+	for key := range t.sent {
+		if _, ok := current[key]; !ok {
+			delete(t.sent, key)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,346 @@
+package statesync
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	"github.com/cybozu-go/coil/v2/pkg/syncrpc"
+)
+
+func makeEntry(clientIP string, clientPort uint32, tcpState uint32) *syncrpc.ConntrackEntry {
+	return &syncrpc.ConntrackEntry{
+		Family:   4,
+		Protocol: unix.IPPROTO_TCP,
+		Original: &syncrpc.Tuple{SrcIp: clientIP, DstIp: "192.0.2.1", SrcPort: clientPort, DstPort: 443},
+		Reply:    &syncrpc.Tuple{SrcIp: "192.0.2.1", DstIp: "198.51.100.1", SrcPort: 443, DstPort: clientPort},
+		Timeout:  100,
+		TcpState: tcpState,
+	}
+}
+
+type fakeTable struct {
+	mu      sync.Mutex
+	entries map[string]*syncrpc.ConntrackEntry
+	lists   int
+}
+
+func newFakeTable(entries ...*syncrpc.ConntrackEntry) *fakeTable {
+	t := &fakeTable{entries: make(map[string]*syncrpc.ConntrackEntry)}
+	for _, e := range entries {
+		t.entries[entryKey(e)] = e
+	}
+	return t
+}
+
+func (t *fakeTable) List(src net.IP) ([]*syncrpc.ConntrackEntry, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lists++
+	var entries []*syncrpc.ConntrackEntry
+	for _, e := range t.entries {
+		if net.ParseIP(e.Reply.DstIp).Equal(src) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (t *fakeTable) Put(e *syncrpc.ConntrackEntry) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries[entryKey(e)] = e
+	return nil
+}
+
+func (t *fakeTable) get(key string) *syncrpc.ConntrackEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.entries[key]
+}
+
+func (t *fakeTable) listCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lists
+}
+
+func TestConversion(t *testing.T) {
+	e := makeEntry("10.64.0.1", 40000, 3)
+	e.Status = ipsSeenReply | ipsAssured | ipsConfirmed | ipsSrcNAT | ipsSrcNATDone | ipsDstNATDone
+	family, attrs, err := entryToAttrs(e, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if family != unix.AF_INET {
+		t.Errorf("unexpected family: %d", family)
+	}
+	_, created, err := entryToAttrs(e, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != len(attrs)+1 {
+		t.Error("SNATed entry should be created with NAT setup")
+	}
+
+	msg := (&nl.Nfgenmsg{NfgenFamily: family}).Serialize()
+	for _, a := range append(attrs, statusAttr(e)) {
+		msg = append(msg, a.Serialize()...)
+	}
+	got, err := messageToEntry(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(e, got, protocmp.Transform()); diff != "" {
+		t.Errorf("round trip mismatch (-want +got):\n%s", diff)
+	}
+
+	e.Family = 5
+	if _, _, err := entryToAttrs(e, false); err == nil {
+		t.Error("invalid family should be rejected")
+	}
+	e = makeEntry("10.64.0.1", 40000, 3)
+	e.Reply.SrcIp = "foo"
+	if _, _, err := entryToAttrs(e, false); err == nil {
+		t.Error("invalid address should be rejected")
+	}
+	e = makeEntry("10.64.0.1", 40000, 3)
+	e.Reply.SrcIp = "fd02::1"
+	if _, _, err := entryToAttrs(e, false); err == nil {
+		t.Error("IPv6 address in IPv4 entry should be rejected")
+	}
+	e = makeEntry("10.64.0.1", 40000, 3)
+	e.Protocol = unix.IPPROTO_ICMP
+	if _, _, err := entryToAttrs(e, false); err == nil {
+		t.Error("ICMP should be rejected")
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tr := newTracker(10 * time.Second)
+	now := time.Now()
+	e1 := makeEntry("10.64.0.1", 40000, 3)
+	e2 := makeEntry("10.64.0.2", 40000, 3)
+
+	if changed := tr.update([]*syncrpc.ConntrackEntry{e1, e2}, now); len(changed) != 2 {
+		t.Errorf("all entries should be sent first: %d", len(changed))
+	}
+	if changed := tr.update([]*syncrpc.ConntrackEntry{e1, e2}, now.Add(time.Second)); len(changed) != 0 {
+		t.Errorf("unchanged entries should not be sent: %d", len(changed))
+	}
+
+	e1 = makeEntry("10.64.0.1", 40000, 5)
+	changed := tr.update([]*syncrpc.ConntrackEntry{e1, e2}, now.Add(2*time.Second))
+	if len(changed) != 1 || changed[0] != e1 {
+		t.Errorf("changed entry should be sent: %v", changed)
+	}
+	e2 = makeEntry("10.64.0.2", 40000, 3)
+	e2.Status = ipsAssured
+	changed = tr.update([]*syncrpc.ConntrackEntry{e1, e2}, now.Add(2*time.Second))
+	if len(changed) != 1 || changed[0] != e2 {
+		t.Errorf("entry with changed status should be sent: %v", changed)
+	}
+
+	changed = tr.update([]*syncrpc.ConntrackEntry{e1}, now.Add(11*time.Second))
+	if len(changed) != 0 {
+		t.Errorf("e1 should not be sent before the resync period: %v", changed)
+	}
+	if len(tr.sent) != 1 {
+		t.Errorf("removed entries should be forgotten: %d", len(tr.sent))
+	}
+
+	changed = tr.update([]*syncrpc.ConntrackEntry{e1}, now.Add(12*time.Second))
+	if len(changed) != 1 {
+		t.Errorf("e1 should be sent again after the resync period: %v", changed)
+	}
+}
+
+func TestImportLog(t *testing.T) {
+	l := newImportLog(30 * time.Second)
+	now := time.Now()
+	l.record("a", now)
+	l.record("b", now.Add(20*time.Second))
+
+	if !l.recent("a", now.Add(10*time.Second)) {
+		t.Error("a should be recent")
+	}
+	if l.recent("c", now) {
+		t.Error("c is not imported")
+	}
+
+	l.prune(now.Add(40 * time.Second))
+	if l.recent("a", now.Add(40*time.Second)) {
+		t.Error("a should be expired")
+	}
+	if !l.recent("b", now.Add(40*time.Second)) {
+		t.Error("b should be recent")
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	s := &Server{subs: make(map[chan []*syncrpc.ConntrackEntry]struct{})}
+	if s.hasSubscribers() {
+		t.Error("no subscribers yet")
+	}
+	ch1 := s.subscribe()
+	ch2 := s.subscribe()
+
+	old := []*syncrpc.ConntrackEntry{makeEntry("10.64.0.1", 40000, 3)}
+	latest := []*syncrpc.ConntrackEntry{makeEntry("10.64.0.2", 40000, 3)}
+	s.publish(old)
+	s.publish(latest)
+	for _, ch := range []chan []*syncrpc.ConntrackEntry{ch1, ch2} {
+		if got := <-ch; len(got) != 1 || got[0] != latest[0] {
+			t.Errorf("subscribers should receive only the latest entries: %v", got)
+		}
+	}
+
+	s.unsubscribe(ch1)
+	if !s.hasSubscribers() {
+		t.Error("ch2 is still subscribing")
+	}
+	s.unsubscribe(ch2)
+	if s.hasSubscribers() {
+		t.Error("no subscribers should remain")
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func waitSynced(t *testing.T, table *fakeTable, key string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for table.get(key) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("entry was not synchronized")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func staticPeers(addr string) PeerLister {
+	return func(context.Context) ([]string, error) {
+		return []string{addr}, nil
+	}
+}
+
+func TestSync(t *testing.T) {
+	srcIP := net.ParseIP("198.51.100.1")
+	local := makeEntry("10.64.0.1", 40000, 3)
+	other := makeEntry("10.64.0.2", 40000, 3)
+	other.Reply.DstIp = "198.51.100.2"
+	tableA := newFakeTable(local, other)
+	tableB := newFakeTable()
+
+	tableC := newFakeTable()
+	addr := freeAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	creds := InsecureCredentials()
+	serverA, _ := New(addr, tableA, []net.IP{srcIP}, nil, creds, logr.Discard())
+	_, syncerB := New("", tableB, []net.IP{srcIP}, staticPeers(addr), creds, logr.Discard())
+	_, syncerC := New("", tableC, []net.IP{srcIP}, staticPeers(addr), creds, logr.Discard())
+
+	go serverA.Start(ctx)
+	go syncerB.Start(ctx)
+	go syncerC.Start(ctx)
+
+	key := entryKey(local)
+	waitSynced(t, tableB, key)
+	waitSynced(t, tableC, key)
+
+	// the conntrack table is scanned once per interval for all peers.
+	before := tableA.listCount()
+	time.Sleep(3 * pollInterval)
+	if n := tableA.listCount() - before; n > 4 {
+		t.Errorf("conntrack table is scanned too often: %d", n)
+	}
+
+	if tableB.get(entryKey(other)) != nil {
+		t.Error("entries for other source addresses should not be synchronized")
+	}
+	if !syncerB.imported.recent(key, time.Now()) {
+		t.Error("synchronized entry should be recorded as imported")
+	}
+}
+
+func TestSyncTLS(t *testing.T) {
+	writeKeyPair := func() string {
+		dir := t.TempDir()
+		certPEM, keyPEM, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, CertFile), certPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, KeyFile), keyPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	creds, err := LoadCredentials(writeKeyPair())
+	if err != nil {
+		t.Fatal(err)
+	}
+	others, err := LoadCredentials(writeKeyPair())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCredentials(t.TempDir()); err == nil {
+		t.Error("loading credentials from an empty directory should fail")
+	}
+
+	srcIP := net.ParseIP("198.51.100.1")
+	local := makeEntry("10.64.0.1", 40000, 3)
+	tableA := newFakeTable(local)
+	tableB := newFakeTable()
+	tableC := newFakeTable()
+	tableD := newFakeTable()
+	addr := freeAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverA, _ := New(addr, tableA, []net.IP{srcIP}, nil, creds, logr.Discard())
+	_, syncerB := New("", tableB, []net.IP{srcIP}, staticPeers(addr), creds, logr.Discard())
+	_, syncerC := New("", tableC, []net.IP{srcIP}, staticPeers(addr), others, logr.Discard())
+	_, syncerD := New("", tableD, []net.IP{srcIP}, staticPeers(addr), InsecureCredentials(), logr.Discard())
+
+	go serverA.Start(ctx)
+	go syncerB.Start(ctx)
+	go syncerC.Start(ctx)
+	go syncerD.Start(ctx)
+
+	key := entryKey(local)
+	waitSynced(t, tableB, key)
+
+	time.Sleep(2 * pollInterval)
+	if tableC.get(key) != nil {
+		t.Error("peers with another key pair should be rejected")
+	}
+	if tableD.get(key) != nil {
+		t.Error("peers without TLS should be rejected")
+	}
+}
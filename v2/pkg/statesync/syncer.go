@@ -0,0 +1,226 @@
+package statesync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/cybozu-go/coil/v2/pkg/syncrpc"
+)
+
+const (
+	// peerRefreshInterval is the interval to look up the peers.
+	peerRefreshInterval = 10 * time.Second
+
+	// retryInterval is the interval to reconnect to a peer.
+	retryInterval = 3 * time.Second
+
+	// importExpiration is the period after which an imported entry is regarded
+	// as owned by this egress pod unless the peer sends it again.
+	importExpiration = 3 * resyncPeriod
+)
+
+// PeerLister returns the addresses of the peers in host:port form.
+type PeerLister func(ctx context.Context) ([]string, error)
+
+// Syncer imports conntrack entries from the peers into the local table.
+//
+// Entries are never deleted by Syncer.  They expire by the timeouts
+// sent from the peers unless the flows come to this egress pod.
+type Syncer struct {
+	table    Table
+	peers    PeerLister
+	imported *importLog
+	creds    credentials.TransportCredentials
+	logger   logr.Logger
+}
+
+var _ manager.LeaderElectionRunnable = &Syncer{}
+
+// New creates a Server listening on addr and a Syncer importing entries from peers.
+//
+// Server does not export entries recently imported by Syncer, so that the
+// entries do not return to the peers.  Once the peer stops sending them,
+// e.g. because it is lost, Server exports them to the remaining peers.
+func New(addr string, table Table, sourceIPs []net.IP, peers PeerLister, creds Credentials, logger logr.Logger) (*Server, *Syncer) {
+	imported := newImportLog(importExpiration)
+	server := &Server{
+		addr:      addr,
+		table:     table,
+		sourceIPs: sourceIPs,
+		imported:  imported,
+		creds:     creds.Server,
+		logger:    logger.WithName("server"),
+		subs:      make(map[chan []*syncrpc.ConntrackEntry]struct{}),
+	}
+	syncer := &Syncer{
+		table:    table,
+		peers:    peers,
+		imported: imported,
+		creds:    creds.Client,
+		logger:   logger.WithName("syncer"),
+	}
+	return server, syncer
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (s *Syncer) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable.
+func (s *Syncer) Start(ctx context.Context) error {
+	var wg sync.WaitGroup
+	cancels := make(map[string]context.CancelFunc)
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+		wg.Wait()
+	}()
+
+	ticker := time.NewTicker(peerRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		s.imported.prune(time.Now())
+
+		addrs, err := s.peers(ctx)
+		if err != nil {
+			s.logger.Error(err, "failed to list peers")
+		} else {
+			current := make(map[string]struct{}, len(addrs))
+			for _, addr := range addrs {
+				current[addr] = struct{}{}
+				if _, ok := cancels[addr]; ok {
+					continue
+				}
+				s.logger.Info("start syncing with a peer", "peer", addr)
+				peerCtx, cancel := context.WithCancel(ctx)
+				cancels[addr] = cancel
+				wg.Go(func() {
+					s.watch(peerCtx, addr)
+				})
+			}
+			for addr, cancel := range cancels {
+				if _, ok := current[addr]; ok {
+					continue
+				}
+				s.logger.Info("stop syncing with a peer", "peer", addr)
+				cancel()
+				delete(cancels, addr)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Syncer) watch(ctx context.Context, addr string) {
+	for {
+		err := s.watchOnce(ctx, addr)
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger.Error(err, "lost connection to a peer", "peer", addr)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (s *Syncer) watchOnce(ctx context.Context, addr string) error {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(s.creds))
+	if err != nil {
+		return fmt.Errorf("failed to create a client: %w", err)
+	}
+	defer conn.Close()
+
+	stream, err := syncrpc.NewConntrackSyncClient(conn).Watch(ctx, &emptypb.Empty{})
+	if err != nil {
+		return fmt.Errorf("failed to watch: %w", err)
+	}
+
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return fmt.Errorf("failed to receive entries: %w", err)
+		}
+
+		var failed int
+		var lastErr error
+		now := time.Now()
+		for _, e := range msg.Entries {
+			s.imported.record(entryKey(e), now)
+			if err := s.table.Put(e); err != nil {
+				failed++
+				lastErr = err
+			}
+		}
+		if failed > 0 {
+			s.logger.Error(lastErr, "failed to import conntrack entries", "peer", addr, "failed", failed, "total", len(msg.Entries))
+		}
+	}
+}
+
+// importLog remembers when entries are imported from the peers.
+type importLog struct {
+	expiration time.Duration
+
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+func newImportLog(expiration time.Duration) *importLog {
+	return &importLog{
+		expiration: expiration,
+		entries:    make(map[string]time.Time),
+	}
+}
+
+func (l *importLog) record(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[key] = now
+}
+
+// recent returns true if the entry has been imported within the expiration period.
+func (l *importLog) recent(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	at, ok := l.entries[key]
+	if !ok {
+		return false
+	}
+	if now.Sub(at) >= l.expiration {
+		delete(l.entries, key)
+		return false
+	}
+	return true
+}
+
+// prune forgets expired entries.
+func (l *importLog) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, at := range l.entries {
+		if now.Sub(at) >= l.expiration {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package statesync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+
+	"github.com/cybozu-go/coil/v2/pkg/syncrpc"
+)
+
+// Table represents the conntrack table of an egress pod.
+type Table interface {
+	// List returns the entries of flows SNATed to src.
+	List(src net.IP) ([]*syncrpc.ConntrackEntry, error)
+
+	// Put creates an entry or updates the existing one.
+	Put(e *syncrpc.ConntrackEntry) error
+}
+
+// NewTable returns a Table that manipulates the kernel conntrack table via netlink.
+func NewTable() Table {
+	return nlTable{}
+}
+
+// Attributes of ctnetlink not defined in the nl package.
+// See include/uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	ctaNATSrc = 6
+
+	ctaNATV4MinIP = 1
+	ctaNATV4MaxIP = 2
+	ctaNATProto   = 3
+	ctaNATV6MinIP = 4
+	ctaNATV6MaxIP = 5
+
+	ctaProtoNATPortMin = 1
+	ctaProtoNATPortMax = 2
+
+	nlaTypeMask = ^uint16(unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+)
+
+// Status bits of conntrack entries.
+// See include/uapi/linux/netfilter/nf_conntrack_common.h.
+const (
+	ipsSeenReply  = 1 << 1
+	ipsAssured    = 1 << 2
+	ipsConfirmed  = 1 << 3
+	ipsSrcNAT     = 1 << 4
+	ipsSrcNATDone = 1 << 7
+	ipsDstNATDone = 1 << 8
+
+	// syncedStatus is the status bits synced between egress pods.
+	// The other bits are local to the kernel that created the entry.
+	syncedStatus = ipsSeenReply | ipsAssured | ipsConfirmed | ipsSrcNAT | ipsSrcNATDone | ipsDstNATDone
+)
+
+// nlTable talks ctnetlink directly because the netlink library neither
+// sends nor parses the status and the NAT setup of the entries.
+// Without them, imported entries are not SNATed by the kernel.
+type nlTable struct{}
+
+func (nlTable) List(src net.IP) ([]*syncrpc.ConntrackEntry, error) {
+	family := unix.AF_INET
+	if src.To4() == nil {
+		family = unix.AF_INET6
+	}
+
+	req := newConntrackRequest(nl.IPCTNL_MSG_CT_GET, unix.NLM_F_DUMP, uint8(family))
+	msgs, err := req.Execute(unix.NETLINK_NETFILTER, 0)
+	if err != nil {
+		return nil, fmt.Errorf("netlink: failed to list conntrack entries: %w", err)
+	}
+
+	var entries []*syncrpc.ConntrackEntry
+	for _, msg := range msgs {
+		e, err := messageToEntry(msg)
+		if err != nil {
+			return nil, fmt.Errorf("netlink: failed to parse conntrack entry: %w", err)
+		}
+		// entries of ICMP and the like are not synced as their tuples have no ports.
+		if !hasPorts(e.Protocol) {
+			continue
+		}
+		// the destination of the reply tuple is the SNATed source address.
+		if !net.ParseIP(e.Reply.DstIp).Equal(src) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (nlTable) Put(e *syncrpc.ConntrackEntry) error {
+	family, attrs, err := entryToAttrs(e, false)
+	if err != nil {
+		return err
+	}
+
+	// The status bits that have been set cannot be cleared.  If the local
+	// entry has such bits, update it without the status.
+	err = execConntrackRequest(0, family, attrs, statusAttr(e))
+	if errors.Is(err, unix.EBUSY) {
+		err = execConntrackRequest(0, family, attrs)
+	}
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, unix.ENOENT) {
+		return fmt.Errorf("netlink: failed to update conntrack entry %s: %w", entryKey(e), err)
+	}
+
+	_, attrs, err = entryToAttrs(e, true)
+	if err != nil {
+		return err
+	}
+	if err := execConntrackRequest(unix.NLM_F_CREATE, family, attrs, statusAttr(e)); err != nil {
+		return fmt.Errorf("netlink: failed to create conntrack entry %s: %w", entryKey(e), err)
+	}
+	return nil
+}
+
+func newConntrackRequest(msgType, flags int, family uint8) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest((unix.NFNL_SUBSYS_CTNETLINK<<8)|msgType, flags)
+	req.AddData(&nl.Nfgenmsg{
+		NfgenFamily: family,
+		Version:     nl.NFNETLINK_V0,
+	})
+	return req
+}
+
+func execConntrackRequest(flags int, family uint8, attrs []*nl.RtAttr, extra ...*nl.RtAttr) error {
+	req := newConntrackRequest(nl.IPCTNL_MSG_CT_NEW, unix.NLM_F_ACK|flags, family)
+	for _, a := range attrs {
+		req.AddData(a)
+	}
+	for _, a := range extra {
+		req.AddData(a)
+	}
+	_, err := req.Execute(unix.NETLINK_NETFILTER, 0)
+	return err
+}
+
+func hasPorts(protocol uint32) bool {
+	switch protocol {
+	case unix.IPPROTO_TCP, unix.IPPROTO_UDP, unix.IPPROTO_UDPLITE, unix.IPPROTO_SCTP, unix.IPPROTO_DCCP:
+		return true
+	}
+	return false
+}
+
+// entryToAttrs returns the address family and the attributes of e other than the status.
+//
+// NAT can be set up only when the entry is created, so create must be true
+// for a new entry.  The kernel sets IPS_SRC_NAT only if NAT changes the
+// reply tuple, so the entry is created with the reply tuple of the flow
+// before SNAT and the NAT setup that translates it to the reply tuple of e.
+func entryToAttrs(e *syncrpc.ConntrackEntry, create bool) (uint8, []*nl.RtAttr, error) {
+	var family uint8
+	switch e.Family {
+	case 4:
+		family = unix.AF_INET
+	case 6:
+		family = unix.AF_INET6
+	default:
+		return 0, nil, fmt.Errorf("invalid address family: %d", e.Family)
+	}
+	if !hasPorts(e.Protocol) {
+		return 0, nil, fmt.Errorf("unsupported protocol: %d", e.Protocol)
+	}
+
+	orig, err := tupleAttr(nl.CTA_TUPLE_ORIG, e.Original, family, uint8(e.Protocol))
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid original tuple: %w", err)
+	}
+	reply, err := tupleAttr(nl.CTA_TUPLE_REPLY, e.Reply, family, uint8(e.Protocol))
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid reply tuple: %w", err)
+	}
+	var nat *nl.RtAttr
+	if create {
+		nat, err = natAttr(e, family)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if nat != nil {
+		o := e.Original
+		inverted := &syncrpc.Tuple{SrcIp: o.DstIp, DstIp: o.SrcIp, SrcPort: o.DstPort, DstPort: o.SrcPort}
+		reply, err = tupleAttr(nl.CTA_TUPLE_REPLY, inverted, family, uint8(e.Protocol))
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid original tuple: %w", err)
+		}
+	}
+	attrs := []*nl.RtAttr{
+		orig,
+		reply,
+		nl.NewRtAttr(nl.CTA_TIMEOUT, nl.BEUint32Attr(e.Timeout)),
+	}
+	if nat != nil {
+		attrs = append(attrs, nat)
+	}
+
+	if e.Protocol == unix.IPPROTO_TCP {
+		info := nl.NewRtAttr(unix.NLA_F_NESTED|nl.CTA_PROTOINFO, nil)
+		tcp := info.AddRtAttr(unix.NLA_F_NESTED|nl.CTA_PROTOINFO_TCP, nil)
+		tcp.AddRtAttr(nl.CTA_PROTOINFO_TCP_STATE, nl.Uint8Attr(uint8(e.TcpState)))
+		attrs = append(attrs, info)
+	}
+	return family, attrs, nil
+}
+
+// statusAttr returns the CTA_STATUS attribute of e.
+// The entry is always confirmed as the kernel confirms entries created via netlink.
+func statusAttr(e *syncrpc.ConntrackEntry) *nl.RtAttr {
+	return nl.NewRtAttr(nl.CTA_STATUS, nl.BEUint32Attr(e.Status&syncedStatus|ipsConfirmed))
+}
+
+// natAttr returns the CTA_NAT_SRC attribute to translate the source of
+// the original tuple of e to the destination of the reply tuple.
+// It returns nil if the flow is not SNATed.
+func natAttr(e *syncrpc.ConntrackEntry, family uint8) (*nl.RtAttr, error) {
+	src := net.ParseIP(e.Original.SrcIp)
+	nat := net.ParseIP(e.Reply.DstIp)
+	if src.Equal(nat) && e.Original.SrcPort == e.Reply.DstPort {
+		return nil, nil
+	}
+
+	minType, maxType := ctaNATV4MinIP, ctaNATV4MaxIP
+	ip := nat.To4()
+	if family == unix.AF_INET6 {
+		minType, maxType = ctaNATV6MinIP, ctaNATV6MaxIP
+		ip = nat.To16()
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("invalid SNAT address: %s", e.Reply.DstIp)
+	}
+
+	a := nl.NewRtAttr(unix.NLA_F_NESTED|ctaNATSrc, nil)
+	a.AddRtAttr(minType, ip)
+	a.AddRtAttr(maxType, ip)
+	proto := a.AddRtAttr(unix.NLA_F_NESTED|ctaNATProto, nil)
+	proto.AddRtAttr(ctaProtoNATPortMin, nl.BEUint16Attr(uint16(e.Reply.DstPort)))
+	proto.AddRtAttr(ctaProtoNATPortMax, nl.BEUint16Attr(uint16(e.Reply.DstPort)))
+	return a, nil
+}
+
+func tupleAttr(attrType int, t *syncrpc.Tuple, family, protocol uint8) (*nl.RtAttr, error) {
+	if t == nil {
+		return nil, fmt.Errorf("missing tuple")
+	}
+	src := net.ParseIP(t.SrcIp)
+	if src == nil {
+		return nil, fmt.Errorf("invalid source address: %s", t.SrcIp)
+	}
+	dst := net.ParseIP(t.DstIp)
+	if dst == nil {
+		return nil, fmt.Errorf("invalid destination address: %s", t.DstIp)
+	}
+	if t.SrcPort > 65535 || t.DstPort > 65535 {
+		return nil, fmt.Errorf("invalid port: %d, %d", t.SrcPort, t.DstPort)
+	}
+
+	srcType, dstType := nl.CTA_IP_V4_SRC, nl.CTA_IP_V4_DST
+	if family == unix.AF_INET6 {
+		srcType, dstType = nl.CTA_IP_V6_SRC, nl.CTA_IP_V6_DST
+		if src.To4() != nil || dst.To4() != nil {
+			return nil, fmt.Errorf("IPv4 address in IPv6 tuple: %s, %s", t.SrcIp, t.DstIp)
+		}
+	} else {
+		// ctnetlink requires 4-byte addresses for IPv4.
+		src, dst = src.To4(), dst.To4()
+		if src == nil || dst == nil {
+			return nil, fmt.Errorf("IPv6 address in IPv4 tuple: %s, %s", t.SrcIp, t.DstIp)
+		}
+	}
+
+	a := nl.NewRtAttr(unix.NLA_F_NESTED|attrType, nil)
+	ip := a.AddRtAttr(unix.NLA_F_NESTED|nl.CTA_TUPLE_IP, nil)
+	ip.AddRtAttr(srcType, src)
+	ip.AddRtAttr(dstType, dst)
+	proto := a.AddRtAttr(unix.NLA_F_NESTED|nl.CTA_TUPLE_PROTO, nil)
+	proto.AddRtAttr(nl.CTA_PROTO_NUM, nl.Uint8Attr(protocol))
+	proto.AddRtAttr(nl.CTA_PROTO_SRC_PORT, nl.BEUint16Attr(uint16(t.SrcPort)))
+	proto.AddRtAttr(nl.CTA_PROTO_DST_PORT, nl.BEUint16Attr(uint16(t.DstPort)))
+	return a, nil
+}
+
+// messageToEntry parses a ctnetlink message that starts with the nfgenmsg header.
+func messageToEntry(msg []byte) (*syncrpc.ConntrackEntry, error) {
+	if len(msg) < nl.SizeofNfgenmsg {
+		return nil, fmt.Errorf("too short message: %d bytes", len(msg))
+	}
+	e := &syncrpc.ConntrackEntry{}
+	switch msg[0] {
+	case unix.AF_INET:
+		e.Family = 4
+	case unix.AF_INET6:
+		e.Family = 6
+	default:
+		return nil, fmt.Errorf("invalid address family: %d", msg[0])
+	}
+
+	attrs, err := nl.ParseRouteAttr(msg[nl.SizeofNfgenmsg:])
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attrs {
+		switch a.Attr.Type & nlaTypeMask {
+		case nl.CTA_TUPLE_ORIG:
+			e.Original, e.Protocol, err = parseTuple(a.Value)
+		case nl.CTA_TUPLE_REPLY:
+			e.Reply, _, err = parseTuple(a.Value)
+		case nl.CTA_STATUS:
+			var status uint32
+			status, err = parseUint32(a.Value)
+			e.Status = status & syncedStatus
+		case nl.CTA_TIMEOUT:
+			e.Timeout, err = parseUint32(a.Value)
+		case nl.CTA_PROTOINFO:
+			e.TcpState, err = parseTCPState(a.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if e.Original == nil || e.Reply == nil {
+		return nil, fmt.Errorf("missing tuple")
+	}
+	return e, nil
+}
+
+func parseTuple(b []byte) (*syncrpc.Tuple, uint32, error) {
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	t := &syncrpc.Tuple{}
+	var protocol uint32
+	for _, a := range attrs {
+		switch a.Attr.Type & nlaTypeMask {
+		case nl.CTA_TUPLE_IP:
+			ips, err := nl.ParseRouteAttr(a.Value)
+			if err != nil {
+				return nil, 0, err
+			}
+			for _, ip := range ips {
+				switch ip.Attr.Type & nlaTypeMask {
+				case nl.CTA_IP_V4_SRC, nl.CTA_IP_V6_SRC:
+					t.SrcIp = net.IP(ip.Value).String()
+				case nl.CTA_IP_V4_DST, nl.CTA_IP_V6_DST:
+					t.DstIp = net.IP(ip.Value).String()
+				}
+			}
+		case nl.CTA_TUPLE_PROTO:
+			protos, err := nl.ParseRouteAttr(a.Value)
+			if err != nil {
+				return nil, 0, err
+			}
+			for _, p := range protos {
+				if len(p.Value) == 0 {
+					return nil, 0, fmt.Errorf("empty attribute: %d", p.Attr.Type)
+				}
+				switch p.Attr.Type & nlaTypeMask {
+				case nl.CTA_PROTO_NUM:
+					protocol = uint32(p.Value[0])
+				case nl.CTA_PROTO_SRC_PORT:
+					t.SrcPort, err = parseUint16(p.Value)
+				case nl.CTA_PROTO_DST_PORT:
+					t.DstPort, err = parseUint16(p.Value)
+				}
+				if err != nil {
+					return nil, 0, err
+				}
+			}
+		}
+	}
+	return t, protocol, nil
+}
+
+func parseTCPState(b []byte) (uint32, error) {
+	infos, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return 0, err
+	}
+	for _, info := range infos {
+		if info.Attr.Type&nlaTypeMask != nl.CTA_PROTOINFO_TCP {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(info.Value)
+		if err != nil {
+			return 0, err
+		}
+		for _, a := range attrs {
+			if a.Attr.Type&nlaTypeMask == nl.CTA_PROTOINFO_TCP_STATE && len(a.Value) > 0 {
+				return uint32(a.Value[0]), nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+func parseUint16(b []byte) (uint32, error) {
+	if len(b) < 2 {
+		return 0, fmt.Errorf("too short attribute: %d bytes", len(b))
+	}
+	return uint32(binary.BigEndian.Uint16(b)), nil
+}
+
+func parseUint32(b []byte) (uint32, error) {
+	if len(b) < 4 {
+		return 0, fmt.Errorf("too short attribute: %d bytes", len(b))
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func entryKey(e *syncrpc.ConntrackEntry) string {
+	o := e.GetOriginal()
+	return fmt.Sprintf("%d %s:%d -> %s:%d", e.Protocol, o.GetSrcIp(), o.GetSrcPort(), o.GetDstIp(), o.GetDstPort())
+}
@@ -0,0 +1,127 @@
+//go:build privileged
+
+package statesync
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/cybozu-go/coil/v2/pkg/syncrpc"
+)
+
+func TestNLTable(t *testing.T) {
+	tns, err := testutils.NewNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tns.Close()
+
+	err = tns.Do(func(ns.NetNS) error {
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := netlink.LinkSetUp(lo); err != nil {
+			t.Fatal(err)
+		}
+		// the client, the server, and the SNAT address
+		for _, addr := range []string{"10.64.0.1/32", "192.0.2.1/32", "198.51.100.1/32"} {
+			a, _ := netlink.ParseAddr(addr)
+			if err := netlink.AddrAdd(lo, a); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Conntrack and NAT work only when a masquerade rule exists like in egress pods.
+		// ex. nft add rule ip nat postrouting ip saddr 10.100.0.1 masquerade
+		conn, err := nftables.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		table := conn.AddTable(&nftables.Table{Family: nftables.TableFamilyIPv4, Name: "nat"})
+		chain := conn.AddChain(&nftables.Chain{
+			Name:     "postrouting",
+			Table:    table,
+			Type:     nftables.ChainTypeNAT,
+			Hooknum:  nftables.ChainHookPostrouting,
+			Priority: nftables.ChainPriorityNATSource,
+		})
+		conn.AddRule(&nftables.Rule{
+			Table: table,
+			Chain: chain,
+			Exprs: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: net.ParseIP("10.100.0.1").To4()},
+				&expr.Masq{},
+			},
+		})
+		if err := conn.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		e := &syncrpc.ConntrackEntry{
+			Family:   4,
+			Protocol: unix.IPPROTO_UDP,
+			Original: &syncrpc.Tuple{SrcIp: "10.64.0.1", DstIp: "192.0.2.1", SrcPort: 40000, DstPort: 8000},
+			Reply:    &syncrpc.Tuple{SrcIp: "192.0.2.1", DstIp: "198.51.100.1", SrcPort: 8000, DstPort: 50000},
+			Timeout:  100,
+			Status:   ipsSeenReply | ipsAssured | ipsConfirmed | ipsSrcNAT | ipsSrcNATDone | ipsDstNATDone,
+		}
+		tbl := NewTable()
+		if err := tbl.Put(e); err != nil {
+			t.Fatal(err)
+		}
+		// the second Put updates the entry
+		e.Timeout = 120
+		if err := tbl.Put(e); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := tbl.List(net.ParseIP("198.51.100.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("expected 1 entry, got %d", len(entries))
+		}
+		if got := entries[0]; got.Status != e.Status || got.Timeout < 110 {
+			t.Errorf("unexpected entry: %v", got)
+		}
+
+		server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 8000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("10.64.0.1"), Port: 40000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		if _, err := client.WriteToUDP([]byte("hello"), &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 8000}); err != nil {
+			t.Fatal(err)
+		}
+		server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 16)
+		_, from, err := server.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !from.IP.Equal(net.ParseIP("198.51.100.1")) || from.Port != 50000 {
+			t.Errorf("packet should have been SNATed by the imported entry: %s", from)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
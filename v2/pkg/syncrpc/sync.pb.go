@@ -0,0 +1,315 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v7.35.1
+// source: pkg/syncrpc/sync.proto
+
+package syncrpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Tuple represents a conntrack tuple.
+type Tuple struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SrcIp         string                 `protobuf:"bytes,1,opt,name=src_ip,json=srcIp,proto3" json:"src_ip,omitempty"`
+	DstIp         string                 `protobuf:"bytes,2,opt,name=dst_ip,json=dstIp,proto3" json:"dst_ip,omitempty"`
+	SrcPort       uint32                 `protobuf:"varint,3,opt,name=src_port,json=srcPort,proto3" json:"src_port,omitempty"`
+	DstPort       uint32                 `protobuf:"varint,4,opt,name=dst_port,json=dstPort,proto3" json:"dst_port,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Tuple) Reset() {
+	*x = Tuple{}
+	mi := &file_pkg_syncrpc_sync_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Tuple) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Tuple) ProtoMessage() {}
+
+func (x *Tuple) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_syncrpc_sync_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Tuple.ProtoReflect.Descriptor instead.
+func (*Tuple) Descriptor() ([]byte, []int) {
+	return file_pkg_syncrpc_sync_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Tuple) GetSrcIp() string {
+	if x != nil {
+		return x.SrcIp
+	}
+	return ""
+}
+
+func (x *Tuple) GetDstIp() string {
+	if x != nil {
+		return x.DstIp
+	}
+	return ""
+}
+
+func (x *Tuple) GetSrcPort() uint32 {
+	if x != nil {
+		return x.SrcPort
+	}
+	return 0
+}
+
+func (x *Tuple) GetDstPort() uint32 {
+	if x != nil {
+		return x.DstPort
+	}
+	return 0
+}
+
+// ConntrackEntry represents a conntrack entry of a flow SNATed by an egress pod.
+//
+// `family` is the address family, either 4 or 6.
+// `protocol` is the IP protocol number.
+// `timeout` is the remaining lifetime of the entry in seconds.
+// `tcp_state` is the state of the TCP connection and is zero for other protocols.
+// `status` is the status bits of the entry such as IPS_ASSURED and IPS_SRC_NAT.
+type ConntrackEntry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Family        uint32                 `protobuf:"varint,1,opt,name=family,proto3" json:"family,omitempty"`
+	Protocol      uint32                 `protobuf:"varint,2,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	Original      *Tuple                 `protobuf:"bytes,3,opt,name=original,proto3" json:"original,omitempty"`
+	Reply         *Tuple                 `protobuf:"bytes,4,opt,name=reply,proto3" json:"reply,omitempty"`
+	Timeout       uint32                 `protobuf:"varint,5,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	TcpState      uint32                 `protobuf:"varint,6,opt,name=tcp_state,json=tcpState,proto3" json:"tcp_state,omitempty"`
+	Status        uint32                 `protobuf:"varint,7,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConntrackEntry) Reset() {
+	*x = ConntrackEntry{}
+	mi := &file_pkg_syncrpc_sync_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConntrackEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConntrackEntry) ProtoMessage() {}
+
+func (x *ConntrackEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_syncrpc_sync_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConntrackEntry.ProtoReflect.Descriptor instead.
+func (*ConntrackEntry) Descriptor() ([]byte, []int) {
+	return file_pkg_syncrpc_sync_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ConntrackEntry) GetFamily() uint32 {
+	if x != nil {
+		return x.Family
+	}
+	return 0
+}
+
+func (x *ConntrackEntry) GetProtocol() uint32 {
+	if x != nil {
+		return x.Protocol
+	}
+	return 0
+}
+
+func (x *ConntrackEntry) GetOriginal() *Tuple {
+	if x != nil {
+		return x.Original
+	}
+	return nil
+}
+
+func (x *ConntrackEntry) GetReply() *Tuple {
+	if x != nil {
+		return x.Reply
+	}
+	return nil
+}
+
+func (x *ConntrackEntry) GetTimeout() uint32 {
+	if x != nil {
+		return x.Timeout
+	}
+	return 0
+}
+
+func (x *ConntrackEntry) GetTcpState() uint32 {
+	if x != nil {
+		return x.TcpState
+	}
+	return 0
+}
+
+func (x *ConntrackEntry) GetStatus() uint32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+// ConntrackEntries is a list of conntrack entries.
+type ConntrackEntries struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Entries       []*ConntrackEntry      `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConntrackEntries) Reset() {
+	*x = ConntrackEntries{}
+	mi := &file_pkg_syncrpc_sync_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConntrackEntries) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConntrackEntries) ProtoMessage() {}
+
+func (x *ConntrackEntries) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_syncrpc_sync_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConntrackEntries.ProtoReflect.Descriptor instead.
+func (*ConntrackEntries) Descriptor() ([]byte, []int) {
+	return file_pkg_syncrpc_sync_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ConntrackEntries) GetEntries() []*ConntrackEntry {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+var File_pkg_syncrpc_sync_proto protoreflect.FileDescriptor
+
+const file_pkg_syncrpc_sync_proto_rawDesc = "" +
+	"\n" +
+	"\x16pkg/syncrpc/sync.proto\x12\vpkg.syncrpc\x1a\x1bgoogle/protobuf/empty.proto\"k\n" +
+	"\x05Tuple\x12\x15\n" +
+	"\x06src_ip\x18\x01 \x01(\tR\x05srcIp\x12\x15\n" +
+	"\x06dst_ip\x18\x02 \x01(\tR\x05dstIp\x12\x19\n" +
+	"\bsrc_port\x18\x03 \x01(\rR\asrcPort\x12\x19\n" +
+	"\bdst_port\x18\x04 \x01(\rR\adstPort\"\xed\x01\n" +
+	"\x0eConntrackEntry\x12\x16\n" +
+	"\x06family\x18\x01 \x01(\rR\x06family\x12\x1a\n" +
+	"\bprotocol\x18\x02 \x01(\rR\bprotocol\x12.\n" +
+	"\boriginal\x18\x03 \x01(\v2\x12.pkg.syncrpc.TupleR\boriginal\x12(\n" +
+	"\x05reply\x18\x04 \x01(\v2\x12.pkg.syncrpc.TupleR\x05reply\x12\x18\n" +
+	"\atimeout\x18\x05 \x01(\rR\atimeout\x12\x1b\n" +
+	"\ttcp_state\x18\x06 \x01(\rR\btcpState\x12\x16\n" +
+	"\x06status\x18\a \x01(\rR\x06status\"I\n" +
+	"\x10ConntrackEntries\x125\n" +
+	"\aentries\x18\x01 \x03(\v2\x1b.pkg.syncrpc.ConntrackEntryR\aentries2Q\n" +
+	"\rConntrackSync\x12@\n" +
+	"\x05Watch\x12\x16.google.protobuf.Empty\x1a\x1d.pkg.syncrpc.ConntrackEntries0\x01B*Z(github.com/cybozu-go/coil/v2/pkg/syncrpcb\x06proto3"
+
+var (
+	file_pkg_syncrpc_sync_proto_rawDescOnce sync.Once
+	file_pkg_syncrpc_sync_proto_rawDescData []byte
+)
+
+func file_pkg_syncrpc_sync_proto_rawDescGZIP() []byte {
+	file_pkg_syncrpc_sync_proto_rawDescOnce.Do(func() {
+		file_pkg_syncrpc_sync_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pkg_syncrpc_sync_proto_rawDesc), len(file_pkg_syncrpc_sync_proto_rawDesc)))
+	})
+	return file_pkg_syncrpc_sync_proto_rawDescData
+}
+
+var file_pkg_syncrpc_sync_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_pkg_syncrpc_sync_proto_goTypes = []any{
+	(*Tuple)(nil),            // 0: pkg.syncrpc.Tuple
+	(*ConntrackEntry)(nil),   // 1: pkg.syncrpc.ConntrackEntry
+	(*ConntrackEntries)(nil), // 2: pkg.syncrpc.ConntrackEntries
+	(*emptypb.Empty)(nil),    // 3: google.protobuf.Empty
+}
+var file_pkg_syncrpc_sync_proto_depIdxs = []int32{
+	0, // 0: pkg.syncrpc.ConntrackEntry.original:type_name -> pkg.syncrpc.Tuple
+	0, // 1: pkg.syncrpc.ConntrackEntry.reply:type_name -> pkg.syncrpc.Tuple
+	1, // 2: pkg.syncrpc.ConntrackEntries.entries:type_name -> pkg.syncrpc.ConntrackEntry
+	3, // 3: pkg.syncrpc.ConntrackSync.Watch:input_type -> google.protobuf.Empty
+	2, // 4: pkg.syncrpc.ConntrackSync.Watch:output_type -> pkg.syncrpc.ConntrackEntries
+	4, // [4:5] is the sub-list for method output_type
+	3, // [3:4] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_pkg_syncrpc_sync_proto_init() }
+func file_pkg_syncrpc_sync_proto_init() {
+	if File_pkg_syncrpc_sync_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pkg_syncrpc_sync_proto_rawDesc), len(file_pkg_syncrpc_sync_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pkg_syncrpc_sync_proto_goTypes,
+		DependencyIndexes: file_pkg_syncrpc_sync_proto_depIdxs,
+		MessageInfos:      file_pkg_syncrpc_sync_proto_msgTypes,
+	}.Build()
+	File_pkg_syncrpc_sync_proto = out.File
+	file_pkg_syncrpc_sync_proto_goTypes = nil
+	file_pkg_syncrpc_sync_proto_depIdxs = nil
+}
@@ -0,0 +1,133 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             v7.35.1
+// source: pkg/syncrpc/sync.proto
+
+package syncrpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ConntrackSync_Watch_FullMethodName = "/pkg.syncrpc.ConntrackSync/Watch"
+)
+
+// ConntrackSyncClient is the client API for ConntrackSync service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ConntrackSync exchanges NAT state between egress pods.
+type ConntrackSyncClient interface {
+	// Watch streams conntrack entries created or updated in the egress pod.
+	// All the entries are sent first, then changed ones follow.
+	Watch(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ConntrackEntries], error)
+}
+
+type conntrackSyncClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewConntrackSyncClient(cc grpc.ClientConnInterface) ConntrackSyncClient {
+	return &conntrackSyncClient{cc}
+}
+
+func (c *conntrackSyncClient) Watch(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ConntrackEntries], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ConntrackSync_ServiceDesc.Streams[0], ConntrackSync_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[emptypb.Empty, ConntrackEntries]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ConntrackSync_WatchClient = grpc.ServerStreamingClient[ConntrackEntries]
+
+// ConntrackSyncServer is the server API for ConntrackSync service.
+// All implementations must embed UnimplementedConntrackSyncServer
+// for forward compatibility.
+//
+// ConntrackSync exchanges NAT state between egress pods.
+type ConntrackSyncServer interface {
+	// Watch streams conntrack entries created or updated in the egress pod.
+	// All the entries are sent first, then changed ones follow.
+	Watch(*emptypb.Empty, grpc.ServerStreamingServer[ConntrackEntries]) error
+	mustEmbedUnimplementedConntrackSyncServer()
+}
+
+// UnimplementedConntrackSyncServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedConntrackSyncServer struct{}
+
+func (UnimplementedConntrackSyncServer) Watch(*emptypb.Empty, grpc.ServerStreamingServer[ConntrackEntries]) error {
+	return status.Error(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedConntrackSyncServer) mustEmbedUnimplementedConntrackSyncServer() {}
+func (UnimplementedConntrackSyncServer) testEmbeddedByValue()                       {}
+
+// UnsafeConntrackSyncServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ConntrackSyncServer will
+// result in compilation errors.
+type UnsafeConntrackSyncServer interface {
+	mustEmbedUnimplementedConntrackSyncServer()
+}
+
+func RegisterConntrackSyncServer(s grpc.ServiceRegistrar, srv ConntrackSyncServer) {
+	// If the following call panics, it indicates UnimplementedConntrackSyncServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ConntrackSync_ServiceDesc, srv)
+}
+
+func _ConntrackSync_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(emptypb.Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConntrackSyncServer).Watch(m, &grpc.GenericServerStream[emptypb.Empty, ConntrackEntries]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ConntrackSync_WatchServer = grpc.ServerStreamingServer[ConntrackEntries]
+
+// ConntrackSync_ServiceDesc is the grpc.ServiceDesc for ConntrackSync service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ConntrackSync_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pkg.syncrpc.ConntrackSync",
+	HandlerType: (*ConntrackSyncServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _ConntrackSync_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pkg/syncrpc/sync.proto",
+}
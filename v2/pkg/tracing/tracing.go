@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v2 "github.com/cybozu-go/coil/v2"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+const tracerName = "github.com/cybozu-go/coil/v2"
+
+// Environment variables to configure the OTLP exporter.
+// Other OTEL_EXPORTER_OTLP_* variables are also honored by the exporter.
+const (
+	envEndpoint       = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	envTracesEndpoint = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+)
+
+// Tracer returns the tracer for Coil components.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Setup configures the global propagator and TracerProvider.
+//
+// Spans are exported to an OTLP/gRPC collector only when the endpoint
+// is given by the standard environment variables.  Otherwise, the trace
+// context is still propagated but no spans are recorded.
+//
+// The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv(envEndpoint) == "" && os.Getenv(envTracesEndpoint) == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			semconv.ServiceName(service),
+			semconv.ServiceVersion(v2.Version()),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// EndSpan records err, if any, to span and ends it.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// annotationCarrier stores the trace context in annotations of a Kubernetes object.
+type annotationCarrier struct {
+	obj metav1.Object
+}
+
+var _ propagation.TextMapCarrier = annotationCarrier{}
+
+func (c annotationCarrier) Get(key string) string {
+	return c.obj.GetAnnotations()[constants.AnnTracePrefix+key]
+}
+
+func (c annotationCarrier) Set(key, value string) {
+	annotations := c.obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[constants.AnnTracePrefix+key] = value
+	c.obj.SetAnnotations(annotations)
+}
+
+func (c annotationCarrier) Keys() []string {
+	var keys []string
+	for k := range c.obj.GetAnnotations() {
+		if strings.HasPrefix(k, constants.AnnTracePrefix) {
+			keys = append(keys, k[len(constants.AnnTracePrefix):])
+		}
+	}
+	return keys
+}
+
+// InjectAnnotations stores the trace context of ctx in the annotations of obj
+// so that the controller handling obj can continue the trace.
+func InjectAnnotations(ctx context.Context, obj metav1.Object) {
+	otel.GetTextMapPropagator().Inject(ctx, annotationCarrier{obj: obj})
+}
+
+// ExtractAnnotations returns a context that carries the trace context
+// stored in the annotations of obj.
+func ExtractAnnotations(ctx context.Context, obj metav1.Object) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, annotationCarrier{obj: obj})
+}
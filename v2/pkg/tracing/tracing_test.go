@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+func TestAnnotations(t *testing.T) {
+	t.Setenv(envEndpoint, "")
+	t.Setenv(envTracesEndpoint, "")
+	shutdown, err := Setup(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x01, 0x02, 0x03},
+		SpanID:     trace.SpanID{0x04, 0x05},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	pod := &corev1.Pod{}
+	pod.Annotations = map[string]string{"foo": "bar"}
+	InjectAnnotations(ctx, pod)
+
+	if pod.Annotations["foo"] != "bar" {
+		t.Error("existing annotations should be kept")
+	}
+	expected := "00-01020300000000000000000000000000-0405000000000000-01"
+	if v := pod.Annotations[constants.AnnTracePrefix+"traceparent"]; v != expected {
+		t.Errorf("unexpected traceparent: %s", v)
+	}
+
+	extracted := trace.SpanContextFromContext(ExtractAnnotations(context.Background(), pod))
+	if extracted.TraceID() != sc.TraceID() || extracted.SpanID() != sc.SpanID() {
+		t.Errorf("unexpected span context: %v", extracted)
+	}
+	if !extracted.IsRemote() {
+		t.Error("extracted span context should be remote")
+	}
+
+	empty := trace.SpanContextFromContext(ExtractAnnotations(context.Background(), &corev1.Pod{}))
+	if empty.IsValid() {
+		t.Error("span context should not be extracted from an object without annotations")
+	}
+}
@@ -0,0 +1,153 @@
+package runners
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/adminrpc"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+// adminServer implements adminrpc.AdminServer on the coild socket.
+type adminServer struct {
+	adminrpc.UnimplementedAdminServer
+	coild *coildServer
+}
+
+func (a *adminServer) requireIPAM() error {
+	if !a.coild.cfg.EnableIPAM {
+		return status.Error(codes.FailedPrecondition, "IPAM is disabled")
+	}
+	return nil
+}
+
+func (a *adminServer) ListAllocations(ctx context.Context, _ *emptypb.Empty) (*adminrpc.ListAllocationsResponse, error) {
+	if err := a.requireIPAM(); err != nil {
+		return nil, err
+	}
+
+	resp := &adminrpc.ListAllocationsResponse{}
+	for _, alloc := range a.coild.nodeIPAM.Allocations() {
+		resp.Allocations = append(resp.Allocations, &adminrpc.Allocation{
+			ContainerId: alloc.ContainerID,
+			Ifname:      alloc.Iface,
+			Pool:        alloc.PoolName,
+			Block:       alloc.BlockName,
+			Ipv4:        ipString(alloc.IPv4),
+			Ipv6:        ipString(alloc.IPv6),
+		})
+	}
+	return resp, nil
+}
+
+func (a *adminServer) ListPodNetworks(ctx context.Context, _ *emptypb.Empty) (*adminrpc.ListPodNetworksResponse, error) {
+	confs, err := a.coild.podNet.List()
+	if err != nil {
+		withCtxFields(ctx, a.coild.logger).Sugar().Errorw("failed to list pod networks", "error", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &adminrpc.ListPodNetworksResponse{}
+	for _, c := range confs {
+		resp.PodNetworks = append(resp.PodNetworks, &adminrpc.PodNetwork{
+			ContainerId: c.ContainerId,
+			Ifname:      c.IFace,
+			Pool:        c.PoolName,
+			Ipv4:        ipString(c.IPv4),
+			Ipv6:        ipString(c.IPv6),
+			HostVeth:    c.HostVethName,
+		})
+	}
+	return resp, nil
+}
+
+func (a *adminServer) RunGC(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	if err := a.requireIPAM(); err != nil {
+		return nil, err
+	}
+
+	if err := a.coild.nodeIPAM.GC(ctx); err != nil {
+		withCtxFields(ctx, a.coild.logger).Sugar().Errorw("failed to run GC", "error", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (a *adminServer) SyncRoutes(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	if err := a.requireIPAM(); err != nil {
+		return nil, err
+	}
+
+	if err := a.coild.nodeIPAM.SyncRoutes(ctx); err != nil {
+		withCtxFields(ctx, a.coild.logger).Sugar().Errorw("failed to sync routes", "error", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (a *adminServer) ListEgressHooks(ctx context.Context, _ *emptypb.Empty) (*adminrpc.ListEgressHooksResponse, error) {
+	if !a.coild.cfg.EnableEgress {
+		return nil, status.Error(codes.FailedPrecondition, "egress is disabled")
+	}
+
+	pods := &corev1.PodList{}
+	err := a.coild.client.List(ctx, pods, client.MatchingFields{
+		constants.PodNodeNameKey: a.coild.nodeName,
+	})
+	if err != nil {
+		withCtxFields(ctx, a.coild.logger).Sugar().Errorw("failed to list pods", "error", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &adminrpc.ListEgressHooksResponse{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.HostNetwork {
+			continue
+		}
+		egNames := coilv2.EgressesOf(pod.Annotations)
+		if len(egNames) == 0 {
+			continue
+		}
+
+		hook := &adminrpc.EgressHook{
+			PodNamespace: pod.Namespace,
+			PodName:      pod.Name,
+		}
+		for _, n := range egNames {
+			hook.Egresses = append(hook.Egresses, n.String())
+		}
+
+		gwlist, err := a.coild.getGWNets(ctx, egNames)
+		if err != nil {
+			hook.Error = err.Error()
+		}
+		for _, gwn := range gwlist {
+			gw := &adminrpc.EgressGateway{
+				Gateway:         gwn.Gateway.String(),
+				SportAuto:       gwn.SportAuto,
+				OriginatingOnly: gwn.OriginatingOnly,
+			}
+			for _, n := range gwn.Networks {
+				gw.Destinations = append(gw.Destinations, n.String())
+			}
+			hook.Gateways = append(hook.Gateways, gw)
+		}
+		resp.Hooks = append(resp.Hooks, hook)
+	}
+	return resp, nil
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -0,0 +1,119 @@
+package runners
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/cybozu-go/coil/v2/pkg/cnirpc"
+	"github.com/cybozu-go/coil/v2/pkg/constants"
+)
+
+// Phases of CNI requests
+const (
+	phasePoolLookup       = "pool_lookup"
+	phaseQuotaReservation = "quota_reservation"
+	phaseAllocation       = "address_allocation"
+	phaseVethSetup        = "veth_setup"
+	phaseEgressHook       = "egress_hook"
+	phaseVethTeardown     = "veth_teardown"
+	phaseAddressRelease   = "address_release"
+	phaseVethCheck        = "veth_check"
+)
+
+// cniCodeOK is the value of "code" label for successful requests.
+const cniCodeOK = "OK"
+
+// cniBuckets covers the CNI request timeout of coil, which is 1 minute.
+var cniBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	cniRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "cni_request_duration_seconds",
+			Help:      "the latency of CNI requests",
+			Buckets:   cniBuckets,
+		},
+		[]string{"method", "code"},
+	)
+
+	cniPhaseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "cni_phase_duration_seconds",
+			Help:      "the latency of each phase of CNI requests",
+			Buckets:   cniBuckets,
+		},
+		[]string{"method", "phase", "code"},
+	)
+
+	cniRequestsInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: constants.MetricsNS,
+			Subsystem: "coild",
+			Name:      "cni_requests_in_flight",
+			Help:      "the number of CNI requests being processed",
+		},
+		[]string{"method"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(cniRequestDuration)
+	metrics.Registry.MustRegister(cniPhaseDuration)
+	metrics.Registry.MustRegister(cniRequestsInFlight)
+}
+
+// cniMetricsInterceptor records the latency and the result of CNI requests.
+func cniMetricsInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(info.FullMethod, "/"), "/")
+	if !ok || service != cnirpc.CNI_ServiceDesc.ServiceName {
+		return handler(ctx, req)
+	}
+
+	inFlight := cniRequestsInFlight.WithLabelValues(method)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	cniRequestDuration.WithLabelValues(method, cniErrorCode(err)).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// cniErrorCode returns the name of cnirpc.ErrorCode carried by err.
+func cniErrorCode(err error) string {
+	if err == nil {
+		return cniCodeOK
+	}
+	if st, ok := status.FromError(err); ok {
+		for _, d := range st.Details() {
+			if cniErr, ok := d.(*cnirpc.CNIError); ok {
+				return cniErr.Code.String()
+			}
+		}
+	}
+	return cnirpc.ErrorCode_UNKNOWN.String()
+}
+
+// observePhase records the time elapsed since start as the latency of phase.
+// err is the result of the phase.  Errors without cnirpc.CNIError are
+// counted as INTERNAL because coild returns them with that code.
+func observePhase(method, phase string, start time.Time, err error) {
+	code := cniCodeOK
+	if err != nil {
+		code = cnirpc.ErrorCode_INTERNAL.String()
+		if _, ok := status.FromError(err); ok {
+			code = cniErrorCode(err)
+		}
+	}
+	cniPhaseDuration.WithLabelValues(method, phase, code).Observe(time.Since(start).Seconds())
+}
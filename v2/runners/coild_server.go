@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,6 +18,9 @@ import (
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/vishvananda/netlink"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -24,16 +29,24 @@ import (
 	"google.golang.org/protobuf/types/known/emptypb"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/adminrpc"
 	"github.com/cybozu-go/coil/v2/pkg/cnirpc"
 	"github.com/cybozu-go/coil/v2/pkg/config"
 	"github.com/cybozu-go/coil/v2/pkg/constants"
 	"github.com/cybozu-go/coil/v2/pkg/fou"
 	"github.com/cybozu-go/coil/v2/pkg/ipam"
+	"github.com/cybozu-go/coil/v2/pkg/nat"
 	"github.com/cybozu-go/coil/v2/pkg/nat/netfilter"
 	"github.com/cybozu-go/coil/v2/pkg/nodenet"
 )
@@ -96,13 +109,20 @@ func (n natSetup) Hook(l []GWNets, backend string, log *zap.Logger) func(ipv4, i
 	}
 }
 
+// PodObserver is notified when coild has set up the network of a Pod.
+// If AddPod returns an error, the Pod network is torn down and Add fails.
+type PodObserver interface {
+	AddPod(ctx context.Context, pod *corev1.Pod, containerID string) error
+}
+
 // NewCoildServer returns an implementation of cnirpc.CNIServer for coild.
 func NewCoildServer(l net.Listener, mgr manager.Manager, nodeIPAM ipam.NodeIPAM, podNet nodenet.PodNetwork, setup NATSetup, cfg *config.Config, logger *zap.Logger,
-	aliasFunc func(conf *nodenet.PodNetConf, ifName string) error, nodeName string) manager.Runnable {
+	aliasFunc func(conf *nodenet.PodNetConf, ifName string) error, nodeName string, observers ...PodObserver) manager.Runnable {
 	return &coildServer{
 		listener:  l,
 		apiReader: mgr.GetAPIReader(),
 		client:    mgr.GetClient(),
+		recorder:  mgr.GetEventRecorder("coild"),
 		nodeIPAM:  nodeIPAM,
 		podNet:    podNet,
 		natSetup:  setup,
@@ -110,6 +130,7 @@ func NewCoildServer(l net.Listener, mgr manager.Manager, nodeIPAM ipam.NodeIPAM,
 		cfg:       cfg,
 		aliasFunc: aliasFunc,
 		nodeName:  nodeName,
+		observers: observers,
 	}
 }
 
@@ -117,6 +138,10 @@ func NewCoildServer(l net.Listener, mgr manager.Manager, nodeIPAM ipam.NodeIPAM,
 // +kubebuilder:rbac:groups="",resources=namespaces;services,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=nodes,verbs=get
 // +kubebuilder:rbac:groups=coil.cybozu.com,resources=egresses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=addresspools,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=poolquotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=poolquotas/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
 
 const nodeDeletedCleanupTimeout = 10 * time.Second
 
@@ -132,6 +157,7 @@ type coildServer struct {
 	listener  net.Listener
 	apiReader client.Reader
 	client    client.Client
+	recorder  events.EventRecorder
 	nodeIPAM  ipam.NodeIPAM
 	podNet    nodenet.PodNetwork
 	natSetup  NATSetup
@@ -139,6 +165,7 @@ type coildServer struct {
 	cfg       *config.Config
 	aliasFunc func(conf *nodenet.PodNetConf, ifName string) error
 	nodeName  string
+	observers []PodObserver
 }
 
 var _ manager.LeaderElectionRunnable = &coildServer{}
@@ -148,14 +175,16 @@ func (s *coildServer) NeedLeaderElection() bool {
 }
 
 func (s *coildServer) Start(ctx context.Context) error {
-	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+	grpcServer := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()), grpc.ChainUnaryInterceptor(
 		logging.UnaryServerInterceptor(
 			InterceptorLogger(s.logger),
 			logging.WithFieldsFromContextAndCallMeta(loggingFields),
 			logging.WithLogOnEvents(logging.FinishCall)),
 		grpcMetrics.UnaryServerInterceptor(),
+		cniMetricsInterceptor,
 	))
 	cnirpc.RegisterCNIServer(grpcServer, s)
+	adminrpc.RegisterAdminServer(grpcServer, &adminServer{coild: s})
 
 	// after all services are registered, initialize metrics.
 	grpcMetrics.InitializeMetrics(grpcServer)
@@ -237,13 +266,30 @@ func (s *coildServer) Add(ctx context.Context, args *cnirpc.CNIArgs) (*cnirpc.Ad
 	if err != nil {
 		return nil, newInternalError(err, "failed to get pod")
 	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("k8s.pod.name", pod.Name),
+		attribute.String("k8s.namespace.name", pod.Namespace),
+		attribute.String("coil.container_id", args.ContainerId),
+	)
 
 	var ipv4, ipv6 net.IP
 	var poolName string
+	var bandwidth *nodenet.BandwidthLimits
+	var mtu int
+	var tuning *nodenet.PodTuning
 
 	if s.cfg.EnableIPAM {
+		bandwidth, err = getBandwidth(args, pod)
+		if err != nil {
+			logger.Sugar().Errorw("invalid bandwidth limits", "error", err)
+			return nil, newError(codes.InvalidArgument, cnirpc.ErrorCode_INVALID_NETWORK_CONFIG,
+				"invalid bandwidth limits", err.Error())
+		}
+
+		start := time.Now()
 		ns := &corev1.Namespace{}
 		if err := s.client.Get(ctx, client.ObjectKey{Name: pod.Namespace}, ns); err != nil {
+			observePhase("Add", phasePoolLookup, start, err)
 			logger.Sugar().Errorw("failed to get namespace", "name", pod.Namespace, "error", err)
 			return nil, newInternalError(err, "failed to get namespace")
 		}
@@ -252,9 +298,39 @@ func (s *coildServer) Add(ctx context.Context, args *cnirpc.CNIArgs) (*cnirpc.Ad
 			poolName = v
 		}
 
+		pool, err := s.getAddressPool(ctx, poolName)
+		observePhase("Add", phasePoolLookup, start, err)
+		if err != nil {
+			logger.Sugar().Errorw("failed to get address pool", "name", poolName, "error", err)
+			return nil, newInternalError(err, "failed to get address pool")
+		}
+		if ok, err := pool.Spec.AllowsNamespace(ns); err != nil {
+			return nil, newInternalError(err, "invalid allowedNamespaces of address pool "+poolName)
+		} else if !ok {
+			logger.Sugar().Errorw("namespace is not allowed to use the pool", "namespace", pod.Namespace, "pool", poolName)
+			return nil, newError(codes.PermissionDenied, cnirpc.ErrorCode_INVALID_NETWORK_CONFIG,
+				"namespace is not allowed to use address pool "+poolName, pod.Namespace)
+		}
+		start = time.Now()
+		err = s.reserveQuota(ctx, pod, args.ContainerId, poolName)
+		observePhase("Add", phaseQuotaReservation, start, err)
+		if err != nil {
+			logger.Sugar().Errorw("address quota exceeded", "namespace", pod.Namespace, "pool", poolName, "error", err)
+			s.recorder.Eventf(pod, nil, corev1.EventTypeWarning, constants.ReasonQuotaExceeded, "Allocate",
+				"address quota of pool %s is exceeded", poolName)
+			return nil, err
+		}
+		mtu = int(pool.Spec.MTU)
+		tuning = podTuning(pool.Spec.Tuning)
+
+		start = time.Now()
 		ipv4, ipv6, err = s.nodeIPAM.Allocate(ctx, poolName, args.ContainerId, args.Ifname)
+		observePhase("Add", phaseAllocation, start, err)
 		if err != nil {
+			s.releaseQuota(ctx, pod.Namespace, args.ContainerId)
 			logger.Sugar().Errorw("failed to allocate address", "error", err)
+			s.recorder.Eventf(pod, nil, corev1.EventTypeWarning, constants.ReasonAllocationFailed, "Allocate",
+				"failed to allocate address from pool %s on node %s: %v", poolName, s.nodeName, err)
 			return nil, newInternalError(err, "failed to allocate address")
 		}
 	} else {
@@ -281,17 +357,40 @@ func (s *coildServer) Add(ctx context.Context, args *cnirpc.CNIArgs) (*cnirpc.Ad
 		IPv4:        ipv4,
 		IPv6:        ipv6,
 		PoolName:    poolName,
+		Bandwidth:   bandwidth,
+		MTU:         mtu,
+		Tuning:      tuning,
 	}
 
 	if s.cfg.EnableIPAM {
+		start := time.Now()
 		result, err = s.podNet.SetupIPAM(args.Netns, pod.Name, pod.Namespace, config)
+		observePhase("Add", phaseVethSetup, start, err)
 		if err != nil {
 			if err := s.nodeIPAM.Free(ctx, args.ContainerId, args.Ifname); err != nil {
 				logger.Sugar().Warnw("failed to deallocate address", "error", err)
 			}
+			s.releaseQuota(ctx, pod.Namespace, args.ContainerId)
 			logger.Sugar().Errorw("failed to setup pod network", "error", err)
+			s.recorder.Eventf(pod, nil, corev1.EventTypeWarning, constants.ReasonPodNetworkFailed, "Setup",
+				"failed to setup pod network: %v", err)
 			return nil, newInternalError(err, "failed to setup pod network IPAM")
 		}
+		for _, o := range s.observers {
+			if err := o.AddPod(ctx, pod, config.ContainerId); err != nil {
+				if err := s.podNet.Destroy(args.ContainerId, args.Ifname); err != nil {
+					logger.Sugar().Warnw("failed to destroy pod network", "error", err)
+				}
+				if err := s.nodeIPAM.Free(ctx, args.ContainerId, args.Ifname); err != nil {
+					logger.Sugar().Warnw("failed to deallocate address", "error", err)
+				}
+				s.releaseQuota(ctx, pod.Namespace, args.ContainerId)
+				logger.Sugar().Errorw("failed to protect pod network", "error", err)
+				s.recorder.Eventf(pod, nil, corev1.EventTypeWarning, constants.ReasonPodNetworkFailed, "Setup",
+					"failed to enforce NetworkPolicies: %v", err)
+				return nil, newInternalError(err, "failed to enforce NetworkPolicies")
+			}
+		}
 	}
 
 	if s.cfg.EnableEgress {
@@ -301,15 +400,24 @@ func (s *coildServer) Add(ctx context.Context, args *cnirpc.CNIArgs) (*cnirpc.Ad
 			}
 		}
 
+		start := time.Now()
 		hook, err := s.getHook(ctx, pod)
 		if err != nil {
+			observePhase("Add", phaseEgressHook, start, err)
 			logger.Sugar().Errorw("failed to setup NAT hook", "error", err)
+			s.recorder.Eventf(pod, nil, corev1.EventTypeWarning, constants.ReasonEgressSetupFailed, "SetupEgress",
+				"failed to setup NAT hook: %v", err)
 			return nil, newInternalError(err, "failed to setup NAT hook")
 		}
 
 		if hook != nil {
 			logger.Sugar().Info("enabling NAT")
-			if err := s.podNet.SetupEgress(args.Netns, config, hook); err != nil {
+			err := s.podNet.SetupEgress(args.Netns, config, hook)
+			observePhase("Add", phaseEgressHook, start, err)
+			if err != nil {
+				logger.Sugar().Errorw("failed to setup pod network egress", "error", err)
+				s.recorder.Eventf(pod, nil, corev1.EventTypeWarning, constants.ReasonEgressSetupFailed, "SetupEgress",
+					"failed to setup pod network egress: %v", err)
 				return nil, newInternalError(err, "failed to setup pod network egress")
 			}
 		}
@@ -382,15 +490,24 @@ func (s *coildServer) Del(ctx context.Context, args *cnirpc.CNIArgs) (*emptypb.E
 	logger := withCtxFields(ctx, s.logger)
 
 	if s.cfg.EnableIPAM {
-		if err := s.podNet.Destroy(args.ContainerId, args.Ifname); err != nil {
+		start := time.Now()
+		err := s.podNet.Destroy(args.ContainerId, args.Ifname)
+		observePhase("Del", phaseVethTeardown, start, err)
+		if err != nil {
 			logger.Sugar().Errorw("failed to destroy pod network", "error", err)
 			return nil, newInternalError(err, "failed to destroy pod network")
 		}
 
-		if err := s.nodeIPAM.Free(ctx, args.ContainerId, args.Ifname); err != nil {
+		start = time.Now()
+		err = s.nodeIPAM.Free(ctx, args.ContainerId, args.Ifname)
+		observePhase("Del", phaseAddressRelease, start, err)
+		if err != nil {
 			logger.Sugar().Errorw("failed to free addresses", "error", err)
 			return nil, newInternalError(err, "failed to free addresses")
 		}
+		if ns := args.Args[constants.PodNamespaceKey]; ns != "" {
+			s.releaseQuota(ctx, ns, args.ContainerId)
+		}
 	}
 	return &emptypb.Empty{}, nil
 }
@@ -399,7 +516,10 @@ func (s *coildServer) Check(ctx context.Context, args *cnirpc.CNIArgs) (*emptypb
 	logger := withCtxFields(ctx, s.logger)
 
 	if s.cfg.EnableIPAM {
-		if err := s.podNet.Check(args.ContainerId, args.Ifname); err != nil {
+		start := time.Now()
+		err := s.podNet.Check(args.ContainerId, args.Ifname)
+		observePhase("Check", phaseVethCheck, start, err)
+		if err != nil {
 			logger.Sugar().Errorw("check failed", "error", err)
 			return nil, newInternalError(err, "check failed")
 		}
@@ -409,7 +529,10 @@ func (s *coildServer) Check(ctx context.Context, args *cnirpc.CNIArgs) (*emptypb
 			return nil, newInternalError(err, "unable to get pod")
 		}
 
-		if err := s.podNet.Check(string(pod.UID), args.Ifname); err != nil {
+		start := time.Now()
+		err = s.podNet.Check(string(pod.UID), args.Ifname)
+		observePhase("Check", phaseVethCheck, start, err)
+		if err != nil {
 			logger.Sugar().Errorw("check failed", "error", err)
 			return nil, newInternalError(err, "check failed")
 		}
@@ -441,6 +564,157 @@ func (s *coildServer) getPodFromArgs(ctx context.Context, args *cnirpc.CNIArgs,
 	return pod, nil
 }
 
+// getAddressPool returns the AddressPool.
+// A missing pool is not an error here because the allocation will report it,
+// so an empty AddressPool is returned in that case.
+func (s *coildServer) getAddressPool(ctx context.Context, poolName string) (*coilv2.AddressPool, error) {
+	pool := &coilv2.AddressPool{}
+	if err := s.client.Get(ctx, client.ObjectKey{Name: poolName}, pool); err != nil {
+		if apierrors.IsNotFound(err) {
+			return &coilv2.AddressPool{}, nil
+		}
+		return nil, err
+	}
+	return pool, nil
+}
+
+// quotaBackoff is the backoff to update PoolQuota status.  PoolQuota is
+// updated by coild on every node for each Pod in the namespace, so conflicts
+// are common when many Pods are created at once.
+var quotaBackoff = wait.Backoff{
+	Steps:    10,
+	Duration: 10 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   1.0,
+	Cap:      2 * time.Second,
+}
+
+// reserveQuota reserves an address for the Pod in PoolQuotas for the pool.
+// It returns an error if Pods in the namespace have used up the addresses.
+//
+// The reservation is made by updating the status of PoolQuota so that
+// concurrent Adds on any nodes cannot exceed the quota.  It is released by
+// releaseQuota or by coil-ipam-controller when the Pod reports its address.
+func (s *coildServer) reserveQuota(ctx context.Context, pod *corev1.Pod, containerID, poolName string) error {
+	quotas := &coilv2.PoolQuotaList{}
+	if err := s.client.List(ctx, quotas, client.InNamespace(pod.Namespace)); err != nil {
+		return newInternalError(err, "failed to list PoolQuota")
+	}
+
+	for _, q := range quotas.Items {
+		if q.Spec.PoolName != poolName {
+			continue
+		}
+		if q.Spec.MaxAddresses == 0 {
+			// the Pod will never be able to start.
+			return newError(codes.PermissionDenied, cnirpc.ErrorCode_INVALID_NETWORK_CONFIG,
+				"no addresses are allowed by PoolQuota "+q.Name, pod.Namespace)
+		}
+
+		// the cached object saves an API request in most cases.  The latest
+		// object is read after a conflict, or if the cached one is used up
+		// because it may be outdated.
+		quota := q.DeepCopy()
+		err := retry.RetryOnConflict(quotaBackoff, func() error {
+			if quota == nil || quota.Status.Used >= quota.Spec.MaxAddresses {
+				quota = &coilv2.PoolQuota{}
+				if err := s.apiReader.Get(ctx, client.ObjectKeyFromObject(&q), quota); err != nil {
+					return err
+				}
+			}
+			defer func() { quota = nil }()
+
+			for _, r := range quota.Status.Reservations {
+				if r.ContainerID == containerID {
+					return nil
+				}
+			}
+			if quota.Status.Used >= quota.Spec.MaxAddresses {
+				// the Pod can start after other Pods release their addresses.
+				return newError(codes.ResourceExhausted, cnirpc.ErrorCode_TRY_AGAIN_LATER,
+					"addresses are used up by PoolQuota "+quota.Name,
+					fmt.Sprintf("used %d of %d", quota.Status.Used, quota.Spec.MaxAddresses))
+			}
+
+			quota.Status.Used++
+			quota.Status.Reservations = append(quota.Status.Reservations, coilv2.PoolQuotaReservation{
+				PodName:     pod.Name,
+				ContainerID: containerID,
+				Time:        metav1.Now(),
+			})
+			return s.client.Status().Update(ctx, quota)
+		})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			s.releaseQuota(ctx, pod.Namespace, containerID)
+			if _, ok := status.FromError(err); ok {
+				return err
+			}
+			if apierrors.IsConflict(err) {
+				// too many Pods are being created at once.
+				return newError(codes.Aborted, cnirpc.ErrorCode_TRY_AGAIN_LATER,
+					"PoolQuota "+q.Name+" is being updated by others", err.Error())
+			}
+			return newInternalError(err, "failed to reserve an address in PoolQuota "+q.Name)
+		}
+	}
+	return nil
+}
+
+// releaseQuota releases the addresses reserved for the container.
+// Failures are only logged because coil-ipam-controller eventually
+// releases reservations of Pods that no longer exist.
+func (s *coildServer) releaseQuota(ctx context.Context, namespace, containerID string) {
+	quotas := &coilv2.PoolQuotaList{}
+	if err := s.client.List(ctx, quotas, client.InNamespace(namespace)); err != nil {
+		s.logger.Sugar().Warnw("failed to list PoolQuota", "namespace", namespace, "error", err)
+		return
+	}
+
+	for _, q := range quotas.Items {
+		err := retry.RetryOnConflict(quotaBackoff, func() error {
+			quota := &coilv2.PoolQuota{}
+			if err := s.apiReader.Get(ctx, client.ObjectKeyFromObject(&q), quota); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+			idx := slices.IndexFunc(quota.Status.Reservations, func(r coilv2.PoolQuotaReservation) bool {
+				return r.ContainerID == containerID
+			})
+			if idx < 0 {
+				return nil
+			}
+			quota.Status.Reservations = slices.Delete(quota.Status.Reservations, idx, idx+1)
+			if quota.Status.Used > 0 {
+				quota.Status.Used--
+			}
+			return s.client.Status().Update(ctx, quota)
+		})
+		if err != nil {
+			s.logger.Sugar().Warnw("failed to release the reservation in PoolQuota",
+				"namespace", namespace, "name", q.Name, "container", containerID, "error", err)
+		}
+	}
+}
+
+func podTuning(t *coilv2.PodNetworkTuning) *nodenet.PodTuning {
+	if t == nil {
+		return nil
+	}
+
+	pt := &nodenet.PodTuning{
+		Sysctls: t.Sysctls,
+		GRO:     t.GRO,
+		GSO:     t.GSO,
+		TSO:     t.TSO,
+	}
+	if t.TxQueueLen != nil {
+		pt.TxQueueLen = ptr.To(int(*t.TxQueueLen))
+	}
+	return pt
+}
+
 func (s *coildServer) getHook(ctx context.Context, pod *corev1.Pod) (nodenet.SetupHook, error) {
 	logger := withCtxFields(ctx, s.logger)
 
@@ -450,31 +724,112 @@ func (s *coildServer) getHook(ctx context.Context, pod *corev1.Pod) (nodenet.Set
 		return nil, nil
 	}
 
-	var egNames []client.ObjectKey
+	egNames := coilv2.EgressesOf(pod.Annotations)
+	if len(egNames) == 0 {
+		return nil, nil
+	}
 
-	for k, v := range pod.Annotations {
-		if !strings.HasPrefix(k, constants.AnnEgressPrefix) {
-			continue
-		}
+	if err := s.checkEgressClient(ctx, pod, egNames); err != nil {
+		return nil, err
+	}
+	if err := s.checkEgressLoop(ctx, pod, egNames); err != nil {
+		return nil, err
+	}
 
-		ns := k[len(constants.AnnEgressPrefix):]
-		for _, name := range strings.Split(v, ",") {
-			egNames = append(egNames, client.ObjectKey{Namespace: ns, Name: name})
-		}
+	gwlist, err := s.getGWNets(ctx, egNames)
+	if err != nil {
+		return nil, err
 	}
-	if len(egNames) == 0 {
-		return nil, nil
+
+	if len(gwlist) > 0 {
+		logger = logger.With(zap.String("pod_name", pod.Name), zap.String("pod_namespace", pod.Namespace))
+		logger.Sugar().Infof("gwlist: %v", gwlist)
+		return s.natSetup.Hook(gwlist, s.cfg.Backend, logger), nil
+	}
+	return nil, nil
+}
+
+// checkEgressClient returns an error if the Pod is not allowed to use any of the Egresses.
+func (s *coildServer) checkEgressClient(ctx context.Context, pod *corev1.Pod, egNames []client.ObjectKey) error {
+	ns := &corev1.Namespace{}
+	if err := s.client.Get(ctx, client.ObjectKey{Name: pod.Namespace}, ns); err != nil {
+		return newInternalError(err, "failed to get namespace")
 	}
 
-	var gwlist []GWNets
 	for _, n := range egNames {
 		eg := &coilv2.Egress{}
-		svc := &corev1.Service{}
+		if err := s.client.Get(ctx, n, eg); err != nil {
+			return newError(codes.FailedPrecondition, cnirpc.ErrorCode_INTERNAL,
+				"failed to get Egress "+n.String(), err.Error())
+		}
+
+		ok, err := eg.Spec.AllowsClient(ns, pod)
+		if err != nil {
+			return newInternalError(err, "invalid allowedClients of Egress "+n.String())
+		}
+		if !ok {
+			return newError(codes.PermissionDenied, cnirpc.ErrorCode_INVALID_NETWORK_CONFIG,
+				"pod is not allowed to use Egress "+n.String(), pod.Namespace+"/"+pod.Name)
+		}
+	}
+	return nil
+}
+
+// checkEgressLoop returns an error if the Pod is a router Pod of an Egress
+// and the chain of Egresses used by it leads back to the Egress.
+func (s *coildServer) checkEgressLoop(ctx context.Context, pod *corev1.Pod, egNames []client.ObjectKey) error {
+	if pod.Labels[constants.LabelAppName] != "coil" || pod.Labels[constants.LabelAppComponent] != "egress" {
+		return nil
+	}
+	name := pod.Labels[constants.LabelAppInstance]
+	if name == "" {
+		return nil
+	}
+
+	key := client.ObjectKey{Namespace: pod.Namespace, Name: name}
+	loop, err := coilv2.FindEgressLoop(ctx, s.client, key, egNames)
+	if err != nil {
+		return newInternalError(err, "failed to check loops of Egress "+key.String())
+	}
+	if loop != nil {
+		return newError(codes.FailedPrecondition, cnirpc.ErrorCode_INVALID_NETWORK_CONFIG,
+			"Egress chain loops", coilv2.FormatEgressChain(loop))
+	}
+	return nil
+}
+
+// getGWNets returns the gateways of the Egresses and the destinations for each gateway.
+// Overlapping destinations are resolved by the priorities of the Egresses.
+func (s *coildServer) getGWNets(ctx context.Context, egNames []client.ObjectKey) ([]GWNets, error) {
+	egNames = slices.Clone(egNames)
+	slices.SortFunc(egNames, func(a, b client.ObjectKey) int {
+		return strings.Compare(a.String(), b.String())
+	})
 
+	egs := make([]*coilv2.Egress, len(egNames))
+	dests := make([]nat.Destinations, len(egNames))
+	for i, n := range egNames {
+		eg := &coilv2.Egress{}
 		if err := s.client.Get(ctx, n, eg); err != nil {
 			return nil, newError(codes.FailedPrecondition, cnirpc.ErrorCode_INTERNAL,
 				"failed to get Egress "+n.String(), err.Error())
 		}
+		egs[i] = eg
+		dests[i].Priority = eg.Spec.Priority
+
+		for _, sn := range eg.Spec.Destinations {
+			_, subnet, err := net.ParseCIDR(sn)
+			if err != nil {
+				return nil, newInternalError(err, "invalid network in Egress "+n.String())
+			}
+			dests[i].Networks = append(dests[i].Networks, subnet)
+		}
+	}
+	resolved := netfilter.ResolveDestinations(dests)
+
+	var gwlist []GWNets
+	for i, n := range egNames {
+		svc := &corev1.Service{}
 		if err := s.client.Get(ctx, n, svc); err != nil {
 			return nil, newError(codes.FailedPrecondition, cnirpc.ErrorCode_INTERNAL,
 				"failed to get Service "+n.String(), err.Error())
@@ -488,11 +843,7 @@ func (s *coildServer) getHook(ctx context.Context, pod *corev1.Pod) (nodenet.Set
 			}
 			var subnets []*net.IPNet
 
-			for _, sn := range eg.Spec.Destinations {
-				_, subnet, err := net.ParseCIDR(sn)
-				if err != nil {
-					return nil, newInternalError(err, "invalid network in Egress "+n.String())
-				}
+			for _, subnet := range resolved[i] {
 				if (svcIP.To4() != nil) == (subnet.IP.To4() != nil) {
 					subnets = append(subnets, subnet)
 				}
@@ -500,17 +851,11 @@ func (s *coildServer) getHook(ctx context.Context, pod *corev1.Pod) (nodenet.Set
 
 			if len(subnets) > 0 {
 				gwlist = append(gwlist, GWNets{Gateway: svcIP, Networks: subnets,
-					SportAuto: eg.Spec.FouSourcePortAuto, OriginatingOnly: s.cfg.OriginatingOnly})
+					SportAuto: egs[i].Spec.FouSourcePortAuto, OriginatingOnly: s.cfg.OriginatingOnly})
 			}
 		}
 	}
-
-	if len(gwlist) > 0 {
-		logger = logger.With(zap.String("pod_name", pod.Name), zap.String("pod_namespace", pod.Namespace))
-		logger.Sugar().Infof("gwlist: %v", gwlist)
-		return s.natSetup.Hook(gwlist, s.cfg.Backend, logger), nil
-	}
-	return nil, nil
+	return gwlist, nil
 }
 
 // ref: https://github.com/grpc-ecosystem/go-grpc-middleware/blob/71d7422112b1d7fadd4b8bf12a6f33ba6d22e98e/interceptors/logging/examples/zap/example_test.go#L17
@@ -581,6 +926,69 @@ func withCtxFields(ctx context.Context, l *zap.Logger) *zap.Logger {
 	return l.With(toZapFields(logging.ExtractFields(ctx))...)
 }
 
+// annotationBurst is the burst size in bits for limits given by Pod annotations.
+// Containerd and CRI-O use the same value for the `bandwidth` capability.
+const annotationBurst = math.MaxUint32
+
+// getBandwidth returns the bandwidth limits for the Pod.
+// Limits passed by the container runtime via the `bandwidth` capability
+// take precedence over the Pod annotations.
+func getBandwidth(args *cnirpc.CNIArgs, pod *corev1.Pod) (*nodenet.BandwidthLimits, error) {
+	if len(args.StdinData) > 0 {
+		conf := struct {
+			RuntimeConfig struct {
+				Bandwidth *nodenet.BandwidthLimits `json:"bandwidth"`
+			} `json:"runtimeConfig"`
+		}{}
+		if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+			return nil, fmt.Errorf("failed to parse network configuration: %w", err)
+		}
+		if bw := conf.RuntimeConfig.Bandwidth; !bw.IsZero() {
+			return bw, bw.Validate()
+		}
+	}
+
+	bw := &nodenet.BandwidthLimits{}
+	if v, ok := pod.Annotations[constants.AnnIngressBandwidth]; ok {
+		rate, err := parseBandwidth(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %w", constants.AnnIngressBandwidth, err)
+		}
+		bw.IngressRate = rate
+		bw.IngressBurst = annotationBurst
+	}
+	if v, ok := pod.Annotations[constants.AnnEgressBandwidth]; ok {
+		rate, err := parseBandwidth(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %w", constants.AnnEgressBandwidth, err)
+		}
+		bw.EgressRate = rate
+		bw.EgressBurst = annotationBurst
+	}
+	if bw.IsZero() {
+		return nil, nil
+	}
+	return bw, nil
+}
+
+// parseBandwidth parses a bandwidth annotation value such as "10M".
+// The allowed range is the same as kubelet's.
+func parseBandwidth(v string) (uint64, error) {
+	q, err := resource.ParseQuantity(v)
+	if err != nil {
+		return 0, err
+	}
+	if q.Cmp(minBandwidth) < 0 || q.Cmp(maxBandwidth) > 0 {
+		return 0, fmt.Errorf("%s is out of range [%s, %s]", v, minBandwidth.String(), maxBandwidth.String())
+	}
+	return uint64(q.Value()), nil
+}
+
+var (
+	minBandwidth = resource.MustParse("1k")
+	maxBandwidth = resource.MustParse("1P")
+)
+
 func getSettings(args *cnirpc.CNIArgs) (bool, error) {
 	isChained := false
 	var err error
@@ -9,6 +9,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -21,19 +22,28 @@ import (
 	uberzap "go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	coilv2 "github.com/cybozu-go/coil/v2/api/v2"
+	"github.com/cybozu-go/coil/v2/pkg/adminrpc"
 	"github.com/cybozu-go/coil/v2/pkg/cnirpc"
 	"github.com/cybozu-go/coil/v2/pkg/config"
 	"github.com/cybozu-go/coil/v2/pkg/constants"
+	"github.com/cybozu-go/coil/v2/pkg/indexing"
+	"github.com/cybozu-go/coil/v2/pkg/ipam"
 	"github.com/cybozu-go/coil/v2/pkg/nodenet"
 )
 
@@ -47,13 +57,16 @@ type mockNodeIPAM struct {
 	nFree        int
 	errFree      bool
 	nClearRoutes atomic.Int32
+	nGC          atomic.Int32
+	nSyncRoutes  atomic.Int32
 }
 
 func (n *mockNodeIPAM) Register(ctx context.Context, poolName, containerID, iface string, ipv4, ipv6 net.IP) error {
 	panic("not implemented")
 }
 func (n *mockNodeIPAM) GC(ctx context.Context) error {
-	panic("not implemented")
+	n.nGC.Add(1)
+	return nil
 }
 func (n *mockNodeIPAM) Notify(*coilv2.BlockRequest) {
 	panic("not implemented")
@@ -65,6 +78,16 @@ func (n *mockNodeIPAM) ClearRoutes(ctx context.Context) error {
 	n.nClearRoutes.Add(1)
 	return nil
 }
+func (n *mockNodeIPAM) SyncRoutes(ctx context.Context) error {
+	n.nSyncRoutes.Add(1)
+	return nil
+}
+func (n *mockNodeIPAM) Allocations() []ipam.Allocation {
+	return []ipam.Allocation{
+		{ContainerID: "pod1", Iface: "eth0", PoolName: "default", BlockName: "default-0",
+			IPv4: net.ParseIP("10.1.2.3"), IPv6: net.ParseIP("fd02::1")},
+	}
+}
 
 func (n *mockNodeIPAM) Allocate(ctx context.Context, poolName, containerID, iface string) (ipv4, ipv6 net.IP, err error) {
 	n.nAllocate++
@@ -76,10 +99,19 @@ func (n *mockNodeIPAM) Allocate(ctx context.Context, poolName, containerID, ifac
 			return net.ParseIP("10.1.2.4"), net.ParseIP("fd02::2"), nil
 		case "nat-client2":
 			return net.ParseIP("10.1.2.5"), net.ParseIP("fd02::3"), nil
+		case "shaped1", "shaped2":
+			return net.ParseIP("10.1.2.6"), net.ParseIP("fd02::4"), nil
+		case "mtu1":
+			return net.ParseIP("10.1.2.7"), net.ParseIP("fd02::5"), nil
 		}
 	}
-	if poolName == "global" && containerID == "dns1" {
-		return net.ParseIP("8.8.8.8"), nil, nil
+	if poolName == "global" {
+		switch containerID {
+		case "dns1":
+			return net.ParseIP("8.8.8.8"), nil, nil
+		case "mtu2":
+			return net.ParseIP("10.3.0.1"), nil, nil
+		}
 	}
 	return nil, nil, errors.New("some error")
 }
@@ -100,18 +132,27 @@ type mockPodNetwork struct {
 	errSetup   bool
 	errDestroy bool
 
-	expected string
+	expected  string
+	bandwidth *nodenet.BandwidthLimits
+	mtu       int
+	tuning    *nodenet.PodTuning
 }
 
 func (p *mockPodNetwork) Init() error {
 	panic("not implemented")
 }
 func (p *mockPodNetwork) List() ([]*nodenet.PodNetConf, error) {
-	panic("not implemented")
+	return []*nodenet.PodNetConf{
+		{PoolName: "default", ContainerId: "pod1", IFace: "eth0",
+			IPv4: net.ParseIP("10.1.2.3"), IPv6: net.ParseIP("fd02::1"), HostVethName: "veth01234567"},
+	}, nil
 }
 
 func (p *mockPodNetwork) SetupIPAM(nsPath, podName, podNS string, conf *nodenet.PodNetConf) (*current.Result, error) {
 	p.nSetup++
+	p.bandwidth = conf.Bandwidth
+	p.mtu = conf.MTU
+	p.tuning = conf.Tuning
 	if p.errSetup {
 		return nil, errors.New("setup failure")
 	}
@@ -209,6 +250,8 @@ var _ = Describe("Coild server", func() {
 	var logbuf *bytes.Buffer
 	var conn *grpc.ClientConn
 	var cniClient cnirpc.CNIClient
+	var adminClient adminrpc.AdminClient
+	var cachedClient client.Client
 	metricPort := 13449
 
 	BeforeEach(func() {
@@ -222,6 +265,8 @@ var _ = Describe("Coild server", func() {
 		})
 		metricPort--
 		Expect(err).ToNot(HaveOccurred())
+		cachedClient = mgr.GetClient()
+		Expect(indexing.SetupIndexForPodByNodeName(ctx, mgr)).To(Succeed())
 
 		l, err := net.Listen("unix", coildSocket)
 		if err != nil {
@@ -268,6 +313,7 @@ var _ = Describe("Coild server", func() {
 		conn, err = grpc.NewClient(coildSocket, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithContextDialer(dialFunc))
 		Expect(err).ToNot(HaveOccurred())
 		cniClient = cnirpc.NewCNIClient(conn)
+		adminClient = adminrpc.NewAdminClient(conn)
 	})
 
 	AfterEach(func() {
@@ -393,6 +439,37 @@ var _ = Describe("Coild server", func() {
 			// Expecting at least 1 call, because there may be other calls in BeforeEach.
 			Expect(metric.GetCounter().GetValue()).To(BeNumerically(">=", 1))
 
+			By("checking CNI request metrics")
+			Expect(mfs).To(HaveKey("coil_coild_cni_request_duration_seconds"))
+			metric = findMetric(mfs["coil_coild_cni_request_duration_seconds"], map[string]string{
+				"method": "Add",
+				"code":   "OK",
+			})
+			Expect(metric).NotTo(BeNil())
+			Expect(metric.GetHistogram().GetSampleCount()).To(BeNumerically(">=", 1))
+			metric = findMetric(mfs["coil_coild_cni_request_duration_seconds"], map[string]string{
+				"method": "Add",
+				"code":   "INTERNAL",
+			})
+			Expect(metric).NotTo(BeNil())
+
+			Expect(mfs).To(HaveKey("coil_coild_cni_requests_in_flight"))
+			metric = findMetric(mfs["coil_coild_cni_requests_in_flight"], map[string]string{"method": "Add"})
+			Expect(metric).NotTo(BeNil())
+			Expect(metric.GetGauge().GetValue()).To(BeNumerically("==", 0))
+
+			if testIPAM {
+				Expect(mfs).To(HaveKey("coil_coild_cni_phase_duration_seconds"))
+				for _, phase := range []string{"pool_lookup", "quota_reservation", "address_allocation", "veth_setup"} {
+					metric = findMetric(mfs["coil_coild_cni_phase_duration_seconds"], map[string]string{
+						"method": "Add",
+						"phase":  phase,
+						"code":   "OK",
+					})
+					Expect(metric).NotTo(BeNil(), phase)
+				}
+			}
+
 			By("creating a pod in ns2")
 			pod = &corev1.Pod{}
 			pod.Namespace = "ns2"
@@ -413,6 +490,19 @@ var _ = Describe("Coild server", func() {
 					Netns:       "/run/netns/bar",
 				})
 				Expect(err).To(HaveOccurred())
+
+				By("checking the metrics of the failed phase")
+				resp, err := http.Get("http://localhost:13449/metrics")
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+				mfs, err := textParser.TextToMetricFamilies(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				metric = findMetric(mfs["coil_coild_cni_phase_duration_seconds"], map[string]string{
+					"method": "Add",
+					"phase":  "veth_setup",
+					"code":   "INTERNAL",
+				})
+				Expect(metric).NotTo(BeNil())
 			}
 
 			By("calling Add for ns2/bar")
@@ -510,6 +600,350 @@ var _ = Describe("Coild server", func() {
 		}
 	})
 
+	if testIPAM {
+		It("should pass bandwidth limits to the pod network", func() {
+			By("creating pod with bandwidth annotations")
+			pod := &corev1.Pod{}
+			pod.Namespace = "ns1"
+			pod.Name = "shaped"
+			pod.Spec.Containers = []corev1.Container{
+				{Name: "foo", Image: "nginx"},
+			}
+			pod.Annotations = map[string]string{
+				constants.AnnIngressBandwidth: "10M",
+				constants.AnnEgressBandwidth:  "20M",
+			}
+			err = k8sClient.Create(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("calling Add")
+			_, err := cniClient.Add(ctx, &cnirpc.CNIArgs{
+				Args:        map[string]string{"K8S_POD_NAME": "shaped", "K8S_POD_NAMESPACE": "ns1"},
+				ContainerId: "shaped1",
+				Ifname:      "eth0",
+				Netns:       "/run/netns/shaped",
+				Interfaces:  map[string]bool{"eth0": false},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(podNet.bandwidth).To(Equal(&nodenet.BandwidthLimits{
+				IngressRate:  10_000_000,
+				IngressBurst: annotationBurst,
+				EgressRate:   20_000_000,
+				EgressBurst:  annotationBurst,
+			}))
+
+			By("calling Add with the bandwidth capability")
+			_, err = cniClient.Add(ctx, &cnirpc.CNIArgs{
+				Args:        map[string]string{"K8S_POD_NAME": "shaped", "K8S_POD_NAMESPACE": "ns1"},
+				ContainerId: "shaped2",
+				Ifname:      "eth0",
+				Netns:       "/run/netns/shaped",
+				StdinData:   []byte(`{"runtimeConfig": {"bandwidth": {"ingressRate": 1000000, "ingressBurst": 8000}}}`),
+				Interfaces:  map[string]bool{"eth0": false},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(podNet.bandwidth).To(Equal(&nodenet.BandwidthLimits{
+				IngressRate:  1_000_000,
+				IngressBurst: 8000,
+			}))
+
+			By("calling Add with an invalid bandwidth capability")
+			_, err = cniClient.Add(ctx, &cnirpc.CNIArgs{
+				Args:        map[string]string{"K8S_POD_NAME": "shaped", "K8S_POD_NAMESPACE": "ns1"},
+				ContainerId: "shaped3",
+				Ifname:      "eth0",
+				Netns:       "/run/netns/shaped",
+				StdinData:   []byte(`{"runtimeConfig": {"bandwidth": {"egressRate": 1000000}}}`),
+			})
+			Expect(err).To(HaveOccurred())
+
+			By("updating the annotation to an invalid value")
+			pod.Annotations[constants.AnnEgressBandwidth] = "1"
+			err = k8sClient.Update(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cniClient.Add(ctx, &cnirpc.CNIArgs{
+				Args:        map[string]string{"K8S_POD_NAME": "shaped", "K8S_POD_NAMESPACE": "ns1"},
+				ContainerId: "shaped4",
+				Ifname:      "eth0",
+				Netns:       "/run/netns/shaped",
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	}
+
+	if testIPAM {
+		It("should record an event on the pod when address allocation fails", func() {
+			pod := &corev1.Pod{}
+			pod.Namespace = "ns1"
+			pod.Name = "unlucky"
+			pod.Spec.Containers = []corev1.Container{
+				{Name: "foo", Image: "nginx"},
+			}
+			err := k8sClient.Create(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cniClient.Add(ctx, &cnirpc.CNIArgs{
+				Args:        map[string]string{"K8S_POD_NAME": "unlucky", "K8S_POD_NAMESPACE": "ns1"},
+				ContainerId: "unknown",
+				Ifname:      "eth0",
+				Netns:       "/run/netns/unlucky",
+				Interfaces:  map[string]bool{"eth0": false},
+			})
+			Expect(err).To(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				evs := &eventsv1.EventList{}
+				g.Expect(k8sClient.List(ctx, evs, client.InNamespace("ns1"))).To(Succeed())
+				var reasons []string
+				for _, ev := range evs.Items {
+					if ev.Regarding.Kind == "Pod" && ev.Regarding.Name == "unlucky" {
+						g.Expect(ev.Type).To(Equal(corev1.EventTypeWarning))
+						reasons = append(reasons, ev.Reason)
+					}
+				}
+				g.Expect(reasons).To(ContainElement(constants.ReasonAllocationFailed))
+			}).Should(Succeed())
+		})
+	}
+
+	if testIPAM {
+		It("should pass the parameters of the address pool to the pod network", func() {
+			By("calling Add for a pool without parameters")
+			_, err := cniClient.Add(ctx, &cnirpc.CNIArgs{
+				Args:        map[string]string{"K8S_POD_NAME": "foo", "K8S_POD_NAMESPACE": "ns1"},
+				ContainerId: "mtu1",
+				Ifname:      "eth0",
+				Netns:       "/run/netns/foo",
+				Interfaces:  map[string]bool{"eth0": false},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(podNet.mtu).To(Equal(0))
+			Expect(podNet.tuning).To(BeNil())
+
+			By("creating a pool with parameters")
+			pool := &coilv2.AddressPool{}
+			pool.Name = "global"
+			pool.Spec.BlockSizeBits = 1
+			pool.Spec.Subnets = []coilv2.SubnetSet{{IPv4: ptr.To("10.3.0.0/24")}}
+			pool.Spec.MTU = 1400
+			pool.Spec.Tuning = &coilv2.PodNetworkTuning{
+				Sysctls:    map[string]string{"net.ipv4.tcp_congestion_control": "bbr"},
+				GRO:        ptr.To(false),
+				TxQueueLen: ptr.To[int32](100),
+			}
+			err = k8sClient.Create(ctx, pool)
+			Expect(err).NotTo(HaveOccurred())
+
+			pod := &corev1.Pod{}
+			pod.Namespace = "ns2"
+			pod.Name = "mtu"
+			pod.Spec.Containers = []corev1.Container{
+				{Name: "foo", Image: "nginx"},
+			}
+			err = k8sClient.Create(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("calling Add for a pool with parameters")
+			Eventually(func(g Gomega) {
+				_, err := cniClient.Add(ctx, &cnirpc.CNIArgs{
+					Args:        map[string]string{"K8S_POD_NAME": "mtu", "K8S_POD_NAMESPACE": "ns2"},
+					ContainerId: "mtu2",
+					Ifname:      "eth0",
+					Netns:       "/run/netns/mtu",
+					Interfaces:  map[string]bool{"eth0": false},
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(podNet.mtu).To(Equal(1400))
+			}).Should(Succeed())
+			Expect(podNet.tuning).To(Equal(&nodenet.PodTuning{
+				Sysctls:    map[string]string{"net.ipv4.tcp_congestion_control": "bbr"},
+				GRO:        ptr.To(false),
+				TxQueueLen: ptr.To(100),
+			}))
+
+			err = k8sClient.Delete(ctx, pool)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should deny Pods in namespaces not allowed to use the pool", func() {
+			pool := &coilv2.AddressPool{}
+			pool.Name = "restricted"
+			pool.Spec.BlockSizeBits = 1
+			pool.Spec.Subnets = []coilv2.SubnetSet{{IPv4: ptr.To("10.5.0.0/24")}}
+			pool.Spec.AllowedNamespaces = &metav1.LabelSelector{
+				MatchLabels: map[string]string{"team": "network"},
+			}
+			err := k8sClient.Create(ctx, pool)
+			Expect(err).NotTo(HaveOccurred())
+
+			ns := &corev1.Namespace{}
+			ns.Name = "restricted"
+			ns.Annotations = map[string]string{constants.AnnPool: "restricted"}
+			err = k8sClient.Create(ctx, ns)
+			Expect(err).NotTo(HaveOccurred())
+
+			pod := &corev1.Pod{}
+			pod.Namespace = "restricted"
+			pod.Name = "denied"
+			pod.Spec.Containers = []corev1.Container{
+				{Name: "foo", Image: "nginx"},
+			}
+			err = k8sClient.Create(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func(g Gomega) {
+				_, err := cniClient.Add(ctx, &cnirpc.CNIArgs{
+					Args:        map[string]string{"K8S_POD_NAME": "denied", "K8S_POD_NAMESPACE": "restricted"},
+					ContainerId: "denied",
+					Ifname:      "eth0",
+					Netns:       "/run/netns/denied",
+					Interfaces:  map[string]bool{"eth0": false},
+				})
+				g.Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			}).Should(Succeed())
+
+			err = k8sClient.Delete(ctx, pool)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should deny Pods exceeding the PoolQuota of the namespace", func() {
+			ns := &corev1.Namespace{}
+			ns.Name = "quota"
+			err := k8sClient.Create(ctx, ns)
+			Expect(err).NotTo(HaveOccurred())
+
+			pod := &corev1.Pod{}
+			pod.Namespace = "quota"
+			pod.Name = "over"
+			pod.Spec.Containers = []corev1.Container{
+				{Name: "foo", Image: "nginx"},
+			}
+			err = k8sClient.Create(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+
+			quota := &coilv2.PoolQuota{}
+			quota.Namespace = "quota"
+			quota.Name = "default"
+			quota.Spec.PoolName = "default"
+			quota.Spec.MaxAddresses = 1
+			err = k8sClient.Create(ctx, quota)
+			Expect(err).NotTo(HaveOccurred())
+			quota.Status.Used = 1
+			err = k8sClient.Status().Update(ctx, quota)
+			Expect(err).NotTo(HaveOccurred())
+
+			args := &cnirpc.CNIArgs{
+				Args:        map[string]string{"K8S_POD_NAME": "over", "K8S_POD_NAMESPACE": "quota"},
+				ContainerId: "over",
+				Ifname:      "eth0",
+				Netns:       "/run/netns/over",
+				Interfaces:  map[string]bool{"eth0": false},
+			}
+
+			By("calling Add when the quota is used up")
+			Eventually(func(g Gomega) {
+				_, err := cniClient.Add(ctx, args)
+				g.Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+			}).Should(Succeed())
+
+			By("calling Add when no addresses are allowed")
+			quota.Spec.MaxAddresses = 0
+			err = k8sClient.Update(ctx, quota)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func(g Gomega) {
+				_, err := cniClient.Add(ctx, args)
+				g.Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			}).Should(Succeed())
+
+			err = k8sClient.Delete(ctx, quota)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should not exceed the PoolQuota with concurrent Adds", func() {
+			ns := &corev1.Namespace{}
+			ns.Name = "quota-race"
+			err := k8sClient.Create(ctx, ns)
+			Expect(err).NotTo(HaveOccurred())
+
+			names := []string{"race0", "race1"}
+			for _, name := range names {
+				pod := &corev1.Pod{}
+				pod.Namespace = "quota-race"
+				pod.Name = name
+				pod.Spec.Containers = []corev1.Container{
+					{Name: "foo", Image: "nginx"},
+				}
+				err = k8sClient.Create(ctx, pod)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			quota := &coilv2.PoolQuota{}
+			quota.Namespace = "quota-race"
+			quota.Name = "default"
+			quota.Spec.PoolName = "default"
+			quota.Spec.MaxAddresses = 1
+			err = k8sClient.Create(ctx, quota)
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				err := k8sClient.Delete(ctx, quota)
+				Expect(err).NotTo(HaveOccurred())
+			}()
+
+			argsFor := func(name string) *cnirpc.CNIArgs {
+				return &cnirpc.CNIArgs{
+					Args:        map[string]string{"K8S_POD_NAME": name, "K8S_POD_NAMESPACE": "quota-race"},
+					ContainerId: name,
+					Ifname:      "eth0",
+					Netns:       "/run/netns/" + name,
+					Interfaces:  map[string]bool{"eth0": false},
+				}
+			}
+
+			Eventually(func() error {
+				return cachedClient.Get(ctx, client.ObjectKeyFromObject(quota), &coilv2.PoolQuota{})
+			}).Should(Succeed())
+
+			By("calling Add for two Pods concurrently")
+			var wg sync.WaitGroup
+			errs := make([]error, len(names))
+			for i, name := range names {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, errs[i] = cniClient.Add(ctx, argsFor(name))
+				}()
+			}
+			wg.Wait()
+
+			var added string
+			for i, err := range errs {
+				if err == nil {
+					Expect(added).To(BeEmpty(), "both Pods got addresses")
+					added = names[i]
+					continue
+				}
+				Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+			}
+			Expect(added).NotTo(BeEmpty())
+
+			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(quota), quota)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(quota.Status.Used).To(Equal(int32(1)))
+			Expect(quota.Status.Reservations).To(HaveLen(1))
+			Expect(quota.Status.Reservations[0].ContainerID).To(Equal(added))
+
+			By("calling Del to release the reservation")
+			_, err = cniClient.Del(ctx, argsFor(added))
+			Expect(err).NotTo(HaveOccurred())
+
+			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(quota), quota)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(quota.Status.Used).To(Equal(int32(0)))
+			Expect(quota.Status.Reservations).To(BeEmpty())
+		})
+	}
+
 	if testEgress {
 		It("should setup Foo-over-UDP NAT", func() {
 			By("creating pod declaring itself as a NAT client")
@@ -580,8 +1014,155 @@ var _ = Describe("Coild server", func() {
 			Expect(gwnets.Networks).To(HaveLen(1))
 			subnet := gwnets.Networks[0]
 			Expect(subnet.IP.Equal(net.ParseIP("192.168.0.0"))).To(BeTrue())
+
+			By("restricting the clients of the Egress")
+			eg.Spec.AllowedClients = &coilv2.EgressAllowedClients{
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"nat": "allowed"}},
+			}
+			err = k8sClient.Update(ctx, eg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func() error {
+				_, err := cniClient.Add(ctx, &cnirpc.CNIArgs{
+					Args:        map[string]string{"K8S_POD_NAME": "nat-client1", "K8S_POD_NAMESPACE": "ns1"},
+					ContainerId: "nat-client1",
+					Ifname:      "eth0",
+					Netns:       "/run/netns/nat-client1",
+					Interfaces:  map[string]bool{"eth0": false},
+				})
+				return err
+			}).Should(HaveOccurred())
+		})
+
+		It("should deny router Pods of Egresses chained in a loop", func() {
+			eg := &coilv2.Egress{}
+			eg.Namespace = "ns2"
+			eg.Name = "loop-egress"
+			eg.Spec.Destinations = []string{"192.168.0.0/16"}
+			eg.Spec.Replicas = 1
+			err := k8sClient.Create(ctx, eg)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("creating a router Pod of the Egress using the Egress itself")
+			pod := &corev1.Pod{}
+			pod.Namespace = "ns2"
+			pod.Name = "loop-router"
+			pod.Labels = map[string]string{
+				constants.LabelAppName:      "coil",
+				constants.LabelAppInstance:  "loop-egress",
+				constants.LabelAppComponent: "egress",
+			}
+			pod.Spec.Containers = []corev1.Container{
+				{Name: "foo", Image: "nginx"},
+			}
+			pod.Annotations = map[string]string{
+				"egress.coil.cybozu.com/ns2": "loop-egress",
+			}
+			err = k8sClient.Create(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = cniClient.Add(ctx, &cnirpc.CNIArgs{
+				Args:        map[string]string{"K8S_POD_NAME": "loop-router", "K8S_POD_NAMESPACE": "ns2"},
+				ContainerId: "loop-router",
+				Ifname:      "eth0",
+				Netns:       "/run/netns/loop-router",
+				Interfaces:  map[string]bool{"eth0": false},
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Egress chain loops"))
 		})
 	}
+
+	It("should serve the admin API", func() {
+		By("listing pod networks")
+		podNets, err := adminClient.ListPodNetworks(ctx, &emptypb.Empty{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(podNets.PodNetworks).To(HaveLen(1))
+		Expect(podNets.PodNetworks[0].HostVeth).To(Equal("veth01234567"))
+
+		if testIPAM {
+			By("listing allocations")
+			allocs, err := adminClient.ListAllocations(ctx, &emptypb.Empty{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allocs.Allocations).To(HaveLen(1))
+			Expect(allocs.Allocations[0].ContainerId).To(Equal("pod1"))
+			Expect(allocs.Allocations[0].Ipv4).To(Equal("10.1.2.3"))
+
+			By("running GC and syncing routes")
+			_, err = adminClient.RunGC(ctx, &emptypb.Empty{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nodeIPAM.nGC.Load()).To(BeNumerically(">=", 1))
+			_, err = adminClient.SyncRoutes(ctx, &emptypb.Empty{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nodeIPAM.nSyncRoutes.Load()).To(BeNumerically("==", 1))
+		} else {
+			_, err := adminClient.RunGC(ctx, &emptypb.Empty{})
+			Expect(status.Code(err)).To(Equal(codes.FailedPrecondition))
+		}
+
+		if !testEgress {
+			return
+		}
+
+		By("creating a NAT client pod on the node")
+		pod := &corev1.Pod{}
+		pod.Namespace = "ns1"
+		pod.Name = "admin-client"
+		pod.Spec.NodeName = "test-node"
+		pod.Spec.Containers = []corev1.Container{
+			{Name: "foo", Image: "nginx"},
+		}
+		pod.Annotations = map[string]string{
+			"egress.coil.cybozu.com/ns2": "admin-egress,missing",
+		}
+		err = k8sClient.Create(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+
+		eg := &coilv2.Egress{}
+		eg.Namespace = "ns2"
+		eg.Name = "admin-egress"
+		eg.Spec.Destinations = []string{"192.168.0.0/16"}
+		eg.Spec.Replicas = 1
+		err = k8sClient.Create(ctx, eg)
+		Expect(err).NotTo(HaveOccurred())
+
+		svc := &corev1.Service{}
+		svc.Namespace = "ns2"
+		svc.Name = "admin-egress"
+		svc.Spec.ClusterIP = "10.0.0.6"
+		svc.Spec.ClusterIPs = []string{"10.0.0.6"}
+		svc.Spec.Ports = []corev1.ServicePort{{Port: 8080}}
+		err = k8sClient.Create(ctx, svc)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("listing egress hooks")
+		Eventually(func(g Gomega) {
+			hooks, err := adminClient.ListEgressHooks(ctx, &emptypb.Empty{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(hooks.Hooks).To(HaveLen(1))
+			hook := hooks.Hooks[0]
+			g.Expect(hook.PodName).To(Equal("admin-client"))
+			g.Expect(hook.Egresses).To(Equal([]string{"ns2/admin-egress", "ns2/missing"}))
+			// the missing Egress is reported as an error
+			g.Expect(hook.Error).NotTo(BeEmpty())
+		}).Should(Succeed())
+
+		By("removing the missing Egress from the annotation")
+		pod.Annotations["egress.coil.cybozu.com/ns2"] = "admin-egress"
+		err = k8sClient.Update(ctx, pod)
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			hooks, err := adminClient.ListEgressHooks(ctx, &emptypb.Empty{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(hooks.Hooks).To(HaveLen(1))
+			hook := hooks.Hooks[0]
+			g.Expect(hook.Error).To(BeEmpty())
+			g.Expect(hook.Gateways).To(HaveLen(1))
+			g.Expect(hook.Gateways[0].Gateway).To(Equal("10.0.0.6"))
+			g.Expect(hook.Gateways[0].Destinations).To(Equal([]string{"192.168.0.0/16"}))
+		}).Should(Succeed())
+	})
 })
 
 var _ = Describe("shutdown route cleanup", func() {
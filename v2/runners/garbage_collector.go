@@ -7,6 +7,7 @@ import (
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -16,25 +17,32 @@ import (
 	"github.com/cybozu-go/coil/v2/pkg/constants"
 )
 
+// provisionalGracePeriod is the time to wait for coild to adopt a provisional block.
+const provisionalGracePeriod = 5 * time.Minute
+
 // NewGarbageCollector creates a manager.Runnable to collect
-// orphaned AddressBlocks of deleted nodes.
+// orphaned AddressBlocks of deleted nodes and provisional
+// AddressBlocks that are not given to any node.
 func NewGarbageCollector(mgr manager.Manager, log logr.Logger, interval time.Duration) manager.Runnable {
 	return &garbageCollector{
-		Client:    mgr.GetClient(),
-		apiReader: mgr.GetAPIReader(),
-		log:       log,
-		interval:  interval,
+		Client:         mgr.GetClient(),
+		apiReader:      mgr.GetAPIReader(),
+		log:            log,
+		interval:       interval,
+		provisionalAge: provisionalGracePeriod,
 	}
 }
 
 type garbageCollector struct {
 	client.Client
-	apiReader client.Reader
-	log       logr.Logger
-	interval  time.Duration
+	apiReader      client.Reader
+	log            logr.Logger
+	interval       time.Duration
+	provisionalAge time.Duration
 }
 
 // +kubebuilder:rbac:groups=coil.cybozu.com,resources=addressblocks,verbs=get;list;watch;update;patch;delete
+// +kubebuilder:rbac:groups=coil.cybozu.com,resources=blockrequests,verbs=get;list
 // +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list
 
 var _ manager.LeaderElectionRunnable = &garbageCollector{}
@@ -80,7 +88,25 @@ func (gc *garbageCollector) do(ctx context.Context) error {
 		nodeNames[n.Name] = true
 	}
 
+	requests := &coilv2.BlockRequestList{}
+	if err := gc.apiReader.List(ctx, requests); err != nil {
+		return fmt.Errorf("failed to list block requests: %w", err)
+	}
+
+	requestMap := make(map[string]*coilv2.BlockRequest)
+	for i := range requests.Items {
+		requestMap[string(requests.Items[i].UID)] = &requests.Items[i]
+	}
+
 	for _, b := range blocks.Items {
+		if gc.isAbandoned(&b, requestMap) {
+			err := gc.deleteProvisionalBlock(ctx, b.Name)
+			if err != nil {
+				return fmt.Errorf("failed to delete a block: %w", err)
+			}
+			continue
+		}
+
 		n := b.Labels[constants.LabelNode]
 		if nodeNames[n] {
 			continue
@@ -97,6 +123,64 @@ func (gc *garbageCollector) do(ctx context.Context) error {
 	return nil
 }
 
+// isAbandoned returns true if b is a provisional block that will not be adopted.
+// Such a block is left when a controller fails to delete the block that lost
+// the race for a request, or when coild gives up the request.
+func (gc *garbageCollector) isAbandoned(b *coilv2.AddressBlock, requests map[string]*coilv2.BlockRequest) bool {
+	if b.Labels[constants.LabelProvisional] != "true" {
+		return false
+	}
+	if time.Since(b.CreationTimestamp.Time) < gc.provisionalAge {
+		return false
+	}
+
+	req, ok := requests[b.Labels[constants.LabelRequest]]
+	if !ok {
+		return true
+	}
+	if len(req.Status.Conditions) == 0 {
+		return false
+	}
+	return req.Status.AddressBlockName != b.Name
+}
+
+// deleteProvisionalBlock deletes a block unless it has been adopted by coild.
+func (gc *garbageCollector) deleteProvisionalBlock(ctx context.Context, name string) error {
+	b := &coilv2.AddressBlock{}
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if err := gc.apiReader.Get(ctx, client.ObjectKey{Name: name}, b); err != nil {
+			return err
+		}
+		if b.Labels[constants.LabelProvisional] != "true" {
+			return nil
+		}
+		if !controllerutil.RemoveFinalizer(b, constants.FinCoil) {
+			return nil
+		}
+		// the update conflicts if coild adopts the block in the meantime.
+		return gc.Client.Update(ctx, b)
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove finalizer from %s: %w", name, err)
+	}
+	if b.Labels[constants.LabelProvisional] != "true" {
+		return nil
+	}
+
+	err = gc.Client.Delete(ctx, b, client.Preconditions{ResourceVersion: &b.ResourceVersion})
+	if apierrors.IsConflict(err) {
+		return nil
+	}
+	if err := client.IgnoreNotFound(err); err != nil {
+		return err
+	}
+	gc.log.Info("deleted an abandoned provisional block", "block", name, "request", b.Labels[constants.LabelRequest])
+	return nil
+}
+
 func (gc *garbageCollector) deleteBlock(ctx context.Context, name string) error {
 	// remove finalizer
 	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
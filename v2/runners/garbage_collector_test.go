@@ -7,6 +7,8 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
@@ -30,6 +32,7 @@ var _ = Describe("Garbage collector", func() {
 		Expect(err).ToNot(HaveOccurred())
 
 		gc := NewGarbageCollector(mgr, ctrl.Log.WithName("garbage collector"), 3*time.Second)
+		gc.(*garbageCollector).provisionalAge = 0
 		err = mgr.Add(gc)
 		Expect(err).ToNot(HaveOccurred())
 
@@ -111,4 +114,68 @@ var _ = Describe("Garbage collector", func() {
 			return nil
 		}, 5).Should(Succeed())
 	})
+
+	It("should collect abandoned provisional blocks", func() {
+		By("creating requests")
+		pending := &coilv2.BlockRequest{}
+		pending.Name = "pending"
+		pending.Spec.NodeName = "node1"
+		pending.Spec.PoolName = "default"
+		err := k8sClient.Create(ctx, pending)
+		Expect(err).To(Succeed())
+
+		done := &coilv2.BlockRequest{}
+		done.Name = "done"
+		done.Spec.NodeName = "node1"
+		done.Spec.PoolName = "default"
+		err = k8sClient.Create(ctx, done)
+		Expect(err).To(Succeed())
+		done.Status.AddressBlockName = "default-1"
+		done.Status.Conditions = []coilv2.BlockRequestCondition{
+			{
+				Type:               coilv2.BlockRequestComplete,
+				Status:             corev1.ConditionTrue,
+				LastProbeTime:      metav1.Now(),
+				LastTransitionTime: metav1.Now(),
+			},
+		}
+		err = k8sClient.Status().Update(ctx, done)
+		Expect(err).To(Succeed())
+
+		By("creating provisional blocks")
+		create := func(name string, index int32, requestUID string) {
+			block := &coilv2.AddressBlock{}
+			block.Name = name
+			block.Index = index
+			block.Labels = map[string]string{
+				constants.LabelPool:        "default",
+				constants.LabelNode:        "node1",
+				constants.LabelRequest:     requestUID,
+				constants.LabelProvisional: "true",
+			}
+			block.Finalizers = []string{constants.FinCoil}
+			err := k8sClient.Create(ctx, block)
+			Expect(err).To(Succeed())
+		}
+		create("default-0", 0, string(pending.UID))
+		create("default-1", 1, string(done.UID))
+		create("default-2", 2, string(done.UID))
+		create("default-3", 3, "2c5e0a5d-5a3f-4c0e-9d55-1f8e3c8d7b21")
+
+		Eventually(func() error {
+			blocks := &coilv2.AddressBlockList{}
+			err := k8sClient.List(ctx, blocks)
+			if err != nil {
+				return err
+			}
+			var names []string
+			for _, b := range blocks.Items {
+				names = append(names, b.Name)
+			}
+			if len(names) != 2 || names[0] != "default-0" || names[1] != "default-1" {
+				return fmt.Errorf("unexpected blocks: %v", names)
+			}
+			return nil
+		}, 5).Should(Succeed())
+	})
 })
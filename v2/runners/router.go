@@ -49,26 +49,31 @@ func initMetrics(nodeName string) {
 }
 
 // NewRouter creates a manager.Runnable for coil-router.
-func NewRouter(mgr manager.Manager, log logr.Logger, nodeName string, notifyCh <-chan struct{}, syncer nodenet.RouteSyncer, interval time.Duration) manager.Runnable {
+//
+// If `ipv4ViaIPv6` is true, IPv4 address blocks of nodes that have no IPv4
+// address are routed via the IPv6 address of the nodes (RFC 5549).
+func NewRouter(mgr manager.Manager, log logr.Logger, nodeName string, notifyCh <-chan struct{}, syncer nodenet.RouteSyncer, interval time.Duration, ipv4ViaIPv6 bool) manager.Runnable {
 	return &router{
-		Client:    mgr.GetClient(),
-		apiReader: mgr.GetAPIReader(),
-		log:       log,
-		nodeName:  nodeName,
-		notifyCh:  notifyCh,
-		syncer:    syncer,
-		interval:  interval,
+		Client:      mgr.GetClient(),
+		apiReader:   mgr.GetAPIReader(),
+		log:         log,
+		nodeName:    nodeName,
+		notifyCh:    notifyCh,
+		syncer:      syncer,
+		interval:    interval,
+		ipv4ViaIPv6: ipv4ViaIPv6,
 	}
 }
 
 type router struct {
 	client.Client
-	apiReader client.Reader
-	log       logr.Logger
-	nodeName  string
-	notifyCh  <-chan struct{}
-	syncer    nodenet.RouteSyncer
-	interval  time.Duration
+	apiReader   client.Reader
+	log         logr.Logger
+	nodeName    string
+	notifyCh    <-chan struct{}
+	syncer      nodenet.RouteSyncer
+	interval    time.Duration
+	ipv4ViaIPv6 bool
 }
 
 // +kubebuilder:rbac:groups=coil.cybozu.com,resources=addressblocks,verbs=get;list;watch
@@ -153,6 +158,9 @@ func (r *router) sync(ctx context.Context) error {
 		if b.IPv4 != nil {
 			_, n, _ := net.ParseCIDR(*b.IPv4)
 			gw := nm.IPv4
+			if gw == nil && r.ipv4ViaIPv6 {
+				gw = nm.IPv6
+			}
 			if gw == nil {
 				r.log.Info("node has no IPv4 address", "node", nodeName)
 				goto IPv6
@@ -72,7 +72,7 @@ var _ = Describe("Router", func() {
 		})
 		Expect(err).ToNot(HaveOccurred())
 
-		r := NewRouter(mgr, ctrl.Log.WithName("garbage collector"), "node1", syncCh, &fakeSyncer{ch: resultCh}, 1*time.Minute)
+		r := NewRouter(mgr, ctrl.Log.WithName("garbage collector"), "node1", syncCh, &fakeSyncer{ch: resultCh}, 1*time.Minute, false)
 		err = mgr.Add(r)
 		Expect(err).ToNot(HaveOccurred())
 
@@ -196,3 +196,80 @@ var _ = Describe("Router", func() {
 		Expect(metric.GetGauge().GetValue()).To(BeNumerically("==", 6))
 	})
 })
+
+var _ = Describe("Router with IPv4 via IPv6", func() {
+	ctx := context.Background()
+	var cancel context.CancelFunc
+	syncCh := make(chan struct{})
+	resultCh := make(chan map[string]nodenet.GatewayInfo)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.TODO())
+		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+			Scheme:         scheme,
+			LeaderElection: false,
+			Metrics: metricsserver.Options{
+				BindAddress: "0",
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		r := NewRouter(mgr, ctrl.Log.WithName("router"), "node1", syncCh, &fakeSyncer{ch: resultCh}, 1*time.Minute, true)
+		err = mgr.Add(r)
+		Expect(err).ToNot(HaveOccurred())
+
+		go func() {
+			err := mgr.Start(ctx)
+			if err != nil {
+				panic(err)
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		deleteAllAddressBlocks()
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	It("should route IPv4 blocks via IPv6 next-hops of IPv6-only nodes", func() {
+		// node4 has only IPv6 address
+		createBlock(ctx, "block-2", "node4", newIPNet("10.30.4.0/24"), newIPNet("fd02::0400/120"))
+		// node5 has only IPv4 address
+		createBlock(ctx, "block-3", "node5", newIPNet("10.30.5.0/24"), newIPNet("fd02::0500/120"))
+		// node6 has both IPv4 and IPv6 addresses
+		createBlock(ctx, "block-4", "node6", newIPNet("10.30.6.0/24"), newIPNet("fd02::0600/120"))
+
+		Eventually(func() error {
+			syncCh <- struct{}{}
+			result := <-resultCh
+
+			nets := result[net.ParseIP("fd10::44").String()].Networks
+			expected := []*net.IPNet{newIPNet("10.30.4.0/24"), newIPNet("fd02::0400/120")}
+			if !cmp.Equal(nets, expected) {
+				return fmt.Errorf("unexpected networks: %v", cmp.Diff(nets, expected))
+			}
+
+			nets = result[net.ParseIP("10.20.30.45").String()].Networks
+			expected = []*net.IPNet{newIPNet("10.30.5.0/24")}
+			if !cmp.Equal(nets, expected) {
+				return fmt.Errorf("unexpected networks: %v", cmp.Diff(nets, expected))
+			}
+
+			// IPv4 address is preferred if the node has one
+			nets = result[net.ParseIP("10.20.30.46").String()].Networks
+			expected = []*net.IPNet{newIPNet("10.30.6.0/24")}
+			if !cmp.Equal(nets, expected) {
+				return fmt.Errorf("unexpected networks: %v", cmp.Diff(nets, expected))
+			}
+
+			nets = result[net.ParseIP("fd10::46").String()].Networks
+			expected = []*net.IPNet{newIPNet("fd02::0600/120")}
+			if !cmp.Equal(nets, expected) {
+				return fmt.Errorf("unexpected networks: %v", cmp.Diff(nets, expected))
+			}
+
+			return nil
+		}).Should(Succeed())
+	})
+})